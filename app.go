@@ -2,24 +2,36 @@ package main
 
 import (
 	"context"
+	"errors"
+	"eth-daq-software/config"
 	"eth-daq-software/logger"
 	"eth-daq-software/server"
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // App struct
 type App struct {
-	ctx    context.Context
-	server *server.Server
+	ctx       context.Context
+	server    *server.Server
+	config    *config.Config
+	configErr error // Reported once the logger is available
+	// remote is the server the GUI attached to, see config.AttachService. nil if the GUI
+	// runs its own server.
+	remote *server.Client
 }
 
-// NewApp creates a new App application struct
-func NewApp() *App {
+// NewApp creates a new App application struct with the settings in configPath
+func NewApp(configPath string) *App {
+	cfg, err := config.Load(configPath)
+	srv := server.NewServer(cfg)
+	srv.SetConfigPath(configPath)
 	return &App{
-		server: server.NewServer(),
+		server:    srv,
+		config:    cfg,
+		configErr: err,
 	}
 }
 
@@ -28,21 +40,69 @@ func NewApp() *App {
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 	logger.Initialize(ctx)
+	a.server.SetEventEmitter(func(name string, data ...interface{}) {
+		runtime.EventsEmit(ctx, name, data...)
+	})
+	configureLogging(a.config, a.configErr)
 
-	if err := os.MkdirAll("data", 0755); err != nil {
-		runtime.LogErrorf(ctx, "Failed to create data directory: %v\n", err)
+	if a.config.AttachService && a.attach() {
+		return
+	}
+	if err := a.server.Start(); err != nil {
+		runtime.LogErrorf(ctx, "Failed to start server: %v\n", err)
 		return
 	}
 
-	ports := []int{5002, 5555, 5556, 5557}
+	if a.config.HTTPAddr != "" {
+		if err := a.server.StartHTTP(a.config.HTTPAddr); err != nil {
+			logger.Errorf("%v\n", err)
+		}
+	}
+}
 
-	for _, port := range ports {
-		go a.server.StartListener(port)
+// configureLogging applies the logging features of cfg and reports configErr, the
+// error of loading cfg
+func configureLogging(cfg *config.Config, configErr error) {
+	logger.SetJSON(cfg.Features.JSONLogging)
+	if cfg.Features.FileLogging {
+		if err := logger.EnableFile(logger.LOG_FILE, logger.LOG_MAX_SIZE, logger.LOG_BACKUPS); err != nil {
+			logger.Errorf("Failed to enable file logging: %v\n", err)
+		}
 	}
+	if configErr != nil {
+		logger.Errorf("Using default settings: %v\n", configErr)
+	}
+}
 
+// attach connects the GUI to the server already serving the status API, reporting
+// whether one answered
+func (a *App) attach() bool {
+	addr := a.config.HTTPAddr
+	if addr == "" {
+		addr = config.DEFAULT_HTTP_ADDR
+	}
+	client := server.NewClient(addr)
+	if _, err := client.Status(); err != nil {
+		logger.Infof("No server to attach to, listening for devices: %v\n", err)
+		return false
+	}
+	a.remote = client
+	logger.Infof("Attached to the server on %s\n", addr)
+	return true
 }
+
 func (a *App) shutdown(ctx context.Context) {
-	a.server.Shutdown()
+	// The attached server keeps running
+	if a.remote == nil {
+		a.server.Shutdown()
+	}
+	logger.CloseFile()
+}
+
+// IsAttached reports whether the GUI shows a server running in another process, such as
+// the Windows service
+func (a *App) IsAttached() bool {
+	return a.remote != nil
 }
 
 // Greet returns a greeting for the given name
@@ -52,6 +112,9 @@ func (a *App) Greet(name string) string {
 
 // GetPortRate returns the current transfer rate for a specific port
 func (a *App) GetPortRate(key server.BufferKey) float64 {
+	if a.remote != nil {
+		return a.remoteChannel(key).Rate
+	}
 	rate, exists := a.server.GetBufferRate(key)
 	if !exists {
 		return 0
@@ -61,6 +124,13 @@ func (a *App) GetPortRate(key server.BufferKey) float64 {
 
 // GetAllRates returns all current transfer rates
 func (a *App) GetAllRates() map[string]float64 {
+	if a.remote != nil {
+		rates, err := a.remote.Rates()
+		if err != nil {
+			logger.Errorf("%v\n", err)
+		}
+		return rates
+	}
 	rates := a.server.GetAllBufferRates()
 	if rates == nil {
 		return make(map[string]float64)
@@ -68,7 +138,27 @@ func (a *App) GetAllRates() map[string]float64 {
 	return rates
 }
 
+// GetRateHistory returns the per-second transfer rates of a channel, keyed "ip:port", over
+// the last seconds, oldest first
+func (a *App) GetRateHistory(key string, seconds int) []server.RateSample {
+	if a.remote != nil {
+		samples, err := a.remote.RateHistory(key, seconds)
+		if err != nil {
+			logger.Errorf("%v\n", err)
+		}
+		return samples
+	}
+	return a.server.GetRateHistory(key, seconds)
+}
+
 func (a *App) GetAllConnectedIPs() map[string]server.IPConnection {
+	if a.remote != nil {
+		devices, err := a.remote.Devices()
+		if err != nil {
+			logger.Errorf("%v\n", err)
+		}
+		return devices
+	}
 	ips := a.server.GetAllConnectedIPs()
 	if ips == nil {
 		return make(map[string]server.IPConnection)
@@ -77,11 +167,25 @@ func (a *App) GetAllConnectedIPs() map[string]server.IPConnection {
 }
 
 func (a *App) GetLogs(ip string) []string {
+	if a.remote != nil {
+		entries, err := a.remote.Logs(ip, nil)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		lines := make([]string, len(entries))
+		for i, entry := range entries {
+			lines[i] = entry.Line
+		}
+		return lines
+	}
 	logs := a.server.GetLastLogs(ip)
 	return logs
 }
 
 func (a *App) GetPortAverage(key server.BufferKey) float64 {
+	if a.remote != nil {
+		return a.remoteChannel(key).Average
+	}
 	// fmt.Printf("Request: %s, %d\n", key.IP, key.Port)
 	result, _ := a.server.GetPortAverage(key)
 	// fmt.Printf("Result: %f", result)
@@ -89,15 +193,502 @@ func (a *App) GetPortAverage(key server.BufferKey) float64 {
 }
 
 func (a *App) GetPortAverageB(key server.BufferKey) float64 {
+	if a.remote != nil {
+		return a.remoteChannel(key).AverageB
+	}
 	result, _ := a.server.GetPortAverageB(key)
 	return result
 }
 
 func (a *App) GetIPConnectionData(ip string) server.IPConnection {
+	if a.remote != nil {
+		connection, err := a.remote.Device(ip)
+		if err != nil && !errors.Is(err, server.ErrNotFound) {
+			logger.Errorf("%v\n", err)
+		}
+		return connection
+	}
 	result, _ := a.server.GetIPConnectionData(ip)
 	return result
 }
 
+// remoteChannel returns the rate and averages of a channel of the attached server, zero
+// if it has no such channel
+func (a *App) remoteChannel(key server.BufferKey) server.ChannelValues {
+	values, err := a.remote.Channel(key)
+	if err != nil && !errors.Is(err, server.ErrNotFound) {
+		logger.Errorf("%v\n", err)
+	}
+	return values
+}
+
+// CaptureTrigger captures preMs milliseconds of history and postMs milliseconds of new
+// samples around a trigger on the given channel
+func (a *App) CaptureTrigger(key server.BufferKey, preMs int, postMs int) server.TriggerCapture {
+	capture, err := a.server.CaptureTrigger(key,
+		time.Duration(preMs)*time.Millisecond,
+		time.Duration(postMs)*time.Millisecond)
+	if err != nil {
+		logger.Errorf("Trigger capture failed: %v\n", err)
+	}
+	return capture
+}
+
+// SetCalibration sets and persists the calibration of a device channel
+func (a *App) SetCalibration(key server.CalibrationKey, calibration server.Calibration) error {
+	return a.server.SetCalibration(key, calibration)
+}
+
+// ResetCalibration removes all calibrations of a device
+func (a *App) ResetCalibration(uuid string) error {
+	return a.server.ResetCalibration(uuid)
+}
+
+// GetCalibrations returns all calibrations of a device
+func (a *App) GetCalibrations(uuid string) []server.CalibrationEntry {
+	return a.server.GetCalibrations(uuid)
+}
+
+// ExportCSV exports data files of one channel to a CSV file, optionally decimated
+func (a *App) ExportCSV(paths []string, dest string, options server.ExportOptions) error {
+	return a.server.ExportCSV(paths, dest, options)
+}
+
+// SetDerivedChannel defines a virtual channel computed from the physical channels
+func (a *App) SetDerivedChannel(channel server.DerivedChannel) error {
+	return a.server.SetDerivedChannel(channel)
+}
+
+// RemoveDerivedChannel removes a virtual channel
+func (a *App) RemoveDerivedChannel(name string) {
+	a.server.RemoveDerivedChannel(name)
+}
+
+// GetDerivedChannels returns all virtual channel definitions
+func (a *App) GetDerivedChannels() []server.DerivedChannel {
+	return a.server.GetDerivedChannels()
+}
+
+// GetDerivedAverage returns the value of a virtual channel over the current averaging windows
+func (a *App) GetDerivedAverage(ip string, name string) float64 {
+	result, _ := a.server.GetDerivedAverage(ip, name)
+	return result
+}
+
+// GetAllDerivedAverages returns the values of all virtual channels for a device
+func (a *App) GetAllDerivedAverages(ip string) map[string]float64 {
+	return a.server.GetAllDerivedAverages(ip)
+}
+
+// GetDerivedHistogram returns a value histogram of a virtual channel over the live sample history
+func (a *App) GetDerivedHistogram(ip string, name string, bins int, from float64, to float64) (*server.Histogram, error) {
+	return a.server.GetDerivedHistogram(ip, name, bins, from, to)
+}
+
+// DetectDerivedPeaks returns the peaks (or valleys) of a virtual channel in the recent sample window
+func (a *App) DetectDerivedPeaks(ip string, name string, options server.PeakOptions) ([]server.Peak, error) {
+	return a.server.DetectDerivedPeaks(ip, name, options)
+}
+
+// CaptureDerivedTrigger captures a virtual channel around a trigger, like CaptureTrigger
+func (a *App) CaptureDerivedTrigger(ip string, name string, preMs int, postMs int) server.TriggerCapture {
+	capture, err := a.server.CaptureDerivedTrigger(ip, name,
+		time.Duration(preMs)*time.Millisecond,
+		time.Duration(postMs)*time.Millisecond)
+	if err != nil {
+		logger.Errorf("Trigger capture failed: %v\n", err)
+	}
+	return capture
+}
+
+// ExportDerivedCSV exports a virtual channel computed from the data files of one device to a CSV file
+func (a *App) ExportDerivedCSV(paths []string, name string, dest string, options server.ExportOptions) error {
+	return a.server.ExportDerivedCSV(paths, name, dest, options)
+}
+
+// GetHistogram returns a value histogram over the live sample history of a channel
+func (a *App) GetHistogram(key server.BufferKey, bins int, from float64, to float64) (*server.Histogram, error) {
+	return a.server.GetHistogram(key, bins, from, to)
+}
+
+// GetFileHistogram returns a value histogram over recorded data files of one channel
+func (a *App) GetFileHistogram(paths []string, bins int, from float64, to float64) (*server.Histogram, error) {
+	return a.server.GetFileHistogram(paths, bins, from, to)
+}
+
+// DetectPeaks returns the peaks (or valleys) in the recent sample window of a channel
+func (a *App) DetectPeaks(key server.BufferKey, options server.PeakOptions) ([]server.Peak, error) {
+	return a.server.DetectPeaks(key, options)
+}
+
+// GetPlotData returns the recent samples of a channel reduced to a fixed number of plot points
+func (a *App) GetPlotData(key server.BufferKey, options server.PlotOptions) (server.PlotData, error) {
+	return a.server.GetPlotData(key, options)
+}
+
+// QueryHistory returns the recorded data of a device port between from and to, reduced to
+// at most maxPoints points per channel
+func (a *App) QueryHistory(uuid string, port int, from time.Time, to time.Time, maxPoints int) (server.HistoryData, error) {
+	return a.server.QueryHistory(uuid, port, from, to, maxPoints)
+}
+
+// StartReplay replays recorded segments as a virtual device
+func (a *App) StartReplay(options server.ReplayOptions) (server.Replay, error) {
+	return a.server.StartReplay(options)
+}
+
+// StopReplay stops a running replay
+func (a *App) StopReplay(id string) error {
+	return a.server.StopReplay(id)
+}
+
+// GetReplays returns the running replays
+func (a *App) GetReplays() []server.Replay {
+	return a.server.GetReplays()
+}
+
+// RunBenchmark measures the maximum sustainable ingest rate of this host
+func (a *App) RunBenchmark(options server.BenchmarkOptions) (server.BenchmarkResult, error) {
+	return a.server.RunBenchmark(options)
+}
+
+// AddMarker records a note at the current time in the active sessions and exports
+func (a *App) AddMarker(label string) (server.Marker, error) {
+	return a.server.AddMarker(label)
+}
+
+// GetMarkers returns the markers added since the server started
+func (a *App) GetMarkers() []server.Marker {
+	return a.server.GetMarkers()
+}
+
+// GetDecoders returns the names of the decoders that can be selected for a data port
+func (a *App) GetDecoders() []string {
+	return server.GetDecoders()
+}
+
+// GetChannelAverage returns the average of channel 0 or B (1) of a port in unit, "" for
+// the channel's own unit
+func (a *App) GetChannelAverage(key server.BufferKey, channel int, unit string) (server.ChannelValue, error) {
+	return a.server.GetChannelAverage(key, channel, unit)
+}
+
+// GetChannelUnits returns the unit of each channel of a port
+func (a *App) GetChannelUnits(port int) []string {
+	return server.ChannelUnits(port)
+}
+
+// ConvertUnit converts a value between units of the same quantity, e.g. V to mV
+func (a *App) ConvertUnit(value float64, from string, to string) (float64, error) {
+	return server.ConvertUnit(value, from, to)
+}
+
+// GetChannelSnapshot returns the n most recent scaled samples of each channel of a data port
+func (a *App) GetChannelSnapshot(key server.BufferKey, n int) (server.ChannelSnapshot, error) {
+	return a.server.GetChannelSnapshot(key, n)
+}
+
+// GetStats returns the live statistics of all data channels, also pushed as stats events
+func (a *App) GetStats() server.StatsUpdate {
+	return a.server.GetStats()
+}
+
+// GetDiskUsage returns the space used by recordings and the capture time left on the data volume
+func (a *App) GetDiskUsage() (server.DiskUsage, error) {
+	return a.server.GetDiskUsage()
+}
+
+// ListSessions returns the recorded sessions, newest first
+func (a *App) ListSessions() ([]server.SessionSummary, error) {
+	return a.server.ListSessions()
+}
+
+// GetSessionSummary returns the channel statistics written when a session stopped
+func (a *App) GetSessionSummary(id string) (server.SessionStats, error) {
+	return a.server.GetSessionSummary(id)
+}
+
+// DeleteSession deletes a stopped session and its files
+func (a *App) DeleteSession(id string) error {
+	return a.server.DeleteSession(id)
+}
+
+// ArchiveSession moves a stopped session to destPath
+func (a *App) ArchiveSession(id string, destPath string) error {
+	return a.server.ArchiveSession(id, destPath)
+}
+
+// MergeSession concatenates the segments of one port of a session into one raw file
+func (a *App) MergeSession(id string, port int, dest string) (server.MergeResult, error) {
+	return a.server.MergeSession(id, port, dest)
+}
+
+// ExportSession exports all ports of a session in the background, see GetExportFormats
+func (a *App) ExportSession(id string, format string, opts server.SessionExportOptions) error {
+	return a.server.ExportSession(id, format, opts)
+}
+
+// CancelExport stops the running export of a session
+func (a *App) CancelExport(id string) error {
+	return a.server.CancelExport(id)
+}
+
+// GetExportFormats returns the names of the session export formats
+func (a *App) GetExportFormats() []string {
+	return server.GetExportFormats()
+}
+
+// GetServerStatus returns everything the status bar shows in one call
+func (a *App) GetServerStatus() server.ServerStatus {
+	return a.server.GetServerStatus()
+}
+
+// GetRelayStatus returns the state of the relays of the connected channels
+func (a *App) GetRelayStatus() []server.RelayStatus {
+	return a.server.GetRelayStatus()
+}
+
+// GetMirrorStatus returns the progress of copying segments to the mirror directory
+func (a *App) GetMirrorStatus() server.MirrorStatus {
+	return a.server.GetMirrorStatus()
+}
+
+// GetUploadStatus returns the progress of uploading segments to remote storage
+func (a *App) GetUploadStatus() server.UploadStatus {
+	return a.server.GetUploadStatus()
+}
+
+// GetBandwidthHistory returns the bytes received from a device per day
+func (a *App) GetBandwidthHistory(device string) []server.BandwidthDay {
+	return a.server.GetBandwidthHistory(device)
+}
+
+// GetBandwidthTotals returns the bytes received from every device by UUID
+func (a *App) GetBandwidthTotals() map[string]int64 {
+	return a.server.GetBandwidthTotals()
+}
+
+// SetCompression selects the codec used when flushing a port ("none", "rle4", "zstd", ...)
+func (a *App) SetCompression(port int, codec string) error {
+	return a.server.SetCompression(port, codec)
+}
+
+// GetCompression returns the codec used when flushing a port
+func (a *App) GetCompression(port int) string {
+	return a.server.GetCompression(port)
+}
+
+// GetMisalignments returns the number of connections that ended mid-sample per channel
+func (a *App) GetMisalignments() map[string]int64 {
+	return a.server.GetAllMisalignments()
+}
+
+// GetOutliers returns the number of samples left out of the live values as outliers per channel
+func (a *App) GetOutliers() map[string]int64 {
+	return a.server.GetAllOutliers()
+}
+
+// GetSequenceStats returns the missing, duplicated and reordered frames per channel
+func (a *App) GetSequenceStats() map[string]server.SequenceStats {
+	return a.server.GetSequenceStats()
+}
+
+// GetDeviceStatus returns the latest status message of a device
+func (a *App) GetDeviceStatus(uuid string) (server.DeviceStatus, error) {
+	return a.server.GetDeviceStatus(uuid)
+}
+
+// GetDeviceLatency returns the round trip times of the pings to a device
+func (a *App) GetDeviceLatency(uuid string) (server.RoundTripStats, error) {
+	return a.server.GetDeviceLatency(uuid)
+}
+
+// GetMemoryStats returns the memory held by buffered data, pending writes and device logs
+func (a *App) GetMemoryStats() server.MemoryStats {
+	return a.server.GetMemoryStats()
+}
+
+// GetWriteQueueStats returns the depth and drop counts of the segment write queue
+func (a *App) GetWriteQueueStats() server.WriteQueueStats {
+	return a.server.GetWriteQueueStats()
+}
+
+// VerifyDataDir checks every segment in the data directory against its checksum sidecar
+func (a *App) VerifyDataDir() (server.VerifyResult, error) {
+	return a.server.VerifyDataDir()
+}
+
+// GetCompressionStats returns the compression ratio, throughput and time spent compressing per channel
+func (a *App) GetCompressionStats() map[string]server.CompressionStats {
+	return a.server.GetCompressionStats()
+}
+
+// SetJSONLogging switches the application log between JSON entries and plain text
+func (a *App) SetJSONLogging(enabled bool) {
+	logger.SetJSON(enabled)
+}
+
+// SetSyslogForwarding forwards device log lines to a syslog server ("host[:port]"), "" disables it
+func (a *App) SetSyslogForwarding(address string) error {
+	return a.server.SetSyslogForwarding(address)
+}
+
+// GetSyslogForwarding returns the syslog server device logs are forwarded to
+func (a *App) GetSyslogForwarding() string {
+	return a.server.GetSyslogForwarding()
+}
+
+// GetLogPage returns a page of the device log lines of an IP, filtered by time and severity
+func (a *App) GetLogPage(query server.LogQuery) (server.LogPage, error) {
+	return a.server.GetLogPage(query)
+}
+
+// GetFilteredLogs returns the device log lines of an IP with the given severities, newest first
+func (a *App) GetFilteredLogs(ip string, severities []string) []server.LogEntry {
+	if a.remote != nil {
+		entries, err := a.remote.Logs(ip, severities)
+		if err != nil {
+			logger.Errorf("%v\n", err)
+		}
+		return entries
+	}
+	return a.server.GetFilteredLogs(ip, severities)
+}
+
+// GetAllLogCounts returns the error and warning counts of every device
+func (a *App) GetAllLogCounts() map[string]server.LogCounts {
+	if a.remote != nil {
+		status, err := a.remote.Status()
+		if err != nil {
+			logger.Errorf("%v\n", err)
+		}
+		return status.LogCounts
+	}
+	return a.server.GetAllLogCounts()
+}
+
+// GetAllLogLoss returns the lost and repeated log datagrams of every device
+func (a *App) GetAllLogLoss() map[string]server.LogLossStats {
+	return a.server.GetAllLogLoss()
+}
+
+// GetDiagnostics returns the goroutine count, heap, GC pauses and queue depths of the server
+func (a *App) GetDiagnostics() server.Diagnostics {
+	if a.remote != nil {
+		diagnostics, err := a.remote.Diagnostics()
+		if err != nil {
+			logger.Errorf("%v\n", err)
+		}
+		return diagnostics
+	}
+	return a.server.GetDiagnostics()
+}
+
+// GetIncidents returns the panics and listener failures the server recovered from
+func (a *App) GetIncidents() []server.Incident {
+	return a.server.GetIncidents()
+}
+
+// GetRecentAppLogs returns the most recent application log entries, newest first
+func (a *App) GetRecentAppLogs() []logger.AppLogEntry {
+	return logger.Recent()
+}
+
+// GetSettings returns the settings that can be changed at runtime
+func (a *App) GetSettings() server.Settings {
+	if a.remote != nil {
+		settings, err := a.remote.Settings()
+		if err != nil {
+			logger.Errorf("%v\n", err)
+		}
+		return settings
+	}
+	return a.server.GetSettings()
+}
+
+// UpdateSettings applies new settings and saves them to the config file
+func (a *App) UpdateSettings(settings server.Settings) error {
+	if a.remote != nil {
+		return a.remote.UpdateSettings(settings)
+	}
+	return a.server.UpdateSettings(settings)
+}
+
+// StartRecording starts a recording session for a device
+func (a *App) StartRecording(uuid string) (server.Session, error) {
+	return a.server.StartRecording(uuid)
+}
+
+// StopRecording stops the recording session of a device
+func (a *App) StopRecording(uuid string) error {
+	return a.server.StopRecording(uuid)
+}
+
+// GetActiveSessions returns the sessions currently recording
+func (a *App) GetActiveSessions() []server.Session {
+	return a.server.GetActiveSessions()
+}
+
+// SetAutoRecord enables or disables starting a session when a device connects
+func (a *App) SetAutoRecord(uuid string, enabled bool) error {
+	return a.server.SetAutoRecord(uuid, enabled)
+}
+
+// GetAutoRecord reports whether a session is started when the device connects
+func (a *App) GetAutoRecord(uuid string) bool {
+	return a.server.GetAutoRecord(uuid)
+}
+
+// GetListenerStatus returns which ports are listened on and why binding failed
+func (a *App) GetListenerStatus() []server.ListenerStatus {
+	return a.server.GetListenerStatus()
+}
+
+// GetDevices returns all registered devices
+func (a *App) GetDevices() []server.RegisteredDevice {
+	return a.server.GetDevices()
+}
+
+// EditDevice changes the alias, notes and preferred settings of a registered device
+func (a *App) EditDevice(uuid string, edit server.DeviceEdit) error {
+	return a.server.EditDevice(uuid, edit)
+}
+
+// SetDeviceAlias names a device, an empty name removes the alias
+func (a *App) SetDeviceAlias(uuid string, name string) error {
+	return a.server.SetDeviceAlias(uuid, name)
+}
+
+// GetDeviceHealth returns the availability of every device seen since startup
+func (a *App) GetDeviceHealth() map[string]server.DeviceHealth {
+	return a.server.GetDeviceHealth()
+}
+
+// SetGroup defines a group of devices recorded together, no devices removes the group
+func (a *App) SetGroup(name string, uuids []string) error {
+	return a.server.SetGroup(name, uuids)
+}
+
+// GetGroups returns the device groups by name
+func (a *App) GetGroups() map[string][]string {
+	return a.server.GetGroups()
+}
+
+// StartGroupRecording starts a recording session for every device of a group
+func (a *App) StartGroupRecording(name string) ([]server.Session, error) {
+	return a.server.StartGroupRecording(name)
+}
+
+// StopGroupRecording stops the sessions of a group
+func (a *App) StopGroupRecording(name string) error {
+	return a.server.StopGroupRecording(name)
+}
+
+// ForgetDevice removes a device and keeps, deletes or archives its data files
+func (a *App) ForgetDevice(uuid string, data string) error {
+	return a.server.ForgetDevice(uuid, data)
+}
+
 // Add this method to expose the type
 func (a *App) DUMMYGetIPConnectionDetails(conn server.IPConnection) string {
 	// Just a dummy method to expose the type
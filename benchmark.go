@@ -0,0 +1,41 @@
+package main
+
+import (
+	"eth-daq-software/config"
+	"eth-daq-software/server"
+	"fmt"
+	"time"
+)
+
+// runBenchmark runs an ingest benchmark with the settings of the configuration file and
+// prints the results, so that capture machines can be sized without the GUI
+func runBenchmark(configPath string, devices int) error {
+	cfg, err := config.Load(configPath)
+	configureLogging(cfg, err)
+
+	srv := server.NewServer(cfg)
+	result, err := srv.RunBenchmark(server.BenchmarkOptions{
+		Devices:     devices,
+		StartRate:   1,
+		StepSeconds: 5,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Host: %s, %d connections\n", result.Host, result.Connections)
+	fmt.Printf("%12s %12s %10s %10s %10s %10s %8s\n",
+		"offered MB/s", "ingest MB/s", "p50", "p90", "p99", "max", "dropped")
+	for _, step := range result.Steps {
+		latency := step.FlushLatency
+		fmt.Printf("%12.1f %12.1f %10s %10s %10s %10s %8d\n",
+			step.OfferedRate, step.IngestedRate,
+			latency.P50.Round(time.Microsecond), latency.P90.Round(time.Microsecond),
+			latency.P99.Round(time.Microsecond), latency.Max.Round(time.Microsecond),
+			step.DroppedSegments)
+	}
+	fmt.Printf("Maximum sustained rate: %.1f MB/s\n", result.MaxSustainedRate)
+	fmt.Printf("Memory high-water mark: %.1f MB buffered, %.1f MB heap\n",
+		float64(result.MemoryHighWater)/1e6, float64(result.HeapHighWater)/1e6)
+	return nil
+}
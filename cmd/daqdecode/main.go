@@ -0,0 +1,215 @@
+// daqdecode converts captured data segments, raw .bin files or compressed segments, so
+// captures can be used outside of eth-daq-software.
+//
+// The csv format scales the samples of each port with the decoder configured for it (HS
+// ADC, GADC or thermocouple) and the calibrations of the device, and writes one CSV file
+// per channel with sample indices and timestamps. Segments of the same port and device
+// are joined in capture order. The file names must be those written by the server, as
+// they carry the port and device.
+//
+// Usage:
+//
+//	daqdecode [-format csv|bin|codes] [-config config.yaml] [-calibration calibration.json] [-o dir] segment ...
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"eth-daq-software/server"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	format := flag.String("format", "csv", "output format: csv (scaled samples), bin (raw bytes) or codes (one raw sample code per line)")
+	configPath := flag.String("config", "config.yaml", "configuration selecting the decoders of the data ports")
+	calibrationPath := flag.String("calibration", server.CALIBRATION_FILE, "calibrations applied to the scaled samples, if the file exists")
+	outDir := flag.String("o", "", "output directory (default: next to the input file)")
+	thermocouple := flag.String("thermocouple", "", "type of the thermocouple of thermocouple ports, e.g. J (default: the configured type or K)")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: daqdecode [-format csv|bin|codes] [-config file] [-calibration file] [-thermocouple type] [-o dir] segment ...")
+		os.Exit(2)
+	}
+	if *format != "csv" && *format != "bin" && *format != "codes" {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(2)
+	}
+
+	if *format == "csv" {
+		if err := decodeCSV(flag.Args(), *configPath, *calibrationPath, *outDir, *thermocouple); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		dest, err := decodeFile(path, *format, *outDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s -> %s\n", path, dest)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// channelKey identifies the segments joined into one CSV file
+type channelKey struct {
+	port int
+	ip   string
+	uuid string
+}
+
+// decodeCSV writes the scaled samples of the segments at paths, one CSV file per channel
+func decodeCSV(paths []string, configPath string, calibrationPath string, outDir string, thermocouple string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	if err := server.ConfigureDecoders(cfg); err != nil {
+		return err
+	}
+	calibrations := server.NewCalibrationStore(calibrationPath)
+	if err := calibrations.Load(); err != nil {
+		return err
+	}
+
+	var keys []channelKey
+	channels := make(map[channelKey][]server.SegmentInfo)
+	for _, path := range paths {
+		segment, err := server.ParseSegmentName(path)
+		if err != nil {
+			return err
+		}
+		key := channelKey{port: segment.Port, ip: segment.IP, uuid: segment.UUID}
+		if _, exists := channels[key]; !exists {
+			keys = append(keys, key)
+		}
+		channels[key] = append(channels[key], segment)
+	}
+
+	failed := false
+	for _, key := range keys {
+		segments := channels[key]
+		dest, err := writeChannelCSV(segments, calibrations, outDir, thermocouple)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "port %d of %s: %v\n", key.port, key.uuid, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%d segments of port %d of %s -> %s\n", len(segments), key.port, key.uuid, dest)
+	}
+	if failed {
+		return fmt.Errorf("some channels could not be decoded")
+	}
+	return nil
+}
+
+// writeChannelCSV exports the segments of one channel to a CSV file named after the
+// earliest of them and returns its path
+func writeChannelCSV(segments []server.SegmentInfo, calibrations *server.CalibrationStore, outDir string, thermocouple string) (string, error) {
+	first := segments[0]
+	for _, segment := range segments {
+		if segment.Timestamp < first.Timestamp {
+			first = segment
+		}
+	}
+	dir := filepath.Dir(first.Path)
+	if outDir != "" {
+		dir = outDir
+	}
+	base := strings.TrimSuffix(filepath.Base(first.Path), filepath.Ext(first.Path))
+	dest := filepath.Join(dir, base+".csv")
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	err = server.ExportCSV(writer, segments,
+		calibrations.Get(server.CalibrationKey{UUID: first.UUID, Port: first.Port, Channel: 0}),
+		calibrations.Get(server.CalibrationKey{UUID: first.UUID, Port: first.Port, Channel: 1}),
+		server.ExportOptions{Timestamps: true, Thermocouple: thermocouple})
+	if err != nil {
+		return "", err
+	}
+	return dest, writer.Flush()
+}
+
+// decodeFile decodes one segment and returns the path of the written file
+func decodeFile(path string, format string, outDir string) (string, error) {
+	reader, err := compress.DecompressFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(path)
+	if outDir != "" {
+		dir = outDir
+	}
+	extension := ".bin"
+	if format == "codes" {
+		extension = ".csv"
+	}
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	dest := filepath.Join(dir, base+extension)
+	if dest == path {
+		return "", fmt.Errorf("output would overwrite the input file")
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if format == "codes" {
+		err = writeCodes(writer, reader)
+	} else {
+		_, err = io.Copy(writer, reader)
+	}
+	if err != nil {
+		return "", err
+	}
+	return dest, writer.Flush()
+}
+
+// writeCodes writes the little-endian uint16 sample codes of data, one per line
+func writeCodes(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintln(w, "sample,code"); err != nil {
+		return err
+	}
+	line := make([]byte, 0, 32)
+	for i := 0; i+1 < len(data); i += 2 {
+		line = strconv.AppendInt(line[:0], int64(i/2), 10)
+		line = append(line, ',')
+		line = strconv.AppendUint(line, uint64(binary.LittleEndian.Uint16(data[i:i+2])), 10)
+		line = append(line, '\n')
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
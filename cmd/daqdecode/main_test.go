@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"eth-daq-software/compress"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestDecodeSegment tests the csv, codes and bin formats of a compressed GADC segment of
+// a 0 V and a 1 V sample
+func TestDecodeSegment(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint16(data, 32768)
+	binary.LittleEndian.PutUint16(data[2:], 32768+3200)
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	compressed := compress.AppendSegmentHeader(nil, &compress.Metadata{Port: 5556, StartTime: start.UnixNano()})
+	compressed = compress.HybridRLECompressInto(compressed, data, compress.Options{})
+	base := "port5556_10_0_0_2_dev1_" + strconv.FormatInt(start.Add(2*time.Millisecond).UnixNano(), 10)
+	path := filepath.Join(dir, base+".rle4")
+	if err := os.WriteFile(path, compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	// Missing config and calibration files select the default decoders without calibration
+	if err := decodeCSV([]string{path}, filepath.Join(dir, "config.yaml"), filepath.Join(dir, "calibration.json"), out, ""); err != nil {
+		t.Fatalf("decodeCSV() = %v", err)
+	}
+	want := "sample,time,vgs [V]\n0,2025-03-01T12:00:00Z,0\n1,2025-03-01T12:00:00.001Z,1\n"
+	if got, err := os.ReadFile(filepath.Join(out, base+".csv")); err != nil || string(got) != want {
+		t.Errorf("CSV = %q, %v, want %q", got, err, want)
+	}
+
+	dest, err := decodeFile(path, "codes", out)
+	if err != nil {
+		t.Fatalf("decodeFile() = %v", err)
+	}
+	want = "sample,code\n0,32768\n1,35968\n"
+	if got, _ := os.ReadFile(dest); string(got) != want {
+		t.Errorf("Codes = %q, want %q", got, want)
+	}
+
+	dest, err = decodeFile(path, "bin", out)
+	if err != nil {
+		t.Fatalf("decodeFile() = %v", err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) || filepath.Base(dest) != base+".bin" {
+		t.Errorf("%s = %v, want %v", dest, got, data)
+	}
+	if _, err := decodeFile(dest, "bin", ""); err == nil {
+		t.Error("Expected error for overwriting the input file, got nil")
+	}
+}
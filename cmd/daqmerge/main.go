@@ -0,0 +1,52 @@
+// daqmerge concatenates the segments of one port of a recorded session into a single raw
+// .bin file, for analysis tools that handle one long capture better than many segments.
+// The segments are checked against the session manifests and their checksums, and the
+// gaps in the recording and segments that were never written are listed.
+//
+// Usage:
+//
+//	daqmerge [-o file] session-dir port
+package main
+
+import (
+	"eth-daq-software/server"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+func main() {
+	output := flag.String("o", "", "merged file (default: <session>_port<port>.bin next to the session directory)")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: daqmerge [-o file] session-dir port")
+		os.Exit(2)
+	}
+	dir := filepath.Clean(flag.Arg(0))
+	port, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid port %q\n", flag.Arg(1))
+		os.Exit(2)
+	}
+	dest := *output
+	if dest == "" {
+		dest = filepath.Join(filepath.Dir(dir), fmt.Sprintf("%s_port%d.bin", filepath.Base(dir), port))
+	}
+
+	result, err := server.MergeSegments(dir, port, dest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d segments, %d bytes -> %s\n", result.Segments, result.Bytes, result.Path)
+	for _, sequence := range result.Dropped {
+		fmt.Printf("segment %d was never written\n", sequence)
+	}
+	for _, gap := range result.Gaps {
+		fmt.Printf("gap: %d frames missing from sequence %d, received %s\n",
+			gap.Count, gap.From, gap.Time.Format("2006-01-02 15:04:05.000"))
+	}
+}
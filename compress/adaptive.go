@@ -0,0 +1,160 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// ADAPTIVE_BLOCK_SIZE is the size of the blocks the adaptive codec chooses a codec for
+	ADAPTIVE_BLOCK_SIZE = 1024 * 1024
+	// Block header: codec ID (1 byte) + original length (4 bytes) + payload length (4 bytes)
+	adaptiveBlockHeaderSize = 9
+)
+
+// storeCodec stores data uncompressed
+type storeCodec struct{}
+
+func (storeCodec) ID() byte     { return CodecStore }
+func (storeCodec) Name() string { return "store" }
+
+func (storeCodec) Compress(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
+
+func (storeCodec) Decompress(compressedData []byte) ([]byte, error) {
+	return append([]byte(nil), compressedData...), nil
+}
+
+// BlockStats are the statistics the adaptive codec bases its choice on
+type BlockStats struct {
+	Values      int     // Number of uint16 values in the block
+	MSB12Runs   int     // Number of runs of equal MSB12 values
+	ByteEntropy float64 // Shannon entropy of the bytes in bits per byte
+}
+
+// AnalyzeBlock computes run and entropy statistics of a block
+func AnalyzeBlock(data []byte) BlockStats {
+	stats := BlockStats{Values: (len(data) + 1) / 2}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			stats.ByteEntropy -= p * math.Log2(p)
+		}
+	}
+
+	var previous uint16
+	for i := 0; i+1 < len(data); i += 2 {
+		msb12 := binary.LittleEndian.Uint16(data[i:i+2]) >> 4
+		if i == 0 || msb12 != previous {
+			stats.MSB12Runs++
+		}
+		previous = msb12
+	}
+	if len(data)%2 != 0 {
+		stats.MSB12Runs++
+	}
+	return stats
+}
+
+// chooseCodec picks the codec expected to give the smallest block
+func chooseCodec(data []byte) byte {
+	stats := AnalyzeBlock(data)
+	rawSize := float64(len(data))
+	// RLE4 header + 6 bytes per run or bit-packed MSB12 values, whichever is smaller + packed LSB4 values
+	rleSize := float64(21 + min(stats.MSB12Runs*6, packedMSB12Size(stats.Values)) + (stats.Values+3)/4*2)
+	// A general purpose codec gets within about 10% of the order-0 entropy on ADC data
+	entropySize := stats.ByteEntropy / 8 * rawSize * 1.1
+
+	switch {
+	case rleSize <= entropySize && rleSize < rawSize:
+		return CodecRLE4
+	case entropySize < rawSize*0.9:
+		return CodecZstd
+	default:
+		return CodecStore
+	}
+}
+
+// adaptiveCodec splits data into blocks and compresses each with the codec
+// best suited to its statistics
+type adaptiveCodec struct{}
+
+func (adaptiveCodec) ID() byte     { return CodecAdaptive }
+func (adaptiveCodec) Name() string { return "adaptive" }
+
+// Compress writes a sequence of blocks, each with a header recording its codec:
+// - Codec ID (1 byte)
+// - Original block length (4 bytes, uint32)
+// - Payload length (4 bytes, uint32)
+// - Payload
+func (adaptiveCodec) Compress(data []byte) ([]byte, error) {
+	result := make([]byte, 0, len(data)/2)
+	for offset := 0; offset < len(data); offset += ADAPTIVE_BLOCK_SIZE {
+		block := data[offset:min(offset+ADAPTIVE_BLOCK_SIZE, len(data))]
+
+		codec, err := CodecByID(chooseCodec(block))
+		if err != nil {
+			return nil, err
+		}
+		payload, err := codec.Compress(block)
+		if err != nil {
+			return nil, err
+		}
+		// Never let a block grow
+		if len(payload) >= len(block) {
+			codec, payload = storeCodec{}, block
+		}
+
+		var header [adaptiveBlockHeaderSize]byte
+		header[0] = codec.ID()
+		binary.LittleEndian.PutUint32(header[1:5], uint32(len(block)))
+		binary.LittleEndian.PutUint32(header[5:9], uint32(len(payload)))
+		result = append(result, header[:]...)
+		result = append(result, payload...)
+	}
+	return result, nil
+}
+
+func (adaptiveCodec) Decompress(compressedData []byte) ([]byte, error) {
+	var result []byte
+	for offset := 0; offset < len(compressedData); {
+		if len(compressedData)-offset < adaptiveBlockHeaderSize {
+			return nil, fmt.Errorf("%w: incomplete block header", ErrTruncated)
+		}
+		id := compressedData[offset]
+		originalLength := int(binary.LittleEndian.Uint32(compressedData[offset+1 : offset+5]))
+		payloadLength := int(binary.LittleEndian.Uint32(compressedData[offset+5 : offset+9]))
+		offset += adaptiveBlockHeaderSize
+
+		if id == CodecAdaptive {
+			return nil, fmt.Errorf("nested adaptive block")
+		}
+		if err := checkDecompressedSize(uint64(len(result) + originalLength)); err != nil {
+			return nil, err
+		}
+		if len(compressedData)-offset < payloadLength {
+			return nil, fmt.Errorf("%w: incomplete block payload", ErrTruncated)
+		}
+		codec, err := CodecByID(id)
+		if err != nil {
+			return nil, err
+		}
+		block, err := codec.Decompress(compressedData[offset : offset+payloadLength])
+		if err != nil {
+			return nil, err
+		}
+		if len(block) != originalLength {
+			return nil, fmt.Errorf("block length (%d) doesn't match expected length (%d)", len(block), originalLength)
+		}
+		result = append(result, block...)
+		offset += payloadLength
+	}
+	return result, nil
+}
@@ -0,0 +1,270 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codec IDs stored in the container header
+const (
+	CodecStore byte = 0
+	CodecRLE4  byte = 1
+	CodecZstd  byte = 2
+	CodecLZ4   byte = 3
+	// CodecRLE4Delta is RLE4 with the delta encoding stage
+	CodecRLE4Delta byte = 4
+	// CodecAdaptive chooses between the other codecs per block
+	CodecAdaptive byte = 5
+	// CodecRLE4ZigZag is RLE4 with the zig-zag second difference stage
+	CodecRLE4ZigZag byte = 6
+)
+
+// Codec is a compression algorithm that can be selected for data segments
+type Codec interface {
+	ID() byte
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(compressedData []byte) ([]byte, error)
+}
+
+var (
+	codecs     = make(map[byte]Codec)
+	codecsLock sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(rle4Codec{})
+	RegisterCodec(&zstdCodec{})
+	RegisterCodec(lz4Codec{})
+	RegisterCodec(rle4DeltaCodec{})
+	RegisterCodec(storeCodec{})
+	RegisterCodec(adaptiveCodec{})
+	RegisterCodec(rle4ZigZagCodec{})
+}
+
+// RegisterCodec makes a codec available for compression and automatic decompression.
+// Segments of a NamedCodec are found by its extension.
+func RegisterCodec(codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[codec.ID()] = codec
+}
+
+// CodecByID returns the registered codec with the given ID
+func CodecByID(id byte) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	if codec, exists := codecs[id]; exists {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("unknown codec ID %d", id)
+}
+
+// CodecByName returns the registered codec with the given name
+func CodecByName(name string) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// Container format:
+// - Magic string "DAQC" (4 bytes)
+// - Codec ID (1 byte)
+// - Codec payload
+const (
+	containerMagic      = "DAQC"
+	containerHeaderSize = 5
+)
+
+// Compress compresses data with the given codec and wraps it in a container
+// recording the codec, so that Decompress can pick the right decoder
+func Compress(codec Codec, data []byte) ([]byte, error) {
+	payload, err := codec.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, containerHeaderSize+len(payload))
+	copy(result[0:4], containerMagic)
+	result[4] = codec.ID()
+	copy(result[containerHeaderSize:], payload)
+	return result, nil
+}
+
+// Decompress decompresses a container produced by Compress or CompressIndexed. Bare RLE4
+// data written by HybridRLECompress is also accepted, as are containers following a
+// segment header.
+func Decompress(compressedData []byte) ([]byte, error) {
+	compressedData, err := stripSegmentHeader(compressedData)
+	if err != nil {
+		return nil, err
+	}
+	if IsRLE4(compressedData) {
+		return HybridRLEDecompress(compressedData)
+	}
+	if !IsContainer(compressedData) {
+		return nil, fmt.Errorf("invalid magic string: expected 'DAQS', 'DAQC' or 'RLE4'")
+	}
+	if IsIndexed(compressedData) {
+		return decompressIndexed(compressedData)
+	}
+	codec, err := CodecByID(compressedData[4])
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decompress(compressedData[containerHeaderSize:])
+}
+
+// IsContainer reports whether data starts with a codec container header
+func IsContainer(data []byte) bool {
+	return len(data) >= containerHeaderSize && string(data[0:4]) == containerMagic
+}
+
+// IsRLE4 reports whether data starts with a bare RLE4 header
+func IsRLE4(data []byte) bool {
+	return len(data) >= 4 && string(data[0:4]) == "RLE4"
+}
+
+// rle4Codec is the hybrid MSB12 RLE / LSB4 packing codec
+type rle4Codec struct{}
+
+func (rle4Codec) ID() byte     { return CodecRLE4 }
+func (rle4Codec) Name() string { return "rle4" }
+
+func (rle4Codec) Extension() string { return ".rle4" }
+
+func (rle4Codec) Compress(data []byte) ([]byte, error) {
+	return HybridRLECompress(data), nil
+}
+
+func (rle4Codec) Decompress(compressedData []byte) ([]byte, error) {
+	return HybridRLEDecompress(compressedData)
+}
+
+// rle4DeltaCodec is the hybrid RLE codec with the delta encoding stage
+type rle4DeltaCodec struct{}
+
+func (rle4DeltaCodec) ID() byte     { return CodecRLE4Delta }
+func (rle4DeltaCodec) Name() string { return "rle4-delta" }
+
+func (rle4DeltaCodec) Compress(data []byte) ([]byte, error) {
+	return HybridRLECompressOptions(data, Options{Delta: true}), nil
+}
+
+func (rle4DeltaCodec) Decompress(compressedData []byte) ([]byte, error) {
+	return HybridRLEDecompress(compressedData)
+}
+
+// rle4ZigZagCodec is the hybrid RLE codec with the zig-zag second difference stage
+type rle4ZigZagCodec struct{}
+
+func (rle4ZigZagCodec) ID() byte     { return CodecRLE4ZigZag }
+func (rle4ZigZagCodec) Name() string { return "rle4-zigzag" }
+
+func (rle4ZigZagCodec) Compress(data []byte) ([]byte, error) {
+	return HybridRLECompressOptions(data, Options{ZigZag: true}), nil
+}
+
+func (rle4ZigZagCodec) Decompress(compressedData []byte) ([]byte, error) {
+	return HybridRLEDecompress(compressedData)
+}
+
+// zstdCodec is the Zstandard codec, the encoder is shared since it is safe for concurrent
+// use with EncodeAll
+type zstdCodec struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	err     error
+}
+
+func (*zstdCodec) ID() byte     { return CodecZstd }
+func (*zstdCodec) Name() string { return "zstd" }
+
+func (*zstdCodec) Extension() string { return ".zst" }
+
+func (z *zstdCodec) init() error {
+	z.once.Do(func() {
+		z.encoder, z.err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	})
+	return z.err
+}
+
+func (z *zstdCodec) Compress(data []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.encoder.EncodeAll(data, nil), nil
+}
+
+// Decompress streams the frames through a reader limited to MaxDecompressedSize, as frames
+// need not declare their content size. The decoder's window is limited the same way.
+func (z *zstdCodec) Decompress(compressedData []byte) ([]byte, error) {
+	var header zstd.Header
+	if err := header.Decode(compressedData); err != nil {
+		return nil, err
+	}
+	if header.HasFCS {
+		if err := checkDecompressedSize(header.FrameContentSize); err != nil {
+			return nil, err
+		}
+	}
+	decoder, err := zstd.NewReader(bytes.NewReader(compressedData),
+		zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(MaxDecompressedSize)))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	result, err := io.ReadAll(io.LimitReader(decoder, int64(MaxDecompressedSize)+1))
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, fmt.Errorf("%w: %v", ErrTooLarge, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecompressedSize(uint64(len(result))); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lz4Codec is the LZ4 frame codec
+type lz4Codec struct{}
+
+func (lz4Codec) ID() byte     { return CodecLZ4 }
+func (lz4Codec) Name() string { return "lz4" }
+
+func (lz4Codec) Extension() string { return ".lz4" }
+
+func (lz4Codec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := lz4.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (lz4Codec) Decompress(compressedData []byte) ([]byte, error) {
+	reader := io.LimitReader(lz4.NewReader(bytes.NewReader(compressedData)), int64(MaxDecompressedSize)+1)
+	result, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecompressedSize(uint64(len(result))); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,316 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TestCodecRoundTrip tests every registered codec through the container format
+func TestCodecRoundTrip(t *testing.T) {
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(rand.Intn(16))
+	}
+
+	for _, id := range []byte{CodecStore, CodecRLE4, CodecZstd, CodecLZ4, CodecRLE4Delta, CodecAdaptive, CodecRLE4ZigZag} {
+		codec, err := CodecByID(id)
+		if err != nil {
+			t.Fatalf("Codec %d not registered: %v", id, err)
+		}
+		t.Run(codec.Name(), func(t *testing.T) {
+			compressed, err := Compress(codec, data)
+			if err != nil {
+				t.Fatalf("Failed to compress: %v", err)
+			}
+			if compressed[4] != id {
+				t.Fatalf("Container codec ID = %d, want %d", compressed[4], id)
+			}
+			t.Logf("Compression ratio: %.2f", float64(len(compressed))/float64(len(data)))
+
+			decompressed, err := Decompress(compressed)
+			if err != nil {
+				t.Fatalf("Failed to decompress: %v", err)
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Fatal("Data mismatch after decompression")
+			}
+		})
+	}
+}
+
+// TestDecompressLegacyRLE4 tests that bare RLE4 data is still accepted
+func TestDecompressLegacyRLE4(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7}
+	decompressed, err := Decompress(HybridRLECompress(data))
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Fatal("Data mismatch after decompression")
+	}
+}
+
+// TestDecompressUnknownCodec tests that an unknown codec ID is rejected
+func TestDecompressUnknownCodec(t *testing.T) {
+	if _, err := Decompress([]byte{'D', 'A', 'Q', 'C', 200, 0, 0}); err == nil {
+		t.Fatal("Expected error for unknown codec, got nil")
+	}
+}
+
+// TestAdaptiveCodecChoice tests that the adaptive codec picks a sensible codec per block
+func TestAdaptiveCodecChoice(t *testing.T) {
+	// Long runs of MSB12 values with noisy LSB4 bits compress best with RLE4
+	runs := make([]byte, 8192)
+	for i := 0; i < len(runs); i += 2 {
+		binary.LittleEndian.PutUint16(runs[i:], uint16(0x1230+(i/512)<<4+rand.Intn(16)))
+	}
+	if id := chooseCodec(runs); id != CodecRLE4 {
+		t.Errorf("Runs: chose codec %d, want %d", id, CodecRLE4)
+	}
+
+	// Uniform random bytes can't be compressed
+	noise := make([]byte, 8192)
+	rand.Read(noise)
+	if id := chooseCodec(noise); id != CodecStore {
+		t.Errorf("Noise: chose codec %d, want %d", id, CodecStore)
+	}
+
+	// Noisy values without runs but few distinct bytes suit a general purpose codec
+	limited := make([]byte, 8192)
+	for i := range limited {
+		limited[i] = byte(rand.Intn(4)) << 6
+	}
+	if id := chooseCodec(limited); id != CodecZstd {
+		t.Errorf("Limited alphabet: chose codec %d, want %d", id, CodecZstd)
+	}
+
+	// A mixed stream spanning several blocks round-trips
+	codec, _ := CodecByID(CodecAdaptive)
+	mixed := append(bytes.Repeat(runs, ADAPTIVE_BLOCK_SIZE/len(runs)), noise...)
+	compressed, err := Compress(codec, mixed)
+	if err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	decompressed, err := Decompress(compressed)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	if !bytes.Equal(decompressed, mixed) {
+		t.Fatal("Data mismatch after decompression")
+	}
+}
+
+// TestReadRange tests reading sample ranges from indexed and non-indexed files
+func TestReadRange(t *testing.T) {
+	data := make([]byte, 100001)
+	for i := range data {
+		data[i] = byte(i / 7)
+	}
+	zstd, _ := CodecByName("zstd")
+
+	indexed, err := CompressIndexed(zstd, data, 4097)
+	if err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	decompressed, err := Decompress(indexed)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Fatal("Data mismatch after decompression")
+	}
+
+	dir := t.TempDir()
+	segment, err := AppendIndexed(AppendSegmentHeader(nil, &Metadata{Port: 5556}), zstd, data, 4097)
+	if err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	files := map[string][]byte{
+		"indexed.zst": indexed,
+		"segment.zst": segment,
+		"plain.rle4":  HybridRLECompress(data),
+		"raw.bin":     data,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range [][2]int{{0, 10}, {2047, 2}, {2048, 5000}, {49990, 100}, {60000, 10}} {
+			got, err := ReadRange(path, r[0], r[1])
+			if err != nil {
+				t.Fatalf("%s: ReadRange(%d, %d) failed: %v", name, r[0], r[1], err)
+			}
+			start := min(r[0]*SAMPLE_SIZE, len(data))
+			want := data[start:min(start+r[1]*SAMPLE_SIZE, len(data))]
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s: ReadRange(%d, %d) returned %d bytes, want %d", name, r[0], r[1], len(got), len(want))
+			}
+		}
+	}
+}
+
+// TestSegmentHeader tests that every container can carry metadata in a versioned segment
+// header, and that files without it are read as version 1
+func TestSegmentHeader(t *testing.T) {
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 1000)
+	metadata := &Metadata{UUID: "dev1", Port: 5556, SampleFormat: "uint16le", Scale: 312.5e-6, StartTime: 1}
+	zstd, _ := CodecByName("zstd")
+	lz4, _ := CodecByName("lz4")
+
+	plain, _ := Compress(lz4, data)
+	indexed, _ := CompressIndexed(zstd, data, 1000)
+	containers := map[string][]byte{
+		"rle4":    HybridRLECompress(data),
+		"daqc":    plain,
+		"indexed": indexed,
+	}
+	for name, container := range containers {
+		segment := append(AppendSegmentHeader(nil, metadata), container...)
+
+		if version, err := FormatVersion(segment); err != nil || version != SEGMENT_FORMAT_VERSION {
+			t.Errorf("%s: FormatVersion() = %d, %v, want %d", name, version, err, SEGMENT_FORMAT_VERSION)
+		}
+		if version, err := FormatVersion(container); err != nil || version != 1 {
+			t.Errorf("%s: FormatVersion() without header = %d, %v, want 1", name, version, err)
+		}
+		read, err := ReadMetadata(segment)
+		if err != nil || !reflect.DeepEqual(read, metadata) {
+			t.Errorf("%s: ReadMetadata() = %+v, %v, want %+v", name, read, err, metadata)
+		}
+		if read, err := ReadMetadata(container); err != nil || read != nil {
+			t.Errorf("%s: ReadMetadata() without header = %+v, %v, want nil", name, read, err)
+		}
+		decompressed, err := Decompress(segment)
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Errorf("%s: Decompress() = %d bytes, %v, want the original data", name, len(decompressed), err)
+		}
+	}
+
+	future := append(AppendSegmentHeader(nil, metadata), containers["rle4"]...)
+	future[4] = SEGMENT_FORMAT_VERSION + 1
+	if _, err := Decompress(future); err == nil {
+		t.Error("Expected error for a newer format version, got nil")
+	}
+}
+
+// FuzzDecompress checks that container parsing never panics or exceeds the size limit
+func FuzzDecompress(f *testing.F) {
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 1000)
+	for _, name := range []string{"store", "rle4", "zstd", "lz4", "adaptive"} {
+		codec, _ := CodecByName(name)
+		compressed, _ := Compress(codec, data)
+		f.Add(compressed)
+		indexed, _ := CompressIndexed(codec, data, 1000)
+		f.Add(indexed)
+		f.Add(append(AppendSegmentHeader(nil, &Metadata{Port: 5555}), indexed...))
+	}
+
+	defer func(limit int) { MaxDecompressedSize = limit }(MaxDecompressedSize)
+	MaxDecompressedSize = 1 << 20
+
+	f.Fuzz(func(t *testing.T, compressedData []byte) {
+		result, err := Decompress(compressedData)
+		if err == nil && len(result) > MaxDecompressedSize {
+			t.Fatalf("Decompressed %d bytes, limit is %d", len(result), MaxDecompressedSize)
+		}
+	})
+}
+
+// TestZstdSizeLimit tests that a zstd frame without a content size in its header is cut
+// off at MaxDecompressedSize
+func TestZstdSizeLimit(t *testing.T) {
+	defer func(limit int) { MaxDecompressedSize = limit }(MaxDecompressedSize)
+	MaxDecompressedSize = 1 << 20
+
+	var frame bytes.Buffer
+	writer, err := zstd.NewWriter(&frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		writer.Write(make([]byte, 1<<20))
+	}
+	writer.Close()
+	var header zstd.Header
+	if err := header.Decode(frame.Bytes()); err != nil || header.HasFCS {
+		t.Fatalf("Streamed frame header = %+v, %v, want no content size", header, err)
+	}
+
+	codec, _ := CodecByName("zstd")
+	if _, err := codec.Decompress(frame.Bytes()); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Decompress() of 4 MiB = %v, want ErrTooLarge", err)
+	}
+}
+
+// TestDecompressFileExtension tests that the extension of a segment file decides its
+// format and the header only confirms it
+func TestDecompressFileExtension(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte{1, 2, 3, 4, 5, 6, 7}
+	codec, _ := CodecByName("rle4")
+	compressed, err := Compress(codec, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    []byte
+		wantErr bool
+	}{
+		// Raw samples that happen to start like a header stay raw
+		{"segment.bin", append([]byte("DAQS"), data...), append([]byte("DAQS"), data...), false},
+		{"container.bin", append([]byte("DAQC"), data...), append([]byte("DAQC"), data...), false},
+		{"rle4.bin", append([]byte("RLE4"), make([]byte, 32)...), append([]byte("RLE4"), make([]byte, 32)...), false},
+		// RLE4 segments of old versions are still decompressed
+		{"legacy.bin", HybridRLECompress(data), data, false},
+		{"compressed" + CONTAINER_EXTENSION, compressed, data, false},
+		{"headerless" + CONTAINER_EXTENSION, data, nil, true},
+		{"headerless" + SegmentExtension(codec), data, nil, true},
+		{"unknown.dat", compressed, data, false},
+		{"plain.dat", data, data, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			reader, err := DecompressFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected error, got nil")
+				}
+				if _, err := IsCompressedFile(path); err == nil {
+					t.Fatal("Expected error from IsCompressedFile, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecompressFile() failed: %v", err)
+			}
+			got := new(bytes.Buffer)
+			got.ReadFrom(reader)
+			if !bytes.Equal(got.Bytes(), tt.want) {
+				t.Errorf("DecompressFile() = %v, want %v", got.Bytes(), tt.want)
+			}
+			compressed, err := IsCompressedFile(path)
+			if err != nil {
+				t.Fatalf("IsCompressedFile() failed: %v", err)
+			}
+			if want := !bytes.Equal(tt.content, tt.want); compressed != want {
+				t.Errorf("IsCompressedFile() = %v, want %v", compressed, want)
+			}
+		})
+	}
+}
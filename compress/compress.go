@@ -2,9 +2,36 @@ package compress
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"slices"
+	"sync"
 )
 
+var (
+	// ErrTruncated is returned when compressed data ends before all declared sections
+	ErrTruncated = errors.New("compressed data is truncated")
+	// ErrChecksumMismatch is returned when decompressed data does not match the stored CRC32
+	ErrChecksumMismatch = errors.New("compressed data is corrupt: checksum mismatch")
+	// ErrTooLarge is returned when compressed data declares more than MaxDecompressedSize bytes
+	ErrTooLarge = errors.New("decompressed data exceeds size limit")
+	// ErrCorrupt is returned when the sections of compressed data are inconsistent
+	ErrCorrupt = errors.New("compressed data is corrupt")
+)
+
+// MaxDecompressedSize limits the output of the decompressors, so that a corrupt header
+// cannot make them allocate arbitrary amounts of memory
+var MaxDecompressedSize = 256 * 1024 * 1024
+
+// checkDecompressedSize returns ErrTooLarge if size exceeds MaxDecompressedSize
+func checkDecompressedSize(size uint64) error {
+	if size > uint64(MaxDecompressedSize) {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrTooLarge, size, MaxDecompressedSize)
+	}
+	return nil
+}
+
 // RLEData stores run-length encoded data
 type RLEData struct {
 	Value uint16 // The value that is repeated
@@ -17,8 +44,11 @@ func compressRLE(msb12Bits []uint16) []RLEData {
 	if len(msb12Bits) == 0 {
 		return []RLEData{}
 	}
+	return appendRLE(nil, msb12Bits)
+}
 
-	var result []RLEData
+// appendRLE run-length encodes a non-empty MSB12 array, appending the runs to result
+func appendRLE(result []RLEData, msb12Bits []uint16) []RLEData {
 	currentValue := msb12Bits[0]
 	currentCount := uint32(1)
 	maxCount := uint32(^uint32(0)) // Maximum value of uint32
@@ -205,62 +235,213 @@ func unpackUint16ToLSB4(packedValues []uint16, originalLength int) []uint8 {
 	return unpackedValues
 }
 
-func HybridRLECompress(data []byte) []byte {
-	// Create a padded array if needed
-	// fmt.Println(len(data))
-	paddedArray := data
-	if len(data)%2 != 0 {
-		// Pad with a zero byte at the end
-		paddedArray = make([]byte, len(data)+1)
-		copy(paddedArray, data)
-		// Padding byte is 0x00
-		paddedArray[len(data)] = 0x00
+// packMSB12 bit-packs 12-bit values into packed, two values into three bytes
+func packMSB12(packed []byte, msb12Bits []uint16) {
+	for i := 0; i < len(msb12Bits); i += 2 {
+		offset := i / 2 * 3
+		first := msb12Bits[i] & 0xFFF
+		packed[offset] = byte(first)
+		packed[offset+1] = byte(first >> 8)
+		if i+1 < len(msb12Bits) {
+			second := msb12Bits[i+1] & 0xFFF
+			packed[offset+1] |= byte(second << 4)
+			packed[offset+2] = byte(second >> 4)
+		}
 	}
-	valueCount := len(paddedArray) / 2
+}
 
-	// Create a slice to hold the uint16 values
-	uint16Array := make([]uint16, valueCount)
+// unpackMSB12 reverses packMSB12
+func unpackMSB12(packed []byte, valueCount int) []uint16 {
+	msb12Bits := make([]uint16, valueCount)
+	for i := 0; i < valueCount; i += 2 {
+		offset := i / 2 * 3
+		msb12Bits[i] = uint16(packed[offset]) | uint16(packed[offset+1]&0xF)<<8
+		if i+1 < valueCount {
+			msb12Bits[i+1] = uint16(packed[offset+1]>>4) | uint16(packed[offset+2])<<4
+		}
+	}
+	return msb12Bits
+}
 
-	// Convert the bytes to uint16 values
-	for i := 0; i < valueCount; i++ {
-		// Get the index in the byte array
-		byteIndex := i * 2
+// packedMSB12Size returns the size of valueCount bit-packed 12-bit values
+func packedMSB12Size(valueCount int) int {
+	return (valueCount*3 + 1) / 2
+}
+
+// Flags stored in the flags byte of the RLE4 header
+const (
+	flagPadded byte = 1 << 0 // Data was padded to an even length
+	flagDelta  byte = 1 << 1 // Values were delta encoded before the MSB12/LSB4 split
+	flagCRC32  byte = 1 << 2 // A CRC32 of the original data follows the header
+	// flagMetadata marks a v2 container with a channel metadata section after the CRC32
+	flagMetadata byte = 1 << 3
+	// flagPackedMSB12 marks MSB12 values stored bit-packed instead of as RLE entries
+	flagPackedMSB12 byte = 1 << 4
+	// flagZigZag marks values replaced by zig-zag encoded signed second differences
+	flagZigZag byte = 1 << 5
+)
 
-		// Read 2 bytes and convert to uint16 in little-endian order
-		uint16Array[i] = binary.LittleEndian.Uint16(paddedArray[byteIndex : byteIndex+2])
+// Options selects the optional transform stages of the hybrid RLE compressor
+type Options struct {
+	Delta bool // Delta encode consecutive values before the MSB12/LSB4 split
+	// ZigZag replaces values by their signed second differences, zig-zag encoded so that
+	// small negative and positive values both have zero MSBs. Suited to signed data centred
+	// near zero such as the HS ADC. Takes precedence over Delta.
+	ZigZag bool
+	// Metadata is embedded in the RLE4 header if set, for version 1 files. Segments carry
+	// their metadata in a versioned segment header instead, see AppendSegmentHeader.
+	Metadata *Metadata
+}
+
+// deltaEncode replaces each value by its difference to the previous value, in place
+func deltaEncode(values []uint16) {
+	for i := len(values) - 1; i > 0; i-- {
+		values[i] -= values[i-1]
 	}
-	// Extract 12 most significant bits and 4 least significant bits
-	msb12Bits := make([]uint16, valueCount)
-	lsb4Bits := make([]uint8, valueCount)
+}
 
-	for i, value := range uint16Array {
-		// Extract 12 most significant bits (shift right by 4 bits)
+// deltaDecode reverses deltaEncode, in place
+func deltaDecode(values []uint16) {
+	for i := 1; i < len(values); i++ {
+		values[i] += values[i-1]
+	}
+}
+
+// zigZagEncode replaces each value, read as int16, by its zig-zag encoded second
+// difference, in place
+func zigZagEncode(values []uint16) {
+	var previous, beforePrevious int16
+	for i, value := range values {
+		current := int16(value)
+		residual := current - 2*previous + beforePrevious
+		values[i] = uint16(residual<<1) ^ uint16(residual>>15)
+		beforePrevious, previous = previous, current
+	}
+}
+
+// zigZagDecode reverses zigZagEncode, in place
+func zigZagDecode(values []uint16) {
+	var previous, beforePrevious int16
+	for i, value := range values {
+		residual := int16(value>>1) ^ -int16(value&1)
+		current := residual + 2*previous - beforePrevious
+		values[i] = uint16(current)
+		beforePrevious, previous = previous, current
+	}
+}
+
+// HybridRLECompress compresses data without any transform stage
+func HybridRLECompress(data []byte) []byte {
+	return HybridRLECompressOptions(data, Options{})
+}
+
+// HybridRLECompressOptions compresses data with the selected transform stages,
+// which are recorded in the header so that HybridRLEDecompress can reverse them
+func HybridRLECompressOptions(data []byte, opts Options) []byte {
+	return HybridRLECompressInto(nil, data, opts)
+}
+
+// rle4Scratch holds the intermediate arrays of one compression
+type rle4Scratch struct {
+	values []uint16
+	msb12  []uint16
+	runs   []RLEData
+}
+
+// scratchPool reuses intermediate arrays between compressions, which are as large as
+// the data being compressed
+var scratchPool = sync.Pool{
+	New: func() any { return new(rle4Scratch) },
+}
+
+// growUint16 returns a slice of length n, reusing the capacity of s if possible
+func growUint16(s []uint16, n int) []uint16 {
+	if cap(s) < n {
+		return make([]uint16, n)
+	}
+	return s[:n]
+}
+
+// HybridRLECompressInto compresses data like HybridRLECompressOptions, appending the
+// result to dst and returning the extended slice. Passing a reused dst and pooling the
+// intermediate arrays avoids allocating on every compression.
+func HybridRLECompressInto(dst []byte, data []byte, opts Options) []byte {
+	scratch := scratchPool.Get().(*rle4Scratch)
+	defer scratchPool.Put(scratch)
+
+	// Convert the bytes to uint16 values in little-endian order, padding an odd
+	// length with a zero byte at the end
+	valueCount := (len(data) + 1) / 2
+	values := growUint16(scratch.values, valueCount)
+	scratch.values = values
+	for i := 0; i < len(data)/2; i++ {
+		values[i] = binary.LittleEndian.Uint16(data[i*2 : i*2+2])
+	}
+	if len(data)%2 != 0 {
+		values[valueCount-1] = uint16(data[len(data)-1])
+	}
+	if opts.ZigZag {
+		zigZagEncode(values)
+	} else if opts.Delta {
+		deltaEncode(values)
+	}
+
+	// Extract 12 most significant bits, the 4 least significant bits are packed
+	// straight from values
+	msb12Bits := growUint16(scratch.msb12, valueCount)
+	scratch.msb12 = msb12Bits
+	for i, value := range values {
 		msb12Bits[i] = value >> 4
+	}
 
-		// Extract 4 least significant bits (mask with 0xF)
-		lsb4Bits[i] = uint8(value & 0xF)
+	var compressedRLE []RLEData
+	if valueCount > 0 {
+		compressedRLE = appendRLE(scratch.runs[:0], msb12Bits)
+		scratch.runs = compressedRLE
 	}
+	lsb4Count := (valueCount + 3) / 4
 
-	compressedRLE := compressRLE(msb12Bits)
-	packedLSB4 := packLSB4IntoUint16(lsb4Bits)
+	// Noisy data has short runs, for which bit-packing the MSB12 values is smaller
+	packedMSB12 := len(compressedRLE)*6 > packedMSB12Size(valueCount)
 
 	// Store the compressed data in a binary format with header
 	// Format:
 	// - Magic string "RLE4" (4 bytes)
 	// - Original data length (4 bytes, uint32)
-	// - Number of RLE entries (4 bytes, uint32)
+	// - Number of RLE entries, or of MSB12 values if flag bit 4 is set (4 bytes, uint32)
 	// - Number of LSB4 packed values (4 bytes, uint32)
-	// - Was data padded? (1 byte, uint8: 0=no, 1=yes)
-	// - RLE data entries (each entry is 6 bytes: 2 for Value, 4 for Count)
+	// - Flags (1 byte, bit 0: data was padded, bit 1: delta encoded, bit 2: CRC32 present,
+	//   bit 3: metadata present, bit 4: MSB12 values bit-packed, bit 5: zig-zag second
+	//   differences)
+	// - CRC32 (IEEE) of the original data (4 bytes, uint32, only if flag bit 2 is set)
+	// - Metadata length (4 bytes, uint32) and JSON metadata (only if flag bit 3 is set)
+	// - RLE data entries (each entry is 6 bytes: 2 for Value, 4 for Count), or the
+	//   MSB12 values bit-packed (3 bytes per 2 values) if flag bit 4 is set
 	// - LSB4 packed values (each value is 2 bytes)
 
+	// Encode metadata
+	var metadata []byte
+	if opts.Metadata != nil {
+		metadata = opts.Metadata.encode()
+	}
+
 	// Calculate sizes
-	headerSize := 4 + 4 + 4 + 4 + 1       // Magic + orig len + RLE count + LSB4 count + padding flag
+	headerSize := 4 + 4 + 4 + 4 + 1 + 4 // Magic + orig len + RLE count + LSB4 count + flags + CRC32
+	if metadata != nil {
+		headerSize += 4 + len(metadata)
+	}
 	rleDataSize := len(compressedRLE) * 6 // Each RLE entry is 6 bytes
-	lsb4DataSize := len(packedLSB4) * 2   // Each packed LSB4 value is 2 bytes
+	if packedMSB12 {
+		rleDataSize = packedMSB12Size(valueCount)
+	}
+	lsb4DataSize := lsb4Count * 2 // Each packed LSB4 value is 2 bytes
 
-	// Create result buffer with appropriate size
-	result := make([]byte, headerSize+rleDataSize+lsb4DataSize)
+	// Extend dst by the compressed size, clearing reused capacity
+	start := len(dst)
+	dst = slices.Grow(dst, headerSize+rleDataSize+lsb4DataSize)
+	dst = dst[:start+headerSize+rleDataSize+lsb4DataSize]
+	result := dst[start:]
+	clear(result)
 
 	// Write magic string "RLE4"
 	copy(result[0:4], []byte("RLE4"))
@@ -268,74 +449,174 @@ func HybridRLECompress(data []byte) []byte {
 	// Write original data length
 	binary.LittleEndian.PutUint32(result[4:8], uint32(len(data)))
 
-	// Write number of RLE entries
-	binary.LittleEndian.PutUint32(result[8:12], uint32(len(compressedRLE)))
+	// Write number of RLE entries or MSB12 values
+	if packedMSB12 {
+		binary.LittleEndian.PutUint32(result[8:12], uint32(valueCount))
+	} else {
+		binary.LittleEndian.PutUint32(result[8:12], uint32(len(compressedRLE)))
+	}
 
 	// Write number of LSB4 packed values
-	binary.LittleEndian.PutUint32(result[12:16], uint32(len(packedLSB4)))
+	binary.LittleEndian.PutUint32(result[12:16], uint32(lsb4Count))
 
-	// Write padding flag
+	// Write flags
+	flags := flagCRC32
 	if len(data)%2 != 0 {
-		result[16] = 1 // Data was padded
-	} else {
-		result[16] = 0 // Data was not padded
+		flags |= flagPadded // Data was padded
+	}
+	if opts.ZigZag {
+		flags |= flagZigZag
+	} else if opts.Delta {
+		flags |= flagDelta
+	}
+	if metadata != nil {
+		flags |= flagMetadata
+	}
+	if packedMSB12 {
+		flags |= flagPackedMSB12
+	}
+	result[16] = flags
+
+	// Write CRC32 of the original data
+	binary.LittleEndian.PutUint32(result[17:21], crc32.ChecksumIEEE(data))
+
+	// Write metadata
+	if metadata != nil {
+		binary.LittleEndian.PutUint32(result[21:25], uint32(len(metadata)))
+		copy(result[25:], metadata)
 	}
 
 	// Write RLE data
 	rleOffset := headerSize
-	for _, rle := range compressedRLE {
-		// Write Value (uint16)
-		binary.LittleEndian.PutUint16(result[rleOffset:rleOffset+2], rle.Value)
+	if packedMSB12 {
+		packMSB12(result[rleOffset:rleOffset+rleDataSize], msb12Bits)
+	} else {
+		for _, rle := range compressedRLE {
+			// Write Value (uint16)
+			binary.LittleEndian.PutUint16(result[rleOffset:rleOffset+2], rle.Value)
 
-		// Write Count (uint32)
-		binary.LittleEndian.PutUint32(result[rleOffset+2:rleOffset+6], rle.Count)
+			// Write Count (uint32)
+			binary.LittleEndian.PutUint32(result[rleOffset+2:rleOffset+6], rle.Count)
 
-		// Move to next RLE entry
-		rleOffset += 6
+			// Move to next RLE entry
+			rleOffset += 6
+		}
 	}
 
-	// Write LSB4 packed values
-	lsb4Offset := headerSize + rleDataSize
-	for _, lsb4 := range packedLSB4 {
-		binary.LittleEndian.PutUint16(result[lsb4Offset:lsb4Offset+2], lsb4)
-		lsb4Offset += 2
+	// Write LSB4 packed values, four to each little-endian uint16, so two to each
+	// byte with the first value in the low nibble
+	lsb4 := result[headerSize+rleDataSize:]
+	for i, value := range values {
+		lsb4[i/2] |= byte(value&0xF) << ((i % 2) * 4)
 	}
 
-	return result
+	return dst
 }
 
-// HybridRLEDecompress decompresses data that was compressed with HybridRLECompress
-func HybridRLEDecompress(compressedData []byte) ([]byte, error) {
+// rle4Header is the parsed header of an RLE4 container
+type rle4Header struct {
+	originalLength uint32
+	rleEntryCount  uint32
+	lsb4Count      uint32
+	flags          byte
+	checksum       uint32
+	metadata       []byte // JSON metadata, nil for v1 containers
+	size           int    // Size of the header including the optional sections
+}
+
+// parseRLE4Header parses and validates the header of an RLE4 container
+func parseRLE4Header(compressedData []byte) (rle4Header, error) {
 	// Check if there's enough data for the header
 	if len(compressedData) < 17 {
-		return nil, fmt.Errorf("compressed data too short to contain valid header")
+		return rle4Header{}, fmt.Errorf("compressed data too short to contain valid header")
 	}
 
 	// Check magic string
 	if string(compressedData[0:4]) != "RLE4" {
-		return nil, fmt.Errorf("invalid magic string: expected 'RLE4'")
+		return rle4Header{}, fmt.Errorf("invalid magic string: expected 'RLE4'")
 	}
 
 	// Read header data
-	originalLength := binary.LittleEndian.Uint32(compressedData[4:8])
-	rleEntryCount := binary.LittleEndian.Uint32(compressedData[8:12])
-	lsb4Count := binary.LittleEndian.Uint32(compressedData[12:16])
-	wasPadded := compressedData[16] == 1
+	header := rle4Header{
+		originalLength: binary.LittleEndian.Uint32(compressedData[4:8]),
+		rleEntryCount:  binary.LittleEndian.Uint32(compressedData[8:12]),
+		lsb4Count:      binary.LittleEndian.Uint32(compressedData[12:16]),
+		flags:          compressedData[16],
+		size:           17,
+	}
+
+	if header.flags&flagCRC32 != 0 {
+		if len(compressedData) < header.size+4 {
+			return rle4Header{}, fmt.Errorf("%w: missing checksum", ErrTruncated)
+		}
+		header.checksum = binary.LittleEndian.Uint32(compressedData[header.size : header.size+4])
+		header.size += 4
+	}
+
+	if header.flags&flagMetadata != 0 {
+		if len(compressedData) < header.size+4 {
+			return rle4Header{}, fmt.Errorf("%w: missing metadata length", ErrTruncated)
+		}
+		metadataLength := int(binary.LittleEndian.Uint32(compressedData[header.size : header.size+4]))
+		header.size += 4
+		if len(compressedData)-header.size < metadataLength {
+			return rle4Header{}, fmt.Errorf("%w: incomplete metadata", ErrTruncated)
+		}
+		header.metadata = compressedData[header.size : header.size+metadataLength]
+		header.size += metadataLength
+	}
+
+	return header, nil
+}
+
+// HybridRLEDecompress decompresses data that was compressed with HybridRLECompress
+func HybridRLEDecompress(compressedData []byte) ([]byte, error) {
+	header, err := parseRLE4Header(compressedData)
+	if err != nil {
+		return nil, err
+	}
+	originalLength := header.originalLength
+	rleEntryCount := header.rleEntryCount
+	lsb4Count := header.lsb4Count
+	flags := header.flags
+	wasPadded := flags&flagPadded != 0
 
 	// Calculate offsets
-	headerSize := 17
+	headerSize := header.size
+	checksum := header.checksum
 	rleDataSize := int(rleEntryCount) * 6
+	if flags&flagPackedMSB12 != 0 {
+		rleDataSize = packedMSB12Size(int(rleEntryCount))
+	}
 	rleOffset := headerSize
 	lsb4Offset := headerSize + rleDataSize
 
 	// Ensure the compressed data contains all expected sections
 	if len(compressedData) < headerSize+rleDataSize+int(lsb4Count)*2 {
-		return nil, fmt.Errorf("compressed data is too short to contain all expected sections")
+		return nil, fmt.Errorf("%w: too short to contain all expected sections", ErrTruncated)
+	}
+
+	// Check the declared counts against the original length before allocating anything
+	if err := checkDecompressedSize(uint64(originalLength)); err != nil {
+		return nil, err
+	}
+	if wasPadded != (originalLength%2 != 0) {
+		return nil, fmt.Errorf("%w: padding flag doesn't match original length %d", ErrCorrupt, originalLength)
+	}
+	expectedValues := (int(originalLength) + 1) / 2
+	if int(lsb4Count) != (expectedValues+3)/4 {
+		return nil, fmt.Errorf("%w: %d LSB4 values for %d samples", ErrCorrupt, lsb4Count, expectedValues)
+	}
+	if flags&flagPackedMSB12 != 0 && int(rleEntryCount) != expectedValues {
+		return nil, fmt.Errorf("%w: %d packed MSB12 values for %d samples", ErrCorrupt, rleEntryCount, expectedValues)
 	}
 
 	// Read RLE data
-	compressedRLE := make([]RLEData, rleEntryCount)
-	for i := 0; i < int(rleEntryCount); i++ {
+	var compressedRLE []RLEData
+	if flags&flagPackedMSB12 == 0 {
+		compressedRLE = make([]RLEData, rleEntryCount)
+	}
+	for i := range compressedRLE {
 		// Read Value (uint16)
 		value := binary.LittleEndian.Uint16(compressedData[rleOffset : rleOffset+2])
 
@@ -360,13 +641,23 @@ func HybridRLEDecompress(compressedData []byte) ([]byte, error) {
 
 	// Calculate how many values we expect after decompression
 	// This should match the number of values we compressed
+	var msb12Bits []uint16
 	valueCount := 0
-	for _, rle := range compressedRLE {
-		valueCount += int(rle.Count)
-	}
+	if flags&flagPackedMSB12 != 0 {
+		// Unpack bit-packed MSB12 values
+		valueCount = int(rleEntryCount)
+		msb12Bits = unpackMSB12(compressedData[rleOffset:rleOffset+rleDataSize], valueCount)
+	} else {
+		for _, rle := range compressedRLE {
+			valueCount += int(rle.Count)
+		}
+		if valueCount != expectedValues {
+			return nil, fmt.Errorf("%w: RLE counts sum to %d values, expected %d", ErrCorrupt, valueCount, expectedValues)
+		}
 
-	// Decompress RLE data
-	msb12Bits := decompressRLE(compressedRLE, valueCount)
+		// Decompress RLE data
+		msb12Bits = decompressRLE(compressedRLE, valueCount)
+	}
 
 	// Unpack LSB4 values
 	lsb4Bits := unpackUint16ToLSB4(packedLSB4, valueCount)
@@ -378,6 +669,11 @@ func HybridRLEDecompress(compressedData []byte) ([]byte, error) {
 		lsb4 := uint16(lsb4Bits[i])
 		uint16Array[i] = (msb12 << 4) | lsb4
 	}
+	if flags&flagZigZag != 0 {
+		zigZagDecode(uint16Array)
+	} else if flags&flagDelta != 0 {
+		deltaDecode(uint16Array)
+	}
 
 	// Convert uint16 values back to bytes
 	result := make([]byte, valueCount*2)
@@ -396,5 +692,10 @@ func HybridRLEDecompress(compressedData []byte) ([]byte, error) {
 		return nil, fmt.Errorf("decompressed data length (%d) doesn't match expected length (%d)", len(result), originalLength)
 	}
 
+	// Verify the checksum
+	if flags&flagCRC32 != 0 && crc32.ChecksumIEEE(result) != checksum {
+		return nil, ErrChecksumMismatch
+	}
+
 	return result, nil
 }
@@ -3,6 +3,8 @@ package compress
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"math"
 	"math/rand"
 	"reflect"
 	"testing"
@@ -874,3 +876,289 @@ func TestCompressRLEUnrolledRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+// TestDeltaRoundTrip tests compression and decompression with the delta encoding stage
+func TestDeltaRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "Empty data",
+			data: []byte{},
+		},
+		{
+			name: "Odd length data",
+			data: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name: "Wrapping values",
+			data: []byte{0xFF, 0xFF, 0x00, 0x00, 0xFF, 0xFF, 0x01, 0x00},
+		},
+		{
+			name: "Slow ramp",
+			data: func() []byte {
+				result := make([]byte, 20000)
+				for i := 0; i < len(result)/2; i++ {
+					binary.LittleEndian.PutUint16(result[i*2:], uint16(1000+i/8))
+				}
+				return result
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := HybridRLECompressOptions(tt.data, Options{Delta: true})
+			if compressed[16]&flagDelta == 0 {
+				t.Fatal("Delta flag not set in header")
+			}
+
+			decompressed, err := HybridRLEDecompress(compressed)
+			if err != nil {
+				t.Fatalf("Failed to decompress: %v", err)
+			}
+			if !bytes.Equal(decompressed, tt.data) {
+				t.Fatalf("Data mismatch after decompression\nOriginal: %v\nDecompressed: %v",
+					tt.data, decompressed)
+			}
+		})
+	}
+}
+
+// TestDeltaImprovesRamp checks that delta encoding helps on slowly varying data
+func TestDeltaImprovesRamp(t *testing.T) {
+	data := make([]byte, 200000)
+	for i := 0; i < len(data)/2; i++ {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(i))
+	}
+
+	plain := HybridRLECompress(data)
+	delta := HybridRLECompressOptions(data, Options{Delta: true})
+	t.Logf("Ramp compressed size: plain %d bytes, delta %d bytes", len(plain), len(delta))
+	if len(delta) >= len(plain) {
+		t.Fatalf("Delta encoding did not improve compression: %d >= %d", len(delta), len(plain))
+	}
+}
+
+// TestChecksumDetectsCorruption tests that corrupted and truncated data is reported
+func TestChecksumDetectsCorruption(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(rand.Intn(256))
+	}
+	compressed := HybridRLECompress(data)
+	if compressed[16]&flagCRC32 == 0 {
+		t.Fatal("CRC32 flag not set in header")
+	}
+
+	// Flip a bit in the last LSB4 packed value, which keeps all lengths consistent
+	corrupted := append([]byte(nil), compressed...)
+	corrupted[len(corrupted)-1] ^= 0x01
+	if _, err := HybridRLEDecompress(corrupted); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+	}
+
+	truncated := compressed[:len(compressed)-10]
+	if _, err := HybridRLEDecompress(truncated); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Expected ErrTruncated, got %v", err)
+	}
+}
+
+// TestMetadataRoundTrip tests that v2 containers carry metadata and v1 containers still decode
+func TestMetadataRoundTrip(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}
+	metadata := &Metadata{
+		UUID:         "0123-4567",
+		Port:         5556,
+		SampleFormat: "uint16le",
+		Scale:        312.5e-6,
+		Offset:       -10.24,
+		StartTime:    1740578198048699000,
+	}
+
+	compressed := HybridRLECompressOptions(data, Options{Metadata: metadata})
+	decompressed, err := HybridRLEDecompress(compressed)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Fatal("Data mismatch after decompression")
+	}
+
+	read, err := ReadMetadata(compressed)
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if !reflect.DeepEqual(read, metadata) {
+		t.Fatalf("Metadata mismatch\nWritten: %+v\nRead: %+v", metadata, read)
+	}
+
+	// v1 containers have no metadata
+	read, err = ReadMetadata(HybridRLECompress(data))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if read != nil {
+		t.Fatalf("Expected no metadata in v1 container, got %+v", read)
+	}
+}
+
+// TestPackedMSB12 tests that noisy data is stored bit-packed and still round-trips
+func TestPackedMSB12(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 6, 1001, 100000} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		compressed := HybridRLECompress(data)
+		if size >= 6 && compressed[16]&flagPackedMSB12 == 0 {
+			t.Errorf("Size %d: expected noisy data to be bit-packed", size)
+		}
+		if size >= 1001 && len(compressed) > size*101/100+32 {
+			t.Errorf("Size %d: noisy data expanded to %d bytes", size, len(compressed))
+		}
+
+		decompressed, err := HybridRLEDecompress(compressed)
+		if err != nil {
+			t.Fatalf("Size %d: failed to decompress: %v", size, err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Fatalf("Size %d: data mismatch after decompression", size)
+		}
+	}
+}
+
+// TestCompressInto tests that compressing into a reused destination matches HybridRLECompress
+func TestCompressInto(t *testing.T) {
+	dst := []byte("prefix")
+	for _, size := range []int{1001, 10, 0, 100000} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i / 100)
+		}
+
+		dst = HybridRLECompressInto(dst[:6], data, Options{})
+		if string(dst[:6]) != "prefix" {
+			t.Fatal("Destination prefix was overwritten")
+		}
+		if !bytes.Equal(dst[6:], HybridRLECompress(data)) {
+			t.Fatalf("Size %d: output differs from HybridRLECompress", size)
+		}
+	}
+}
+
+// TestDecompressLimits tests that inconsistent and oversized headers are rejected before allocating
+func TestDecompressLimits(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i / 50)
+	}
+	compressed := HybridRLECompress(data)
+
+	// Declare a huge original length
+	huge := append([]byte(nil), compressed...)
+	binary.LittleEndian.PutUint32(huge[4:8], 0xFFFFFFF0)
+	if _, err := HybridRLEDecompress(huge); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Expected ErrTooLarge, got %v", err)
+	}
+
+	// Inflate the count of the first run
+	inflated := append([]byte(nil), compressed...)
+	binary.LittleEndian.PutUint32(inflated[21+2:21+6], 0x7FFFFFFF)
+	if _, err := HybridRLEDecompress(inflated); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Expected ErrCorrupt, got %v", err)
+	}
+
+	// Lower the limit below the data size
+	defer func(limit int) { MaxDecompressedSize = limit }(MaxDecompressedSize)
+	MaxDecompressedSize = 999
+	if _, err := HybridRLEDecompress(compressed); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+// FuzzHybridRLEDecompress checks that the decompressor never panics or exceeds the size limit
+func FuzzHybridRLEDecompress(f *testing.F) {
+	f.Add(HybridRLECompress([]byte{1, 2, 3, 4, 5}))
+	f.Add(HybridRLECompressOptions(bytes.Repeat([]byte{0x12, 0x34}, 100), Options{Delta: true}))
+	f.Add(HybridRLECompressOptions(make([]byte, 33), Options{Metadata: &Metadata{Port: 5555}}))
+	noisy := make([]byte, 64)
+	rand.Read(noisy)
+	f.Add(HybridRLECompress(noisy))
+
+	defer func(limit int) { MaxDecompressedSize = limit }(MaxDecompressedSize)
+	MaxDecompressedSize = 1 << 20
+
+	f.Fuzz(func(t *testing.T, compressedData []byte) {
+		result, err := HybridRLEDecompress(compressedData)
+		if err == nil && len(result) > MaxDecompressedSize {
+			t.Fatalf("Decompressed %d bytes, limit is %d", len(result), MaxDecompressedSize)
+		}
+		// Must not panic either
+		ReadMetadata(compressedData)
+	})
+}
+
+// simulatedVds returns signed int16 samples of a slow sine centred on zero with a few
+// LSBs of noise, resembling an HS ADC capture
+func simulatedVds(samples int) []byte {
+	data := make([]byte, samples*2)
+	for i := 0; i < samples; i++ {
+		value := 400*math.Sin(float64(i)/2000) + float64(rand.Intn(5)-2)
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(int16(value)))
+	}
+	return data
+}
+
+// TestZigZagRoundTrip tests the zig-zag second difference stage
+func TestZigZagRoundTrip(t *testing.T) {
+	tests := [][]byte{
+		{},
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		{0xFF, 0x7F, 0x00, 0x80, 0xFF, 0x7F, 0x01, 0x80},
+		simulatedVds(50000),
+	}
+	for _, data := range tests {
+		compressed := HybridRLECompressOptions(data, Options{ZigZag: true, Delta: true})
+		if compressed[16]&flagZigZag == 0 || compressed[16]&flagDelta != 0 {
+			t.Fatalf("Unexpected flags %08b", compressed[16])
+		}
+		decompressed, err := HybridRLEDecompress(compressed)
+		if err != nil {
+			t.Fatalf("Failed to decompress: %v", err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Fatalf("Data mismatch after decompression of %d bytes", len(data))
+		}
+	}
+
+	data := simulatedVds(200000)
+	plain := HybridRLECompress(data)
+	zigZag := HybridRLECompressOptions(data, Options{ZigZag: true})
+	t.Logf("Vds compressed size: plain %d bytes, zig-zag %d bytes", len(plain), len(zigZag))
+	if len(zigZag) >= len(plain) {
+		t.Fatalf("Zig-zag encoding did not improve compression: %d >= %d", len(zigZag), len(plain))
+	}
+}
+
+// BenchmarkTransforms compares the transform stages on simulated Vds data
+func BenchmarkTransforms(b *testing.B) {
+	data := simulatedVds(1024 * 1024)
+	for _, bm := range []struct {
+		name string
+		opts Options
+	}{
+		{"plain", Options{}},
+		{"delta", Options{Delta: true}},
+		{"zigzag", Options{ZigZag: true}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var compressed []byte
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				compressed = HybridRLECompressInto(compressed[:0], data, bm.opts)
+			}
+			b.ReportMetric(float64(len(data))/float64(len(compressed)), "ratio")
+		})
+	}
+}
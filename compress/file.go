@@ -0,0 +1,143 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Segment file extensions
+const (
+	RAW_EXTENSION       = ".bin"  // Uncompressed segments, and RLE4 segments of old versions
+	CONTAINER_EXTENSION = ".daqc" // Containers of codecs without an extension of their own
+)
+
+// NamedCodec is a codec whose segments have a file extension of their own, such as ".zst".
+// Segments of other codecs are named CONTAINER_EXTENSION. Readers do not depend on the
+// extension, segments are decompressed according to their header.
+type NamedCodec interface {
+	Codec
+	Extension() string
+}
+
+// SegmentExtension returns the file extension of segments written with codec, nil writes
+// raw segments
+func SegmentExtension(codec Codec) string {
+	if codec == nil {
+		return RAW_EXTENSION
+	}
+	if named, ok := codec.(NamedCodec); ok {
+		return named.Extension()
+	}
+	return CONTAINER_EXTENSION
+}
+
+// IsSegmentExtension reports whether ext is the extension of raw segments, containers or a
+// registered codec
+func IsSegmentExtension(ext string) bool {
+	if ext == RAW_EXTENSION || ext == CONTAINER_EXTENSION {
+		return true
+	}
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	for _, codec := range codecs {
+		if named, ok := codec.(NamedCodec); ok && named.Extension() == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCompressed reports whether data starts with a segment, RLE4 or codec container header
+func IsCompressed(data []byte) bool {
+	return IsSegment(data) || IsRLE4(data) || IsContainer(data)
+}
+
+// isLegacySegment reports whether data of a RAW_EXTENSION file is an RLE4 segment of an
+// old version rather than raw samples that happen to start with "RLE4". The header must
+// account for exactly the size of the file.
+func isLegacySegment(data []byte) bool {
+	if !IsRLE4(data) {
+		return false
+	}
+	header, err := parseRLE4Header(data)
+	if err != nil {
+		return false
+	}
+	rleDataSize := uint64(header.rleEntryCount) * 6
+	if header.flags&flagPackedMSB12 != 0 {
+		rleDataSize = uint64(packedMSB12Size(int(header.rleEntryCount)))
+	}
+	return uint64(len(data)) == uint64(header.size)+rleDataSize+uint64(header.lsb4Count)*2
+}
+
+// isCompressedSegment reports whether the data of a segment file is to be decompressed.
+// The extension decides: raw files are only decompressed if they are RLE4 segments of old
+// versions, files with a codec's extension must start with a header, see
+// DecompressFile. Files with other extensions are recognized by their header alone.
+func isCompressedSegment(path string, data []byte) (bool, error) {
+	ext := filepath.Ext(path)
+	switch {
+	case ext == RAW_EXTENSION:
+		return isLegacySegment(data), nil
+	case IsSegmentExtension(ext):
+		if !IsCompressed(data) {
+			return false, fmt.Errorf("%s has no segment, RLE4 or container header", path)
+		}
+		return true, nil
+	default:
+		return IsCompressed(data), nil
+	}
+}
+
+// IsCompressedFile reports whether a segment file is compressed, see DecompressFile
+func IsCompressedFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	header := make([]byte, containerHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	if filepath.Ext(path) == RAW_EXTENSION && IsRLE4(header[:n]) {
+		// Checking an old RLE4 segment needs all of it
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		return isLegacySegment(data), nil
+	}
+	return isCompressedSegment(path, header[:n])
+}
+
+// DecompressFile reads a segment file and returns a reader over its original data. The
+// format follows from the extension and is confirmed by the header: RAW_EXTENSION files
+// are returned as is, unless they are complete RLE4 segments of old versions. Files with
+// the extension of a codec must start with a segment, RLE4 or container header and are
+// decompressed according to it, so raw and compressed segments can be mixed. Files with
+// other extensions are decompressed if they start with one of these headers and returned
+// as is otherwise.
+func DecompressFile(path string) (io.Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := isCompressedSegment(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if !compressed {
+		return bytes.NewReader(data), nil
+	}
+
+	decompressed, err := Decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return bytes.NewReader(decompressed), nil
+}
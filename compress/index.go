@@ -0,0 +1,271 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// SAMPLE_SIZE is the size of one ADC sample in bytes
+const SAMPLE_SIZE = 2
+
+// Indexed container format:
+//   - Container header with the indexFlag bit set in the codec ID
+//   - Blocks, each compressed independently with the codec
+//   - Index entries (16 bytes each: first sample of the block and byte offset of the
+//     block in the container, both uint64)
+//   - Original data length (8 bytes, uint64)
+//   - Number of index entries (4 bytes, uint32)
+//   - Magic string "DAQI" (4 bytes)
+const (
+	indexFlag       byte = 0x80
+	indexMagic           = "DAQI"
+	indexEntrySize       = 16
+	indexFooterSize      = 16 // Original length + entry count + magic
+)
+
+// indexEntry maps the first sample of a block to the block's offset in the container
+type indexEntry struct {
+	firstSample uint64
+	offset      uint64
+}
+
+// blockIndex is the parsed footer index of an indexed container
+type blockIndex struct {
+	entries        []indexEntry
+	originalLength uint64
+	end            int64 // Offset of the first index entry, where the last block ends
+}
+
+// CompressIndexed compresses data in blocks of blockSize bytes with the given codec and
+// appends an index of the blocks, so that ReadRange can decompress only the blocks
+// holding the requested samples
+func CompressIndexed(codec Codec, data []byte, blockSize int) ([]byte, error) {
+	return AppendIndexed(make([]byte, 0, containerHeaderSize+len(data)/2), codec, data, blockSize)
+}
+
+// AppendIndexed appends the indexed container of data to dst, see CompressIndexed. Offsets
+// in the index are relative to the start of the container.
+func AppendIndexed(dst []byte, codec Codec, data []byte, blockSize int) ([]byte, error) {
+	// Keep blocks aligned to whole samples
+	blockSize -= blockSize % SAMPLE_SIZE
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %d", blockSize)
+	}
+
+	start := len(dst)
+	result := append(dst, containerMagic...)
+	result = append(result, codec.ID()|indexFlag)
+
+	var entries []indexEntry
+	for offset := 0; offset < len(data); offset += blockSize {
+		block := data[offset:min(offset+blockSize, len(data))]
+		payload, err := codec.Compress(block)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, indexEntry{
+			firstSample: uint64(offset / SAMPLE_SIZE),
+			offset:      uint64(len(result) - start),
+		})
+		result = append(result, payload...)
+	}
+
+	for _, entry := range entries {
+		result = binary.LittleEndian.AppendUint64(result, entry.firstSample)
+		result = binary.LittleEndian.AppendUint64(result, entry.offset)
+	}
+	result = binary.LittleEndian.AppendUint64(result, uint64(len(data)))
+	result = binary.LittleEndian.AppendUint32(result, uint32(len(entries)))
+	result = append(result, indexMagic...)
+	return result, nil
+}
+
+// IsIndexed reports whether data starts with an indexed container header
+func IsIndexed(data []byte) bool {
+	return IsContainer(data) && data[4]&indexFlag != 0
+}
+
+// readIndex reads the footer index of an indexed container of the given size
+func readIndex(r io.ReaderAt, size int64) (blockIndex, error) {
+	if size < containerHeaderSize+indexFooterSize {
+		return blockIndex{}, fmt.Errorf("%w: missing index footer", ErrTruncated)
+	}
+	var footer [indexFooterSize]byte
+	if _, err := r.ReadAt(footer[:], size-indexFooterSize); err != nil {
+		return blockIndex{}, err
+	}
+	if string(footer[12:16]) != indexMagic {
+		return blockIndex{}, fmt.Errorf("invalid index magic string: expected 'DAQI'")
+	}
+
+	index := blockIndex{originalLength: binary.LittleEndian.Uint64(footer[0:8])}
+	count := int64(binary.LittleEndian.Uint32(footer[8:12]))
+	index.end = size - indexFooterSize - count*indexEntrySize
+	if index.end < containerHeaderSize {
+		return blockIndex{}, fmt.Errorf("%w: incomplete index", ErrTruncated)
+	}
+
+	entries := make([]byte, count*indexEntrySize)
+	if _, err := r.ReadAt(entries, index.end); err != nil {
+		return blockIndex{}, err
+	}
+	index.entries = make([]indexEntry, count)
+	for i := range index.entries {
+		entry := entries[i*indexEntrySize:]
+		index.entries[i] = indexEntry{
+			firstSample: binary.LittleEndian.Uint64(entry[0:8]),
+			offset:      binary.LittleEndian.Uint64(entry[8:16]),
+		}
+		if index.entries[i].offset < containerHeaderSize || index.entries[i].offset > uint64(index.end) ||
+			(i > 0 && index.entries[i].offset < index.entries[i-1].offset) {
+			return blockIndex{}, fmt.Errorf("invalid index entry %d", i)
+		}
+	}
+	return index, nil
+}
+
+// blockEnd returns the offset where block i of the index ends
+func (index blockIndex) blockEnd(i int) int64 {
+	if i+1 < len(index.entries) {
+		return int64(index.entries[i+1].offset)
+	}
+	return index.end
+}
+
+// decompressIndexed decompresses all blocks of an indexed container
+func decompressIndexed(compressedData []byte) ([]byte, error) {
+	codec, err := CodecByID(compressedData[4] &^ indexFlag)
+	if err != nil {
+		return nil, err
+	}
+	index, err := readIndex(sliceReaderAt(compressedData), int64(len(compressedData)))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecompressedSize(index.originalLength); err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, index.originalLength)
+	for i, entry := range index.entries {
+		block, err := codec.Decompress(compressedData[entry.offset:index.blockEnd(i)])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, block...)
+		if uint64(len(result)) > index.originalLength {
+			return nil, fmt.Errorf("%w: blocks exceed original length %d", ErrCorrupt, index.originalLength)
+		}
+	}
+	if uint64(len(result)) != index.originalLength {
+		return nil, fmt.Errorf("decompressed data length (%d) doesn't match expected length (%d)", len(result), index.originalLength)
+	}
+	return result, nil
+}
+
+// sliceReaderAt reads from a byte slice at an offset
+type sliceReaderAt []byte
+
+func (s sliceReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 || offset > int64(len(s)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// ReadRange returns the original bytes of count samples starting at firstSample from a
+// segment file. Indexed containers only decompress the blocks holding the samples, any
+// other file is decompressed as a whole. The range is clipped to the end of the data.
+func ReadRange(path string, firstSample, count int) ([]byte, error) {
+	if firstSample < 0 || count < 0 {
+		return nil, fmt.Errorf("invalid range %d+%d", firstSample, count)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the segment header, the index is relative to the container
+	var container io.ReaderAt = file
+	size := info.Size()
+	header := make([]byte, segmentHeaderSize)
+	n, _ := io.ReadFull(file, header)
+	if IsSegment(header[:n]) && n == segmentHeaderSize {
+		if version := header[4]; version < 2 || version > SEGMENT_FORMAT_VERSION {
+			return nil, fmt.Errorf("unsupported segment format version %d in %s", version, path)
+		}
+		headerSize := int64(segmentHeaderSize) + int64(binary.LittleEndian.Uint32(header[5:9]))
+		if headerSize > size {
+			return nil, fmt.Errorf("%w: incomplete segment header in %s", ErrTruncated, path)
+		}
+		container = io.NewSectionReader(file, headerSize, size-headerSize)
+		size -= headerSize
+	}
+
+	header = header[:containerHeaderSize]
+	if _, err := container.ReadAt(header, 0); err != nil || !IsIndexed(header) {
+		reader, err := DecompressFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		return sampleRange(data, 0, firstSample, count), nil
+	}
+
+	codec, err := CodecByID(header[4] &^ indexFlag)
+	if err != nil {
+		return nil, err
+	}
+	index, err := readIndex(container, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index of %s: %w", path, err)
+	}
+
+	// Find the block holding the first sample
+	first := sort.Search(len(index.entries), func(i int) bool {
+		return index.entries[i].firstSample > uint64(firstSample)
+	}) - 1
+	if first < 0 {
+		return []byte{}, nil
+	}
+
+	var data []byte
+	end := uint64(firstSample + count)
+	for i := first; i < len(index.entries) && index.entries[i].firstSample < end; i++ {
+		compressedBlock := make([]byte, index.blockEnd(i)-int64(index.entries[i].offset))
+		if _, err := container.ReadAt(compressedBlock, int64(index.entries[i].offset)); err != nil {
+			return nil, err
+		}
+		block, err := codec.Decompress(compressedBlock)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress block %d of %s: %w", i, path, err)
+		}
+		data = append(data, block...)
+	}
+	return sampleRange(data, int(index.entries[first].firstSample), firstSample, count), nil
+}
+
+// sampleRange returns the bytes of count samples starting at firstSample from data
+// starting at sample dataStart, clipped to the end of data
+func sampleRange(data []byte, dataStart, firstSample, count int) []byte {
+	start := min((firstSample-dataStart)*SAMPLE_SIZE, len(data))
+	end := min(start+count*SAMPLE_SIZE, len(data))
+	return data[start:end]
+}
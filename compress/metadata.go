@@ -0,0 +1,155 @@
+package compress
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// Segment header format, written in front of any container (bare RLE4, codec container or
+// indexed container) to carry the channel metadata:
+//   - Magic string "DAQS" (4 bytes)
+//   - Format version (1 byte, SEGMENT_FORMAT_VERSION)
+//   - Metadata length (4 bytes, uint32) and JSON metadata
+//   - The container
+//
+// Files without the header are version 1. Version 1 RLE4 containers may still carry
+// metadata in their own header, see Options.Metadata.
+const (
+	SEGMENT_FORMAT_VERSION = 2 // Version of the segment header written by AppendSegmentHeader
+
+	segmentMagic      = "DAQS"
+	segmentHeaderSize = 4 + 1 + 4 // Magic + version + metadata length
+)
+
+// Metadata describes the channel a compressed segment was captured from, so that a
+// segment is self-describing even when separated from its file name
+type Metadata struct {
+	UUID         string  `json:"uuid,omitempty"`
+	IP           string  `json:"ip,omitempty"`
+	Port         int     `json:"port"`
+	SampleFormat string  `json:"sampleFormat"`    // e.g. "int16le"
+	Scale        float64 `json:"scale,omitempty"` // Linear conversion from raw sample to engineering units
+	Offset       float64 `json:"offset,omitempty"`
+	Unit         string  `json:"unit,omitempty"`
+	StartTime    int64   `json:"startTime"` // Unix nanoseconds of the first sample
+	// Conversions are the expressions of the raw sample x per channel that replaced the
+	// built-in scaling, "" for channels using it. Scale and Offset are unset if any is given.
+	Conversions []string `json:"conversions,omitempty"`
+	// Timestamps record the host clocks at intervals while the segment was received, so
+	// that the time of a sample can be reconstructed despite drift of the sample rate
+	Timestamps []Timestamp `json:"timestamps,omitempty"`
+}
+
+// Timestamp ties a position in the raw data of a segment to the host clocks at the time
+// the data there was received
+type Timestamp struct {
+	Offset    int64 `json:"offset"`    // Byte offset in the raw data of the segment
+	Sample    int64 `json:"sample"`    // Samples per channel received on the connection before Offset
+	Monotonic int64 `json:"monotonic"` // Nanoseconds on the host's monotonic clock since the application started
+	Wall      int64 `json:"wall"`      // Unix nanoseconds on the host's wall clock
+}
+
+func (m *Metadata) encode() []byte {
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// AppendSegmentHeader appends a segment header carrying metadata to dst. The container
+// holding the data must be appended after it.
+func AppendSegmentHeader(dst []byte, metadata *Metadata) []byte {
+	encoded := metadata.encode()
+	dst = append(dst, segmentMagic...)
+	dst = append(dst, SEGMENT_FORMAT_VERSION)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(encoded)))
+	return append(dst, encoded...)
+}
+
+// IsSegment reports whether data starts with a segment header
+func IsSegment(data []byte) bool {
+	return len(data) >= 4 && string(data[0:4]) == segmentMagic
+}
+
+// segmentHeader is a parsed segment header
+type segmentHeader struct {
+	version  byte
+	metadata []byte // JSON metadata
+	size     int    // Size of the header, where the container starts
+}
+
+// parseSegmentHeader parses and validates a segment header at the start of data
+func parseSegmentHeader(data []byte) (segmentHeader, error) {
+	if !IsSegment(data) {
+		return segmentHeader{}, fmt.Errorf("invalid magic string: expected 'DAQS'")
+	}
+	if len(data) < segmentHeaderSize {
+		return segmentHeader{}, fmt.Errorf("%w: incomplete segment header", ErrTruncated)
+	}
+	header := segmentHeader{version: data[4], size: segmentHeaderSize}
+	if header.version < 2 || header.version > SEGMENT_FORMAT_VERSION {
+		return segmentHeader{}, fmt.Errorf("unsupported segment format version %d", header.version)
+	}
+	metadataLength := int(binary.LittleEndian.Uint32(data[5:9]))
+	if len(data)-header.size < metadataLength {
+		return segmentHeader{}, fmt.Errorf("%w: incomplete metadata", ErrTruncated)
+	}
+	header.metadata = data[header.size : header.size+metadataLength]
+	header.size += metadataLength
+	return header, nil
+}
+
+// stripSegmentHeader returns the container following the segment header, or data itself
+// if it has none
+func stripSegmentHeader(data []byte) ([]byte, error) {
+	if !IsSegment(data) {
+		return data, nil
+	}
+	header, err := parseSegmentHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[header.size:], nil
+}
+
+// FormatVersion returns the format version of a compressed segment, 1 for files without
+// a segment header
+func FormatVersion(data []byte) (int, error) {
+	if !IsSegment(data) {
+		return 1, nil
+	}
+	header, err := parseSegmentHeader(data)
+	if err != nil {
+		return 0, err
+	}
+	return int(header.version), nil
+}
+
+// ReadMetadata returns the metadata of a compressed segment from its segment header or,
+// for version 1 files, from the header of an RLE4 container. It returns nil for files
+// without metadata.
+func ReadMetadata(compressedData []byte) (*Metadata, error) {
+	var encoded []byte
+	switch {
+	case IsSegment(compressedData):
+		header, err := parseSegmentHeader(compressedData)
+		if err != nil {
+			return nil, err
+		}
+		encoded = header.metadata
+	case IsRLE4(compressedData):
+		header, err := parseRLE4Header(compressedData)
+		if err != nil {
+			return nil, err
+		}
+		encoded = header.metadata
+	}
+	if encoded == nil {
+		return nil, nil
+	}
+
+	var metadata Metadata
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+	return &metadata, nil
+}
@@ -0,0 +1,564 @@
+package config
+
+import (
+	"errors"
+	"eth-daq-software/compress"
+	"eth-daq-software/expr"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_FILE       = "config.yaml" // Configuration file loaded at startup
+	DEFAULT_HTTP_ADDR = ":8080"       // Status API address in headless mode when HTTPAddr is empty
+)
+
+// Policies of the segment write queue when it is full
+const (
+	WRITE_QUEUE_BLOCK       = "block"       // Wait for room, pausing the connection until the disk catches up
+	WRITE_QUEUE_DROP_OLDEST = "drop-oldest" // Drop the oldest queued segment to keep receiving
+)
+
+// Policies for a data connection from an IP and port that already has a connection
+const (
+	DUPLICATE_REPLACE  = "replace"        // Close the existing connection
+	DUPLICATE_REJECT   = "reject-new"     // Close the new connection
+	DUPLICATE_PARALLEL = "allow-parallel" // Keep both if they come from devices with different UUIDs, otherwise replace
+)
+
+// Durability levels of data segments
+const (
+	DURABILITY_NONE  = "none"  // Segments are handed to the operating system when written
+	DURABILITY_FLUSH = "flush" // Appended data is handed to the operating system on every chunk
+	DURABILITY_FSYNC = "fsync" // Every segment is synced to disk when it is completed
+)
+
+// Statistics shown as the live value of a channel
+const (
+	STATISTIC_MEAN   = "mean"   // Mean of the averaging window
+	STATISTIC_EMA    = "ema"    // Exponential moving average, weighting each new sample with EMAAlpha
+	STATISTIC_MEDIAN = "median" // Median of the averaging window, ignoring occasional spikes
+)
+
+// Durability modes of the device log files
+const (
+	DEVICE_LOG_SYNC_INTERVAL = "interval" // Buffer lines and flush every DeviceLogFlushInterval
+	DEVICE_LOG_SYNC_LINE     = "line"     // Write and sync every line to disk as it arrives
+)
+
+// Config holds the server settings. Fields missing from the file keep their defaults.
+type Config struct {
+	// HandshakePort receives the device handshake
+	HandshakePort int `yaml:"handshake_port"`
+	// DataPorts are the data ports listened on. The decoder of a port is set in Channels,
+	// by default 5555 is the HS ADC, 5556 the GADC and 5557 the thermocouple.
+	DataPorts []int `yaml:"data_ports"`
+	// FallbackPorts are alternate ports listened on, in order, when a port is already in
+	// use. Connections to a fallback port are handled as the configured port.
+	FallbackPorts map[int][]int `yaml:"fallback_ports,omitempty"`
+	// Socket tunes the TCP connections accepted on every listener, Sockets overrides it
+	// per listener port
+	Socket  SocketConfig         `yaml:"socket,omitempty"`
+	Sockets map[int]SocketConfig `yaml:"sockets,omitempty"`
+	// BindRetries is how often binding a port and its fallbacks is retried before giving up
+	BindRetries int `yaml:"bind_retries"`
+	// UDPLogPort receives device log lines
+	UDPLogPort int `yaml:"udp_log_port"`
+	// UDPLogListeners are further ports receiving device log lines, such as one per
+	// firmware subsystem, with the source tag their lines are marked with
+	UDPLogListeners map[int]string `yaml:"udp_log_listeners,omitempty"`
+	// DataDir receives the data segments
+	DataDir string `yaml:"data_dir"`
+	// MirrorDir receives a copy of every written segment, e.g. on a NAS mount, so that a
+	// failed disk does not lose the capture. Copies are made in the background and retried
+	// until they succeed. "" disables the mirror.
+	MirrorDir string `yaml:"mirror_dir"`
+	// LogDir receives the device log files
+	LogDir string `yaml:"log_dir"`
+	// DeviceLogLines is the number of device log lines kept in memory per device
+	DeviceLogLines int `yaml:"device_log_lines"`
+	// DeviceLogSync is how device log files are written: DEVICE_LOG_SYNC_INTERVAL buffers
+	// lines, DEVICE_LOG_SYNC_LINE syncs every line and is slow at high log rates
+	DeviceLogSync string `yaml:"device_log_sync"`
+	// DeviceLogFlushInterval is the time in milliseconds between flushes of buffered device
+	// log lines to disk
+	DeviceLogFlushInterval int `yaml:"device_log_flush_interval"`
+	// AveragingWindow is the number of samples averaged for the live value
+	AveragingWindow int `yaml:"averaging_window"`
+	// ThermocoupleAveragingWindow is the averaging window of the thermocouple port
+	ThermocoupleAveragingWindow int `yaml:"thermocouple_averaging_window"`
+	// Statistic is the live value of a channel, STATISTIC_MEAN, STATISTIC_EMA or STATISTIC_MEDIAN
+	Statistic string `yaml:"statistic"`
+	// EMAAlpha is the weight of a new sample in the exponential moving average, in (0, 1].
+	// Smaller values smooth more and follow slow drifts later.
+	EMAAlpha float64 `yaml:"ema_alpha"`
+	// OutlierSigma leaves samples more than this many standard deviations from the mean of
+	// the averaging window out of the statistic, 0 keeps all samples
+	OutlierSigma float64 `yaml:"outlier_sigma"`
+	// StatsInterval is the time in milliseconds between the stats events sent to the
+	// frontend, 0 disables them
+	StatsInterval int `yaml:"stats_interval"`
+	// RateHistory is the time in seconds the per-second transfer rates of each channel are
+	// kept for GetRateHistory, 0 disables the history
+	RateHistory int `yaml:"rate_history"`
+	// FlushThreshold is the buffer size in bytes at which a segment is flushed
+	FlushThreshold int `yaml:"flush_threshold"`
+	// ReadChunkSize is the size in bytes of the chunks data connections are read into
+	ReadChunkSize int `yaml:"read_chunk_size"`
+	// Durability is how far segments are written before they count as written, one of
+	// DURABILITY_NONE, DURABILITY_FLUSH and DURABILITY_FSYNC. FSYNC survives power loss at
+	// the cost of throughput.
+	Durability string `yaml:"durability"`
+	// Channels overrides the buffer sizes, durability, statistic and decoder of the channel
+	// on a data port
+	Channels map[int]ChannelConfig `yaml:"channels,omitempty"`
+	// DecoderPlugins are Go plugins loaded at startup that add decoders for Channels
+	DecoderPlugins []string `yaml:"decoder_plugins,omitempty"`
+	// FlushInterval is the age in seconds at which a buffer is flushed regardless of its
+	// size, 0 flushes by size only
+	FlushInterval int `yaml:"flush_interval"`
+	// TimestampInterval is the time in milliseconds between the records of the host clocks
+	// stored with segments, which place samples in time despite sample rate drift. 0
+	// disables them.
+	TimestampInterval int `yaml:"timestamp_interval"`
+	// PingInterval is the time in milliseconds between the pings sent to devices that keep
+	// their handshake connection open, to measure round trip times. 0 disables them.
+	PingInterval int `yaml:"ping_interval"`
+	// DuplicateConnections is the DUPLICATE_* policy for a data connection from an IP and
+	// port that already has a connection
+	DuplicateConnections string `yaml:"duplicate_connections"`
+	// DegradedAfter is the silence in seconds after which a device is reported degraded
+	DegradedAfter int `yaml:"degraded_after"`
+	// OfflineAfter is the silence in seconds after which a device is reported offline
+	OfflineAfter int `yaml:"offline_after"`
+	// FlushWorkers is the number of background workers compressing flushed segments
+	FlushWorkers int `yaml:"flush_workers"`
+	// WriteQueueDepth is the number of flushed segments that can wait for a worker
+	WriteQueueDepth int `yaml:"write_queue_depth"`
+	// WriteQueuePolicy is WRITE_QUEUE_BLOCK or WRITE_QUEUE_DROP_OLDEST, what happens to a
+	// flushed segment when the write queue is full
+	WriteQueuePolicy string `yaml:"write_queue_policy"`
+	// MemoryLimit caps the bytes of received data held in memory, buffered or waiting to
+	// be written. Above it buffers are flushed early and flushes wait for the disk. 0 is
+	// unlimited.
+	MemoryLimit int `yaml:"memory_limit"`
+	// RetentionDays is the age at which segments are deleted from DataDir, 0 keeps them forever
+	RetentionDays int `yaml:"retention_days"`
+	// HTTPAddr serves the HTTP status API on this address, e.g. ":8080". "" disables the
+	// API in the GUI, headless mode then uses DEFAULT_HTTP_ADDR.
+	HTTPAddr string `yaml:"http_addr"`
+	// AttachService makes the GUI show the server already serving the status API on
+	// HTTPAddr, or DEFAULT_HTTP_ADDR if empty, such as the Windows service, instead of
+	// listening for devices itself
+	AttachService bool `yaml:"attach_service"`
+	// Relays forward the raw data of channels to other hosts as it is received
+	Relays []RelayConfig `yaml:"relays,omitempty"`
+	// Upload pushes written segments to remote storage
+	Upload UploadConfig `yaml:"upload,omitempty"`
+	// Features toggles optional behaviour
+	Features Features `yaml:"features"`
+}
+
+// ChannelConfig holds the buffer sizes of one channel, which depend on its sample rate,
+// its durability, statistic and the decoder of its samples. Zero values use the global
+// setting.
+type ChannelConfig struct {
+	FlushThreshold int     `yaml:"flush_threshold,omitempty"`
+	ReadChunkSize  int     `yaml:"read_chunk_size,omitempty"`
+	Durability     string  `yaml:"durability,omitempty"`
+	Statistic      string  `yaml:"statistic,omitempty"`
+	EMAAlpha       float64 `yaml:"ema_alpha,omitempty"`
+	OutlierSigma   float64 `yaml:"outlier_sigma,omitempty"`
+	// AcceptLoops is the number of sockets accepting connections on the port, with
+	// SO_REUSEPORT so that the kernel spreads devices connecting at the same time across
+	// them. Linux only, elsewhere the port has one. 0 is one.
+	AcceptLoops int `yaml:"accept_loops,omitempty"`
+	// Decoder is the name of the sample decoder, "" uses the default of the port number
+	Decoder string `yaml:"decoder,omitempty"`
+	// Conversions replace the scaling of the decoder's channels, by channel name, with an
+	// expression of the raw sample x, e.g. "x*187.5e-6 - 6.144"
+	Conversions map[string]string `yaml:"conversions,omitempty"`
+	// ThermocoupleType is the type of the thermocouple of a port using the thermocouple
+	// decoder: J, K, N or T. "" uses the type the device reports in its handshake, or K.
+	ThermocoupleType string `yaml:"thermocouple_type,omitempty"`
+}
+
+// SocketConfig holds the options of accepted TCP connections. Zero values keep the
+// operating system's defaults, or the global setting for a listener port.
+type SocketConfig struct {
+	// NoDelay disables Nagle's algorithm, Go enables it by default
+	NoDelay *bool `yaml:"tcp_nodelay,omitempty"`
+	// ReceiveBuffer is SO_RCVBUF in bytes. Gigabit links need a few MB for the window to
+	// cover the round trip, Linux caps it at net.core.rmem_max.
+	ReceiveBuffer int `yaml:"receive_buffer,omitempty"`
+	// KeepAlive is the idle time and interval of keepalive probes in seconds, negative
+	// disables them
+	KeepAlive int `yaml:"keepalive,omitempty"`
+}
+
+// RelayConfig forwards the raw byte stream of channels to another host over TCP, one
+// connection per channel, each starting with a JSON line naming the channel
+type RelayConfig struct {
+	// Address is the host:port the data is sent to
+	Address string `yaml:"address"`
+	// Ports are the data ports forwarded, empty forwards all of them
+	Ports []int `yaml:"ports,omitempty"`
+	// BufferSize is the bytes per channel held while the host is slow or unreachable,
+	// data beyond it is dropped. 0 uses RELAY_BUFFER_SIZE.
+	BufferSize int `yaml:"buffer_size,omitempty"`
+}
+
+// RELAY_BUFFER_SIZE is the default buffer of a relayed channel
+const RELAY_BUFFER_SIZE = 4 * 1024 * 1024
+
+// BufferSizeFor returns the buffer size of a relayed channel
+func (r RelayConfig) BufferSizeFor() int {
+	if r.BufferSize > 0 {
+		return r.BufferSize
+	}
+	return RELAY_BUFFER_SIZE
+}
+
+// Relays reports whether the relay forwards a data port
+func (r RelayConfig) Relays(port int) bool {
+	return len(r.Ports) == 0 || slices.Contains(r.Ports, port)
+}
+
+// UploadConfig is the remote storage segments are uploaded to once written
+type UploadConfig struct {
+	// URL is the destination, s3://bucket/prefix or sftp://user@host[:port]/path. ""
+	// disables uploads.
+	URL string `yaml:"url"`
+	// Endpoint is the URL, without a path, of an S3-compatible service, "" uses AWS in Region
+	Endpoint string `yaml:"endpoint,omitempty"`
+	Region   string `yaml:"region,omitempty"`
+	// AccessKeyID and SecretAccessKey sign S3 requests, "" reads AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY from the environment
+	AccessKeyID     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+	// Password or the private key in KeyFile log in to the SFTP server
+	Password string `yaml:"password,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// KnownHosts verifies the host key of the SFTP server, "" uses ~/.ssh/known_hosts
+	KnownHosts string `yaml:"known_hosts,omitempty"`
+	// Workers is the number of segments uploaded at the same time, 0 uploads one at a time
+	Workers int `yaml:"workers,omitempty"`
+}
+
+// Features are the optional behaviours that can be switched on and off
+type Features struct {
+	// Compression is the default codec for flushed segments, "none" writes raw data
+	Compression string `yaml:"compression"`
+	// FileLogging writes the application log to app.log
+	FileLogging bool `yaml:"file_logging"`
+	// JSONLogging writes structured JSON log entries instead of text
+	JSONLogging bool `yaml:"json_logging"`
+	// Syslog forwards device log lines to this syslog server, "" disables forwarding
+	Syslog string `yaml:"syslog"`
+	// Checksums writes a SHA-256 sidecar next to every segment, see VerifyDataDir
+	Checksums bool `yaml:"checksums"`
+	// Pprof serves the net/http/pprof profiles under /debug/pprof/ on the HTTP status API
+	Pprof bool `yaml:"pprof"`
+}
+
+// Default returns the settings used when there is no configuration file
+func Default() *Config {
+	return &Config{
+		HandshakePort:               5002,
+		DataPorts:                   []int{5555, 5556, 5557},
+		UDPLogPort:                  2403,
+		DataDir:                     "data",
+		LogDir:                      "logs",
+		DeviceLogLines:              500,
+		DeviceLogSync:               DEVICE_LOG_SYNC_INTERVAL,
+		DeviceLogFlushInterval:      1000,
+		AveragingWindow:             1000,
+		ThermocoupleAveragingWindow: 5,
+		Statistic:                   STATISTIC_MEAN,
+		EMAAlpha:                    0.1,
+		StatsInterval:               250,
+		RateHistory:                 3600,
+		FlushThreshold:              10 * 1024 * 1024,
+		ReadChunkSize:               1024 * 1024,
+		TimestampInterval:           1000,
+		PingInterval:                1000,
+		Durability:                  DURABILITY_NONE,
+		DuplicateConnections:        DUPLICATE_REPLACE,
+		DegradedAfter:               5,
+		OfflineAfter:                30,
+		FlushWorkers:                2,
+		WriteQueueDepth:             8,
+		WriteQueuePolicy:            WRITE_QUEUE_BLOCK,
+		Features: Features{
+			Compression: "rle4",
+			FileLogging: true,
+			Checksums:   true,
+		},
+	}
+}
+
+// Load reads the configuration file at path on top of the defaults. A missing file
+// is not an error and returns the defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return Default(), fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Default(), fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that the settings are usable
+func (c *Config) Validate() error {
+	ports := append([]int{c.HandshakePort, c.UDPLogPort}, c.DataPorts...)
+	for _, fallbacks := range c.FallbackPorts {
+		ports = append(ports, fallbacks...)
+	}
+	for port, source := range c.UDPLogListeners {
+		if port == c.UDPLogPort {
+			return fmt.Errorf("udp_log_listeners must not repeat udp_log_port %d", port)
+		}
+		if source == "" || strings.ContainsAny(source, "<> ") {
+			return fmt.Errorf("invalid source tag %q of UDP log port %d", source, port)
+		}
+		ports = append(ports, port)
+	}
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	switch {
+	case c.BindRetries < 0:
+		return fmt.Errorf("bind_retries must not be negative")
+	case c.DataDir == "":
+		return fmt.Errorf("data_dir must not be empty")
+	case c.MirrorDir != "" && filepath.Clean(c.MirrorDir) == filepath.Clean(c.DataDir):
+		return fmt.Errorf("mirror_dir must differ from data_dir")
+	case c.LogDir == "":
+		return fmt.Errorf("log_dir must not be empty")
+	case c.DeviceLogLines <= 0:
+		return fmt.Errorf("device_log_lines must be positive")
+	case c.DeviceLogSync != DEVICE_LOG_SYNC_INTERVAL && c.DeviceLogSync != DEVICE_LOG_SYNC_LINE:
+		return fmt.Errorf("device_log_sync must be %q or %q", DEVICE_LOG_SYNC_INTERVAL, DEVICE_LOG_SYNC_LINE)
+	case c.DeviceLogFlushInterval <= 0:
+		return fmt.Errorf("device_log_flush_interval must be positive")
+	case c.AveragingWindow <= 0 || c.ThermocoupleAveragingWindow <= 0:
+		return fmt.Errorf("averaging windows must be positive")
+	case c.FlushThreshold <= 0:
+		return fmt.Errorf("flush_threshold must be positive")
+	case c.ReadChunkSize <= 0:
+		return fmt.Errorf("read_chunk_size must be positive")
+	case c.FlushInterval < 0:
+		return fmt.Errorf("flush_interval must not be negative")
+	case c.TimestampInterval < 0:
+		return fmt.Errorf("timestamp_interval must not be negative")
+	case c.PingInterval < 0:
+		return fmt.Errorf("ping_interval must not be negative")
+	case c.DuplicateConnections != DUPLICATE_REPLACE && c.DuplicateConnections != DUPLICATE_REJECT &&
+		c.DuplicateConnections != DUPLICATE_PARALLEL:
+		return fmt.Errorf("duplicate_connections must be %q, %q or %q", DUPLICATE_REPLACE, DUPLICATE_REJECT, DUPLICATE_PARALLEL)
+	case c.DegradedAfter <= 0 || c.OfflineAfter < c.DegradedAfter:
+		return fmt.Errorf("degraded_after must be positive and offline_after at least degraded_after")
+	case c.FlushWorkers <= 0:
+		return fmt.Errorf("flush_workers must be positive")
+	case c.MemoryLimit < 0:
+		return fmt.Errorf("memory_limit must not be negative")
+	case c.WriteQueueDepth <= 0:
+		return fmt.Errorf("write_queue_depth must be positive")
+	case c.WriteQueuePolicy != WRITE_QUEUE_BLOCK && c.WriteQueuePolicy != WRITE_QUEUE_DROP_OLDEST:
+		return fmt.Errorf("write_queue_policy must be %q or %q", WRITE_QUEUE_BLOCK, WRITE_QUEUE_DROP_OLDEST)
+	case c.RetentionDays < 0:
+		return fmt.Errorf("retention_days must not be negative")
+	case c.OutlierSigma < 0:
+		return fmt.Errorf("outlier_sigma must not be negative")
+	case c.StatsInterval < 0:
+		return fmt.Errorf("stats_interval must not be negative")
+	case c.RateHistory < 0:
+		return fmt.Errorf("rate_history must not be negative")
+	}
+	if err := validDurability(c.Durability); err != nil {
+		return err
+	}
+	if err := validStatistic(c.Statistic, c.EMAAlpha); err != nil {
+		return err
+	}
+	for port, channel := range c.Channels {
+		if channel.FlushThreshold < 0 || channel.ReadChunkSize < 0 {
+			return fmt.Errorf("buffer sizes of channel %d must not be negative", port)
+		}
+		if channel.AcceptLoops < 0 {
+			return fmt.Errorf("accept_loops of channel %d must not be negative", port)
+		}
+		if channel.OutlierSigma < 0 {
+			return fmt.Errorf("outlier_sigma of channel %d must not be negative", port)
+		}
+		if channel.Durability != "" {
+			if err := validDurability(channel.Durability); err != nil {
+				return fmt.Errorf("channel %d: %v", port, err)
+			}
+		}
+		if err := validStatistic(c.StatisticFor(port), c.EMAAlphaFor(port)); err != nil {
+			return fmt.Errorf("channel %d: %v", port, err)
+		}
+		for name, conversion := range channel.Conversions {
+			if _, err := expr.Compile(conversion, []string{"x"}); err != nil {
+				return fmt.Errorf("channel %d: conversion of %s: %v", port, name, err)
+			}
+		}
+	}
+	for port, socket := range c.Sockets {
+		if socket.ReceiveBuffer < 0 {
+			return fmt.Errorf("receive_buffer of port %d must not be negative", port)
+		}
+	}
+	if c.Socket.ReceiveBuffer < 0 {
+		return fmt.Errorf("socket receive_buffer must not be negative")
+	}
+	for _, relay := range c.Relays {
+		if _, _, err := net.SplitHostPort(relay.Address); err != nil {
+			return fmt.Errorf("invalid relay address %q: %v", relay.Address, err)
+		}
+		if relay.BufferSize < 0 {
+			return fmt.Errorf("buffer_size of relay %s must not be negative", relay.Address)
+		}
+	}
+	if c.Upload.URL != "" {
+		target, err := url.Parse(c.Upload.URL)
+		if err != nil {
+			return fmt.Errorf("invalid upload url: %v", err)
+		}
+		if target.Scheme == "" || target.Host == "" {
+			return fmt.Errorf("upload url must be s3://bucket/prefix or sftp://user@host/path")
+		}
+		if c.Upload.Workers < 0 {
+			return fmt.Errorf("upload workers must not be negative")
+		}
+	}
+	if c.Features.Compression != "" && c.Features.Compression != "none" {
+		if _, err := compress.CodecByName(c.Features.Compression); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlushThresholdFor returns the flush threshold of the channel on a data port
+func (c *Config) FlushThresholdFor(port int) int {
+	if threshold := c.Channels[port].FlushThreshold; threshold > 0 {
+		return threshold
+	}
+	return c.FlushThreshold
+}
+
+// ReadChunkSizeFor returns the read chunk size of the channel on a data port
+func (c *Config) ReadChunkSizeFor(port int) int {
+	if size := c.Channels[port].ReadChunkSize; size > 0 {
+		return size
+	}
+	return c.ReadChunkSize
+}
+
+// SocketFor returns the options of connections accepted on a listener port
+func (c *Config) SocketFor(port int) SocketConfig {
+	socket := c.Socket
+	override := c.Sockets[port]
+	if override.NoDelay != nil {
+		socket.NoDelay = override.NoDelay
+	}
+	if override.ReceiveBuffer != 0 {
+		socket.ReceiveBuffer = override.ReceiveBuffer
+	}
+	if override.KeepAlive != 0 {
+		socket.KeepAlive = override.KeepAlive
+	}
+	return socket
+}
+
+// AcceptLoopsFor returns the number of sockets accepting connections on a data port
+func (c *Config) AcceptLoopsFor(port int) int {
+	return max(c.Channels[port].AcceptLoops, 1)
+}
+
+// DurabilityFor returns the durability of the channel on a data port
+func (c *Config) DurabilityFor(port int) string {
+	if durability := c.Channels[port].Durability; durability != "" {
+		return durability
+	}
+	return c.Durability
+}
+
+// StatisticFor returns the statistic of the channel on a data port
+func (c *Config) StatisticFor(port int) string {
+	if statistic := c.Channels[port].Statistic; statistic != "" {
+		return statistic
+	}
+	return c.Statistic
+}
+
+// EMAAlphaFor returns the weight of new samples in the exponential moving average of the
+// channel on a data port
+func (c *Config) EMAAlphaFor(port int) float64 {
+	if alpha := c.Channels[port].EMAAlpha; alpha != 0 {
+		return alpha
+	}
+	return c.EMAAlpha
+}
+
+// OutlierSigmaFor returns the outlier rejection threshold of the channel on a data port
+func (c *Config) OutlierSigmaFor(port int) float64 {
+	if sigma := c.Channels[port].OutlierSigma; sigma != 0 {
+		return sigma
+	}
+	return c.OutlierSigma
+}
+
+// validStatistic checks that statistic is a known statistic and alpha a valid EMA weight
+func validStatistic(statistic string, alpha float64) error {
+	if statistic != STATISTIC_MEAN && statistic != STATISTIC_EMA && statistic != STATISTIC_MEDIAN {
+		return fmt.Errorf("statistic must be %q, %q or %q", STATISTIC_MEAN, STATISTIC_EMA, STATISTIC_MEDIAN)
+	}
+	if alpha <= 0 || alpha > 1 {
+		return fmt.Errorf("ema_alpha must be in (0, 1]")
+	}
+	return nil
+}
+
+// validDurability checks that durability is a known durability level
+func validDurability(durability string) error {
+	switch durability {
+	case DURABILITY_NONE, DURABILITY_FLUSH, DURABILITY_FSYNC:
+		return nil
+	}
+	return fmt.Errorf("durability must be %q, %q or %q", DURABILITY_NONE, DURABILITY_FLUSH, DURABILITY_FSYNC)
+}
+
+// Save writes the settings to the configuration file at path
+func (c *Config) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	return nil
+}
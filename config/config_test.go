@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestLoad tests that a partial file overrides only the settings it contains
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil || !reflect.DeepEqual(cfg, Default()) {
+		t.Fatalf("Missing file: got %+v, %v, want defaults", cfg, err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("data_ports: [5555]\nfeatures:\n  compression: zstd\n"), 0644)
+	cfg, err = Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	want := Default()
+	want.DataPorts = []int{5555}
+	want.Features.Compression = "zstd"
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Got %+v, want %+v", cfg, want)
+	}
+
+	os.WriteFile(path, []byte("features:\n  compression: brotli\n"), 0644)
+	if _, err := Load(path); err == nil {
+		t.Fatal("Expected error for unknown codec, got nil")
+	}
+
+	// The example configuration must match the defaults
+	cfg, err = Load(filepath.Join("..", "config.example.yaml"))
+	if err != nil || !reflect.DeepEqual(cfg, Default()) {
+		t.Fatalf("config.example.yaml: got %+v, %v, want defaults", cfg, err)
+	}
+
+	// Saved settings load back unchanged
+	cfg.RetentionDays = 30
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	saved, err := Load(path)
+	if err != nil || !reflect.DeepEqual(saved, cfg) {
+		t.Fatalf("Saved config: got %+v, %v, want %+v", saved, err, cfg)
+	}
+}
+
+// TestChannelSizes tests that per-channel buffer sizes override the global ones
+func TestChannelSizes(t *testing.T) {
+	cfg := Default()
+	cfg.Channels = map[int]ChannelConfig{5557: {FlushThreshold: 65536, ReadChunkSize: 4096}, 5555: {ReadChunkSize: 4 << 20}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	tests := []struct {
+		port      int
+		threshold int
+		chunk     int
+	}{
+		{5557, 65536, 4096},
+		{5555, cfg.FlushThreshold, 4 << 20},
+		{5556, cfg.FlushThreshold, cfg.ReadChunkSize},
+	}
+	for _, tt := range tests {
+		if threshold, chunk := cfg.FlushThresholdFor(tt.port), cfg.ReadChunkSizeFor(tt.port); threshold != tt.threshold || chunk != tt.chunk {
+			t.Errorf("Port %d: got %d and %d, want %d and %d", tt.port, threshold, chunk, tt.threshold, tt.chunk)
+		}
+	}
+
+	cfg.Channels[5556] = ChannelConfig{ReadChunkSize: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for a negative chunk size, got nil")
+	}
+}
+
+// TestSocketFor tests that the socket options of a listener port override the global ones
+// they set
+func TestSocketFor(t *testing.T) {
+	enabled, disabled := true, false
+	cfg := Default()
+	cfg.Socket = SocketConfig{NoDelay: &enabled, KeepAlive: 30}
+	cfg.Sockets = map[int]SocketConfig{5555: {NoDelay: &disabled, ReceiveBuffer: 4 << 20}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	if socket := cfg.SocketFor(5555); *socket.NoDelay || socket.ReceiveBuffer != 4<<20 || socket.KeepAlive != 30 {
+		t.Errorf("SocketFor(5555) = %+v", socket)
+	}
+	if socket := cfg.SocketFor(5556); !*socket.NoDelay || socket.ReceiveBuffer != 0 || socket.KeepAlive != 30 {
+		t.Errorf("SocketFor(5556) = %+v", socket)
+	}
+
+	cfg.Sockets[5556] = SocketConfig{ReceiveBuffer: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for a negative receive buffer, got nil")
+	}
+}
@@ -0,0 +1,309 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled arithmetic expression over a fixed set of variables
+type Expr struct {
+	source string
+	eval   func(vars []float64) float64
+	uses   []bool // Which of the compiled names the expression references
+}
+
+// functions available to expressions, by name and number of arguments
+var functions = map[string]struct {
+	args int
+	fn   func(args []float64) float64
+}{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"exp":   {1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"log":   {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"log10": {1, func(a []float64) float64 { return math.Log10(a[0]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+	"pow":   {2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+}
+
+// Compile parses an expression such as "x * -2.5 / 32768" or "vds * vgs". Identifiers must
+// be one of names; at evaluation time the value of names[i] is taken from vars[i].
+func Compile(source string, names []string) (*Expr, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, names: names, uses: make([]bool, len(names))}
+	eval, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression %q", p.tokens[p.pos].text, source)
+	}
+	return &Expr{source: source, eval: eval, uses: p.uses}, nil
+}
+
+// Eval evaluates the expression with the variable values in the order of the compiled names
+func (e *Expr) Eval(vars []float64) float64 {
+	return e.eval(vars)
+}
+
+// Uses reports whether the expression references names[i]
+func (e *Expr) Uses(i int) bool {
+	return e.uses[i]
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.source
+}
+
+type tokenKind int
+
+const (
+	tokenNumber tokenKind = iota
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value float64
+}
+
+// tokenize splits an expression into numbers, identifiers and operators
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			// Exponent, e.g. 187.5e-6
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					i = j
+					for i < len(runes) && unicode.IsDigit(runes[i]) {
+						i++
+					}
+				}
+			}
+			text := string(runes[start:i])
+			value, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", text)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: value})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i])})
+		case strings.ContainsRune("+-*/^(),", r):
+			tokens = append(tokens, token{kind: tokenOperator, text: string(r)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent parser producing evaluation closures
+type parser struct {
+	tokens []token
+	pos    int
+	names  []string
+	uses   []bool
+}
+
+func (p *parser) peek(text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator && p.tokens[p.pos].text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.peek(text) {
+		if p.pos < len(p.tokens) {
+			return fmt.Errorf("expected %q, got %q", text, p.tokens[p.pos].text)
+		}
+		return fmt.Errorf("expected %q at end of expression", text)
+	}
+	p.pos++
+	return nil
+}
+
+// expression := term (('+' | '-') term)*
+func (p *parser) parseExpression() (func([]float64) float64, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("+") || p.peek("-") {
+		op := p.tokens[p.pos].text
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(v []float64) float64 { return l(v) + right(v) }
+		} else {
+			left = func(v []float64) float64 { return l(v) - right(v) }
+		}
+	}
+	return left, nil
+}
+
+// term := unary (('*' | '/') unary)*
+func (p *parser) parseTerm() (func([]float64) float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("*") || p.peek("/") {
+		op := p.tokens[p.pos].text
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "*" {
+			left = func(v []float64) float64 { return l(v) * right(v) }
+		} else {
+			left = func(v []float64) float64 { return l(v) / right(v) }
+		}
+	}
+	return left, nil
+}
+
+// unary := ('-' | '+') unary | power
+func (p *parser) parseUnary() (func([]float64) float64, error) {
+	if p.peek("-") {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v []float64) float64 { return -operand(v) }, nil
+	}
+	if p.peek("+") {
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// power := primary ('^' unary)?
+func (p *parser) parsePower() (func([]float64) float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek("^") {
+		p.pos++
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v []float64) float64 { return math.Pow(base(v), exponent(v)) }, nil
+	}
+	return base, nil
+}
+
+// primary := number | identifier | function '(' args ')' | '(' expression ')'
+func (p *parser) parsePrimary() (func([]float64) float64, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case tokenNumber:
+		value := tok.value
+		return func([]float64) float64 { return value }, nil
+	case tokenIdent:
+		if p.peek("(") {
+			return p.parseCall(tok.text)
+		}
+		for i, name := range p.names {
+			if name == tok.text {
+				index := i
+				p.uses[i] = true
+				return func(v []float64) float64 { return v[index] }, nil
+			}
+		}
+		if tok.text == "pi" {
+			return func([]float64) float64 { return math.Pi }, nil
+		}
+		return nil, fmt.Errorf("unknown variable %q", tok.text)
+	default:
+		if tok.text == "(" {
+			inner, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+}
+
+// parseCall parses the arguments of a function call
+func (p *parser) parseCall(name string) (func([]float64) float64, error) {
+	function, exists := functions[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var args []func([]float64) float64
+	for !p.peek(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.pos++
+
+	if len(args) != function.args {
+		return nil, fmt.Errorf("function %q takes %d arguments, got %d", name, function.args, len(args))
+	}
+	return func(v []float64) float64 {
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			values[i] = arg(v)
+		}
+		return function.fn(values)
+	}, nil
+}
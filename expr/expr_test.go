@@ -0,0 +1,64 @@
+package expr
+
+import (
+	"math"
+	"testing"
+)
+
+// TestEval tests evaluation of valid expressions
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source   string
+		vars     []float64
+		expected float64
+	}{
+		{"1 + 2 * 3", nil, 7},
+		{"(1 + 2) * 3", nil, 9},
+		{"-x", []float64{2, 0}, -2},
+		{"x * -2.5 / 32768", []float64{32768, 0}, -2.5},
+		{"x*187.5e-6 - 6.144", []float64{1000, 0}, 0.1875 - 6.144},
+		{"x * y", []float64{3, 4}, 12},
+		{"2 ^ 3 ^ 2", nil, 512},
+		{"-2 ^ 2", nil, -4},
+		{"max(x, y) - min(x, y)", []float64{3, 7}, 4},
+		{"sqrt(abs(x))", []float64{-16, 0}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			e, err := Compile(tt.source, []string{"x", "y"})
+			if err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			vars := tt.vars
+			if vars == nil {
+				vars = []float64{0, 0}
+			}
+			if result := e.Eval(vars); math.Abs(result-tt.expected) > 1e-12 {
+				t.Fatalf("Eval = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestCompileErrors tests that invalid expressions are rejected
+func TestCompileErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 + 2)",
+		"z * 2",
+		"foo(1)",
+		"max(1)",
+		"1 $ 2",
+	}
+
+	for _, source := range tests {
+		t.Run(source, func(t *testing.T) {
+			if _, err := Compile(source, []string{"x"}); err == nil {
+				t.Fatalf("Expected error compiling %q, got nil", source)
+			}
+		})
+	}
+}
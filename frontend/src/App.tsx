@@ -1,5 +1,6 @@
 import { use, useEffect, useState } from 'react';
 import { Greet, GetAllConnectedIPs, GetPortAverage, GetLogs, GetPortRate, GetPortAverageB } from "../wailsjs/go/main/App";
+import { EventsOn } from "../wailsjs/runtime/runtime";
 import "./globals.scss";
 import "./inf.scss"
 import {
@@ -67,8 +68,14 @@ const App = () => {
         };
 
         fetchConnectedIPs();
-        const interval = setInterval(fetchConnectedIPs, 200);
-        return () => clearInterval(interval);
+        // Devices are refetched when they connect, disconnect or go offline. The slow poll
+        // picks up byte counts and covers a GUI attached to a service, which sends no events.
+        const unsubscribe = EventsOn("device-connection", fetchConnectedIPs);
+        const interval = setInterval(fetchConnectedIPs, 1000);
+        return () => {
+            unsubscribe();
+            clearInterval(interval);
+        };
     }, [selectedIP]);
 
     useEffect(() => {
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"eth-daq-software/config"
+	"eth-daq-software/logger"
+	"eth-daq-software/server"
+	"eth-daq-software/service"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runHeadless runs the acquisition server without the GUI until interrupted. Data is
+// written as in the GUI, status is served by the HTTP status API and logs go to stderr.
+func runHeadless(configPath string, httpAddr string) error {
+	// SIGINT (Ctrl+C) and SIGTERM (service stop) flush and write all buffers before
+	// exiting, a second signal exits immediately
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	stop := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		stopSignals()
+		close(stop)
+	}()
+	return serveHeadless(configPath, httpAddr, stop)
+}
+
+// serveHeadless runs the acquisition server and the HTTP status API until stop is closed,
+// then shuts the server down
+func serveHeadless(configPath string, httpAddr string, stop <-chan struct{}) error {
+	cfg, err := config.Load(configPath)
+	configureLogging(cfg, err)
+
+	srv := server.NewServer(cfg)
+	srv.SetConfigPath(configPath)
+	if err := srv.Start(); err != nil {
+		return err
+	}
+
+	if httpAddr == "" {
+		httpAddr = cfg.HTTPAddr
+	}
+	if httpAddr == "" {
+		httpAddr = config.DEFAULT_HTTP_ADDR
+	}
+	if err := srv.StartHTTP(httpAddr); err != nil {
+		return err
+	}
+
+	logger.Infof("Running headless\n")
+	if err := service.Notify(service.READY); err != nil {
+		logger.Errorf("Failed to notify service manager: %v\n", err)
+	}
+	<-stop
+
+	logger.Infof("Received stop signal\n")
+	service.Notify(service.STOPPING)
+	srv.Shutdown()
+	logger.CloseFile()
+	return nil
+}
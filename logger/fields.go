@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Fields are structured values attached to a log entry, e.g. device UUID, port, rate or bytes
+type Fields map[string]interface{}
+
+var jsonMode atomic.Bool
+
+// SetJSON switches between JSON entries and pre-formatted text for every sink
+func SetJSON(enabled bool) {
+	jsonMode.Store(enabled)
+}
+
+// format renders an entry as a JSON object in JSON mode and as "message key=value ..." otherwise
+func format(level string, message string, fields Fields) string {
+	message = strings.TrimRight(message, "\n")
+	if jsonMode.Load() {
+		entry := make(map[string]interface{}, len(fields)+3)
+		for key, value := range fields {
+			entry[key] = value
+		}
+		entry["time"] = time.Now().Format(time.RFC3339Nano)
+		entry["level"] = strings.ToLower(level)
+		entry["msg"] = message
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Sprintf(`{"level":"error","msg":"failed to marshal log entry: %v"}`, err)
+		}
+		return string(data)
+	}
+
+	if len(fields) == 0 {
+		return message
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(message)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, fields[key])
+	}
+	return b.String()
+}
+
+// InfoFields logs an informational message with structured fields
+func InfoFields(message string, fields Fields) {
+	entry := format("INFO", message, fields)
+	writeFile("INFO", entry)
+	remember("INFO", entry)
+	logEntry("INFO", entry)
+}
+
+// ErrorFields logs an error message with structured fields
+func ErrorFields(message string, fields Fields) {
+	entry := format("ERROR", message, fields)
+	writeFile("ERROR", entry)
+	remember("ERROR", entry)
+	logEntry("ERROR", entry)
+}
+
+// DebugFields logs a debug message with structured fields
+func DebugFields(message string, fields Fields) {
+	entry := format("DEBUG", message, fields)
+	writeFile("DEBUG", entry)
+	logEntry("DEBUG", entry)
+}
@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	LOG_FILE     = "app.log"        // Default file sink path
+	LOG_MAX_SIZE = 10 * 1024 * 1024 // Size at which the log file is rotated
+	LOG_BACKUPS  = 5                // Rotated files kept as app.log.1 ... app.log.N
+)
+
+// rotatingFile appends log lines to a file, rotating it when it exceeds maxSize
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+var (
+	fileSink     *rotatingFile
+	fileSinkLock sync.Mutex
+)
+
+// EnableFile starts writing every log message to path as well, rotating the file when
+// it grows beyond maxSize and keeping the given number of rotated files
+func EnableFile(path string, maxSize int64, backups int) error {
+	sink := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := sink.open(); err != nil {
+		return err
+	}
+
+	fileSinkLock.Lock()
+	defer fileSinkLock.Unlock()
+	if fileSink != nil {
+		fileSink.file.Close()
+	}
+	fileSink = sink
+	return nil
+}
+
+// CloseFile stops writing to the file sink
+func CloseFile() {
+	fileSinkLock.Lock()
+	defer fileSinkLock.Unlock()
+	if fileSink != nil {
+		fileSink.file.Close()
+		fileSink = nil
+	}
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate shifts app.log.N-1 to app.log.N and so on, dropping the oldest file
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups))
+	for i := r.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.backups > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) write(line string) {
+	if r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+			return
+		}
+	}
+	n, _ := r.file.WriteString(line)
+	r.size += int64(n)
+}
+
+// writeFile writes a formatted entry to the file sink, if enabled. JSON entries already
+// carry their time and level.
+func writeFile(level string, entry string) {
+	fileSinkLock.Lock()
+	defer fileSinkLock.Unlock()
+	if fileSink == nil {
+		return
+	}
+	if jsonMode.Load() {
+		fileSink.write(entry + "\n")
+		return
+	}
+	fileSink.write(fmt.Sprintf("%s %-5s %s\n", time.Now().Format(time.RFC3339Nano), level, entry))
+}
@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFileRotation tests that the file sink rotates and keeps the configured number of files
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := EnableFile(path, 200, 2); err != nil {
+		t.Fatalf("Failed to enable file logging: %v", err)
+	}
+	defer CloseFile()
+
+	for i := 0; i < 20; i++ {
+		Infof("message %d\n", i)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(current), "INFO  message 19\n") {
+		t.Fatalf("Last message missing from log file:\n%s", current)
+	}
+	if len(current) > 200 {
+		t.Fatalf("Log file not rotated, size %d", len(current))
+	}
+	for _, backup := range []string{".1", ".2"} {
+		if _, err := os.Stat(path + backup); err != nil {
+			t.Fatalf("Missing rotated file: %v", err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("More rotated files kept than configured")
+	}
+}
+
+// TestJSONFields tests that JSON mode writes one parseable object per entry
+func TestJSONFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := EnableFile(path, LOG_MAX_SIZE, 0); err != nil {
+		t.Fatalf("Failed to enable file logging: %v", err)
+	}
+	defer CloseFile()
+	SetJSON(true)
+	defer SetJSON(false)
+
+	InfoFields("Segment written\n", Fields{"uuid": "abc", "port": 5555, "bytes": 1024})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Invalid JSON entry %q: %v", data, err)
+	}
+	if entry["msg"] != "Segment written" || entry["level"] != "info" || entry["uuid"] != "abc" || entry["port"] != 5555.0 {
+		t.Fatalf("Unexpected entry: %v", entry)
+	}
+}
+
+// TestRecent tests that the ring of recent entries keeps the newest entries, newest first
+func TestRecent(t *testing.T) {
+	for i := 0; i < APP_LOG_LINES+10; i++ {
+		Infof("entry %d\n", i)
+	}
+	Debugf("not kept\n")
+
+	recent := Recent()
+	if len(recent) != APP_LOG_LINES {
+		t.Fatalf("Got %d entries, want %d", len(recent), APP_LOG_LINES)
+	}
+	if recent[0].Message != fmt.Sprintf("entry %d", APP_LOG_LINES+9) || recent[len(recent)-1].Message != "entry 10" {
+		t.Fatalf("Unexpected order: newest %q, oldest %q", recent[0].Message, recent[len(recent)-1].Message)
+	}
+}
+
+// TestFileFallback tests that a log file that cannot be opened keeps the previous sink and
+// that entries still reach stderr and are kept for the Wails runtime
+func TestFileFallback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := EnableFile(path, LOG_MAX_SIZE, 0); err != nil {
+		t.Fatalf("Failed to enable file logging: %v", err)
+	}
+	defer CloseFile()
+	if err := EnableFile(filepath.Join(dir, "missing", "app.log"), LOG_MAX_SIZE, 0); err == nil {
+		t.Fatal("Expected error for a log file in a missing directory, got nil")
+	}
+
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	savedStderr := os.Stderr
+	os.Stderr = stderr
+	contextLock.Lock()
+	pending = nil
+	contextLock.Unlock()
+	Errorf("still logged\n")
+	os.Stderr = savedStderr
+
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "ERROR still logged\n") {
+		t.Errorf("Log file = %q, want the entry in the previous file", data)
+	}
+	if data, _ := os.ReadFile(stderr.Name()); string(data) != "ERROR still logged\n" {
+		t.Errorf("Stderr = %q, want the entry", data)
+	}
+	contextLock.Lock()
+	defer contextLock.Unlock()
+	if len(pending) != 1 || pending[0] != (pendingEntry{level: "ERROR", entry: "still logged"}) {
+		t.Errorf("Pending = %+v, want the entry for replay", pending)
+	}
+	pending = nil
+}
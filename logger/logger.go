@@ -4,48 +4,92 @@ package logger
 import (
 	"context"
 	"fmt"
+	"os"
+	"sync"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
-var appContext context.Context
+// PENDING_LOG_LINES is the number of entries logged before Initialize that are kept
+// for the Wails runtime
+const PENDING_LOG_LINES = 1000
 
-// Initialize stores the application context for logging
+// pendingEntry is an entry logged before the Wails context was available
+type pendingEntry struct {
+	level string
+	entry string
+}
+
+var (
+	appContext  context.Context
+	pending     []pendingEntry
+	contextLock sync.Mutex
+)
+
+// Initialize stores the application context for logging and replays the entries
+// logged before it was available
 func Initialize(ctx context.Context) {
+	contextLock.Lock()
 	appContext = ctx
+	replay := pending
+	pending = nil
+	contextLock.Unlock()
+
+	for _, p := range replay {
+		toRuntime(ctx, p.level, p.entry)
+	}
+}
+
+// logEntry sends an entry to the Wails runtime. Before Initialize, or when running
+// headless, the entry is written to stderr and kept for replay instead.
+func logEntry(level string, entry string) {
+	contextLock.Lock()
+	ctx := appContext
+	if ctx == nil {
+		if len(pending) < PENDING_LOG_LINES {
+			pending = append(pending, pendingEntry{level: level, entry: entry})
+		}
+		contextLock.Unlock()
+		fmt.Fprintf(os.Stderr, "%-5s %s\n", level, entry)
+		return
+	}
+	contextLock.Unlock()
+
+	toRuntime(ctx, level, entry)
+}
+
+func toRuntime(ctx context.Context, level string, entry string) {
+	switch level {
+	case "ERROR":
+		runtime.LogError(ctx, entry)
+	case "DEBUG":
+		runtime.LogDebug(ctx, entry)
+	default:
+		runtime.LogInfo(ctx, entry)
+	}
 }
 
 // Info logs an informational message
 func Info(message string) {
-	if appContext != nil {
-		runtime.LogInfo(appContext, message)
-	}
+	InfoFields(message, nil)
 }
 
 // Error logs an error message
 func Error(message string) {
-	if appContext != nil {
-		runtime.LogError(appContext, message)
-	}
+	ErrorFields(message, nil)
 }
 
 // Printf logs a formatted message
 func Infof(format string, args ...interface{}) {
-	if appContext != nil {
-		runtime.LogInfo(appContext, fmt.Sprintf(format, args...))
-	}
+	Info(fmt.Sprintf(format, args...))
 }
 
 // Printf logs a formatted message
 func Errorf(format string, args ...interface{}) {
-	if appContext != nil {
-		runtime.LogError(appContext, fmt.Sprintf(format, args...))
-	}
+	Error(fmt.Sprintf(format, args...))
 }
 
 // Printf logs a formatted message
 func Debugf(format string, args ...interface{}) {
-	if appContext != nil {
-		runtime.LogDebug(appContext, fmt.Sprintf(format, args...))
-	}
+	DebugFields(fmt.Sprintf(format, args...), nil)
 }
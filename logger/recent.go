@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// APP_LOG_LINES is the number of application log entries kept in memory
+const APP_LOG_LINES = 500
+
+// AppLogEntry is an application log entry kept for the diagnostics panel
+type AppLogEntry struct {
+	Time    time.Time
+	Level   string
+	Message string
+}
+
+var (
+	recentEntries []AppLogEntry
+	recentNext    int // Index the next entry is written to once the ring is full
+	recentLock    sync.Mutex
+)
+
+// remember adds an entry to the ring of recent entries
+func remember(level string, message string) {
+	entry := AppLogEntry{Time: time.Now(), Level: level, Message: strings.TrimRight(message, "\n")}
+
+	recentLock.Lock()
+	defer recentLock.Unlock()
+	if len(recentEntries) < APP_LOG_LINES {
+		recentEntries = append(recentEntries, entry)
+		return
+	}
+	recentEntries[recentNext] = entry
+	recentNext = (recentNext + 1) % APP_LOG_LINES
+}
+
+// Recent returns the last APP_LOG_LINES info and error entries, newest first.
+// Debug entries such as the per-second rates are not kept.
+func Recent() []AppLogEntry {
+	recentLock.Lock()
+	defer recentLock.Unlock()
+
+	result := make([]AppLogEntry, 0, len(recentEntries))
+	for i := 1; i <= len(recentEntries); i++ {
+		result = append(result, recentEntries[(recentNext-i+len(recentEntries))%len(recentEntries)])
+	}
+	return result
+}
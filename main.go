@@ -2,6 +2,8 @@ package main
 
 import (
 	"embed"
+	"eth-daq-software/config"
+	"eth-daq-software/service"
 	"flag"
 	"log"
 	"os"
@@ -19,6 +21,11 @@ var assets embed.FS
 func main() {
 	var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 	var memprofile = flag.String("memprofile", "", "write memory profile to this file")
+	var configPath = flag.String("config", config.CONFIG_FILE, "configuration file")
+	var headless = flag.Bool("headless", false, "run without the GUI, serving the HTTP status API")
+	var httpAddr = flag.String("http", "", "HTTP status API address in headless mode (default: http_addr from the config, else "+config.DEFAULT_HTTP_ADDR+")")
+	var serviceAction = flag.String("service", "", "install, uninstall, start or stop the Windows service")
+	var benchmark = flag.Int("benchmark", 0, "run an ingest benchmark with this many virtual devices and exit")
 	flag.Parse()
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -28,8 +35,33 @@ func main() {
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
+	if service.IsWindowsService() {
+		if err := runService(*configPath, *httpAddr); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *serviceAction != "" {
+		if err := controlService(*serviceAction, *configPath, *httpAddr); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *benchmark > 0 {
+		if err := runBenchmark(*configPath, *benchmark); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *headless {
+		if err := runHeadless(*configPath, *httpAddr); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Create an instance of the app structure
-	app := NewApp()
+	app := NewApp(*configPath)
 
 	// Create application with options
 	err := wails.Run(&options.App{
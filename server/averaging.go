@@ -0,0 +1,150 @@
+package server
+
+import (
+	"eth-daq-software/logger"
+	"time"
+)
+
+const (
+	STATS_QUEUE_CHUNKS = 16 // Chunks a buffer can queue for its averaging goroutine
+)
+
+// startStats moves sample processing of the buffer to its own goroutine, so that the
+// averaging, history and the frontend reading them under statsMu never delay storing
+// received data. Must be called before data is added.
+func (db *DataBuffer) startStats() {
+	queue := make(chan queuedChunk, STATS_QUEUE_CHUNKS)
+	done := make(chan struct{})
+	db.statsSendLock.Lock()
+	db.statsQueue = queue
+	db.statsDone = done
+	db.statsSendLock.Unlock()
+	go func() {
+		defer close(done)
+		for queued := range queue {
+			db.statsMu.Lock()
+			if queued.chunk == nil {
+				// Queued by queueAlignmentReset
+				db.resetAlignment()
+				db.statsMu.Unlock()
+				continue
+			}
+			db.processBytes((*queued.chunk)[:queued.n])
+			db.statsMu.Unlock()
+			readChunks.Put(queued.chunk)
+		}
+	}()
+}
+
+// stopStats processes the queued chunks and stops the averaging goroutine, later data is
+// processed in AddData
+func (db *DataBuffer) stopStats() {
+	db.statsSendLock.Lock()
+	queue, done := db.statsQueue, db.statsDone
+	db.statsQueue = nil
+	if queue != nil {
+		close(queue)
+	}
+	db.statsSendLock.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// queueStats passes received data to the averaging goroutine, taking ownership of chunk
+// if it is not nil. Data without a chunk is copied into a pooled chunk. Without an
+// averaging goroutine the samples are processed right away. The caller must not hold
+// db.mu, as the send blocks while the queue is full.
+func (db *DataBuffer) queueStats(data []byte, chunk *[]byte) {
+	db.statsSendLock.RLock()
+	defer db.statsSendLock.RUnlock()
+	if db.statsQueue == nil {
+		db.statsMu.Lock()
+		db.processBytes(data)
+		db.statsMu.Unlock()
+		if chunk != nil {
+			readChunks.Put(chunk)
+		}
+		return
+	}
+
+	if chunk != nil {
+		db.statsQueue <- queuedChunk{chunk: chunk, n: len(data)}
+		return
+	}
+	copied := getReadChunk(len(data))
+	copy(*copied, data)
+	db.statsQueue <- queuedChunk{chunk: copied, n: len(data)}
+}
+
+// updateSampleRate recomputes the sample rate about once a second. The caller must hold
+// db.statsMu.
+func (db *DataBuffer) updateSampleRate() {
+	elapsed := time.Since(db.statsCheck).Seconds()
+	if elapsed < 1.0 {
+		return
+	}
+	db.sampleRate = float64(db.samplesReceived) / elapsed
+	db.samplesReceived = 0
+	db.statsCheck = time.Now()
+
+	if db.outOfRange > db.outOfRangeLogged {
+		logger.ErrorFields("Readings out of range", logger.Fields{
+			"uuid":  db.uuid,
+			"ip":    db.clientIP,
+			"port":  db.port,
+			"count": db.outOfRange - db.outOfRangeLogged,
+			"total": db.outOfRange,
+		})
+		db.outOfRangeLogged = db.outOfRange
+	}
+}
+
+// queueAlignmentReset resets the sample alignment once the data queued before it has been
+// processed, see resetAlignment
+func (db *DataBuffer) queueAlignmentReset() {
+	db.statsSendLock.RLock()
+	defer db.statsSendLock.RUnlock()
+	if db.statsQueue == nil {
+		db.statsMu.Lock()
+		db.resetAlignment()
+		db.statsMu.Unlock()
+		return
+	}
+	db.statsQueue <- queuedChunk{}
+}
+
+// resetAlignment discards a partial sample and restarts interleaved channels at the first
+// channel, such as the thermocouple port at the internal sensor, as a new connection
+// starts on a frame boundary. A discarded partial sample or frame is counted as a
+// misalignment. The caller must hold db.statsMu.
+func (db *DataBuffer) resetAlignment() {
+	if db.samples.reset() {
+		db.misalignments++
+		logger.Errorf("Connection of %s:%d ended mid-sample, discarding the partial sample\n", db.clientIP, db.port)
+	}
+}
+
+// GetMisalignments returns the number of connections that ended mid-sample since the
+// buffer was created
+func (db *DataBuffer) GetMisalignments() int64 {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	return db.misalignments
+}
+
+// GetOutliers returns the number of samples left out of the averaging windows as outliers
+// since the buffer was created
+func (db *DataBuffer) GetOutliers() int64 {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	return db.outliers
+}
+
+// GetOutOfRangeCount returns the number of samples the decoder could not convert, such as
+// thermocouple readings outside the table of their type, since the buffer was created
+func (db *DataBuffer) GetOutOfRangeCount() int64 {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	return db.outOfRange
+}
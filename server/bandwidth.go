@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/logger"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	BANDWIDTH_FILE          = "bandwidth.json" // Bytes received per device and day, in the data directory
+	BANDWIDTH_SAVE_INTERVAL = time.Minute      // How often changed byte counts are saved
+	BANDWIDTH_DAY_FORMAT    = "2006-01-02"     // Local date the bytes of a day are kept under
+)
+
+// BandwidthDay is the data received from a device on one day
+type BandwidthDay struct {
+	Date  string // Local date, BANDWIDTH_DAY_FORMAT
+	Bytes int64
+}
+
+// BandwidthStore counts the bytes received from each device per day and persists them,
+// so that the totals survive disconnects and restarts. Devices are counted by UUID, or by
+// sanitized IP before their handshake. Connections count their bytes themselves, the
+// counts are added to the store in batches by foldBandwidth.
+type BandwidthStore struct {
+	path  string
+	days  map[string]map[string]int64 // Bytes by device and date
+	dirty bool
+	mu    sync.Mutex
+}
+
+// NewBandwidthStore creates an empty store backed by the given file
+func NewBandwidthStore(path string) *BandwidthStore {
+	return &BandwidthStore{
+		path: path,
+		days: make(map[string]map[string]int64),
+	}
+}
+
+// Load adds the byte counts on disk to those counted so far. A missing file is not an error.
+func (b *BandwidthStore) Load() error {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read bandwidth history: %v", err)
+	}
+	days := make(map[string]map[string]int64)
+	if err := json.Unmarshal(data, &days); err != nil {
+		return fmt.Errorf("failed to parse bandwidth history: %v", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for device, loaded := range days {
+		if counted, exists := b.days[device]; exists {
+			for day, bytes := range counted {
+				loaded[day] += bytes
+			}
+		}
+		b.days[device] = loaded
+	}
+	return nil
+}
+
+// Save writes the byte counts to disk if they changed since the last save
+func (b *BandwidthStore) Save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(b.days, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bandwidth history: %v", err)
+	}
+	if err := os.WriteFile(b.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write bandwidth history: %v", err)
+	}
+	b.dirty = false
+	return nil
+}
+
+// add counts bytes received from devices today
+func (b *BandwidthStore) add(bytes map[string]int64) {
+	if len(bytes) == 0 {
+		return
+	}
+	day := time.Now().Format(BANDWIDTH_DAY_FORMAT)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for device, n := range bytes {
+		days, exists := b.days[device]
+		if !exists {
+			days = make(map[string]int64)
+			b.days[device] = days
+		}
+		days[day] += n
+	}
+	b.dirty = true
+}
+
+// Total returns the bytes received from a device over all days
+func (b *BandwidthStore) Total(device string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var total int64
+	for _, bytes := range b.days[device] {
+		total += bytes
+	}
+	return total
+}
+
+// History returns the bytes received from a device per day, oldest first
+func (b *BandwidthStore) History(device string) []BandwidthDay {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	days := b.days[device]
+	history := make([]BandwidthDay, 0, len(days))
+	for _, date := range slices.Sorted(maps.Keys(days)) {
+		history = append(history, BandwidthDay{Date: date, Bytes: days[date]})
+	}
+	return history
+}
+
+// Totals returns the bytes received from every device counted so far
+func (b *BandwidthStore) Totals() map[string]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	totals := make(map[string]int64, len(b.days))
+	for device, days := range b.days {
+		for _, bytes := range days {
+			totals[device] += bytes
+		}
+	}
+	return totals
+}
+
+// foldBandwidth adds the bytes received on the connections of buffers since they were last
+// folded to the bandwidth store. They are accounted to the UUID the device has by now, or
+// to its IP before the handshake.
+func (s *Server) foldBandwidth(buffers ...*DataBuffer) {
+	bytes := make(map[string]int64)
+	s.buffersLock.RLock()
+	for _, buffer := range buffers {
+		n := buffer.unaccountedBytes.Swap(0)
+		if n == 0 {
+			continue
+		}
+		device := buffer.uuid
+		if device == "" {
+			device = buffer.clientIP
+		}
+		bytes[device] += n
+	}
+	s.buffersLock.RUnlock()
+	s.bandwidth.add(bytes)
+}
+
+// foldAllBandwidth adds the bytes received on all connections to the bandwidth store
+func (s *Server) foldAllBandwidth() {
+	s.buffersLock.RLock()
+	buffers := slices.Collect(maps.Values(s.buffers))
+	s.buffersLock.RUnlock()
+	s.foldBandwidth(buffers...)
+}
+
+// StartBandwidthAccounting folds and saves the byte counts every BANDWIDTH_SAVE_INTERVAL
+// until the server is stopped, Stop saves them a last time
+func (s *Server) StartBandwidthAccounting() {
+	if err := s.bandwidth.Load(); err != nil {
+		logger.Errorf("%v\n", err)
+	}
+	go func() {
+		ticker := time.NewTicker(BANDWIDTH_SAVE_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.foldAllBandwidth()
+				if err := s.bandwidth.Save(); err != nil {
+					logger.Errorf("%v\n", err)
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// GetBandwidthHistory returns the bytes received from a device per day, by UUID or by
+// sanitized IP for data received before a handshake
+func (s *Server) GetBandwidthHistory(device string) []BandwidthDay {
+	s.foldAllBandwidth()
+	return s.bandwidth.History(device)
+}
+
+// GetBandwidthTotals returns the bytes received from every device since accounting began
+func (s *Server) GetBandwidthTotals() map[string]int64 {
+	s.foldAllBandwidth()
+	return s.bandwidth.Totals()
+}
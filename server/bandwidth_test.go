@@ -0,0 +1,54 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestBandwidthStore tests that byte counts are kept per device and day across a reload
+func TestBandwidthStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), BANDWIDTH_FILE)
+	store := NewBandwidthStore(path)
+	store.add(map[string]int64{"dev1": 100, "10_0_0_2": 7})
+	store.add(map[string]int64{"dev1": 50})
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+
+	reloaded := NewBandwidthStore(path)
+	reloaded.add(map[string]int64{"dev1": 1})
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	today := time.Now().Format(BANDWIDTH_DAY_FORMAT)
+	if history := reloaded.History("dev1"); len(history) != 1 || history[0] != (BandwidthDay{Date: today, Bytes: 151}) {
+		t.Errorf("History(dev1) = %+v, want 151 bytes today", history)
+	}
+	if totals := reloaded.Totals(); len(totals) != 2 || totals["10_0_0_2"] != 7 {
+		t.Errorf("Totals() = %v, want dev1 and 10_0_0_2", totals)
+	}
+}
+
+// TestBandwidthFold tests that bytes counted by a connection are accounted to the UUID its
+// device has when they are folded into the store
+func TestBandwidthFold(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "", 1024)
+	s.buffers[BufferKey{IP: "10.0.0.2", Port: 5556}] = buffer
+
+	buffer.unaccountedBytes.Add(100)
+	s.buffersLock.Lock()
+	buffer.uuid = "dev1" // Handshake after the data arrived
+	s.buffersLock.Unlock()
+	buffer.unaccountedBytes.Add(20)
+	if totals := s.GetBandwidthTotals(); len(totals) != 1 || totals["dev1"] != 120 {
+		t.Errorf("GetBandwidthTotals() = %v, want 120 bytes of dev1", totals)
+	}
+	if totals := s.GetBandwidthTotals(); totals["dev1"] != 120 {
+		t.Errorf("GetBandwidthTotals() = %v after a second fold, want 120 bytes of dev1", totals)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BENCHMARK_CHUNK_SIZE       = 64 * 1024             // Bytes written by a virtual connection at a time
+	BENCHMARK_PACING_INTERVAL  = 10 * time.Millisecond // Interval the offered rate is paced over
+	BENCHMARK_SAMPLE_INTERVAL  = 50 * time.Millisecond // Interval memory use is sampled at
+	BENCHMARK_SUSTAINED_RATIO  = 0.95                  // Fraction of the offered rate a step must ingest to be sustained
+	BENCHMARK_MAX_LATENCY_LOGS = 100000                // Flush latencies kept per step
+)
+
+// BenchmarkOptions controls an ingest benchmark
+type BenchmarkOptions struct {
+	Devices     int     // Virtual devices, each sending on every data port
+	StartRate   float64 // MB/s offered per connection in the first step
+	MaxRate     float64 // MB/s per connection after which the benchmark stops, 0 runs until a step is not sustained
+	StepSeconds float64 // Duration of each step
+}
+
+// LatencyStats is the distribution of flush latencies, from taking the data out of the
+// buffer until the segment is written
+type LatencyStats struct {
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// BenchmarkStep is the result of one offered rate
+type BenchmarkStep struct {
+	OfferedRate     float64 // MB/s offered over all connections
+	IngestedRate    float64 // MB/s accepted by the server over all connections
+	Sustained       bool    // Ingested at least BENCHMARK_SUSTAINED_RATIO of the offered rate without dropped segments
+	DroppedSegments int64   // Segments dropped by the write queue, see config.WRITE_QUEUE_DROP_OLDEST
+	FlushLatency    LatencyStats
+}
+
+// BenchmarkResult is the result of an ingest benchmark on the current host
+type BenchmarkResult struct {
+	Host             string // Operating system, architecture and CPUs
+	Connections      int
+	Steps            []BenchmarkStep
+	MaxSustainedRate float64 // Highest sustained MB/s over all connections, 0 if no step was sustained
+	MemoryHighWater  int64   // Peak of buffered and pending write bytes, see MemoryStats
+	HeapHighWater    uint64  // Peak heap in use
+}
+
+// latencyLog collects flush latencies
+type latencyLog struct {
+	latencies []time.Duration
+	mu        sync.Mutex
+}
+
+func (l *latencyLog) add(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.latencies) < BENCHMARK_MAX_LATENCY_LOGS {
+		l.latencies = append(l.latencies, latency)
+	}
+}
+
+// stats returns the distribution of the collected latencies
+func (l *latencyLog) stats() LatencyStats {
+	l.mu.Lock()
+	latencies := slices.Clone(l.latencies)
+	l.mu.Unlock()
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		return latencies[min(int(math.Ceil(p*float64(len(latencies))))-1, len(latencies)-1)]
+	}
+	return LatencyStats{
+		Count: len(latencies),
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		Max:   latencies[len(latencies)-1],
+	}
+}
+
+// benchmarkData returns a chunk of synthetic samples, a slow sine with noise so that it
+// compresses like a real signal rather than like zeros
+func benchmarkData() []byte {
+	data := make([]byte, BENCHMARK_CHUNK_SIZE)
+	for i := 0; i < len(data)/2; i++ {
+		sample := uint16(32768 + 8000*math.Sin(float64(i)/500) + float64(i*7919%64))
+		binary.LittleEndian.PutUint16(data[2*i:], sample)
+	}
+	return data
+}
+
+// RunBenchmark drives a separate server instance with synthetic traffic from virtual
+// devices, doubling the offered rate each step until a step is not sustained. The server
+// uses the current settings and writes to a temporary directory in the data directory,
+// which is removed afterwards, so that the disk of real captures is measured.
+func (s *Server) RunBenchmark(opts BenchmarkOptions) (BenchmarkResult, error) {
+	if opts.Devices <= 0 || opts.StartRate <= 0 || opts.StepSeconds <= 0 {
+		return BenchmarkResult{}, fmt.Errorf("devices, start rate and step duration must be positive")
+	}
+	cfg := *s.settings()
+	dir, err := os.MkdirTemp(cfg.DataDir, "benchmark-")
+	if err != nil {
+		return BenchmarkResult{}, fmt.Errorf("failed to create benchmark directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cfg.DataDir = dir
+	cfg.RetentionDays = 0
+
+	bench := NewServer(&cfg)
+	bench.registry = NewDeviceRegistry(filepath.Join(dir, DEVICE_REGISTRY_FILE))
+	bench.calibrations = NewCalibrationStore(filepath.Join(dir, CALIBRATION_FILE))
+	defer func() {
+		bench.Stop(time.Minute)
+		bench.writer.close()
+	}()
+
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for device := 0; device < opts.Devices; device++ {
+		for _, port := range cfg.DataPorts {
+			client, server := net.Pipe()
+			addr := virtualAddr(fmt.Sprintf("benchmark-%d:%d", device+1, port))
+			bench.acceptConnection(virtualConn{Conn: server, addr: addr}, port)
+			conns = append(conns, client)
+		}
+	}
+
+	result := BenchmarkResult{
+		Host:        fmt.Sprintf("%s/%s, %d CPUs", runtime.GOOS, runtime.GOARCH, runtime.NumCPU()),
+		Connections: len(conns),
+	}
+
+	// Sample the memory high-water marks while the steps run
+	stopSampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(BENCHMARK_SAMPLE_INTERVAL)
+		defer ticker.Stop()
+		for {
+			memory := bench.GetMemoryStats()
+			result.MemoryHighWater = max(result.MemoryHighWater, memory.BufferedBytes+memory.PendingWriteBytes)
+			result.HeapHighWater = max(result.HeapHighWater, memory.HeapBytes)
+			select {
+			case <-ticker.C:
+			case <-stopSampling:
+				return
+			}
+		}
+	}()
+
+	data := benchmarkData()
+	for rate := opts.StartRate; opts.MaxRate <= 0 || rate <= opts.MaxRate; rate *= 2 {
+		step := runBenchmarkStep(bench, conns, data, rate, time.Duration(opts.StepSeconds*float64(time.Second)))
+		result.Steps = append(result.Steps, step)
+		if !step.Sustained {
+			break
+		}
+		result.MaxSustainedRate = step.IngestedRate
+	}
+	close(stopSampling)
+	<-sampled
+	return result, nil
+}
+
+// runBenchmarkStep offers rate MB/s on every connection for duration
+func runBenchmarkStep(bench *Server, conns []net.Conn, data []byte, rate float64, duration time.Duration) BenchmarkStep {
+	latencies := &latencyLog{}
+	bench.writer.latencies.Store(latencies)
+	defer bench.writer.latencies.Store(nil)
+	dropped := bench.writer.queue.snapshot().DroppedSegments
+
+	var written atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+	for _, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(BENCHMARK_PACING_INTERVAL)
+			defer ticker.Stop()
+			budget := 0.0
+			last := start
+			for now := range ticker.C {
+				if now.After(deadline) {
+					return
+				}
+				// Offered data that could not be written in time is not made up later
+				budget = min(budget+rate*1e6*now.Sub(last).Seconds(), 2*rate*1e6*BENCHMARK_PACING_INTERVAL.Seconds())
+				last = now
+				for budget >= 1 && time.Now().Before(deadline) {
+					n := min(int(budget), len(data)) &^ 1 // Whole samples
+					if n == 0 {
+						break
+					}
+					if _, err := conn.Write(data[:n]); err != nil {
+						return
+					}
+					written.Add(int64(n))
+					budget -= float64(n)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	// Flush what the step left in the buffers, so that its latencies are included
+	bench.buffersLock.RLock()
+	for _, buffer := range bench.buffers {
+		buffer.FlushAsync()
+	}
+	bench.buffersLock.RUnlock()
+	bench.writer.wait()
+
+	step := BenchmarkStep{
+		OfferedRate:     rate * float64(len(conns)),
+		IngestedRate:    float64(written.Load()) / 1e6 / elapsed,
+		DroppedSegments: bench.writer.queue.snapshot().DroppedSegments - dropped,
+		FlushLatency:    latencies.stats(),
+	}
+	step.Sustained = step.IngestedRate >= BENCHMARK_SUSTAINED_RATIO*step.OfferedRate && step.DroppedSegments == 0
+	return step
+}
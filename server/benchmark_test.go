@@ -0,0 +1,35 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRunBenchmark tests that a short benchmark reports its steps and removes its data
+func TestRunBenchmark(t *testing.T) {
+	s := newDerivedTestServer(t, "vds", nil, nil)
+
+	result, err := s.RunBenchmark(BenchmarkOptions{Devices: 1, StartRate: 0.5, MaxRate: 1, StepSeconds: 0.2})
+	if err != nil {
+		t.Fatalf("RunBenchmark() = %v", err)
+	}
+	if result.Connections != len(s.settings().DataPorts) || len(result.Steps) == 0 || len(result.Steps) > 2 {
+		t.Fatalf("RunBenchmark() = %+v, want 1 or 2 steps over every data port", result)
+	}
+	if step := result.Steps[0]; step.IngestedRate <= 0 || step.OfferedRate != 0.5*float64(result.Connections) {
+		t.Errorf("First step = %+v", step)
+	}
+	if result.MemoryHighWater < 0 || result.HeapHighWater == 0 {
+		t.Errorf("High-water marks = %d, %d", result.MemoryHighWater, result.HeapHighWater)
+	}
+
+	entries, _ := os.ReadDir(s.settings().DataDir)
+	if len(entries) != 0 {
+		t.Errorf("Benchmark left %d entries in the data directory", len(entries))
+	}
+
+	if _, err := s.RunBenchmark(BenchmarkOptions{StepSeconds: float64(time.Second)}); err == nil {
+		t.Error("Expected error without devices, got nil")
+	}
+}
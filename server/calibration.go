@@ -0,0 +1,235 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/logger"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+const (
+	CALIBRATION_FILE = "calibration.json"
+)
+
+// Calibration holds the correction applied to scaled samples of one channel.
+// If Polynomial is set it takes precedence over Gain and Offset and is evaluated
+// as Polynomial[0] + Polynomial[1]*x + Polynomial[2]*x^2 + ...
+type Calibration struct {
+	Gain       float64
+	Offset     float64
+	Polynomial []float64 `json:",omitempty"`
+}
+
+// Apply returns the calibrated value of a sample
+func (c *Calibration) Apply(value float64) float64 {
+	if c == nil {
+		return value
+	}
+	if len(c.Polynomial) > 0 {
+		return evalPoly(c.Polynomial, value)
+	}
+	return value*c.Gain + c.Offset
+}
+
+// Validate checks that the calibration does not lose the signal. A zero gain would turn
+// every sample into the offset, so an offset-only correction must set Gain to 1.
+func (c Calibration) Validate() error {
+	if len(c.Polynomial) > 0 {
+		for _, coefficient := range c.Polynomial {
+			if math.IsNaN(coefficient) || math.IsInf(coefficient, 0) {
+				return fmt.Errorf("polynomial coefficients must be finite")
+			}
+		}
+		return nil
+	}
+	if c.Gain == 0 {
+		return fmt.Errorf("gain must not be zero, use 1 for an offset-only calibration")
+	}
+	if math.IsNaN(c.Gain) || math.IsInf(c.Gain, 0) || math.IsNaN(c.Offset) || math.IsInf(c.Offset, 0) {
+		return fmt.Errorf("gain and offset must be finite")
+	}
+	return nil
+}
+
+// CalibrationKey identifies a channel of a device. Channel is 0 for the primary
+// channel and 1 for channel B of the thermocouple port.
+type CalibrationKey struct {
+	UUID    string
+	Port    int
+	Channel int
+}
+
+// CalibrationEntry is a calibration together with the channel it belongs to
+type CalibrationEntry struct {
+	CalibrationKey
+	Calibration Calibration
+}
+
+// CalibrationStore keeps per-device calibration coefficients and persists them to disk
+type CalibrationStore struct {
+	path         string
+	calibrations map[CalibrationKey]*Calibration
+	mu           sync.RWMutex
+}
+
+// NewCalibrationStore creates an empty calibration store backed by the given file
+func NewCalibrationStore(path string) *CalibrationStore {
+	return &CalibrationStore{
+		path:         path,
+		calibrations: make(map[CalibrationKey]*Calibration),
+	}
+}
+
+// Load reads the calibrations from disk. A missing file is not an error.
+func (cs *CalibrationStore) Load() error {
+	data, err := os.ReadFile(cs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read calibration file: %v", err)
+	}
+
+	var entries []CalibrationEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse calibration file: %v", err)
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.calibrations = make(map[CalibrationKey]*Calibration)
+	for _, entry := range entries {
+		calibration := entry.Calibration
+		if err := calibration.Validate(); err != nil {
+			logger.Errorf("Ignoring calibration of %s port %d channel %d: %v\n",
+				entry.UUID, entry.Port, entry.Channel, err)
+			continue
+		}
+		cs.calibrations[entry.CalibrationKey] = &calibration
+	}
+	logger.Infof("Loaded %d calibrations from %s\n", len(entries), cs.path)
+	return nil
+}
+
+// save writes the calibrations to disk, the caller must hold the lock
+func (cs *CalibrationStore) save() error {
+	entries := make([]CalibrationEntry, 0, len(cs.calibrations))
+	for key, calibration := range cs.calibrations {
+		entries = append(entries, CalibrationEntry{CalibrationKey: key, Calibration: *calibration})
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode calibrations: %v", err)
+	}
+	if err := os.WriteFile(cs.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write calibration file: %v", err)
+	}
+	return nil
+}
+
+// Get returns the calibration for a channel, or nil if it is uncalibrated
+func (cs *CalibrationStore) Get(key CalibrationKey) *Calibration {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.calibrations[key]
+}
+
+// Set stores and persists the calibration for a channel
+func (cs *CalibrationStore) Set(key CalibrationKey, calibration Calibration) error {
+	if err := calibration.Validate(); err != nil {
+		return fmt.Errorf("invalid calibration: %v", err)
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.calibrations[key] = &calibration
+	return cs.save()
+}
+
+// Reset removes all calibrations of a device and persists the change
+func (cs *CalibrationStore) Reset(uuid string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for key := range cs.calibrations {
+		if key.UUID == uuid {
+			delete(cs.calibrations, key)
+		}
+	}
+	return cs.save()
+}
+
+// List returns the calibrations of a device
+func (cs *CalibrationStore) List(uuid string) []CalibrationEntry {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	entries := make([]CalibrationEntry, 0)
+	for key, calibration := range cs.calibrations {
+		if key.UUID == uuid {
+			entries = append(entries, CalibrationEntry{CalibrationKey: key, Calibration: *calibration})
+		}
+	}
+	return entries
+}
+
+// setCalibration replaces the calibrations used by processBytes
+func (db *DataBuffer) setCalibration(calibration *Calibration, calibrationB *Calibration) {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	db.calibration = calibration
+	db.calibrationB = calibrationB
+}
+
+// applyCalibrations looks up the calibrations for a buffer and installs them
+func (s *Server) applyCalibrations(buffer *DataBuffer, uuid string) {
+	buffer.setCalibration(
+		s.calibrations.Get(CalibrationKey{UUID: uuid, Port: buffer.port, Channel: 0}),
+		s.calibrations.Get(CalibrationKey{UUID: uuid, Port: buffer.port, Channel: 1}),
+	)
+}
+
+// refreshCalibrations re-applies calibrations to all live buffers of a device
+func (s *Server) refreshCalibrations(uuid string) {
+	s.buffersLock.RLock()
+	defer s.buffersLock.RUnlock()
+	for _, buffer := range s.buffers {
+		if buffer.uuid == uuid {
+			s.applyCalibrations(buffer, uuid)
+		}
+	}
+}
+
+// LoadCalibrations loads the persisted calibrations
+func (s *Server) LoadCalibrations() error {
+	return s.calibrations.Load()
+}
+
+// SetCalibration sets the calibration of a device channel, applies it to live buffers and persists it
+func (s *Server) SetCalibration(key CalibrationKey, calibration Calibration) error {
+	if key.UUID == "" {
+		return fmt.Errorf("device UUID is required")
+	}
+	if err := s.calibrations.Set(key, calibration); err != nil {
+		return err
+	}
+	s.refreshCalibrations(key.UUID)
+	logger.Infof("Calibration set for %s port %d channel %d: %+v\n", key.UUID, key.Port, key.Channel, calibration)
+	return nil
+}
+
+// ResetCalibration removes all calibrations of a device
+func (s *Server) ResetCalibration(uuid string) error {
+	if err := s.calibrations.Reset(uuid); err != nil {
+		return err
+	}
+	s.refreshCalibrations(uuid)
+	logger.Infof("Calibration reset for %s\n", uuid)
+	return nil
+}
+
+// GetCalibrations returns all calibrations of a device
+func (s *Server) GetCalibrations(uuid string) []CalibrationEntry {
+	return s.calibrations.List(uuid)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCalibrationApply tests linear and polynomial calibrations
+func TestCalibrationApply(t *testing.T) {
+	var uncalibrated *Calibration
+	if got := uncalibrated.Apply(2); got != 2 {
+		t.Errorf("nil Apply(2) = %v, want 2", got)
+	}
+	if got := (&Calibration{Gain: 2, Offset: 1}).Apply(2); got != 5 {
+		t.Errorf("Linear Apply(2) = %v, want 5", got)
+	}
+	if got := (&Calibration{Polynomial: []float64{1, 0, 1}}).Apply(2); got != 5 {
+		t.Errorf("Polynomial Apply(2) = %v, want 5", got)
+	}
+}
+
+// TestCalibrationStore tests that calibrations are validated, persisted and reset per device
+func TestCalibrationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CALIBRATION_FILE)
+	store := NewCalibrationStore(path)
+	if err := store.Load(); err != nil {
+		t.Fatalf("Load() of a missing file = %v", err)
+	}
+
+	vgs := CalibrationKey{UUID: "dev1", Port: 5556}
+	tc := CalibrationKey{UUID: "dev1", Port: 5557, Channel: 1}
+	if err := store.Set(vgs, Calibration{Offset: 0.1}); err == nil {
+		t.Error("Expected error for a zero gain, got nil")
+	}
+	if err := store.Set(vgs, Calibration{Gain: 1, Offset: 0.1}); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	if err := store.Set(tc, Calibration{Polynomial: []float64{0, 1.01}}); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	if err := store.Set(CalibrationKey{UUID: "dev2", Port: 5556}, Calibration{Gain: 2}); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+
+	reloaded := NewCalibrationStore(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if got := reloaded.Get(vgs); got == nil || got.Gain != 1 || got.Offset != 0.1 {
+		t.Errorf("Reloaded %+v = %+v", vgs, got)
+	}
+	if got := reloaded.Get(tc); got == nil || len(got.Polynomial) != 2 {
+		t.Errorf("Reloaded %+v = %+v", tc, got)
+	}
+	if entries := reloaded.List("dev1"); len(entries) != 2 {
+		t.Errorf("List(dev1) returned %d entries, want 2", len(entries))
+	}
+
+	if err := reloaded.Reset("dev1"); err != nil {
+		t.Fatalf("Reset() = %v", err)
+	}
+	if entries := reloaded.List("dev1"); len(entries) != 0 {
+		t.Errorf("List(dev1) after Reset returned %d entries, want 0", len(entries))
+	}
+	if reloaded.Get(CalibrationKey{UUID: "dev2", Port: 5556}) == nil {
+		t.Error("Reset(dev1) removed the calibration of dev2")
+	}
+
+	// Entries with a zero gain written by older versions are ignored
+	os.WriteFile(path, []byte(`[{"UUID":"dev1","Port":5556,"Channel":0,"Calibration":{"Gain":0,"Offset":1}}]`), 0644)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if got := reloaded.Get(vgs); got != nil {
+		t.Errorf("Loaded zero gain calibration %+v, want it ignored", got)
+	}
+}
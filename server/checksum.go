@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CHECKSUM_SUFFIX = ".sha256" // Appended to the segment name for its checksum sidecar
+)
+
+// writeChecksum writes the SHA-256 sidecar of a segment in the format of sha256sum, so that
+// "sha256sum -c" can check a segment without this application
+func writeChecksum(path string, sum []byte) error {
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), filepath.Base(path))
+	if err := os.WriteFile(path+CHECKSUM_SUFFIX, []byte(line), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum of %s: %v", path, err)
+	}
+	return nil
+}
+
+// readChecksum reads the checksum from the sidecar of a segment
+func readChecksum(path string) ([]byte, error) {
+	data, err := os.ReadFile(path + CHECKSUM_SUFFIX)
+	if err != nil {
+		return nil, err
+	}
+	digest, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	sum, err := hex.DecodeString(digest)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid checksum file %s", path+CHECKSUM_SUFFIX)
+	}
+	return sum, nil
+}
+
+// fileChecksum computes the SHA-256 of a file
+func fileChecksum(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, bufio.NewReader(file)); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// VerifyResult lists the segments of a data directory by the outcome of their check.
+// Paths are relative to the data directory.
+type VerifyResult struct {
+	Verified   int      // Segments matching their checksum
+	Mismatched []string // Segments that changed since they were written
+	Missing    []string // Segments without a checksum sidecar
+	Errors     []string // Segments or sidecars that could not be read
+}
+
+// VerifyDataDir re-computes the checksum of every segment in the data directory and its
+// session directories and compares it with the segment's sidecar
+func (s *Server) VerifyDataDir() (VerifyResult, error) {
+	dataDir := s.settings().DataDir
+	result := VerifyResult{Mismatched: []string{}, Missing: []string{}, Errors: []string{}}
+	err := filepath.WalkDir(dataDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !isSegmentFile(path) {
+			return nil
+		}
+		name, _ := filepath.Rel(dataDir, path)
+
+		want, err := readChecksum(path)
+		if os.IsNotExist(err) {
+			result.Missing = append(result.Missing, name)
+			return nil
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", name, err))
+			return nil
+		}
+		got, err := fileChecksum(path)
+		switch {
+		case err != nil:
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", name, err))
+		case string(got) != string(want):
+			result.Mismatched = append(result.Mismatched, name)
+		default:
+			result.Verified++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to read data directory: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestVerifyDataDir tests that appended and compressed segments get checksums and that
+// changed and unchecked segments are reported
+func TestVerifyDataDir(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+
+	appended := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1000)
+	appended.writer = s.writer
+	appended.AddData(make([]byte, 100))
+	compressed := NewDataBuffer(5555, "10.0.0.2", 10, "dev1", 1000)
+	compressed.writer = s.writer
+	compressed.codec, _ = compress.CodecByName("zstd")
+	compressed.AddData(make([]byte, 100))
+	for _, buffer := range []*DataBuffer{appended, compressed} {
+		if err := buffer.FlushSync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := s.VerifyDataDir()
+	if err != nil || result.Verified != 2 || len(result.Mismatched)+len(result.Missing)+len(result.Errors) != 0 {
+		t.Fatalf("VerifyDataDir() = %+v, %v, want 2 verified segments", result, err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(cfg.DataDir, "port5556_*.bin"))
+	os.WriteFile(segments[0], make([]byte, 99), 0644)
+	unchecked := writeSegment(t, cfg.DataDir, 5557, 1, []uint16{0})
+
+	result, err = s.VerifyDataDir()
+	want := VerifyResult{
+		Verified:   1,
+		Mismatched: []string{filepath.Base(segments[0])},
+		Missing:    []string{filepath.Base(unchecked)},
+		Errors:     []string{},
+	}
+	if err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("VerifyDataDir() = %+v, %v, want %+v", result, err, want)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CLIENT_TIMEOUT = 5 * time.Second // Time a request to the status API of another server may take
+)
+
+// ErrNotFound is returned for a device or channel the server does not have
+var ErrNotFound = errors.New("not found")
+
+// Client reads the HTTP status API of a server running in another process, such as the
+// Windows service the GUI attaches to
+type Client struct {
+	base   string
+	client *http.Client
+}
+
+// NewClient returns a client of the status API on addr ("host:port" or ":port" for the
+// local host)
+func NewClient(addr string) *Client {
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	return &Client{base: "http://" + addr, client: &http.Client{Timeout: CLIENT_TIMEOUT}}
+}
+
+// do sends a request to the status API and decodes the JSON response into value
+func (c *Client) do(method string, path string, body interface{}, value interface{}) error {
+	reader := bytes.NewReader(nil)
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach server at %s: %v", c.base, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var message bytes.Buffer
+		message.ReadFrom(response.Body)
+		if response.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("server at %s: %w: %s", c.base, ErrNotFound, strings.TrimSpace(message.String()))
+		}
+		return fmt.Errorf("server at %s: %s", c.base, strings.TrimSpace(message.String()))
+	}
+	if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+		return fmt.Errorf("invalid response from %s%s: %v", c.base, path, err)
+	}
+	return nil
+}
+
+// Status returns the state of the server, failing if addr does not serve the status API
+func (c *Client) Status() (Status, error) {
+	var status Status
+	if err := c.do(http.MethodGet, "/api/status", nil, &status); err != nil {
+		return Status{}, err
+	}
+	if status.Listeners == nil {
+		return Status{}, fmt.Errorf("%s is not an eth-daq-software status API", c.base)
+	}
+	return status, nil
+}
+
+// Devices returns the connected devices by sanitized IP
+func (c *Client) Devices() (map[string]IPConnection, error) {
+	devices := make(map[string]IPConnection)
+	err := c.do(http.MethodGet, "/api/devices", nil, &devices)
+	return devices, err
+}
+
+// Device returns the connection of the device connected from ip
+func (c *Client) Device(ip string) (IPConnection, error) {
+	var connection IPConnection
+	err := c.do(http.MethodGet, "/api/devices/"+url.PathEscape(ip), nil, &connection)
+	return connection, err
+}
+
+// Channel returns the rate and averages of a data channel
+func (c *Client) Channel(key BufferKey) (ChannelValues, error) {
+	query := url.Values{"ip": {key.IP}, "port": {strconv.Itoa(key.Port)}}
+	if key.UUID != "" {
+		query.Set("uuid", key.UUID)
+	}
+	var values ChannelValues
+	err := c.do(http.MethodGet, "/api/channel?"+query.Encode(), nil, &values)
+	return values, err
+}
+
+// Rates returns the transfer rates in MB/s by "ip:port"
+func (c *Client) Rates() (map[string]float64, error) {
+	rates := make(map[string]float64)
+	err := c.do(http.MethodGet, "/api/rates", nil, &rates)
+	return rates, err
+}
+
+// RateHistory returns the per-second transfer rates of a channel over the last seconds
+func (c *Client) RateHistory(key string, seconds int) ([]RateSample, error) {
+	query := url.Values{"key": {key}, "seconds": {strconv.Itoa(seconds)}}
+	samples := []RateSample{}
+	err := c.do(http.MethodGet, "/api/rates/history?"+query.Encode(), nil, &samples)
+	return samples, err
+}
+
+// Logs returns the buffered log lines of an IP with one of the severities, newest first
+func (c *Client) Logs(ip string, severities []string) ([]LogEntry, error) {
+	query := url.Values{"ip": {ip}}
+	if len(severities) > 0 {
+		query.Set("severity", strings.Join(severities, ","))
+	}
+	entries := []LogEntry{}
+	err := c.do(http.MethodGet, "/api/logs?"+query.Encode(), nil, &entries)
+	return entries, err
+}
+
+// Diagnostics returns the runtime figures and queue depths of the server
+func (c *Client) Diagnostics() (Diagnostics, error) {
+	var diagnostics Diagnostics
+	err := c.do(http.MethodGet, "/api/diagnostics", nil, &diagnostics)
+	return diagnostics, err
+}
+
+// Settings returns the runtime settings of the server
+func (c *Client) Settings() (Settings, error) {
+	var settings Settings
+	err := c.do(http.MethodGet, "/api/settings", nil, &settings)
+	return settings, err
+}
+
+// UpdateSettings applies new runtime settings to the server
+func (c *Client) UpdateSettings(settings Settings) error {
+	var updated Settings
+	return c.do(http.MethodPut, "/api/settings", settings, &updated)
+}
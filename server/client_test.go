@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"eth-daq-software/config"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestClient tests that the client reads and updates a server through its status API and
+// rejects addresses serving something else
+func TestClient(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	s.SetConfigPath(filepath.Join(t.TempDir(), "config.yaml"))
+	s.connectedIPs["10_0_0_2"] = &IPConnection{ActivePorts: map[int]bool{5556: true}, UUID: "dev1"}
+	api := httptest.NewServer(s.StatusHandler())
+	defer api.Close()
+
+	client := NewClient(strings.TrimPrefix(api.URL, "http://"))
+	if _, err := client.Status(); err != nil {
+		t.Fatalf("Status() = %v", err)
+	}
+	devices, err := client.Devices()
+	if err != nil || devices["10_0_0_2"].UUID != "dev1" || !devices["10_0_0_2"].ActivePorts[5556] {
+		t.Errorf("Devices() = %+v, %v", devices, err)
+	}
+
+	settings, err := client.Settings()
+	if err != nil {
+		t.Fatalf("Settings() = %v", err)
+	}
+	settings.AveragingWindow = 250
+	if err := client.UpdateSettings(settings); err != nil {
+		t.Fatalf("UpdateSettings() = %v", err)
+	}
+	if got := s.GetSettings().AveragingWindow; got != 250 {
+		t.Errorf("Averaging window = %d, want 250", got)
+	}
+	settings.AveragingWindow = -1
+	if err := client.UpdateSettings(settings); err == nil {
+		t.Error("Expected error for invalid settings, got nil")
+	}
+
+	buffer := NewDataBuffer(5557, "10.0.0.2", 2, "dev1", 1024)
+	buffer.AddData([]byte{0x80, 0x0c, 0, 0, 0x80, 0x0c, 0, 0}) // 25 °C internal, 0 mV thermocouple
+	s.buffers[BufferKey{IP: "10_0_0_2", Port: 5557}] = buffer
+	values, err := client.Channel(BufferKey{IP: "10_0_0_2", Port: 5557})
+	if err != nil || !values.Valid || math.Abs(values.Average-25) > 0.1 || math.Abs(values.AverageB-25) > 0.1 {
+		t.Errorf("Channel() = %+v, %v, want averages of 25 °C", values, err)
+	}
+	if _, err := client.Channel(BufferKey{IP: "10_0_0_2", Port: 5555}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Channel() of an unknown port = %v, want ErrNotFound", err)
+	}
+	if device, err := client.Device("10.0.0.2"); err != nil || device.UUID != "dev1" {
+		t.Errorf("Device() = %+v, %v, want dev1", device, err)
+	}
+	if _, err := client.Device("10.0.0.3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Device() of an unknown IP = %v, want ErrNotFound", err)
+	}
+
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	if _, err := NewClient(strings.TrimPrefix(other.URL, "http://")).Status(); err == nil {
+		t.Error("Expected error for an address without the status API, got nil")
+	}
+}
+
+// TestDiagnostics tests that the diagnostics report the channel queues and that the pprof
+// profiles are only served when enabled
+func TestDiagnostics(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := config.Default()
+		cfg.DataDir = t.TempDir()
+		cfg.Features.Pprof = enabled
+		s := NewServer(cfg)
+		buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+		buffer.AddData(make([]byte, 100))
+		s.buffers[BufferKey{IP: "10_0_0_2", Port: 5556}] = buffer
+		api := httptest.NewServer(s.StatusHandler())
+		defer api.Close()
+
+		diagnostics, err := NewClient(strings.TrimPrefix(api.URL, "http://")).Diagnostics()
+		if err != nil || diagnostics.Goroutines == 0 || diagnostics.HeapSys == 0 || len(diagnostics.Channels) != 1 ||
+			diagnostics.Channels[0].BufferedBytes != 100 || diagnostics.Channels[0].StatsCapacity != 0 {
+			t.Errorf("Diagnostics() = %+v, %v, want one channel with 100 buffered bytes", diagnostics, err)
+		}
+
+		response, err := http.Get(api.URL + "/debug/pprof/goroutine?debug=1")
+		if err != nil {
+			t.Fatalf("GET /debug/pprof/goroutine = %v", err)
+		}
+		response.Body.Close()
+		if want := map[bool]int{false: http.StatusNotFound, true: http.StatusOK}[enabled]; response.StatusCode != want {
+			t.Errorf("GET /debug/pprof/goroutine with pprof %v = %d, want %d", enabled, response.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// CodecStats summarises the segments written with one codec
+type CodecStats struct {
+	Segments        int64   // Number of segments written
+	OriginalBytes   int64   // Bytes before compression
+	CompressedBytes int64   // Bytes written to disk
+	Ratio           float64 // OriginalBytes / CompressedBytes
+	CompressSeconds float64 // Total time spent compressing
+	Throughput      float64 // Bytes compressed per second of compression time
+}
+
+// add adds a written segment
+func (cs *CodecStats) add(originalSize int, compressedSize int, elapsed time.Duration) {
+	cs.Segments++
+	cs.OriginalBytes += int64(originalSize)
+	cs.CompressedBytes += int64(compressedSize)
+	cs.CompressSeconds += elapsed.Seconds()
+	if cs.CompressedBytes > 0 {
+		cs.Ratio = float64(cs.OriginalBytes) / float64(cs.CompressedBytes)
+	}
+	if cs.CompressSeconds > 0 {
+		cs.Throughput = float64(cs.OriginalBytes) / cs.CompressSeconds
+	}
+}
+
+// CompressionStats summarises the segments written for a channel, to judge whether
+// compress-on-flush is worth it. The totals cover every codec the channel has used,
+// ByCodec separates them as the codec of a channel can be changed while it is running.
+type CompressionStats struct {
+	CodecStats
+	Codec   string                // Codec of the most recent segment
+	ByCodec map[string]CodecStats // Statistics per codec name, "none" for uncompressed segments
+}
+
+// record adds a written segment to the statistics of its channel
+func (w *segmentWriter) record(key BufferKey, codec compress.Codec, originalSize int, compressedSize int, elapsed time.Duration) {
+	codecName := "none"
+	if codec != nil {
+		codecName = codec.Name()
+	}
+
+	w.statsLock.Lock()
+	defer w.statsLock.Unlock()
+
+	stats, exists := w.stats[key]
+	if !exists {
+		stats = &CompressionStats{ByCodec: make(map[string]CodecStats)}
+		w.stats[key] = stats
+	}
+	stats.Codec = codecName
+	stats.add(originalSize, compressedSize, elapsed)
+	codecStats := stats.ByCodec[codecName]
+	codecStats.add(originalSize, compressedSize, elapsed)
+	stats.ByCodec[codecName] = codecStats
+}
+
+// GetCompressionStats returns the compression statistics of every channel that has
+// written a segment, keyed by "ip:port"
+func (s *Server) GetCompressionStats() map[string]CompressionStats {
+	s.writer.statsLock.Lock()
+	defer s.writer.statsLock.Unlock()
+
+	stats := make(map[string]CompressionStats, len(s.writer.stats))
+	for key, channelStats := range s.writer.stats {
+		channelCopy := *channelStats
+		channelCopy.ByCodec = maps.Clone(channelStats.ByCodec)
+		stats[fmt.Sprintf("%s:%d", key.IP, key.Port)] = channelCopy
+	}
+	return stats
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCompressionStats tests that ratio and compression time are accounted per channel
+// and per codec
+func TestCompressionStats(t *testing.T) {
+	s := NewServer(config.Default())
+	zstd, _ := compress.CodecByName("zstd")
+	key := BufferKey{IP: "10_0_0_2", Port: 5556}
+
+	s.writer.record(key, nil, 1000, 1000, 0)
+	s.writer.record(key, zstd, 4000, 1000, time.Second)
+	s.writer.record(key, zstd, 4000, 1000, time.Second)
+	s.writer.record(BufferKey{IP: "10_0_0_3", Port: 5556}, zstd, 100, 50, time.Second)
+
+	stats := s.GetCompressionStats()
+	channel, exists := stats["10_0_0_2:5556"]
+	if !exists || len(stats) != 2 {
+		t.Fatalf("GetCompressionStats() = %+v, want 2 channels", stats)
+	}
+	if channel.Codec != "zstd" || channel.Segments != 3 || channel.OriginalBytes != 9000 ||
+		channel.CompressedBytes != 3000 || channel.Ratio != 3 || channel.CompressSeconds != 2 ||
+		channel.Throughput != 4500 {
+		t.Errorf("Channel totals = %+v", channel.CodecStats)
+	}
+	if none := channel.ByCodec["none"]; none.Segments != 1 || none.Ratio != 1 || none.Throughput != 0 {
+		t.Errorf("Uncompressed stats = %+v", none)
+	}
+	if zstdStats := channel.ByCodec["zstd"]; zstdStats.Segments != 2 || zstdStats.Ratio != 4 || zstdStats.Throughput != 4000 {
+		t.Errorf("zstd stats = %+v", zstdStats)
+	}
+
+	// The returned statistics are a copy
+	channel.ByCodec["zstd"] = CodecStats{}
+	if s.GetCompressionStats()["10_0_0_2:5556"].ByCodec["zstd"].Segments != 2 {
+		t.Error("Modifying the returned statistics changed the writer's statistics")
+	}
+}
+
+// TestCompressionStatsWrite tests that written segments are recorded with their size on disk
+func TestCompressionStatsWrite(t *testing.T) {
+	dir := t.TempDir()
+	writer := newSegmentWriter(0, dir)
+	zstd, _ := compress.CodecByName("zstd")
+	key := BufferKey{IP: "10_0_0_2", Port: 5556}
+	metadata := decoderForPort(5556).Metadata()
+
+	data := getFlushBuffer(64 * 1024)[:64*1024]
+	if err := writer.write(flushJob{key: key, data: data, filename: "segment.zst", codec: zstd, metadata: &metadata}); err != nil {
+		t.Fatalf("write() = %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "segment.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := writer.stats[key]
+	if stats.OriginalBytes != 64*1024 || stats.CompressedBytes != info.Size() || stats.Ratio <= 1 {
+		t.Errorf("Stats = %+v, want %d bytes compressed to %d", stats.CodecStats, 64*1024, info.Size())
+	}
+}
@@ -0,0 +1,438 @@
+package server
+
+import (
+	"encoding/binary"
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"eth-daq-software/expr"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Names of the built-in decoders, selected per data port with config.ChannelConfig.Decoder
+const (
+	DECODER_HSADC        = "hs-adc"       // Signed 16-bit HS ADC samples in volts
+	DECODER_GADC         = "gadc"         // Unsigned 16-bit GADC samples in volts
+	DECODER_THERMOCOUPLE = "thermocouple" // Internal sensor and thermocouple samples in °C, alternating
+)
+
+// ChannelDecoder describes the raw sample format of a data port and converts its samples.
+// A port carries one or two channels whose samples alternate in the order of Channels,
+// one sample of each channel forms a frame.
+type ChannelDecoder interface {
+	// Channels returns the names of the channels, used as export columns
+	Channels() []string
+	// Units returns the unit of each channel's converted samples, e.g. UNIT_VOLT
+	Units() []string
+	// SampleWidth returns the bytes per raw sample, 2 or 4
+	SampleWidth() int
+	// ByteOrder returns the byte order of raw samples
+	ByteOrder() binary.ByteOrder
+	// Convert scales a raw sample of a channel and applies its calibration. frame holds the
+	// converted samples of the preceding channels of the frame, e.g. the cold junction of a
+	// thermocouple. A sample that cannot be converted returns false and is kept as NaN.
+	Convert(channel int, raw uint32, frame []float64, calibration *Calibration) (float64, bool)
+	// Slow reports a slow channel, such as a thermocouple, which is averaged over
+	// config.ThermocoupleAveragingWindow and keeps TC_HISTORY_SAMPLES of history
+	Slow() bool
+	// Metadata returns the sample format and linear scaling written to segment headers
+	Metadata() compress.Metadata
+}
+
+// ConvertibleDecoder is a ChannelDecoder whose scaling of raw samples can be replaced with
+// a conversion expression of x, the raw sample as a number in the decoder's sample format
+// (e.g. signed for int16 samples), see config.ChannelConfig.Conversions
+type ConvertibleDecoder interface {
+	ChannelDecoder
+	// WithConversion returns the decoder with the scaling of a channel replaced
+	WithConversion(channel int, conversion *expr.Expr) ChannelDecoder
+}
+
+// portDecoder is the decoder selected for a data port and its conversion expressions by
+// channel index
+type portDecoder struct {
+	name         string
+	conversions  map[int]*expr.Expr
+	thermocouple *thermocoupleTable // Linearization of a thermocouple decoder, nil for the type of the handshake or K
+}
+
+// DecoderFactory creates the decoder of one data connection, so that a decoder can keep
+// state across the chunks of its connection
+type DecoderFactory func() ChannelDecoder
+
+// defaultDecoders are the decoders of the data ports without a configured decoder. Other
+// ports default to DECODER_THERMOCOUPLE.
+var defaultDecoders = map[int]string{
+	5555: DECODER_HSADC,
+	5556: DECODER_GADC,
+	5557: DECODER_THERMOCOUPLE,
+}
+
+var (
+	decoders     = make(map[string]DecoderFactory)
+	portDecoders = make(map[int]portDecoder) // Configured decoder per data port
+	decodersLock sync.RWMutex
+)
+
+func init() {
+	RegisterDecoder(DECODER_HSADC, newHSADCDecoder)
+	RegisterDecoder(DECODER_GADC, newGADCDecoder)
+	RegisterDecoder(DECODER_THERMOCOUPLE, newThermocoupleDecoder)
+}
+
+// RegisterDecoder makes a decoder available for selection with config.ChannelConfig.Decoder.
+// Packages adding a payload format call it from their init function, which for a Go
+// plugin runs when the plugin is loaded, see LoadDecoderPlugins. A decoder registered
+// with the name of another replaces it for connections opened afterwards.
+func RegisterDecoder(name string, factory DecoderFactory) {
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	decoders[name] = factory
+}
+
+// decoderByName returns the factory of a registered decoder
+func decoderByName(name string) (DecoderFactory, error) {
+	decodersLock.RLock()
+	defer decodersLock.RUnlock()
+	if factory, exists := decoders[name]; exists {
+		return factory, nil
+	}
+	return nil, fmt.Errorf("unknown decoder %q", name)
+}
+
+// GetDecoders returns the names of the registered decoders
+func GetDecoders() []string {
+	decodersLock.RLock()
+	defer decodersLock.RUnlock()
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decoderForPort returns a new decoder of the type selected for a data port
+func decoderForPort(port int) ChannelDecoder {
+	decodersLock.RLock()
+	defer decodersLock.RUnlock()
+	selected := portDecoders[port]
+	name := selected.name
+	if name == "" {
+		name = defaultDecoders[port]
+	}
+	if name == "" {
+		name = DECODER_THERMOCOUPLE
+	}
+	decoder := decoders[name]()
+	for channel, conversion := range selected.conversions {
+		decoder = decoder.(ConvertibleDecoder).WithConversion(channel, conversion)
+	}
+	if tc, ok := decoder.(thermocoupleDecoder); ok && selected.thermocouple != nil {
+		tc.table.Store(selected.thermocouple)
+		tc.configured = true
+		decoder = tc
+	}
+	return decoder
+}
+
+// ConfigureDecoders loads the decoder plugins of a configuration and selects the
+// decoders and conversions of its data ports. Start calls it, tools decoding captures
+// without a server such as daqdecode call it themselves.
+func ConfigureDecoders(cfg *config.Config) error {
+	if err := LoadDecoderPlugins(cfg.DecoderPlugins); err != nil {
+		return err
+	}
+	return selectDecoders(cfg.Channels)
+}
+
+// selectDecoders selects the decoders and conversions configured for the data ports.
+// Connections already open keep their decoder.
+func selectDecoders(channels map[int]config.ChannelConfig) error {
+	selected := make(map[int]portDecoder)
+	for port, channel := range channels {
+		if channel.Decoder == "" && len(channel.Conversions) == 0 && channel.ThermocoupleType == "" {
+			continue
+		}
+		name := channel.Decoder
+		if name == "" {
+			name = defaultDecoders[port]
+		}
+		if name == "" {
+			name = DECODER_THERMOCOUPLE
+		}
+		factory, err := decoderByName(name)
+		if err != nil {
+			return fmt.Errorf("channel %d: %v", port, err)
+		}
+		decoder := factory()
+		if n := len(decoder.Channels()); n < 1 || n > 2 {
+			return fmt.Errorf("channel %d: decoder %q has %d channels, 1 or 2 are supported", port, name, n)
+		}
+		if len(decoder.Units()) != len(decoder.Channels()) {
+			return fmt.Errorf("channel %d: decoder %q does not give a unit for every channel", port, name)
+		}
+		if width := decoder.SampleWidth(); width != 2 && width != 4 {
+			return fmt.Errorf("channel %d: decoder %q has %d byte samples, 2 or 4 are supported", port, name, width)
+		}
+
+		selection := portDecoder{name: name, conversions: make(map[int]*expr.Expr)}
+		for channelName, source := range channel.Conversions {
+			if _, ok := decoder.(ConvertibleDecoder); !ok {
+				return fmt.Errorf("channel %d: decoder %q does not support conversions", port, name)
+			}
+			index := slices.Index(decoder.Channels(), channelName)
+			if index < 0 {
+				return fmt.Errorf("channel %d: decoder %q has no channel %q", port, name, channelName)
+			}
+			conversion, err := expr.Compile(source, []string{"x"})
+			if err != nil {
+				return fmt.Errorf("channel %d: conversion of %s: %v", port, channelName, err)
+			}
+			selection.conversions[index] = conversion
+		}
+		if channel.ThermocoupleType != "" {
+			if _, ok := decoder.(thermocoupleDecoder); !ok {
+				return fmt.Errorf("channel %d: decoder %q has no thermocouple type", port, name)
+			}
+			table, exists := thermocoupleTypes[channel.ThermocoupleType]
+			if !exists {
+				return fmt.Errorf("channel %d: unsupported thermocouple type %q, supported: %s", port, channel.ThermocoupleType, strings.Join(slices.Sorted(maps.Keys(thermocoupleTypes)), ", "))
+			}
+			selection.thermocouple = table
+		}
+		selected[port] = selection
+	}
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	portDecoders = selected
+	return nil
+}
+
+// scaling converts a raw sample, as a number in its sample format, to the unit of a
+// channel with a built-in function or a conversion expression of x
+type scaling struct {
+	builtin    func(x float64) float64
+	conversion *expr.Expr // Replaces builtin if set
+	vars       []float64  // Variables of conversion, reused across samples
+}
+
+func (s *scaling) apply(x float64) float64 {
+	if s.conversion == nil {
+		return s.builtin(x)
+	}
+	s.vars[0] = x
+	return s.conversion.Eval(s.vars)
+}
+
+// with returns the scaling with the built-in function replaced by conversion
+func (s *scaling) with(conversion *expr.Expr) *scaling {
+	return &scaling{builtin: s.builtin, conversion: conversion, vars: make([]float64, 1)}
+}
+
+// conversions returns the conversion expressions of the channels for segment metadata,
+// "" for channels using the built-in scaling, or nil if none is converted
+func conversions(scalings ...*scaling) []string {
+	var sources []string
+	for i, s := range scalings {
+		if s.conversion == nil {
+			continue
+		}
+		if sources == nil {
+			sources = make([]string, len(scalings))
+		}
+		sources[i] = s.conversion.String()
+	}
+	return sources
+}
+
+// hsADCDecoder decodes the HS ADC port
+type hsADCDecoder struct {
+	scale *scaling // Raw sample to V
+}
+
+func newHSADCDecoder() ChannelDecoder {
+	return hsADCDecoder{scale: &scaling{builtin: scaleHSADC}}
+}
+
+func (hsADCDecoder) Channels() []string          { return []string{"vds"} }
+func (hsADCDecoder) Units() []string             { return []string{UNIT_VOLT} }
+func (hsADCDecoder) SampleWidth() int            { return 2 }
+func (hsADCDecoder) ByteOrder() binary.ByteOrder { return binary.LittleEndian }
+func (hsADCDecoder) Slow() bool                  { return false }
+
+func (d hsADCDecoder) Convert(channel int, raw uint32, frame []float64, calibration *Calibration) (float64, bool) {
+	return calibration.Apply(d.scale.apply(float64(int16(raw)))), true
+}
+
+func (d hsADCDecoder) WithConversion(channel int, conversion *expr.Expr) ChannelDecoder {
+	d.scale = d.scale.with(conversion)
+	return d
+}
+
+func (d hsADCDecoder) Metadata() compress.Metadata {
+	if d.scale.conversion != nil {
+		return compress.Metadata{SampleFormat: "int16le", Unit: UNIT_VOLT, Conversions: conversions(d.scale)}
+	}
+	// Positive values are additionally multiplied by 20, see scaleHSADC
+	return compress.Metadata{SampleFormat: "int16le", Scale: -5.0 / 32768, Unit: UNIT_VOLT}
+}
+
+// gadcDecoder decodes the GADC port
+type gadcDecoder struct {
+	scale *scaling // Raw sample to V
+}
+
+func newGADCDecoder() ChannelDecoder {
+	return gadcDecoder{scale: &scaling{builtin: scaleGADC}}
+}
+
+func (gadcDecoder) Channels() []string          { return []string{"vgs"} }
+func (gadcDecoder) Units() []string             { return []string{UNIT_VOLT} }
+func (gadcDecoder) SampleWidth() int            { return 2 }
+func (gadcDecoder) ByteOrder() binary.ByteOrder { return binary.LittleEndian }
+func (gadcDecoder) Slow() bool                  { return false }
+
+func (d gadcDecoder) Convert(channel int, raw uint32, frame []float64, calibration *Calibration) (float64, bool) {
+	return calibration.Apply(d.scale.apply(float64(uint16(raw)))), true
+}
+
+func (d gadcDecoder) WithConversion(channel int, conversion *expr.Expr) ChannelDecoder {
+	d.scale = d.scale.with(conversion)
+	return d
+}
+
+func (d gadcDecoder) Metadata() compress.Metadata {
+	if d.scale.conversion != nil {
+		return compress.Metadata{SampleFormat: "uint16le", Unit: UNIT_VOLT, Conversions: conversions(d.scale)}
+	}
+	return compress.Metadata{SampleFormat: "uint16le", Scale: 312.5e-6, Offset: -10.24, Unit: UNIT_VOLT}
+}
+
+// thermocoupleDecoder decodes the thermocouple port, whose internal sensor readings
+// alternate with thermocouple readings compensated with the preceding internal reading
+type thermocoupleDecoder struct {
+	scale [2]*scaling // Internal sensor to °C and thermocouple to mV
+	// Linearization of the thermocouple type, which the device may select in its
+	// handshake after the connection was opened, see selectThermocoupleType
+	table      *atomic.Pointer[thermocoupleTable]
+	configured bool // Type selected with config.ChannelConfig.ThermocoupleType
+}
+
+func newThermocoupleDecoder() ChannelDecoder {
+	table := new(atomic.Pointer[thermocoupleTable])
+	table.Store(&thermocoupleK)
+	return thermocoupleDecoder{
+		scale: [2]*scaling{{builtin: scaleTCInternal}, {builtin: scaleTCVoltage}},
+		table: table,
+	}
+}
+
+func (thermocoupleDecoder) Channels() []string          { return []string{"internal_temp", "thermocouple"} }
+func (thermocoupleDecoder) Units() []string             { return []string{UNIT_CELSIUS, UNIT_CELSIUS} }
+func (thermocoupleDecoder) SampleWidth() int            { return 2 }
+func (thermocoupleDecoder) ByteOrder() binary.ByteOrder { return binary.LittleEndian }
+func (thermocoupleDecoder) Slow() bool                  { return true }
+
+func (d thermocoupleDecoder) Convert(channel int, raw uint32, frame []float64, calibration *Calibration) (float64, bool) {
+	value := d.scale[channel].apply(float64(int16(raw)))
+	if channel == 0 {
+		return calibration.Apply(value), true
+	}
+	return thermocoupleSample(d.table.Load(), value, frame[0], calibration)
+}
+
+// WithConversion replaces the scaling of the internal sensor to °C, or of the thermocouple
+// to mV before cold junction compensation and linearization
+func (d thermocoupleDecoder) WithConversion(channel int, conversion *expr.Expr) ChannelDecoder {
+	d.scale[channel] = d.scale[channel].with(conversion)
+	return d
+}
+
+func (d thermocoupleDecoder) Metadata() compress.Metadata {
+	return compress.Metadata{SampleFormat: "int16le-interleaved", Unit: UNIT_CELSIUS, Conversions: conversions(d.scale[:]...)}
+}
+
+// selectThermocoupleType switches a thermocouple decoder to the thermocouple type a device
+// reported in its handshake. A type configured for the port takes precedence. Other
+// decoders, unknown types and "" are ignored. It reports whether the type changed.
+func selectThermocoupleType(decoder ChannelDecoder, name string) bool {
+	tc, ok := decoder.(thermocoupleDecoder)
+	table, exists := thermocoupleTypes[name]
+	if !ok || !exists || tc.configured {
+		return false
+	}
+	return tc.table.Swap(table) != table
+}
+
+// sampleReader splits a byte stream into raw samples and converts them with a decoder,
+// carrying a sample or frame split across chunks over to the next chunk
+type sampleReader struct {
+	decoder ChannelDecoder
+	partial []byte    // Bytes of a sample split across chunks
+	channel int       // Channel of the next sample
+	frame   []float64 // Converted samples of the current frame
+}
+
+func newSampleReader(decoder ChannelDecoder) *sampleReader {
+	return &sampleReader{
+		decoder: decoder,
+		partial: make([]byte, 0, decoder.SampleWidth()),
+		frame:   make([]float64, len(decoder.Channels())),
+	}
+}
+
+// read converts the complete samples in data, calling emit with each sample's channel and
+// value. calibrations holds the calibration of each channel, nil entries are uncalibrated.
+func (r *sampleReader) read(data []byte, calibrations [2]*Calibration, emit func(channel int, value float64, ok bool) error) error {
+	width := r.decoder.SampleWidth()
+	order := r.decoder.ByteOrder()
+	convert := func(sample []byte) error {
+		var raw uint32
+		if width == 4 {
+			raw = order.Uint32(sample)
+		} else {
+			raw = uint32(order.Uint16(sample))
+		}
+		value, ok := r.decoder.Convert(r.channel, raw, r.frame, calibrations[r.channel])
+		r.frame[r.channel] = value
+		channel := r.channel
+		r.channel = (r.channel + 1) % len(r.frame)
+		return emit(channel, value, ok)
+	}
+
+	i := 0
+	if len(r.partial) > 0 {
+		i = min(width-len(r.partial), len(data))
+		r.partial = append(r.partial, data[:i]...)
+		if len(r.partial) < width {
+			return nil
+		}
+		sample := r.partial
+		r.partial = r.partial[:0]
+		if err := convert(sample); err != nil {
+			return err
+		}
+	}
+	for ; i+width <= len(data); i += width {
+		if err := convert(data[i : i+width]); err != nil {
+			return err
+		}
+	}
+	r.partial = append(r.partial, data[i:]...)
+	return nil
+}
+
+// reset discards a partial sample and restarts at the first channel, returning whether a
+// partial sample or frame was discarded
+func (r *sampleReader) reset() bool {
+	discarded := len(r.partial) > 0 || r.channel != 0
+	r.partial = r.partial[:0]
+	r.channel = 0
+	return discarded
+}
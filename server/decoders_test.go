@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/binary"
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"math"
+	"testing"
+)
+
+// wideDecoder decodes big-endian 32-bit samples of two channels, the second relative to the first
+type wideDecoder struct{}
+
+func (wideDecoder) Channels() []string          { return []string{"a", "b"} }
+func (wideDecoder) Units() []string             { return []string{UNIT_MILLIVOLT, UNIT_MILLIVOLT} }
+func (wideDecoder) SampleWidth() int            { return 4 }
+func (wideDecoder) ByteOrder() binary.ByteOrder { return binary.BigEndian }
+func (wideDecoder) Slow() bool                  { return false }
+func (wideDecoder) Metadata() compress.Metadata { return compress.Metadata{SampleFormat: "int32be"} }
+
+func (wideDecoder) Convert(channel int, raw uint32, frame []float64, calibration *Calibration) (float64, bool) {
+	if channel == 1 {
+		return float64(raw) - frame[0], true
+	}
+	return float64(raw), true
+}
+
+// TestSampleReader tests that samples and frames split across chunks are carried over
+func TestSampleReader(t *testing.T) {
+	var data []byte
+	for _, raw := range []uint32{100000, 100005, 7, 10} {
+		data = binary.BigEndian.AppendUint32(data, raw)
+	}
+
+	reader := newSampleReader(wideDecoder{})
+	var got []float64
+	emit := func(channel int, value float64, ok bool) error {
+		if channel != len(got)%2 {
+			t.Errorf("Sample %d on channel %d", len(got), channel)
+		}
+		got = append(got, value)
+		return nil
+	}
+	for _, chunk := range [][]byte{data[:3], data[3:6], data[6:13]} {
+		if err := reader.read(chunk, [2]*Calibration{}, emit); err != nil {
+			t.Fatalf("read() = %v", err)
+		}
+	}
+	if want := []float64{100000, 5, 7}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("Decoded samples = %v, want %v", got, want)
+	}
+	if !reader.reset() {
+		t.Error("reset() = false mid-frame, want true")
+	}
+	if reader.reset() {
+		t.Error("reset() = true after reset, want false")
+	}
+}
+
+// TestSelectDecoders tests that ports use the configured decoder and unknown decoders are rejected
+func TestSelectDecoders(t *testing.T) {
+	defer selectDecoders(nil)
+
+	if err := selectDecoders(map[int]config.ChannelConfig{5555: {Decoder: "unknown"}}); err == nil {
+		t.Error("Expected error for an unknown decoder, got nil")
+	}
+	if err := selectDecoders(map[int]config.ChannelConfig{6000: {Decoder: DECODER_GADC}}); err != nil {
+		t.Fatalf("selectDecoders() = %v", err)
+	}
+	if names := ChannelNames(6000); len(names) != 1 || names[0] != "vgs" {
+		t.Errorf("ChannelNames(6000) = %v, want [vgs]", names)
+	}
+	if names := ChannelNames(5557); len(names) != 2 {
+		t.Errorf("ChannelNames(5557) = %v, want the thermocouple channels", names)
+	}
+
+	if err := selectDecoders(map[int]config.ChannelConfig{5557: {ThermocoupleType: "Q"}}); err == nil {
+		t.Error("Expected error for an unsupported thermocouple type, got nil")
+	}
+	if err := selectDecoders(map[int]config.ChannelConfig{5556: {ThermocoupleType: "K"}}); err == nil {
+		t.Error("Expected error for a thermocouple type on the GADC port, got nil")
+	}
+	for name := range thermocoupleTypes {
+		if err := selectDecoders(map[int]config.ChannelConfig{5557: {ThermocoupleType: name}}); err != nil {
+			t.Errorf("selectDecoders() of type %s = %v", name, err)
+		}
+	}
+}
+
+// TestRegisterDecoder tests that a registered decoder can be selected for a port and decodes
+// its live data
+func TestRegisterDecoder(t *testing.T) {
+	RegisterDecoder("test-wide", func() ChannelDecoder { return wideDecoder{} })
+	defer selectDecoders(nil)
+	if err := selectDecoders(map[int]config.ChannelConfig{6001: {Decoder: "test-wide"}}); err != nil {
+		t.Fatalf("selectDecoders() = %v", err)
+	}
+	if names := GetDecoders(); len(names) < 4 {
+		t.Errorf("GetDecoders() = %v, want the built-in decoders and test-wide", names)
+	}
+
+	var data []byte
+	for _, raw := range []uint32{1000, 1003, 2000, 2010} {
+		data = binary.BigEndian.AppendUint32(data, raw)
+	}
+	buffer := NewDataBuffer(6001, "10.0.0.2", 10, "dev1", 1024)
+	buffer.AddData(data)
+	a, b := buffer.history.Last(2), buffer.historyB.Last(2)
+	if a[0] != 1000 || a[1] != 2000 || b[0] != 3 || b[1] != 10 {
+		t.Errorf("Decoded channels = %v and %v, want [1000 2000] and [3 10]", a, b)
+	}
+}
+
+// TestConversions tests that conversion expressions replace the scaling of a channel
+func TestConversions(t *testing.T) {
+	defer selectDecoders(nil)
+	if err := selectDecoders(map[int]config.ChannelConfig{5556: {Conversions: map[string]string{"vds": "x"}}}); err == nil {
+		t.Error("Expected error for a channel the decoder does not have, got nil")
+	}
+	if err := selectDecoders(map[int]config.ChannelConfig{5556: {Conversions: map[string]string{"vgs": "x*187.5e-6 - 6.144"}}}); err != nil {
+		t.Fatalf("selectDecoders() = %v", err)
+	}
+
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+	buffer.AddData(binary.LittleEndian.AppendUint16(nil, 40000))
+	if got := buffer.history.Last(1)[0]; math.Abs(got-1.356) > 1e-9 {
+		t.Errorf("Converted sample = %v, want 1.356", got)
+	}
+	if metadata := decoderForPort(5556).Metadata(); metadata.Scale != 0 || len(metadata.Conversions) != 1 {
+		t.Errorf("Metadata() = %+v, want the conversion instead of the scale", metadata)
+	}
+}
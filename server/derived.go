@@ -0,0 +1,560 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"eth-daq-software/expr"
+	"eth-daq-software/logger"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// DerivedChannel is a virtual channel computed from the physical channels of a device,
+// e.g. Name "power" with Expression "vds * vgs"
+type DerivedChannel struct {
+	Name       string
+	Expression string
+}
+
+// derivedChannel is a DerivedChannel with its compiled expression
+type derivedChannel struct {
+	DerivedChannel
+	expr     *expr.Expr
+	physical []physicalChannel // Channels the variables of expr refer to, in variable order
+}
+
+// physicalChannel maps a channel name usable in expressions to where its samples come from
+type physicalChannel struct {
+	name string
+	port int
+	isB  bool // Second channel of the port, such as the thermocouple of the thermocouple port
+}
+
+// physicalChannels lists the channels of the decoders of the data ports in expression
+// variable order. A channel named like one of an earlier port is named "<name>_<port>".
+func physicalChannels(ports []int) []physicalChannel {
+	var channels []physicalChannel
+	taken := make(map[string]bool)
+	for _, port := range ports {
+		for i, name := range ChannelNames(port) {
+			if taken[name] {
+				name = fmt.Sprintf("%s_%d", name, port)
+			}
+			taken[name] = true
+			channels = append(channels, physicalChannel{name: name, port: port, isB: i == 1})
+		}
+	}
+	return channels
+}
+
+// compileDerived compiles a derived channel against the channels of the data ports
+func (s *Server) compileDerived(channel DerivedChannel) (*derivedChannel, error) {
+	if channel.Name == "" {
+		return nil, fmt.Errorf("derived channel name is required")
+	}
+	physical := physicalChannels(s.settings().DataPorts)
+	names := make([]string, len(physical))
+	for i, p := range physical {
+		if p.name == channel.Name {
+			return nil, fmt.Errorf("%q is a physical channel", channel.Name)
+		}
+		names[i] = p.name
+	}
+	compiled, err := expr.Compile(channel.Expression, names)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression for %s: %v", channel.Name, err)
+	}
+	return &derivedChannel{DerivedChannel: channel, expr: compiled, physical: physical}, nil
+}
+
+// SetDerivedChannel adds or replaces a derived channel, available for every device
+func (s *Server) SetDerivedChannel(channel DerivedChannel) error {
+	compiled, err := s.compileDerived(channel)
+	if err != nil {
+		return err
+	}
+
+	s.derivedLock.Lock()
+	s.derivedChannels[channel.Name] = compiled
+	s.derivedLock.Unlock()
+
+	logger.Infof("Derived channel %s = %s\n", channel.Name, channel.Expression)
+	return nil
+}
+
+// recompileDerived compiles the derived channels again after the decoders of the data
+// ports changed. Channels whose expression no longer compiles are removed.
+func (s *Server) recompileDerived() {
+	s.derivedLock.Lock()
+	defer s.derivedLock.Unlock()
+	for name, channel := range s.derivedChannels {
+		compiled, err := s.compileDerived(channel.DerivedChannel)
+		if err != nil {
+			logger.Errorf("Removed derived channel %s: %v\n", name, err)
+			delete(s.derivedChannels, name)
+			continue
+		}
+		s.derivedChannels[name] = compiled
+	}
+}
+
+// RemoveDerivedChannel removes a derived channel
+func (s *Server) RemoveDerivedChannel(name string) {
+	s.derivedLock.Lock()
+	defer s.derivedLock.Unlock()
+	delete(s.derivedChannels, name)
+}
+
+// GetDerivedChannels returns all derived channel definitions sorted by name
+func (s *Server) GetDerivedChannels() []DerivedChannel {
+	s.derivedLock.RLock()
+	defer s.derivedLock.RUnlock()
+
+	result := make([]DerivedChannel, 0, len(s.derivedChannels))
+	for _, channel := range s.derivedChannels {
+		result = append(result, channel.DerivedChannel)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// lookupDerived returns a derived channel by name
+func (s *Server) lookupDerived(name string) (*derivedChannel, error) {
+	s.derivedLock.RLock()
+	defer s.derivedLock.RUnlock()
+	channel, exists := s.derivedChannels[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown derived channel %q", name)
+	}
+	return channel, nil
+}
+
+// derivedBuffers returns the buffers of the physical channels a derived channel uses on a
+// device, indexed like channel.physical with nil for unused channels
+func (s *Server) derivedBuffers(ip string, channel *derivedChannel) ([]*DataBuffer, error) {
+	buffers := make([]*DataBuffer, len(channel.physical))
+	s.buffersLock.RLock()
+	defer s.buffersLock.RUnlock()
+	for i, physical := range channel.physical {
+		if !channel.expr.Uses(i) {
+			continue
+		}
+		buffer, exists := s.buffers[BufferKey{IP: ip, Port: physical.port}]
+		if !exists {
+			return nil, fmt.Errorf("channel %s is not connected for %s", physical.name, ip)
+		}
+		buffers[i] = buffer
+	}
+	return buffers, nil
+}
+
+// physicalHistory returns the sample history of a physical channel in a buffer. The caller
+// must hold buffer.statsMu.
+func physicalHistory(buffer *DataBuffer, physical physicalChannel) *SampleRing {
+	if physical.isB {
+		return buffer.historyB
+	}
+	return buffer.history
+}
+
+// derivedSamples evaluates a derived channel on the recent samples of a device and returns
+// the samples with their rate. count returns how many of the newest samples of a physical
+// channel to use, and is called with buffer.statsMu held.
+func (s *Server) derivedSamples(ip string, channel *derivedChannel, count func(buffer *DataBuffer, physical physicalChannel) int) ([]float64, float64, error) {
+	buffers, err := s.derivedBuffers(ip, channel)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	inputs := make([][]float64, len(channel.physical))
+	rates := make([]float64, len(channel.physical))
+	for i, buffer := range buffers {
+		if buffer == nil {
+			continue
+		}
+		buffer.statsMu.Lock()
+		history := physicalHistory(buffer, channel.physical[i])
+		inputs[i] = history.Last(count(buffer, channel.physical[i]))
+		rates[i] = buffer.sampleRate
+		buffer.statsMu.Unlock()
+	}
+	samples, rate := evalAligned(channel.expr, inputs, rates, true)
+	return samples, rate, nil
+}
+
+// evalAligned evaluates an expression sample by sample on the inputs of the channels it
+// uses, indexed like derivedChannel.physical with nil for unused channels. If all sample rates
+// are known the inputs are first cut to the time span covered by all of them, keeping the
+// newest samples if alignEnd is set and the oldest otherwise. Inputs at different rates
+// are then aligned by resampling to the shortest input, and the rate of the result is
+// returned, 0 if unknown.
+func evalAligned(e *expr.Expr, inputs [][]float64, rates []float64, alignEnd bool) ([]float64, float64) {
+	values := make([]float64, len(inputs))
+	span := math.Inf(1)
+	used := 0
+	for i, samples := range inputs {
+		if samples == nil {
+			continue
+		}
+		used++
+		if rates[i] <= 0 {
+			span = math.NaN()
+		} else {
+			span = min(span, float64(len(samples))/rates[i])
+		}
+	}
+	if used == 0 {
+		// A constant expression
+		return []float64{e.Eval(values)}, 0
+	}
+
+	n := math.MaxInt
+	for i, samples := range inputs {
+		if samples == nil {
+			continue
+		}
+		if !math.IsNaN(span) {
+			keep := min(len(samples), int(math.Round(span*rates[i])))
+			if alignEnd {
+				samples = samples[len(samples)-keep:]
+			} else {
+				samples = samples[:keep]
+			}
+			inputs[i] = samples
+		}
+		n = min(n, len(samples))
+	}
+
+	result := make([]float64, n)
+	for k := range result {
+		for i, samples := range inputs {
+			if samples != nil {
+				values[i] = samples[k*len(samples)/n]
+			}
+		}
+		result[k] = e.Eval(values)
+	}
+
+	rate := 0.0
+	if !math.IsNaN(span) && span > 0 {
+		rate = float64(n) / span
+	}
+	return result, rate
+}
+
+// averagingWindow returns the number of samples in the averaging window of a physical channel
+func averagingWindow(buffer *DataBuffer, physical physicalChannel) int {
+	if physical.isB {
+		return buffer.circularBufferB.count
+	}
+	return buffer.circularBuffer.count
+}
+
+// allHistory returns the number of samples in the history of a physical channel
+func allHistory(buffer *DataBuffer, physical physicalChannel) int {
+	return physicalHistory(buffer, physical).Available()
+}
+
+// GetDerivedAverage evaluates a derived channel on every aligned sample of the current
+// averaging windows of a device and returns the average, ignoring NaN samples
+func (s *Server) GetDerivedAverage(ip string, name string) (float64, error) {
+	channel, err := s.lookupDerived(name)
+	if err != nil {
+		return 0, err
+	}
+	samples, _, err := s.derivedSamples(ip, channel, averagingWindow)
+	if err != nil {
+		return 0, err
+	}
+
+	sum, count := 0.0, 0
+	for _, sample := range samples {
+		if !math.IsNaN(sample) {
+			sum += sample
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("no samples of %s for %s", name, ip)
+	}
+	return sum / float64(count), nil
+}
+
+// GetAllDerivedAverages evaluates every derived channel that can be computed for a device
+func (s *Server) GetAllDerivedAverages(ip string) map[string]float64 {
+	result := make(map[string]float64)
+	for _, channel := range s.GetDerivedChannels() {
+		if value, err := s.GetDerivedAverage(ip, channel.Name); err == nil {
+			result[channel.Name] = value
+		}
+	}
+	return result
+}
+
+// GetDerivedHistogram computes a value histogram of a derived channel over the live sample
+// history of a device. If to is not greater than from, the range of the samples is used.
+func (s *Server) GetDerivedHistogram(ip string, name string, bins int, from float64, to float64) (*Histogram, error) {
+	channel, err := s.lookupDerived(name)
+	if err != nil {
+		return nil, err
+	}
+	samples, _, err := s.derivedSamples(ip, channel, allHistory)
+	if err != nil {
+		return nil, err
+	}
+	return sampleHistogram(samples, bins, from, to)
+}
+
+// DetectDerivedPeaks finds peaks (or valleys) of a derived channel in the recent sample
+// window of a device
+func (s *Server) DetectDerivedPeaks(ip string, name string, opts PeakOptions) ([]Peak, error) {
+	channel, err := s.lookupDerived(name)
+	if err != nil {
+		return nil, err
+	}
+	samples, sampleRate, err := s.derivedSamples(ip, channel, func(buffer *DataBuffer, physical physicalChannel) int {
+		n := allHistory(buffer, physical)
+		if opts.WindowMs > 0 && buffer.sampleRate > 0 {
+			n = min(n, int(float64(opts.WindowMs)/1000*buffer.sampleRate))
+		}
+		return n
+	})
+	if err != nil {
+		return nil, err
+	}
+	return samplePeaks(samples, sampleRate, time.Now(), opts), nil
+}
+
+// CaptureDerivedTrigger triggers a capture of a derived channel of a device, like
+// CaptureTrigger does for a physical channel
+func (s *Server) CaptureDerivedTrigger(ip string, name string, pre time.Duration, post time.Duration) (TriggerCapture, error) {
+	channel, err := s.lookupDerived(name)
+	if err != nil {
+		return TriggerCapture{}, err
+	}
+	buffers, err := s.derivedBuffers(ip, channel)
+	if err != nil {
+		return TriggerCapture{}, err
+	}
+
+	capture := TriggerCapture{
+		Key:         BufferKey{IP: ip},
+		Derived:     name,
+		TriggerTime: time.Now(),
+	}
+	positions := make([]triggerPosition, len(buffers))
+	preInputs := make([][]float64, len(buffers))
+	rates := make([]float64, len(buffers))
+	for i, buffer := range buffers {
+		if buffer == nil {
+			continue
+		}
+		var preSamplesB []float64
+		positions[i], preInputs[i], preSamplesB = buffer.markTrigger(pre)
+		if channel.physical[i].isB {
+			preInputs[i] = preSamplesB
+		}
+		rates[i] = positions[i].sampleRate
+	}
+
+	postInputs := make([][]float64, len(buffers))
+	for i, buffer := range buffers {
+		if buffer == nil {
+			continue
+		}
+		n := s.waitPostTrigger(buffer, positions[i], capture.TriggerTime, post)
+		var postSamplesB []float64
+		postInputs[i], postSamplesB = buffer.samplesSince(positions[i])
+		if channel.physical[i].isB {
+			postInputs[i] = postSamplesB
+		}
+		if n > 0 {
+			postInputs[i] = postInputs[i][:min(n, len(postInputs[i]))]
+		}
+	}
+
+	capture.PreTrigger, capture.SampleRate = evalAligned(channel.expr, preInputs, rates, true)
+	capture.PostTrigger, _ = evalAligned(channel.expr, postInputs, rates, false)
+	s.addMarker(Marker{
+		Time:   capture.TriggerTime,
+		Label:  "trigger on " + name,
+		Source: MARKER_TRIGGER,
+		UUID:   s.deviceUUID(SanitizeFilename(ip)),
+	})
+	logger.Infof("Trigger capture of %s on %s: %d pre-trigger and %d post-trigger samples\n",
+		name, ip, len(capture.PreTrigger), len(capture.PostTrigger))
+	return capture, nil
+}
+
+// segmentStream decodes the samples of one physical channel from its segments on demand
+type segmentStream struct {
+	segments []SegmentInfo
+	decoder  *sampleDecoder
+	column   int       // Column of the channel in the decoded values
+	pending  []float64 // Decoded samples of the last decoded segment
+	start    int64     // Index of pending[0] in the stream
+	next     int       // Next segment to decode
+}
+
+func newSegmentStream(segments []SegmentInfo, physical physicalChannel, calibration *Calibration, calibrationB *Calibration) *segmentStream {
+	column := 0
+	if physical.isB {
+		column = 1
+	}
+	return &segmentStream{
+		segments: segments,
+		decoder:  newSampleDecoder(physical.port, calibration, calibrationB),
+		column:   column,
+	}
+}
+
+// decodeSegment decodes a segment with decoder, calling emit with the channel sample
+func (cs *segmentStream) decodeSegment(decoder *sampleDecoder, segment SegmentInfo, emit func(sample float64)) error {
+	data, err := ReadSegment(segment.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", segment.Path, err)
+	}
+	return decoder.decode(data, func(values []float64) error {
+		emit(values[cs.column])
+		return nil
+	})
+}
+
+// count decodes all segments and returns the number of samples in the stream
+func (cs *segmentStream) count() (int64, error) {
+	decoder := *cs.decoder
+	var n int64
+	for _, segment := range cs.segments {
+		if err := cs.decodeSegment(&decoder, segment, func(float64) { n++ }); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// at returns the sample at index, which must not be lower than in the previous call
+func (cs *segmentStream) at(index int64) (float64, error) {
+	for index >= cs.start+int64(len(cs.pending)) {
+		if cs.next == len(cs.segments) {
+			return 0, fmt.Errorf("sample %d is past the end of the data", index)
+		}
+		cs.start += int64(len(cs.pending))
+		cs.pending = cs.pending[:0]
+		err := cs.decodeSegment(cs.decoder, cs.segments[cs.next], func(sample float64) {
+			cs.pending = append(cs.pending, sample)
+		})
+		if err != nil {
+			return 0, err
+		}
+		cs.next++
+	}
+	return cs.pending[index-cs.start], nil
+}
+
+// ExportDerivedCSV exports a derived channel computed from the data files of one device to a
+// CSV file at dest. The files of every channel the expression uses must be given. As segments
+// carry no sample timestamps, the recordings of the channels are assumed to cover the same
+// time and are aligned by resampling to the channel with the fewest samples.
+func (s *Server) ExportDerivedCSV(paths []string, name string, dest string, opts ExportOptions) error {
+	channel, err := s.lookupDerived(name)
+	if err != nil {
+		return err
+	}
+	if opts.Mode != "" && opts.Mode != DECIMATE_MEAN && opts.Mode != DECIMATE_MINMAX {
+		return fmt.Errorf("unknown decimation mode %q", opts.Mode)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no files given")
+	}
+
+	byPort := make(map[int][]SegmentInfo)
+	uuid := ""
+	for _, path := range paths {
+		segment, err := ParseSegmentName(path)
+		if err != nil {
+			return err
+		}
+		if uuid != "" && segment.UUID != uuid {
+			return fmt.Errorf("cannot combine devices %s and %s", uuid, segment.UUID)
+		}
+		uuid = segment.UUID
+		byPort[segment.Port] = append(byPort[segment.Port], segment)
+	}
+
+	streams := make([]*segmentStream, len(channel.physical))
+	lengths := make([]int64, len(channel.physical))
+	n := int64(math.MaxInt64)
+	for i, physical := range channel.physical {
+		if !channel.expr.Uses(i) {
+			continue
+		}
+		segments := byPort[physical.port]
+		if len(segments) == 0 {
+			return fmt.Errorf("no files of channel %s given", physical.name)
+		}
+		sortSegments(segments)
+		streams[i] = newSegmentStream(segments, physical,
+			s.calibrations.Get(CalibrationKey{UUID: uuid, Port: physical.port, Channel: 0}),
+			s.calibrations.Get(CalibrationKey{UUID: uuid, Port: physical.port, Channel: 1}))
+		selectThermocoupleType(streams[i].decoder.samples.decoder, s.deviceThermocouple(uuid, physical.port))
+		if lengths[i], err = streams[i].count(); err != nil {
+			return err
+		}
+		n = min(n, lengths[i])
+	}
+	if n == math.MaxInt64 {
+		return fmt.Errorf("%s does not use any channel", name)
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if opts.Device == "" {
+		device, _ := s.registry.Get(uuid)
+		opts.Device = device.Alias
+	}
+	csvWriter := csv.NewWriter(writer)
+	decimator := newCSVDecimator(csvWriter, 1, opts)
+	if err := decimator.header([]string{name}, nil, opts.Device); err != nil {
+		return err
+	}
+
+	values := make([]float64, len(channel.physical))
+	row := make([]float64, 1)
+	for k := int64(0); k < n; k++ {
+		for i, stream := range streams {
+			if stream == nil {
+				continue
+			}
+			if values[i], err = stream.at(k * lengths[i] / n); err != nil {
+				return err
+			}
+		}
+		row[0] = channel.expr.Eval(values)
+		if err := decimator.add(row); err != nil {
+			return err
+		}
+	}
+	if err := decimator.flush(); err != nil {
+		return err
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write export file: %v", err)
+	}
+
+	logger.Infof("Exported %s from %d files to %s\n", name, len(paths), dest)
+	return nil
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/binary"
+	"eth-daq-software/config"
+	"eth-daq-software/expr"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestEvalAligned tests that channels at different rates are cut to a common time span and
+// resampled before evaluating the expression sample by sample
+func TestEvalAligned(t *testing.T) {
+	e, err := expr.Compile("vds * vgs", []string{"vds", "vgs", "internal_temp", "thermocouple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates := []float64{4, 2, 0, 0}
+
+	// 2 s of vds and 1 s of vgs, only the last second of vds overlaps
+	inputs := [][]float64{{0, 0, 0, 0, 1, 2, 3, 4}, {10, 20}, nil, nil}
+	samples, rate := evalAligned(e, inputs, rates, true)
+	if !reflect.DeepEqual(samples, []float64{10, 60}) || rate != 2 {
+		t.Errorf("evalAligned(alignEnd) = %v, %v, want [10 60], 2", samples, rate)
+	}
+
+	inputs = [][]float64{{1, 2, 3, 4, 0, 0, 0, 0}, {10, 20}, nil, nil}
+	samples, _ = evalAligned(e, inputs, rates, false)
+	if !reflect.DeepEqual(samples, []float64{10, 60}) {
+		t.Errorf("evalAligned(alignStart) = %v, want [10 60]", samples)
+	}
+}
+
+// TestPhysicalChannels tests that the variables of derived channels follow the decoders
+// of the data ports
+func TestPhysicalChannels(t *testing.T) {
+	defer selectDecoders(nil)
+	want := []physicalChannel{
+		{name: "vds", port: 5555},
+		{name: "vgs", port: 5556},
+		{name: "internal_temp", port: 5557},
+		{name: "thermocouple", port: 5557, isB: true},
+	}
+	if got := physicalChannels([]int{5555, 5556, 5557}); !reflect.DeepEqual(got, want) {
+		t.Errorf("physicalChannels() = %+v, want %+v", got, want)
+	}
+
+	// A second GADC port in place of the thermocouple port
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.DataPorts = []int{5555, 5556, 6000}
+	cfg.Channels = map[int]config.ChannelConfig{6000: {Decoder: DECODER_GADC}}
+	if err := ConfigureDecoders(cfg); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(cfg)
+	if err := s.SetDerivedChannel(DerivedChannel{Name: "delta", Expression: "vgs - vgs_6000"}); err != nil {
+		t.Fatalf("SetDerivedChannel() = %v", err)
+	}
+	if err := s.SetDerivedChannel(DerivedChannel{Name: "heat", Expression: "thermocouple * 2"}); err == nil {
+		t.Error("Expected error for a channel of an unused port, got nil")
+	}
+	channel, _ := s.lookupDerived("delta")
+	if got := channel.physical[2]; got != (physicalChannel{name: "vgs_6000", port: 6000}) {
+		t.Errorf("Variable 2 of delta = %+v, want vgs_6000 of port 6000", got)
+	}
+}
+
+// newDerivedTestServer creates a server with a derived channel and vds and vgs buffers of
+// 10.0.0.2 holding the given samples
+func newDerivedTestServer(t *testing.T, expression string, vds []float64, vgs []float64) *Server {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	state := t.TempDir()
+	s.registry = NewDeviceRegistry(filepath.Join(state, DEVICE_REGISTRY_FILE))
+	s.calibrations = NewCalibrationStore(filepath.Join(state, CALIBRATION_FILE))
+
+	if err := s.SetDerivedChannel(DerivedChannel{Name: "power", Expression: expression}); err != nil {
+		t.Fatalf("SetDerivedChannel() = %v", err)
+	}
+	for port, samples := range map[int][]float64{5555: vds, 5556: vgs} {
+		buffer := NewDataBuffer(port, "10.0.0.2", len(samples), "dev1", 1024)
+		for _, sample := range samples {
+			buffer.history.Add(sample)
+			buffer.circularBuffer.Add(sample)
+		}
+		s.buffers[BufferKey{IP: "10.0.0.2", Port: port}] = buffer
+	}
+	return s
+}
+
+// TestGetDerivedAverage tests that the derived average is the average of the expression
+// evaluated per sample rather than the expression of the averages
+func TestGetDerivedAverage(t *testing.T) {
+	s := newDerivedTestServer(t, "vds * vgs", []float64{1, -1, 1, -1}, []float64{1, -1, 1, -1})
+
+	average, err := s.GetDerivedAverage("10.0.0.2", "power")
+	if err != nil || average != 1 {
+		t.Errorf("GetDerivedAverage() = %v, %v, want 1", average, err)
+	}
+	if _, err := s.GetDerivedAverage("10.0.0.3", "power"); err == nil {
+		t.Error("Expected error for a device without channels, got nil")
+	}
+
+	histogram, err := s.GetDerivedHistogram("10.0.0.2", "power", 2, -2, 2)
+	if err != nil || histogram.Total != 4 || histogram.Counts[1] != 4 {
+		t.Errorf("GetDerivedHistogram() = %+v, %v, want 4 samples in the upper bin", histogram, err)
+	}
+}
+
+// TestDetectDerivedPeaks tests that peaks are found in the evaluated samples
+func TestDetectDerivedPeaks(t *testing.T) {
+	s := newDerivedTestServer(t, "vds - vgs", []float64{0, 5, 0, 1, 0}, []float64{0, 0, 0, 0, 0})
+
+	peaks, err := s.DetectDerivedPeaks("10.0.0.2", "power", PeakOptions{Prominence: 2})
+	if err != nil || len(peaks) != 1 || peaks[0].Index != 1 || peaks[0].Amplitude != 5 {
+		t.Errorf("DetectDerivedPeaks() = %+v, %v, want one peak at 1", peaks, err)
+	}
+}
+
+// writeSegment writes raw samples as an uncompressed segment of dev1 and returns its path
+func writeSegment(t *testing.T, dir string, port int, timestamp int64, raw []uint16) string {
+	data := make([]byte, 2*len(raw))
+	for i, sample := range raw {
+		binary.LittleEndian.PutUint16(data[2*i:], sample)
+	}
+	path := filepath.Join(dir, "port"+strconv.Itoa(port)+"_10_0_0_2_dev1_"+strconv.FormatInt(timestamp, 10)+".bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestExportDerivedCSV tests that a derived channel is exported from the segments of the
+// channels it uses, resampled to the channel with the fewest samples
+func TestExportDerivedCSV(t *testing.T) {
+	s := newDerivedTestServer(t, "vds + vgs", nil, nil)
+	dir := t.TempDir()
+
+	// vgs is 1 V then 2 V, vds is 0 V at twice the rate split over two segments
+	paths := []string{
+		writeSegment(t, dir, 5555, 1, []uint16{0, 0}),
+		writeSegment(t, dir, 5555, 2, []uint16{0, 0}),
+		writeSegment(t, dir, 5556, 1, []uint16{32768 + 3200, 32768 + 6400}),
+	}
+	dest := filepath.Join(dir, "power.csv")
+	if err := s.ExportDerivedCSV(paths, "power", dest, ExportOptions{}); err != nil {
+		t.Fatalf("ExportDerivedCSV() = %v", err)
+	}
+
+	data, _ := os.ReadFile(dest)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != "sample,power" {
+		t.Fatalf("Exported %q, want a header and 2 rows", lines)
+	}
+	for i, want := range []float64{1, 2} {
+		fields := strings.Split(lines[i+1], ",")
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || math.Abs(value-want) > 1e-9 {
+			t.Errorf("Row %d = %q, want %v", i, lines[i+1], want)
+		}
+	}
+
+	if err := s.ExportDerivedCSV(paths[2:], "power", dest, ExportOptions{}); err == nil {
+		t.Error("Expected error when the files of vds are missing, got nil")
+	}
+}
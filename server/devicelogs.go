@@ -0,0 +1,357 @@
+package server
+
+import (
+	"bufio"
+	"eth-daq-software/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_LOG_PAGE = 5000 // Lines returned by GetLogPage at a time
+)
+
+// Severities parsed from device log lines
+const (
+	SEVERITY_ERROR   = "error"
+	SEVERITY_WARNING = "warning"
+	SEVERITY_INFO    = "info"
+	SEVERITY_DEBUG   = "debug"
+	SEVERITY_VERBOSE = "verbose"
+	SEVERITY_NONE    = "" // Line without a severity prefix
+)
+
+// severityLetters maps the letter of "[E]" style and ESP-IDF "E (1234)" style prefixes
+var severityLetters = map[byte]string{
+	'E': SEVERITY_ERROR,
+	'W': SEVERITY_WARNING,
+	'I': SEVERITY_INFO,
+	'D': SEVERITY_DEBUG,
+	'V': SEVERITY_VERBOSE,
+}
+
+// LogEntry is a device log line with its parsed severity
+type LogEntry struct {
+	Line     string
+	Severity string
+	Source   string // Source tag of the UDP log port the line was received on, "" for udp_log_port
+}
+
+// LogCounts are the number of error and warning lines received from a device
+type LogCounts struct {
+	Errors   int
+	Warnings int
+}
+
+// parseSeverity returns the severity of a device log line from its prefix
+func parseSeverity(line string) string {
+	line = strings.TrimLeft(line, " \t")
+	switch {
+	case len(line) >= 3 && line[0] == '[' && line[2] == ']':
+		return severityLetters[line[1]]
+	case len(line) >= 3 && line[1] == ' ' && line[2] == '(':
+		return severityLetters[line[0]]
+	}
+	return SEVERITY_NONE
+}
+
+// add appends an entry to the circular buffer and counts errors and warnings.
+// The caller must hold lb.mu.
+func (lb *LogBuffer) add(entry LogEntry) {
+	lb.lastReceived = time.Now()
+	if len(lb.logLines) >= lb.maxLines {
+		// Remove oldest entry if at capacity
+		lb.logLines = append(lb.logLines[1:], entry)
+	} else {
+		lb.logLines = append(lb.logLines, entry)
+	}
+
+	switch entry.Severity {
+	case SEVERITY_ERROR:
+		lb.counts.Errors++
+	case SEVERITY_WARNING:
+		lb.counts.Warnings++
+	}
+}
+
+// GetFilteredLogs returns the buffered log lines of an IP with one of the given
+// severities, newest first. An empty filter returns every line.
+func (s *Server) GetFilteredLogs(ip string, severities []string) []LogEntry {
+	s.logBuffersLock.RLock()
+	buffer, exists := s.logBuffers[SanitizeFilename(ip)]
+	s.logBuffersLock.RUnlock()
+
+	if !exists {
+		return []LogEntry{}
+	}
+
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+
+	result := make([]LogEntry, 0, len(buffer.logLines))
+	for i := len(buffer.logLines) - 1; i >= 0; i-- {
+		entry := buffer.logLines[i]
+		if len(severities) == 0 || slices.Contains(severities, entry.Severity) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// LogQuery selects device log lines of an IP. Zero values do not filter.
+type LogQuery struct {
+	IP         string
+	From       time.Time // Lines received at or after From
+	To         time.Time // Lines received before To
+	Severities []string  // Lines with one of the severities, see parseSeverity
+	Offset     int       // Matching lines skipped, newest first
+	Limit      int       // Lines returned, at most MAX_LOG_PAGE, 0 returns MAX_LOG_PAGE
+}
+
+// LogPage is a page of the device log lines matching a LogQuery
+type LogPage struct {
+	Entries []LogEntry // Newest first
+	Total   int        // Lines matching the query on all pages
+}
+
+// GetLogPage returns a page of the log lines of an IP from its log files, newest first, so
+// that logs longer than the buffered lines can be browsed. Without log files the
+// buffered lines are returned.
+func (s *Server) GetLogPage(query LogQuery) (LogPage, error) {
+	if query.Offset < 0 || query.Limit < 0 {
+		return LogPage{}, fmt.Errorf("offset and limit must not be negative")
+	}
+	if query.Limit == 0 || query.Limit > MAX_LOG_PAGE {
+		query.Limit = MAX_LOG_PAGE
+	}
+	sanitizedIP := SanitizeFilename(query.IP)
+
+	// Buffered lines of the current file must be on disk to be read
+	s.logBuffersLock.RLock()
+	buffer, exists := s.logBuffers[sanitizedIP]
+	s.logBuffersLock.RUnlock()
+	var entries []LogEntry
+	if exists {
+		buffer.mu.Lock()
+		buffer.flush()
+		if buffer.currentFile == nil {
+			entries = slices.Clone(buffer.logLines)
+		}
+		buffer.mu.Unlock()
+	}
+
+	if entries == nil {
+		paths, err := filepath.Glob(filepath.Join(s.settings().LogDir, fmt.Sprintf("logs_%s_*.txt", sanitizedIP)))
+		if err != nil {
+			return LogPage{}, err
+		}
+		// Files are named by the time they were started
+		sort.Slice(paths, func(i, j int) bool { return logFileTime(paths[i]) < logFileTime(paths[j]) })
+		for _, path := range paths {
+			fileEntries, err := readLogFile(path)
+			if err != nil {
+				return LogPage{}, fmt.Errorf("failed to read %s: %v", filepath.Base(path), err)
+			}
+			entries = append(entries, fileEntries...)
+		}
+	}
+
+	var page LogPage
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if len(query.Severities) > 0 && !slices.Contains(query.Severities, entry.Severity) {
+			continue
+		}
+		if !query.From.IsZero() || !query.To.IsZero() {
+			received, ok := logLineTime(entry.Line)
+			if !ok || (!query.From.IsZero() && received.Before(query.From)) ||
+				(!query.To.IsZero() && !received.Before(query.To)) {
+				continue
+			}
+		}
+		if page.Total >= query.Offset && len(page.Entries) < query.Limit {
+			page.Entries = append(page.Entries, entry)
+		}
+		page.Total++
+	}
+	return page, nil
+}
+
+// logFileTime returns the start time in the name of a device log file logs_<ip>_<unixnano>.txt
+func logFileTime(path string) int64 {
+	name := strings.TrimSuffix(filepath.Base(path), ".txt")
+	var nanos int64
+	fmt.Sscan(name[strings.LastIndex(name, "_")+1:], &nanos)
+	return nanos
+}
+
+// readLogFile returns the log lines of a device log file, without its header and footer
+func readLogFile(path string) ([]LogEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, ok := logLineTime(line); !ok {
+			continue
+		}
+		_, message, _ := strings.Cut(line, "] ")
+		source, message := untagLogLine(message)
+		entries = append(entries, LogEntry{Line: line, Severity: parseSeverity(message), Source: source})
+	}
+	return entries, scanner.Err()
+}
+
+// tagLogLine marks a log line with the source tag of its UDP log port as "<source> "
+func tagLogLine(source string, line string) string {
+	if source == "" {
+		return line
+	}
+	return "<" + source + "> " + line
+}
+
+// untagLogLine splits the source tag off a log line marked by tagLogLine
+func untagLogLine(line string) (string, string) {
+	if !strings.HasPrefix(line, "<") {
+		return "", line
+	}
+	source, rest, found := strings.Cut(line[1:], "> ")
+	if !found || strings.ContainsAny(source, "<> ") {
+		return "", line
+	}
+	return source, rest
+}
+
+// lossTracker returns the tracker of lost log datagrams of a source. The caller must
+// hold lb.mu.
+func (lb *LogBuffer) lossTracker(source string) *logLossTracker {
+	if lb.loss == nil {
+		lb.loss = make(map[string]*logLossTracker)
+	}
+	tracker, exists := lb.loss[source]
+	if !exists {
+		tracker = &logLossTracker{}
+		lb.loss[source] = tracker
+	}
+	return tracker
+}
+
+// logLineTime returns the receive time prefixed to a device log line as "[RFC3339] "
+func logLineTime(line string) (time.Time, bool) {
+	stamp, _, found := strings.Cut(line, "] ")
+	if !found || !strings.HasPrefix(stamp, "[") {
+		return time.Time{}, false
+	}
+	received, err := time.Parse(time.RFC3339, stamp[1:])
+	return received, err == nil
+}
+
+// GetLogCounts returns the number of error and warning lines received from an IP
+func (s *Server) GetLogCounts(ip string) LogCounts {
+	s.logBuffersLock.RLock()
+	buffer, exists := s.logBuffers[SanitizeFilename(ip)]
+	s.logBuffersLock.RUnlock()
+
+	if !exists {
+		return LogCounts{}
+	}
+
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return buffer.counts
+}
+
+// GetAllLogCounts returns the error and warning counts of every device that sent logs
+func (s *Server) GetAllLogCounts() map[string]LogCounts {
+	s.logBuffersLock.RLock()
+	defer s.logBuffersLock.RUnlock()
+
+	counts := make(map[string]LogCounts, len(s.logBuffers))
+	for ip, buffer := range s.logBuffers {
+		buffer.mu.Lock()
+		counts[ip] = buffer.counts
+		buffer.mu.Unlock()
+	}
+	return counts
+}
+
+// openFile starts writing log lines to a new file after the header. The caller must hold lb.mu.
+func (lb *LogBuffer) openFile(path string, header string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	lb.currentFile = file
+	lb.writer = bufio.NewWriter(file)
+	lb.writer.WriteString(header)
+	return nil
+}
+
+// writeLine appends a line to the log file if one is open. With sync the line is on disk
+// when writeLine returns, otherwise it is buffered until the next flush. The caller must
+// hold lb.mu.
+func (lb *LogBuffer) writeLine(line string, sync bool) {
+	if lb.writer == nil {
+		return
+	}
+	lb.writer.WriteString(line)
+	if sync {
+		lb.flush()
+		lb.currentFile.Sync()
+	}
+}
+
+// flush writes the buffered lines to the log file. The caller must hold lb.mu.
+func (lb *LogBuffer) flush() {
+	if lb.writer == nil || lb.writer.Buffered() == 0 {
+		return
+	}
+	if err := lb.writer.Flush(); err != nil {
+		logger.Errorf("Failed to write log file of %s: %v\n", lb.ip, err)
+	}
+}
+
+// closeFile writes the footer, flushes the buffered lines and closes the log file. The
+// caller must hold lb.mu.
+func (lb *LogBuffer) closeFile(footer string) {
+	if lb.currentFile == nil {
+		return
+	}
+	lb.writer.WriteString(footer)
+	lb.flush()
+	lb.currentFile.Close()
+	lb.currentFile = nil
+	lb.writer = nil
+}
+
+// flushLogFiles flushes the buffered lines of every log file each interval until stop is
+// closed, so a line reaches the disk at most one interval after it was received
+func (s *Server) flushLogFiles(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.logBuffersLock.RLock()
+			for _, buffer := range s.logBuffers {
+				buffer.mu.Lock()
+				buffer.flush()
+				buffer.mu.Unlock()
+			}
+			s.logBuffersLock.RUnlock()
+		case <-stop:
+			return
+		}
+	}
+}
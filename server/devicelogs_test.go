@@ -0,0 +1,194 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestParseSeverity tests both supported severity prefix styles
+func TestParseSeverity(t *testing.T) {
+	tests := map[string]string{
+		"[E] ADC overrun":              SEVERITY_ERROR,
+		"  [W] link speed 10M":         SEVERITY_WARNING,
+		"[I] started":                  SEVERITY_INFO,
+		"E (1234) eth: PHY not found":  SEVERITY_ERROR,
+		"D (99) tcp: sent 1460 bytes":  SEVERITY_DEBUG,
+		"plain line":                   SEVERITY_NONE,
+		"[X] unknown letter":           SEVERITY_NONE,
+		"":                             SEVERITY_NONE,
+		"Error (code 5) without level": SEVERITY_NONE,
+	}
+	for line, want := range tests {
+		if got := parseSeverity(line); got != want {
+			t.Errorf("parseSeverity(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+// TestLogFileBuffering tests that buffered lines reach the file on the periodic flush and on
+// close, and synced lines immediately
+func TestLogFileBuffering(t *testing.T) {
+	s := NewServer(config.Default())
+	buffer := NewLogBuffer("10_0_0_2", 10)
+	s.logBuffers["10_0_0_2"] = buffer
+	path := filepath.Join(t.TempDir(), "logs.txt")
+	readFile := func() string {
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+
+	buffer.mu.Lock()
+	if err := buffer.openFile(path, "start\n"); err != nil {
+		t.Fatal(err)
+	}
+	buffer.writeLine("buffered\n", false)
+	buffer.mu.Unlock()
+	if got := readFile(); got != "" {
+		t.Errorf("File before the flush = %q, want empty", got)
+	}
+
+	stop := make(chan struct{})
+	go s.flushLogFiles(10*time.Millisecond, stop)
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	if got := readFile(); got != "start\nbuffered\n" {
+		t.Errorf("File after the flush = %q", got)
+	}
+
+	buffer.mu.Lock()
+	buffer.writeLine("synced\n", true)
+	if got := readFile(); got != "start\nbuffered\nsynced\n" {
+		t.Errorf("File after a synced line = %q", got)
+	}
+	buffer.writeLine("last\n", false)
+	buffer.closeFile("end\n")
+	buffer.mu.Unlock()
+	if got := readFile(); got != "start\nbuffered\nsynced\nlast\nend\n" {
+		t.Errorf("File after close = %q", got)
+	}
+}
+
+// TestGetLogPage tests paging and filtering across rotated log files
+func TestGetLogPage(t *testing.T) {
+	cfg := config.Default()
+	cfg.LogDir = t.TempDir()
+	s := NewServer(cfg)
+	files := map[string]string{
+		"logs_10_0_0_2_100.txt": "=== Log started ===\n[2024-05-01T12:00:00Z] [I] boot\n[2024-05-01T12:00:01Z] [E] ADC overrun\n",
+		"logs_10_0_0_2_200.txt": "=== Log started ===\n[2024-05-01T13:00:00Z] [W] link 10M\n[2024-05-01T13:00:01Z] [E] PHY reset\n",
+		"logs_10_0_0_3_100.txt": "[2024-05-01T12:00:00Z] [E] other device\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(cfg.LogDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := s.GetLogPage(LogQuery{IP: "10.0.0.2", Limit: 3})
+	if err != nil {
+		t.Fatalf("GetLogPage() = %v", err)
+	}
+	if page.Total != 4 || len(page.Entries) != 3 || page.Entries[0].Line != "[2024-05-01T13:00:01Z] [E] PHY reset" {
+		t.Errorf("GetLogPage() = %+v, want the 3 newest of 4 lines", page)
+	}
+	page, _ = s.GetLogPage(LogQuery{IP: "10.0.0.2", Severities: []string{SEVERITY_ERROR}, Offset: 1})
+	if page.Total != 2 || len(page.Entries) != 1 || page.Entries[0].Line != "[2024-05-01T12:00:01Z] [E] ADC overrun" {
+		t.Errorf("GetLogPage() = %+v, want the older error", page)
+	}
+	page, _ = s.GetLogPage(LogQuery{IP: "10.0.0.2", From: time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC),
+		To: time.Date(2024, 5, 1, 13, 0, 1, 0, time.UTC)})
+	if page.Total != 2 || page.Entries[0].Severity != SEVERITY_WARNING {
+		t.Errorf("GetLogPage() = %+v, want the overrun and the link warning", page)
+	}
+}
+
+// TestLogLoss tests that numbered log datagrams are counted exactly and lost lines of
+// unnumbered ones are estimated from their device timestamps
+func TestLogLoss(t *testing.T) {
+	var numbered logLossTracker
+	for _, datagram := range []string{"#0 I (1) a", "#1 I (2) b", "#4 I (3) c", "#4 I (3) c", "#3 I (4) d", "#0 I (1) rebooted", "#1 I (2) e"} {
+		sequence, line, sequenced := parseLogSequence(datagram)
+		if !sequenced || line[0] != 'I' {
+			t.Fatalf("parseLogSequence(%q) = %d, %q, %v", datagram, sequence, line, sequenced)
+		}
+		numbered.observe(sequence, sequenced, line)
+	}
+	want := LogLossStats{Datagrams: 7, Sequenced: 7, Missing: 2, Duplicates: 1, OutOfOrder: 1}
+	if numbered.stats != want {
+		t.Errorf("Numbered stats = %+v, want %+v", numbered.stats, want)
+	}
+
+	// Lines every 100 ms with the lines of 200 to 400 ms after 1000 lost
+	var unnumbered logLossTracker
+	for ms := 0; ms <= 2000; ms += 100 {
+		if ms < 1200 || ms > 1400 {
+			unnumbered.observe(0, false, fmt.Sprintf("I (%d) tag: line", ms))
+		}
+	}
+	unnumbered.observe(0, false, "plain line without a timestamp")
+	if unnumbered.stats.Datagrams != 19 || unnumbered.stats.Estimated != 3 || unnumbered.stats.Sequenced != 0 {
+		t.Errorf("Unnumbered stats = %+v, want 19 datagrams and 3 estimated lost", unnumbered.stats)
+	}
+	if _, line, sequenced := parseLogSequence("#notanumber text"); sequenced || line != "#notanumber text" {
+		t.Errorf("parseLogSequence() split a line without a sequence number")
+	}
+}
+
+// TestUDPLogListeners tests that lines received on further UDP log ports are marked with
+// the source tag of their port and kept apart when read back from the log file
+func TestUDPLogListeners(t *testing.T) {
+	cfg := config.Default()
+	cfg.LogDir = t.TempDir()
+	cfg.UDPLogPort = freePort(t)
+	wifiPort := freePort(t)
+	cfg.UDPLogListeners = map[int]string{wifiPort: "wifi"}
+	s := NewServer(cfg)
+	if err := s.InitUDPLogListener(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.StopAllLogListeners()
+
+	for port, line := range map[int]string{cfg.UDPLogPort: "I (1) main: started", wifiPort: "E (2) wifi: disconnected"} {
+		conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(line))
+		conn.Close()
+	}
+	var entries []LogEntry
+	for range 100 {
+		if entries = s.GetFilteredLogs("127.0.0.1", nil); len(entries) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sources := map[string]string{}
+	for _, entry := range entries {
+		sources[entry.Source] = entry.Severity
+	}
+	if len(sources) != 2 || sources[""] != SEVERITY_INFO || sources["wifi"] != SEVERITY_ERROR {
+		t.Errorf("Log entries = %+v, want an info line without source and a wifi error", entries)
+	}
+
+	s.StopAllLogListeners()
+	paths, _ := filepath.Glob(filepath.Join(cfg.LogDir, "logs_127_0_0_1_*.txt"))
+	if len(paths) != 1 {
+		t.Fatalf("Log files = %v, want 1", paths)
+	}
+	read, err := readLogFile(paths[0])
+	if err != nil || len(read) != 2 {
+		t.Fatalf("readLogFile() = %+v, %v", read, err)
+	}
+	for _, entry := range read {
+		if entry.Source == "wifi" && entry.Severity != SEVERITY_ERROR || entry.Source == "" && entry.Severity != SEVERITY_INFO {
+			t.Errorf("Read back %+v", entry)
+		}
+	}
+}
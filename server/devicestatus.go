@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"eth-daq-software/logger"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	DEVICE_STATUS_EVENT    = "device-status" // Event carrying a DeviceStatusChange
+	STATUS_MISSED_MESSAGES = 3               // Status messages that may be missed before the connection is closed
+)
+
+// DeviceStatus is the latest status a device reported on its handshake connection
+type DeviceStatus struct {
+	Uptime      int64   // Seconds since the device booted
+	Temperature float64 // Internal temperature in °C
+	ErrorFlags  uint32  // Device specific error bits, 0 if none
+	BufferFill  float64 // Fill level of the device's sample buffer, 0 to 1
+	Received    time.Time
+}
+
+// DeviceStatusChange is sent to the frontend when a device's status changes
+type DeviceStatusChange struct {
+	IP     string
+	UUID   string
+	Status DeviceStatus
+}
+
+// statusMessage is a status message as sent by the device. Fields it leaves out keep
+// their previous value.
+type statusMessage struct {
+	Uptime      *int64   `json:"uptime"`
+	Temperature *float64 `json:"temperature"`
+	ErrorFlags  *uint32  `json:"errorFlags"`
+	BufferFill  *float64 `json:"bufferFill"`
+	Pong        *uint64  `json:"pong"` // Reply to a ping rather than a status, see RoundTripStats
+}
+
+// merge applies the fields of a status message to the previous status
+func (m statusMessage) merge(status DeviceStatus) DeviceStatus {
+	if m.Uptime != nil {
+		status.Uptime = *m.Uptime
+	}
+	if m.Temperature != nil {
+		status.Temperature = *m.Temperature
+	}
+	if m.ErrorFlags != nil {
+		status.ErrorFlags = *m.ErrorFlags
+	}
+	if m.BufferFill != nil {
+		status.BufferFill = *m.BufferFill
+	}
+	return status
+}
+
+// changed reports whether the status differs from the previous one in more than the
+// uptime, which changes with every message
+func (status DeviceStatus) changed(previous *DeviceStatus) bool {
+	if previous == nil {
+		return true
+	}
+	return status.Temperature != previous.Temperature ||
+		status.ErrorFlags != previous.ErrorFlags ||
+		status.BufferFill != previous.BufferFill
+}
+
+// readDeviceStatus reads the status messages a device sends every interval seconds until
+// the device closes the connection, misses STATUS_MISSED_MESSAGES messages or the server
+// stops. The device is pinged every PingInterval meanwhile.
+func (s *Server) readDeviceStatus(messages *deviceMessages, sanitizedIP string, uuid string, interval int) {
+	timeout := time.Duration(interval*STATUS_MISSED_MESSAGES) * time.Second
+	pings := &pinger{pending: make(map[uint64]time.Time)}
+	if pingInterval := s.settings().PingInterval; pingInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.sendPings(pings, messages, sanitizedIP, uuid, time.Duration(pingInterval)*time.Millisecond, stop)
+	}
+	for {
+		message, err := messages.next(timeout)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && s.ctx.Err() == nil {
+				logger.Errorf("Error reading status from %s: %v\n", sanitizedIP, err)
+			}
+			return
+		}
+
+		var fields statusMessage
+		if err := json.Unmarshal(message, &fields); err != nil {
+			logger.Errorf("Invalid status message from %s: %v\n", sanitizedIP, err)
+			continue
+		}
+		if fields.Pong != nil {
+			if stats, matched := pings.reply(*fields.Pong); matched {
+				s.updateLatency(sanitizedIP, uuid, stats)
+			}
+			continue
+		}
+		s.updateDeviceStatus(sanitizedIP, uuid, fields)
+	}
+}
+
+// updateDeviceStatus merges a status message into the IP's connection and notifies the
+// frontend if the status changed
+func (s *Server) updateDeviceStatus(sanitizedIP string, uuid string, fields statusMessage) {
+	s.connectedIPsLock.Lock()
+	connection, exists := s.connectedIPs[sanitizedIP]
+	if !exists || connection.UUID != uuid {
+		s.connectedIPsLock.Unlock()
+		return
+	}
+	var status DeviceStatus
+	if connection.Status != nil {
+		status = *connection.Status
+	}
+	status = fields.merge(status)
+	status.Received = time.Now()
+	changed := status.changed(connection.Status)
+	connection.Status = &status
+	s.connectedIPsLock.Unlock()
+
+	if changed {
+		s.emitEvent(DEVICE_STATUS_EVENT, DeviceStatusChange{IP: sanitizedIP, UUID: uuid, Status: status})
+	}
+}
+
+// GetDeviceStatus returns the latest status reported by a connected device
+func (s *Server) GetDeviceStatus(uuid string) (DeviceStatus, error) {
+	s.connectedIPsLock.RLock()
+	defer s.connectedIPsLock.RUnlock()
+	for _, connection := range s.connectedIPs {
+		if connection.UUID == uuid && connection.Status != nil {
+			return *connection.Status, nil
+		}
+	}
+	return DeviceStatus{}, fmt.Errorf("no status received from device %s", uuid)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"time"
+)
+
+const (
+	DIAGNOSTICS_GC_PAUSES = 16 // Recent GC pauses reported by GetDiagnostics
+)
+
+// ChannelQueues are the data of a channel waiting to be processed or written
+type ChannelQueues struct {
+	Key           BufferKey
+	StatsQueued   int // Chunks waiting for the averaging goroutine
+	StatsCapacity int // STATS_QUEUE_CHUNKS, 0 without an averaging goroutine
+	BufferedBytes int // Bytes in the flush buffer waiting to be written
+}
+
+// Diagnostics are runtime figures for finding performance problems on a capture machine
+type Diagnostics struct {
+	Time          time.Time
+	Goroutines    int
+	HeapAlloc     uint64 // Bytes of allocated heap objects
+	HeapInuse     uint64 // Bytes in in-use heap spans
+	HeapSys       uint64 // Bytes of heap memory obtained from the OS
+	NumGC         uint32
+	GCPauseTotal  time.Duration
+	GCPauses      []time.Duration // Most recent GC pauses, newest first
+	GCCPUFraction float64         // Fraction of CPU time used by the GC since the start
+	WriteQueue    WriteQueueStats
+	Channels      []ChannelQueues // Ordered by IP and port
+}
+
+// queues returns the queue depths of the buffer
+func (db *DataBuffer) queues(key BufferKey) ChannelQueues {
+	queues := ChannelQueues{Key: key}
+	db.statsSendLock.RLock()
+	if db.statsQueue != nil {
+		queues.StatsQueued = len(db.statsQueue)
+		queues.StatsCapacity = cap(db.statsQueue)
+	}
+	db.statsSendLock.RUnlock()
+	db.mu.Lock()
+	queues.BufferedBytes = len(db.buffer)
+	db.mu.Unlock()
+	return queues
+}
+
+// GetDiagnostics returns the goroutine count, heap and GC figures of the process and the
+// queue depths of the write queue and every live channel
+func (s *Server) GetDiagnostics() Diagnostics {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	diagnostics := Diagnostics{
+		Time:          time.Now(),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     memory.HeapAlloc,
+		HeapInuse:     memory.HeapInuse,
+		HeapSys:       memory.HeapSys,
+		NumGC:         memory.NumGC,
+		GCPauseTotal:  time.Duration(memory.PauseTotalNs),
+		GCPauses:      []time.Duration{},
+		GCCPUFraction: memory.GCCPUFraction,
+		WriteQueue:    s.GetWriteQueueStats(),
+	}
+	// PauseNs is a ring of the last 256 pauses, the newest at (NumGC+255)%256
+	for i := range min(int(memory.NumGC), DIAGNOSTICS_GC_PAUSES) {
+		pause := memory.PauseNs[(int(memory.NumGC)-1-i+len(memory.PauseNs))%len(memory.PauseNs)]
+		diagnostics.GCPauses = append(diagnostics.GCPauses, time.Duration(pause))
+	}
+
+	s.buffersLock.RLock()
+	buffers := make(map[BufferKey]*DataBuffer, len(s.buffers))
+	for key, buffer := range s.buffers {
+		buffers[key] = buffer
+	}
+	s.buffersLock.RUnlock()
+	diagnostics.Channels = make([]ChannelQueues, 0, len(buffers))
+	for key, buffer := range buffers {
+		diagnostics.Channels = append(diagnostics.Channels, buffer.queues(key))
+	}
+	sort.Slice(diagnostics.Channels, func(i, j int) bool {
+		a, b := diagnostics.Channels[i].Key, diagnostics.Channels[j].Key
+		if a.IP != b.IP {
+			return a.IP < b.IP
+		}
+		return a.Port < b.Port
+	})
+	return diagnostics
+}
+
+// handlePprof adds the net/http/pprof profiles under /debug/pprof/ to mux. They answer
+// only while features.pprof is enabled, as profiles expose the internals of the process.
+func (s *Server) handlePprof(mux *http.ServeMux) {
+	enabled := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !s.settings().Features.Pprof {
+				http.NotFound(w, r)
+				return
+			}
+			handler(w, r)
+		}
+	}
+	mux.HandleFunc("/debug/pprof/", enabled(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", enabled(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", enabled(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", enabled(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", enabled(pprof.Trace))
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DiskUsage is the space used by recordings and left on the data volume
+type DiskUsage struct {
+	DataDir    string
+	FreeBytes  uint64           // Space available on the volume of DataDir
+	TotalBytes uint64           // Size of the volume of DataDir
+	DataBytes  int64            // Size of all files in DataDir, including sessions
+	Sessions   map[string]int64 // Size of each session directory, by session ID
+	// IngestRate is the bytes per second currently received on all data ports. Segments
+	// are compressed, so the volume fills at most this fast.
+	IngestRate float64
+	// RemainingSeconds is the capture time FreeBytes lasts at IngestRate, 0 while no data
+	// is received
+	RemainingSeconds float64
+}
+
+// GetDiskUsage returns the space used in the data directory and the capture time left on
+// its volume at the current ingest rate
+func (s *Server) GetDiskUsage() (DiskUsage, error) {
+	dataDir := s.settings().DataDir
+	usage := DiskUsage{DataDir: dataDir, Sessions: make(map[string]int64)}
+
+	var err error
+	usage.FreeBytes, usage.TotalBytes, err = diskSpace(dataDir)
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("failed to get free space of %s: %v", dataDir, err)
+	}
+
+	err = filepath.WalkDir(dataDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Files can be deleted by retention or compression while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		usage.DataBytes += info.Size()
+		// Sessions are the directories directly in the data directory
+		if rel, err := filepath.Rel(dataDir, path); err == nil {
+			if dir, _, nested := strings.Cut(filepath.ToSlash(rel), "/"); nested {
+				usage.Sessions[dir] += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("failed to read %s: %v", dataDir, err)
+	}
+
+	for _, rate := range s.GetAllBufferRates() {
+		usage.IngestRate += rate
+	}
+	if usage.IngestRate > 0 {
+		usage.RemainingSeconds = float64(usage.FreeBytes) / usage.IngestRate
+	}
+	return usage, nil
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGetDiskUsage tests that files are counted in the data directory and their session
+func TestGetDiskUsage(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+
+	session := filepath.Join(cfg.DataDir, "20240501-120000_dev1")
+	if err := os.Mkdir(session, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, size := range map[string]int{
+		filepath.Join(cfg.DataDir, "port5556_a.bin"): 100,
+		filepath.Join(session, "port5556_b.bin"):     200,
+		filepath.Join(session, SESSION_MANIFEST):     10,
+	} {
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := s.GetDiskUsage()
+	if err != nil {
+		t.Fatalf("GetDiskUsage() = %v", err)
+	}
+	if usage.DataBytes != 310 || usage.Sessions["20240501-120000_dev1"] != 210 || len(usage.Sessions) != 1 {
+		t.Errorf("GetDiskUsage() = %+v, want 310 bytes with 210 in the session", usage)
+	}
+	if usage.FreeBytes == 0 || usage.TotalBytes < usage.FreeBytes || usage.RemainingSeconds != 0 {
+		t.Errorf("GetDiskUsage() = %+v, want the volume's space and no estimate without data", usage)
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	DOWNSAMPLE_MINMAX = "minmax" // Each bucket is plotted as its min and max, in the order they occur
+	DOWNSAMPLE_LTTB   = "lttb"   // Largest-Triangle-Three-Buckets, one point per bucket
+)
+
+// PlotOptions controls how a sample window is reduced for plotting
+type PlotOptions struct {
+	WindowMs int    // Length of the recent sample window, 0 plots the whole history
+	Points   int    // Maximum number of points returned, windows with fewer samples are returned whole
+	Method   string // DOWNSAMPLE_MINMAX or DOWNSAMPLE_LTTB, "" selects DOWNSAMPLE_MINMAX
+	Channel  int    // 0 for the primary channel, 1 for channel B of the thermocouple port
+}
+
+// PlotPoint is one point of a downsampled plot
+type PlotPoint struct {
+	X float64 // Seconds relative to the plot's End, or the sample index if the rate is unknown
+	Y float64
+}
+
+// PlotData is a sample window reduced to at most PlotOptions.Points points
+type PlotData struct {
+	End        time.Time // Time of the newest sample
+	SampleRate float64   // Samples per second of the window, 0 if unknown
+	Samples    int       // Number of samples in the window before downsampling
+	Unit       string    // Unit of the Y values, see ChannelUnits
+	Points     []PlotPoint
+}
+
+// Downsample reduces samples to at most points points that keep the shape of the signal,
+// including single-sample spikes. The X of the returned points is the sample index. NaN
+// samples, such as out-of-range thermocouple readings, are left out.
+func Downsample(samples []float64, points int, method string) ([]PlotPoint, error) {
+	data := make([]PlotPoint, len(samples))
+	for i, sample := range samples {
+		data[i] = PlotPoint{X: float64(i), Y: sample}
+	}
+	return downsamplePoints(data, points, method)
+}
+
+// downsamplePoints reduces points ordered by X like Downsample, keeping their X
+func downsamplePoints(data []PlotPoint, points int, method string) ([]PlotPoint, error) {
+	if points <= 0 {
+		return nil, fmt.Errorf("number of points must be positive")
+	}
+	valid := make([]PlotPoint, 0, len(data))
+	for _, point := range data {
+		if !math.IsNaN(point.Y) {
+			valid = append(valid, point)
+		}
+	}
+
+	switch method {
+	case "", DOWNSAMPLE_MINMAX:
+		return downsampleMinMax(valid, points), nil
+	case DOWNSAMPLE_LTTB:
+		return downsampleLTTB(valid, points), nil
+	default:
+		return nil, fmt.Errorf("unknown downsampling method %q", method)
+	}
+}
+
+// downsampleMinMax splits the points into points/2 buckets and keeps the min and max of each
+func downsampleMinMax(data []PlotPoint, points int) []PlotPoint {
+	if len(data) <= points {
+		return data
+	}
+	buckets := max(points/2, 1)
+	result := make([]PlotPoint, 0, 2*buckets)
+	for bucket := 0; bucket < buckets; bucket++ {
+		start := bucket * len(data) / buckets
+		end := (bucket + 1) * len(data) / buckets
+		if start == end {
+			continue
+		}
+		low, high := start, start
+		for i := start + 1; i < end; i++ {
+			if data[i].Y < data[low].Y {
+				low = i
+			}
+			if data[i].Y > data[high].Y {
+				high = i
+			}
+		}
+		switch {
+		case low == high || points == 1:
+			result = append(result, data[low])
+		case low < high:
+			result = append(result, data[low], data[high])
+		default:
+			result = append(result, data[high], data[low])
+		}
+	}
+	return result
+}
+
+// downsampleLTTB keeps the first and last point and picks from each bucket in between the
+// point forming the largest triangle with the previously picked point and the average of
+// the next bucket
+func downsampleLTTB(data []PlotPoint, points int) []PlotPoint {
+	if len(data) <= points {
+		return data
+	}
+	if points < 3 {
+		return downsampleMinMax(data, points)
+	}
+
+	result := make([]PlotPoint, 0, points)
+	result = append(result, data[0])
+	bucketSize := float64(len(data)-2) / float64(points-2)
+	previous := 0
+	for bucket := 0; bucket < points-2; bucket++ {
+		start := int(float64(bucket)*bucketSize) + 1
+		end := int(float64(bucket+1)*bucketSize) + 1
+
+		// Average of the next bucket, the last point for the last bucket
+		nextStart := end
+		nextEnd := min(int(float64(bucket+2)*bucketSize)+1, len(data))
+		if bucket == points-3 {
+			nextStart, nextEnd = len(data)-1, len(data)
+		}
+		var avgX, avgY float64
+		for i := nextStart; i < nextEnd; i++ {
+			avgX += data[i].X
+			avgY += data[i].Y
+		}
+		count := float64(nextEnd - nextStart)
+		avgX /= count
+		avgY /= count
+
+		picked, largest := start, -1.0
+		a := data[previous]
+		for i := start; i < end; i++ {
+			area := math.Abs((a.X-avgX)*(data[i].Y-a.Y) - (a.X-data[i].X)*(avgY-a.Y))
+			if area > largest {
+				picked, largest = i, area
+			}
+		}
+		result = append(result, data[picked])
+		previous = picked
+	}
+	return append(result, data[len(data)-1])
+}
+
+// GetPlotData returns the recent sample window of a channel reduced to at most
+// opts.Points points, with X in seconds before the newest sample
+func (s *Server) GetPlotData(key BufferKey, opts PlotOptions) (PlotData, error) {
+	s.buffersLock.RLock()
+	buffer, exists := s.buffers[key]
+	s.buffersLock.RUnlock()
+	if !exists {
+		return PlotData{}, fmt.Errorf("no active channel for %s:%d", key.IP, key.Port)
+	}
+
+	buffer.statsMu.Lock()
+	history := buffer.history
+	if opts.Channel == 1 {
+		history = buffer.historyB
+	}
+	if history == nil {
+		buffer.statsMu.Unlock()
+		return PlotData{}, fmt.Errorf("port %d has no channel %d", key.Port, opts.Channel)
+	}
+	sampleRate := buffer.sampleRate
+	n := history.Available()
+	if opts.WindowMs > 0 && sampleRate > 0 {
+		n = min(n, int(float64(opts.WindowMs)/1000*sampleRate))
+	}
+	samples := history.Last(n)
+	buffer.statsMu.Unlock()
+
+	plot, err := plotSamples(samples, sampleRate, time.Now(), opts)
+	plot.Unit = buffer.samples.decoder.Units()[opts.Channel]
+	return plot, err
+}
+
+// plotSamples downsamples samples taken at sampleRate, the newest of them at end
+func plotSamples(samples []float64, sampleRate float64, end time.Time, opts PlotOptions) (PlotData, error) {
+	points, err := Downsample(samples, opts.Points, opts.Method)
+	if err != nil {
+		return PlotData{}, err
+	}
+	if sampleRate > 0 {
+		last := float64(len(samples) - 1)
+		for i := range points {
+			points[i].X = (points[i].X - last) / sampleRate
+		}
+	}
+	return PlotData{End: end, SampleRate: sampleRate, Samples: len(samples), Points: points}, nil
+}
+
+// envelope is a min/max downsampler over a fixed X range that takes points one at a time,
+// so that long recordings can be reduced without holding all of their samples
+type envelope struct {
+	from  float64
+	to    float64
+	width float64 // X range of a bucket
+	low   []PlotPoint
+	high  []PlotPoint
+	count []int
+}
+
+// newEnvelope creates an envelope of points/2 buckets over [from, to]
+func newEnvelope(from float64, to float64, points int) *envelope {
+	buckets := max(points/2, 1)
+	return &envelope{
+		from:  from,
+		to:    to,
+		width: (to - from) / float64(buckets),
+		low:   make([]PlotPoint, buckets),
+		high:  make([]PlotPoint, buckets),
+		count: make([]int, buckets),
+	}
+}
+
+// add adds a point, points outside the range and NaN samples are ignored
+func (e *envelope) add(x float64, y float64) {
+	if math.IsNaN(y) || x < e.from || x > e.to {
+		return
+	}
+	bucket := len(e.count) - 1
+	if e.width > 0 {
+		bucket = min(int((x-e.from)/e.width), bucket) // x == to falls into the last bucket
+	}
+	point := PlotPoint{X: x, Y: y}
+	if e.count[bucket] == 0 || y < e.low[bucket].Y {
+		e.low[bucket] = point
+	}
+	if e.count[bucket] == 0 || y > e.high[bucket].Y {
+		e.high[bucket] = point
+	}
+	e.count[bucket]++
+}
+
+// points returns the min and max of each bucket in the order they occur
+func (e *envelope) points() []PlotPoint {
+	result := make([]PlotPoint, 0, 2*len(e.count))
+	for bucket, count := range e.count {
+		low, high := e.low[bucket], e.high[bucket]
+		switch {
+		case count == 0:
+		case low.X == high.X:
+			result = append(result, low)
+		case low.X < high.X:
+			result = append(result, low, high)
+		default:
+			result = append(result, high, low)
+		}
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestDownsampleSpike tests that both methods keep a single-sample spike and return at
+// most the requested number of points
+func TestDownsampleSpike(t *testing.T) {
+	samples := make([]float64, 10000)
+	for i := range samples {
+		samples[i] = math.Sin(float64(i) / 500)
+	}
+	samples[4321] = 50
+	samples[100] = math.NaN()
+
+	for _, method := range []string{DOWNSAMPLE_MINMAX, DOWNSAMPLE_LTTB} {
+		points, err := Downsample(samples, 100, method)
+		if err != nil {
+			t.Fatalf("Downsample(%s) = %v", method, err)
+		}
+		if len(points) == 0 || len(points) > 100 {
+			t.Errorf("Downsample(%s) returned %d points, want at most 100", method, len(points))
+		}
+		spike := false
+		for i, point := range points {
+			if math.IsNaN(point.Y) {
+				t.Errorf("Downsample(%s) kept a NaN sample", method)
+			}
+			if i > 0 && point.X <= points[i-1].X {
+				t.Errorf("Downsample(%s) points out of order at %d", method, i)
+			}
+			spike = spike || (point.X == 4321 && point.Y == 50)
+		}
+		if !spike {
+			t.Errorf("Downsample(%s) lost the spike", method)
+		}
+	}
+
+	if points, _ := Downsample([]float64{1, 2, 3}, 10, DOWNSAMPLE_LTTB); len(points) != 3 {
+		t.Errorf("Downsample() of a short window = %v, want every sample", points)
+	}
+	if _, err := Downsample(samples, 100, "median"); err == nil {
+		t.Error("Expected error for an unknown method, got nil")
+	}
+}
+
+// TestPlotSamples tests that the X of plot points is in seconds before the newest sample
+func TestPlotSamples(t *testing.T) {
+	data, err := plotSamples([]float64{1, 2, 3, 4, 5}, 2, time.Now(), PlotOptions{Points: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Samples != 5 || data.Points[0].X != -2 || data.Points[4].X != 0 {
+		t.Errorf("plotSamples() = %+v, want X from -2 to 0", data)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"eth-daq-software/logger"
+	"sync"
+	"time"
+)
+
+const (
+	DEVICE_LOG_EVENT           = "device-log"           // Event carrying a batch of DeviceLogLine
+	DEVICE_LOG_INTERVAL        = 100 * time.Millisecond // Device log lines are batched over this interval
+	DUPLICATE_CONNECTION_EVENT = "duplicate-connection" // Event carrying a DuplicateConnection
+	DEVICE_CONNECTION_EVENT    = "device-connection"    // Event carrying a DeviceConnectionChange
+)
+
+// Changes of a device's connections sent in a DEVICE_CONNECTION_EVENT
+const (
+	CONNECTION_HANDSHAKE    = "handshake"    // The device completed its handshake
+	CONNECTION_PORT_OPENED  = "port-opened"  // A data port of the device connected
+	CONNECTION_PORT_CLOSED  = "port-closed"  // A data port of the device disconnected
+	CONNECTION_DISCONNECTED = "disconnected" // The last data port of the device disconnected
+	CONNECTION_OFFLINE      = "offline"      // The device went silent for longer than OfflineAfter
+)
+
+// DeviceConnectionChange tells the frontend that a device connected, disconnected or went
+// offline, so that it does not have to poll GetAllConnectedIPs
+type DeviceConnectionChange struct {
+	IP     string // Sanitized IP
+	UUID   string // "" before the handshake
+	Alias  string
+	Port   int    // Data port of CONNECTION_PORT_OPENED and CONNECTION_PORT_CLOSED, else 0
+	Change string // One of the CONNECTION_* changes
+	Time   time.Time
+}
+
+// Actions taken on a duplicate data connection, see config.DuplicateConnections
+const (
+	DUPLICATE_REPLACED = "replaced" // The existing connection was closed
+	DUPLICATE_REJECTED = "rejected" // The new connection was closed
+	DUPLICATE_PARALLEL = "parallel" // Both connections were kept
+)
+
+// DuplicateConnection is a data connection from an IP and port that already had one
+type DuplicateConnection struct {
+	IP           string
+	Port         int
+	UUID         string // Device of the new connection, "" before its handshake
+	ExistingUUID string // Device of the existing connection
+	Action       string // DUPLICATE_REPLACED, DUPLICATE_REJECTED or DUPLICATE_PARALLEL
+}
+
+// EventEmitter sends an event to the frontend, e.g. a wrapper around runtime.EventsEmit
+type EventEmitter func(name string, data ...interface{})
+
+// DeviceLogLine is a log line received from a device over UDP
+type DeviceLogLine struct {
+	IP       string
+	UUID     string
+	Line     string
+	Severity string
+	Source   string // Source tag of the UDP log port, see LogEntry
+}
+
+// deviceLogEvents batches device log lines so that the frontend receives at most one
+// event per DEVICE_LOG_INTERVAL
+type deviceLogEvents struct {
+	emit    EventEmitter
+	pending []DeviceLogLine
+	mu      sync.Mutex
+}
+
+// SetEventEmitter sets the function used to push events to the frontend, nil disables events
+func (s *Server) SetEventEmitter(emit EventEmitter) {
+	s.logEvents.mu.Lock()
+	defer s.logEvents.mu.Unlock()
+	s.logEvents.emit = emit
+}
+
+// emitEvent sends an event to the frontend, if an emitter is set
+func (s *Server) emitEvent(name string, data ...interface{}) {
+	s.logEvents.mu.Lock()
+	emit := s.logEvents.emit
+	s.logEvents.mu.Unlock()
+	if emit != nil {
+		emit(name, data...)
+	}
+}
+
+// publishDeviceLog queues a device log line, scheduling a batch if none is pending
+func (s *Server) publishDeviceLog(sanitizedIP string, line string, severity string, source string) {
+	s.logEvents.mu.Lock()
+	defer s.logEvents.mu.Unlock()
+	if s.logEvents.emit == nil {
+		return
+	}
+
+	if len(s.logEvents.pending) == 0 {
+		time.AfterFunc(DEVICE_LOG_INTERVAL, s.flushDeviceLogs)
+	}
+	s.logEvents.pending = append(s.logEvents.pending, DeviceLogLine{
+		IP:       sanitizedIP,
+		UUID:     s.deviceUUID(sanitizedIP),
+		Line:     line,
+		Severity: severity,
+		Source:   source,
+	})
+}
+
+// deviceUUID returns the UUID a device sent in its handshake, or "" before the handshake
+func (s *Server) deviceUUID(sanitizedIP string) string {
+	s.connectedIPsLock.RLock()
+	defer s.connectedIPsLock.RUnlock()
+	if conn, exists := s.connectedIPs[sanitizedIP]; exists {
+		return conn.UUID
+	}
+	return ""
+}
+
+// flushDeviceLogs emits the pending device log lines as one event
+func (s *Server) flushDeviceLogs() {
+	s.logEvents.mu.Lock()
+	lines := s.logEvents.pending
+	emit := s.logEvents.emit
+	s.logEvents.pending = nil
+	s.logEvents.mu.Unlock()
+
+	if emit != nil && len(lines) > 0 {
+		emit(DEVICE_LOG_EVENT, lines)
+	}
+}
+
+// notifyConnection sends a device connection change to the frontend
+func (s *Server) notifyConnection(change DeviceConnectionChange) {
+	change.Time = time.Now()
+	s.emitEvent(DEVICE_CONNECTION_EVENT, change)
+}
+
+// notifyDuplicate logs a duplicate data connection and reports it to the frontend
+func (s *Server) notifyDuplicate(duplicate DuplicateConnection) {
+	logger.InfoFields("Duplicate data connection", logger.Fields{
+		"ip":            duplicate.IP,
+		"port":          duplicate.Port,
+		"uuid":          duplicate.UUID,
+		"existing_uuid": duplicate.ExistingUUID,
+		"action":        duplicate.Action,
+	})
+	s.emitEvent(DUPLICATE_CONNECTION_EVENT, duplicate)
+}
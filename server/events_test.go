@@ -0,0 +1,61 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"testing"
+	"time"
+)
+
+// TestDeviceLogEvents tests that device log lines are emitted in the order they were
+// published, batched into one event per DEVICE_LOG_INTERVAL
+func TestDeviceLogEvents(t *testing.T) {
+	s := NewServer(config.Default())
+	s.connectedIPs["10_0_0_2"] = &IPConnection{ActivePorts: map[int]bool{}, UUID: "dev1"}
+	events := make(chan []DeviceLogLine, 4)
+	s.SetEventEmitter(func(name string, data ...interface{}) {
+		if name == DEVICE_LOG_EVENT {
+			events <- data[0].([]DeviceLogLine)
+		}
+	})
+	receive := func() []DeviceLogLine {
+		t.Helper()
+		select {
+		case lines := <-events:
+			return lines
+		case <-time.After(10 * DEVICE_LOG_INTERVAL):
+			t.Fatal("No device log event")
+			return nil
+		}
+	}
+
+	s.publishDeviceLog("10_0_0_2", "first", SEVERITY_INFO, "")
+	s.publishDeviceLog("10_0_0_3", "second", SEVERITY_ERROR, "")
+	s.publishDeviceLog("10_0_0_2", "third", SEVERITY_NONE, "")
+	lines := receive()
+	want := []DeviceLogLine{
+		{IP: "10_0_0_2", UUID: "dev1", Line: "first", Severity: SEVERITY_INFO},
+		{IP: "10_0_0_3", Line: "second", Severity: SEVERITY_ERROR},
+		{IP: "10_0_0_2", UUID: "dev1", Line: "third", Severity: SEVERITY_NONE},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Event has %d lines, want %d", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Line %d = %+v, want %+v", i, lines[i], want[i])
+		}
+	}
+
+	s.publishDeviceLog("10_0_0_2", "fourth", SEVERITY_INFO, "")
+	if lines := receive(); len(lines) != 1 || lines[0].Line != "fourth" {
+		t.Errorf("Second event = %+v, want only the line published after the first", lines)
+	}
+
+	s.SetEventEmitter(nil)
+	s.publishDeviceLog("10_0_0_2", "dropped", SEVERITY_INFO, "")
+	select {
+	case lines := <-events:
+		t.Errorf("Event %+v without an emitter", lines)
+	case <-time.After(2 * DEVICE_LOG_INTERVAL):
+	}
+}
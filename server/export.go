@@ -0,0 +1,480 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"eth-daq-software/compress"
+	"eth-daq-software/logger"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DECIMATE_MEAN   = "mean"   // Each exported row is the mean of a block of samples
+	DECIMATE_MINMAX = "minmax" // Each exported row holds the min and max of a block of samples
+)
+
+// ExportOptions controls how captures are exported. The built-in export format is CSV.
+// HDF5 is not supported, as the Go bindings need the HDF5 C library and cgo, which the
+// application build does not use; it can be added with RegisterExporter. Decimated CSV
+// keeps long captures small enough to be loaded into analysis tools directly.
+type ExportOptions struct {
+	Decimation int    // Number of samples combined into one row, 0 or 1 exports every sample
+	Mode       string // DECIMATE_MEAN or DECIMATE_MINMAX
+	Device     string // Device name prefixed to the column names as "name:column", "" leaves them unprefixed
+	// Units to export channels in by channel name, see ConvertUnit. Other channels are
+	// exported in their own unit, which the header names.
+	Units map[string]string
+	// Markers written to a "marker" column at the first sample at or after their time, nil
+	// uses the markers of the exported device and sessions, see AddMarker
+	Markers []Marker
+	// Timestamps adds a "time" column with the time of the first sample of each row. Samples
+	// are spread evenly over the span of their segment, or between its timestamp records.
+	Timestamps bool
+	// Thermocouple is the type of the thermocouple the samples of a thermocouple port were
+	// measured with, see thermocoupleTypes. A type configured for the port takes
+	// precedence, "" uses K.
+	Thermocouple string
+}
+
+// SegmentInfo describes a flushed data file, parsed from its file name
+type SegmentInfo struct {
+	Path      string
+	Port      int
+	IP        string
+	UUID      string
+	Alias     string // Device alias as written to the file name, "" for unnamed devices
+	Timestamp int64  // Unix nanoseconds at flush time, or when an uncompressed segment was opened
+	Sequence  int    // Number of a session segment within its port, 0 for segments named by time
+}
+
+// ParseSegmentName parses a data file name of the form port<port>_<ip>_<uuid>_<unixnano>.bin,
+// optionally followed by +<alias> before the extension. Segments of sessions, named
+// port<port>_<sequence>.bin, take the device and time from the segment manifest of their
+// session, see SegmentRecord.
+func ParseSegmentName(path string) (SegmentInfo, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name, alias, _ := strings.Cut(name, "+")
+	if !strings.HasPrefix(name, "port") {
+		return SegmentInfo{}, fmt.Errorf("not a data file: %s", path)
+	}
+	if _, _, numbered := parseNumberedSegmentName(name); numbered {
+		record, err := lookupSegment(path)
+		if err != nil {
+			return SegmentInfo{}, err
+		}
+		timestamp := record.End
+		if filepath.Ext(path) == ".bin" {
+			timestamp = record.Start
+		}
+		return SegmentInfo{
+			Path:      path,
+			Port:      record.Port,
+			IP:        record.IP,
+			UUID:      record.UUID,
+			Timestamp: timestamp.UnixNano(),
+			Sequence:  record.Sequence,
+		}, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(name, "port"), "_")
+	if len(parts) < 4 {
+		return SegmentInfo{}, fmt.Errorf("not a data file: %s", path)
+	}
+
+	port, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return SegmentInfo{}, fmt.Errorf("invalid port in %s: %v", path, err)
+	}
+	timestamp, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		return SegmentInfo{}, fmt.Errorf("invalid timestamp in %s: %v", path, err)
+	}
+
+	return SegmentInfo{
+		Path:      path,
+		Port:      port,
+		IP:        strings.Join(parts[1:len(parts)-2], "_"),
+		UUID:      parts[len(parts)-2],
+		Alias:     alias,
+		Timestamp: timestamp,
+	}, nil
+}
+
+// sortSegments sorts segments in capture order, numbered segments of a session by number
+func sortSegments(segments []SegmentInfo) {
+	sort.Slice(segments, func(i, j int) bool {
+		a, b := segments[i], segments[j]
+		if a.Sequence > 0 && b.Sequence > 0 && filepath.Dir(a.Path) == filepath.Dir(b.Path) {
+			return a.Sequence < b.Sequence
+		}
+		return a.Timestamp < b.Timestamp
+	})
+}
+
+// parseSegments parses the names of data files of a single channel and returns them in capture order
+func parseSegments(paths []string) ([]SegmentInfo, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files given")
+	}
+	segments := make([]SegmentInfo, 0, len(paths))
+	for _, path := range paths {
+		segment, err := ParseSegmentName(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) > 0 && segment.Port != segments[0].Port {
+			return nil, fmt.Errorf("cannot combine ports %d and %d", segments[0].Port, segment.Port)
+		}
+		segments = append(segments, segment)
+	}
+	sortSegments(segments)
+	return segments, nil
+}
+
+// segmentStart returns the time of the first sample of a compressed segment from its
+// metadata, zero if it has none
+func segmentStart(path string) time.Time {
+	if compressed, err := compress.IsCompressedFile(path); err != nil || !compressed {
+		return time.Time{}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}
+	}
+	metadata, err := compress.ReadMetadata(data)
+	if err != nil || metadata == nil || metadata.StartTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, metadata.StartTime)
+}
+
+// ReadSegment reads a data file, decompressing it if it is compressed
+func ReadSegment(path string) ([]byte, error) {
+	reader, err := compress.DecompressFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// ChannelNames returns the names of the exported columns for a port
+func ChannelNames(port int) []string {
+	return decoderForPort(port).Channels()
+}
+
+// sampleDecoder converts a raw byte stream to scaled samples, keeping alignment state
+// across calls so that consecutive segments can be decoded as one stream
+type sampleDecoder struct {
+	samples      *sampleReader
+	values       []float64 // Samples of the current frame
+	calibration  *Calibration
+	calibrationB *Calibration
+	outOfRange   int64 // Samples the decoder could not convert, see ChannelDecoder
+}
+
+func newSampleDecoder(port int, calibration *Calibration, calibrationB *Calibration) *sampleDecoder {
+	decoder := decoderForPort(port)
+	return &sampleDecoder{
+		samples:      newSampleReader(decoder),
+		values:       make([]float64, len(decoder.Channels())),
+		calibration:  calibration,
+		calibrationB: calibrationB,
+	}
+}
+
+// decode calls emit with one value per channel for every complete frame in data
+func (d *sampleDecoder) decode(data []byte, emit func(values []float64) error) error {
+	return d.samples.read(data, [2]*Calibration{d.calibration, d.calibrationB}, func(channel int, value float64, ok bool) error {
+		if !ok {
+			d.outOfRange++
+		}
+		d.values[channel] = value
+		if channel < len(d.values)-1 {
+			return nil
+		}
+		return emit(d.values)
+	})
+}
+
+// csvDecimator combines blocks of samples into CSV rows
+type csvDecimator struct {
+	w          *csv.Writer
+	factor     int
+	mode       string
+	index      int64 // Index of the first sample of the current block
+	count      int
+	sum        []float64
+	min, max   []float64
+	markers    bool      // Whether rows have a marker column
+	labels     []string  // Labels of the markers in the current block
+	timestamps bool      // Whether rows have a time column
+	time       time.Time // Time of the first sample of the current block
+}
+
+func newCSVDecimator(w *csv.Writer, channels int, opts ExportOptions) *csvDecimator {
+	factor := opts.Decimation
+	if factor < 1 {
+		factor = 1
+	}
+	return &csvDecimator{
+		w:      w,
+		factor: factor,
+		mode:   opts.Mode,
+		sum:    make([]float64, channels),
+		min:    make([]float64, channels),
+		max:    make([]float64, channels),
+	}
+}
+
+// header writes the CSV header for the given channel names, prefixed with the device name
+// and followed by the unit of the channel as "name [unit]". A nil units leaves them out.
+func (cd *csvDecimator) header(names []string, units []string, device string) error {
+	header := []string{"sample"}
+	if cd.timestamps {
+		header = append(header, "time")
+	}
+	for i, name := range names {
+		if device != "" {
+			name = device + ":" + name
+		}
+		columns := []string{name}
+		if cd.factor > 1 && cd.mode == DECIMATE_MINMAX {
+			columns = []string{name + "_min", name + "_max"}
+		}
+		for _, column := range columns {
+			if units != nil && units[i] != "" {
+				column += " [" + units[i] + "]"
+			}
+			header = append(header, column)
+		}
+	}
+	if cd.markers {
+		header = append(header, "marker")
+	}
+	return cd.w.Write(header)
+}
+
+// mark adds marker labels to the current block
+func (cd *csvDecimator) mark(labels []string) {
+	cd.labels = append(cd.labels, labels...)
+}
+
+// at sets the time of the next sample
+func (cd *csvDecimator) at(t time.Time) {
+	if cd.count == 0 {
+		cd.time = t
+	}
+}
+
+// add adds one sample per channel, writing a row once a block is complete
+func (cd *csvDecimator) add(values []float64) error {
+	for i, value := range values {
+		if cd.count == 0 {
+			cd.sum[i], cd.min[i], cd.max[i] = 0, value, value
+		}
+		cd.sum[i] += value
+		cd.min[i] = math.Min(cd.min[i], value)
+		cd.max[i] = math.Max(cd.max[i], value)
+	}
+	cd.count++
+	if cd.count == cd.factor {
+		return cd.flush()
+	}
+	return nil
+}
+
+// flush writes the current (possibly partial) block
+func (cd *csvDecimator) flush() error {
+	if cd.count == 0 {
+		return nil
+	}
+	row := []string{strconv.FormatInt(cd.index, 10)}
+	if cd.timestamps {
+		row = append(row, cd.time.UTC().Format(time.RFC3339Nano))
+	}
+	for i := range cd.sum {
+		if cd.factor > 1 && cd.mode == DECIMATE_MINMAX {
+			row = append(row,
+				strconv.FormatFloat(cd.min[i], 'g', -1, 64),
+				strconv.FormatFloat(cd.max[i], 'g', -1, 64))
+		} else {
+			row = append(row, strconv.FormatFloat(cd.sum[i]/float64(cd.count), 'g', -1, 64))
+		}
+	}
+	if cd.markers {
+		row = append(row, strings.Join(cd.labels, "; "))
+		cd.labels = cd.labels[:0]
+	}
+	cd.index += int64(cd.count)
+	cd.count = 0
+	return cd.w.Write(row)
+}
+
+// ExportCSV decodes the given segments of one channel as a continuous stream and writes them as CSV
+func ExportCSV(w io.Writer, segments []SegmentInfo, calibration *Calibration, calibrationB *Calibration, opts ExportOptions) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("no segments to export")
+	}
+	if opts.Mode != "" && opts.Mode != DECIMATE_MEAN && opts.Mode != DECIMATE_MINMAX {
+		return fmt.Errorf("unknown decimation mode %q", opts.Mode)
+	}
+	port := segments[0].Port
+	for _, segment := range segments {
+		if segment.Port != port {
+			return fmt.Errorf("cannot export ports %d and %d together", port, segment.Port)
+		}
+	}
+
+	// Decode segments in capture order
+	segments = append([]SegmentInfo(nil), segments...)
+	sortSegments(segments)
+
+	// Convert the channels exported in another unit than their own
+	names := ChannelNames(port)
+	channelUnits := slices.Clone(ChannelUnits(port))
+	converters := make([]func(float64) float64, len(names))
+	for i, name := range names {
+		if unit, exists := opts.Units[name]; exists && unit != "" {
+			converter, err := unitConverter(channelUnits[i], unit)
+			if err != nil {
+				return fmt.Errorf("channel %s: %v", name, err)
+			}
+			converters[i] = converter
+			channelUnits[i] = unit
+		}
+	}
+
+	csvWriter := csv.NewWriter(w)
+	decimator := newCSVDecimator(csvWriter, len(names), opts)
+	decimator.markers = len(opts.Markers) > 0
+	decimator.timestamps = opts.Timestamps
+	if err := decimator.header(names, channelUnits, opts.Device); err != nil {
+		return err
+	}
+	addValues := func(values []float64) error {
+		for i, convert := range converters {
+			if convert != nil {
+				values[i] = convert(values[i])
+			}
+		}
+		return decimator.add(values)
+	}
+
+	decoder := newSampleDecoder(port, calibration, calibrationB)
+	if _, exists := thermocoupleTypes[opts.Thermocouple]; opts.Thermocouple != "" && !exists {
+		return fmt.Errorf("unsupported thermocouple type %q", opts.Thermocouple)
+	}
+	selectThermocoupleType(decoder.samples.decoder, opts.Thermocouple)
+	markers := newMarkerCursor(opts.Markers)
+	lastEnd := time.Time{}
+	for _, segment := range segments {
+		data, err := ReadSegment(segment.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", segment.Path, err)
+		}
+		add := addValues
+		if decimator.markers || decimator.timestamps {
+			span, err := segmentSpan(segment)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", segment.Path, err)
+			}
+			// Compressed segments start at the time in their metadata or else where the
+			// previous one ended, see historyReader
+			start := span.start
+			if start.IsZero() {
+				start = segmentStart(segment.Path)
+			}
+			if start.IsZero() {
+				start = lastEnd
+				if start.IsZero() || start.After(span.end) {
+					start = span.end
+				}
+			}
+			if lastEnd.IsZero() {
+				markers.skip(start) // Markers before the export are left out
+			}
+			lastEnd = span.end
+
+			// Frames are spread evenly over the span, or between the timestamp records
+			frameSize := decoder.samples.decoder.SampleWidth() * len(decoder.values)
+			n := max(len(data)/frameSize, 1)
+			timestamps, err := ReadTimestamps(segment.Path)
+			if err != nil {
+				return err
+			}
+			at := sampleClock(timestamps, frameSize, n, start, span.end)
+			i := 0
+			add = func(values []float64) error {
+				decimator.at(at(i))
+				i++
+				if decimator.markers {
+					decimator.mark(markers.until(at(i)))
+				}
+				return addValues(values)
+			}
+		}
+		if err := decoder.decode(data, add); err != nil {
+			return err
+		}
+	}
+	if err := decimator.flush(); err != nil {
+		return err
+	}
+	if decoder.outOfRange > 0 {
+		logger.Errorf("%d readings out of range were exported as NaN\n", decoder.outOfRange)
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// ExportCSV exports data files of one channel to a CSV file at dest, applying the
+// device calibration and the requested decimation
+func (s *Server) ExportCSV(paths []string, dest string, opts ExportOptions) error {
+	segments, err := parseSegments(paths)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	uuid := segments[0].UUID
+	port := segments[0].Port
+	if opts.Device == "" {
+		device, _ := s.registry.Get(uuid)
+		opts.Device = device.Alias
+	}
+	if opts.Markers == nil {
+		opts.Markers = s.exportMarkers(uuid, segments)
+	}
+	if opts.Thermocouple == "" {
+		opts.Thermocouple = s.deviceThermocouple(uuid, port)
+	}
+	err = ExportCSV(writer, segments,
+		s.calibrations.Get(CalibrationKey{UUID: uuid, Port: port, Channel: 0}),
+		s.calibrations.Get(CalibrationKey{UUID: uuid, Port: port, Channel: 1}),
+		opts)
+	if err != nil {
+		logger.Errorf("Export to %s failed: %v\n", dest, err)
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write export file: %v", err)
+	}
+
+	logger.Infof("Exported %d files to %s\n", len(segments), dest)
+	return nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"eth-daq-software/compress"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseSegmentNameAlias tests that the device alias appended to segment names is parsed
+func TestParseSegmentNameAlias(t *testing.T) {
+	tests := []struct {
+		path  string
+		uuid  string
+		alias string
+	}{
+		{"data/port5555_10_0_0_2_ab12_1700000000000000000.rle4", "ab12", ""},
+		{"data/port5555_10_0_0_2_ab12_1700000000000000000+" + aliasFilename("DUT-3 gate driver board") + ".rle4", "ab12", "DUT-3-gate-driver-board"},
+		{"data/port5556_fe80__1_ab12_1700000000000000000+DUT_3.bin", "ab12", "DUT_3"},
+	}
+	for _, tt := range tests {
+		segment, err := ParseSegmentName(tt.path)
+		if err != nil {
+			t.Errorf("ParseSegmentName(%q) = %v", tt.path, err)
+			continue
+		}
+		if segment.UUID != tt.uuid || segment.Alias != tt.alias || segment.Timestamp != 1700000000000000000 {
+			t.Errorf("ParseSegmentName(%q) = %+v, want UUID %q alias %q", tt.path, segment, tt.uuid, tt.alias)
+		}
+	}
+}
+
+// TestExportCSVDecimation tests block mean and min/max decimation, including the partial
+// last block
+func TestExportCSVDecimation(t *testing.T) {
+	dir := t.TempDir()
+	// 0, 1, 2, 3 and 4 V over two segments
+	segments, err := parseSegments([]string{
+		writeSegment(t, dir, 5556, 1, []uint16{32768, 32768 + 3200, 32768 + 6400}),
+		writeSegment(t, dir, 5556, 2, []uint16{32768 + 9600, 32768 + 12800}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts ExportOptions
+		want string
+	}{
+		{"Every sample", ExportOptions{}, "sample,vgs [V]\n0,0\n1,1\n2,2\n3,3\n4,4\n"},
+		{"Mean", ExportOptions{Decimation: 2, Mode: DECIMATE_MEAN}, "sample,vgs [V]\n0,0.5\n2,2.5\n4,4\n"},
+		{"Min/max", ExportOptions{Decimation: 2, Mode: DECIMATE_MINMAX, Device: "DUT-3"},
+			"sample,DUT-3:vgs_min [V],DUT-3:vgs_max [V]\n0,0,1\n2,2,3\n4,4,4\n"},
+		{"Millivolts", ExportOptions{Units: map[string]string{"vgs": UNIT_MILLIVOLT}},
+			"sample,vgs [mV]\n0,0\n1,1000\n2,2000\n3,3000\n4,4000\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := ExportCSV(&out, segments, nil, nil, tt.opts); err != nil {
+				t.Fatalf("ExportCSV() = %v", err)
+			}
+			if got := roundCSV(out.String()); got != tt.want {
+				t.Errorf("ExportCSV() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := ExportCSV(&bytes.Buffer{}, segments, nil, nil, ExportOptions{Decimation: 2, Mode: "median"}); err == nil {
+		t.Error("Expected error for an unknown mode, got nil")
+	}
+	if err := ExportCSV(&bytes.Buffer{}, segments, nil, nil, ExportOptions{Units: map[string]string{"vgs": UNIT_CELSIUS}}); err == nil {
+		t.Error("Expected error for a unit of another quantity, got nil")
+	}
+}
+
+// TestExportCSVTimestamps tests that samples are timed over the span of their segment, with
+// compressed segments starting at the time in their metadata
+func TestExportCSVTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Two samples compressed with their start time, flushed 2 ms later
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint16(data, 32768)
+	binary.LittleEndian.PutUint16(data[2:], 32768+3200)
+	compressed := compress.AppendSegmentHeader(nil, &compress.Metadata{Port: 5556, StartTime: start.UnixNano()})
+	compressed = compress.HybridRLECompressInto(compressed, data, compress.Options{})
+	end := start.Add(2 * time.Millisecond)
+	first := filepath.Join(dir, "port5556_10_0_0_2_dev1_"+strconv.FormatInt(end.UnixNano(), 10)+".rle4")
+	if err := os.WriteFile(first, compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Four samples in a .bin segment opened 1 ms after the flush and closed 4 ms later
+	second := writeSegment(t, dir, 5556, end.Add(time.Millisecond).UnixNano(), []uint16{32768, 32768, 32768, 32768})
+	if err := os.Chtimes(second, end.Add(5*time.Millisecond), end.Add(5*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := parseSegments([]string{second, first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := ExportCSV(&out, segments, nil, nil, ExportOptions{Decimation: 2, Timestamps: true}); err != nil {
+		t.Fatalf("ExportCSV() = %v", err)
+	}
+	want := "sample,time,vgs [V]\n" +
+		"0,2025-03-01T12:00:00Z,0.5\n" +
+		"2,2025-03-01T12:00:00.003Z,0\n" +
+		"4,2025-03-01T12:00:00.005Z,0\n"
+	if got := out.String(); got != want {
+		t.Errorf("ExportCSV() = %q, want %q", got, want)
+	}
+}
+
+// roundCSV rounds the values of a CSV export to 6 decimals, hiding the rounding errors of
+// the ADC scaling
+func roundCSV(csv string) string {
+	lines := strings.Split(csv, "\n")
+	for i, line := range lines[1:] {
+		fields := strings.Split(line, ",")
+		for j, field := range fields[1:] {
+			if value, err := strconv.ParseFloat(field, 64); err == nil {
+				fields[j+1] = strconv.FormatFloat(math.Round(value*1e6)/1e6, 'g', -1, 64)
+			}
+		}
+		lines[i+1] = strings.Join(fields, ",")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,354 @@
+package server
+
+import (
+	"crypto/sha256"
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"eth-daq-software/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	INDEX_BLOCK_SIZE   = 1024 * 1024 // Size of the independently decompressible blocks of indexed segments
+	MAX_ALIAS_FILENAME = 64          // Maximum length of the device alias in segment file names
+)
+
+// aliasFilename converts a device alias to a file name fragment, replacing everything but
+// ASCII letters, digits, '-' and '_' with '-', e.g. "DUT-3 gate driver board" to
+// "DUT-3-gate-driver-board"
+func aliasFilename(alias string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '-'
+	}, strings.TrimSpace(alias))
+	if len(name) > MAX_ALIAS_FILENAME {
+		name = name[:MAX_ALIAS_FILENAME]
+	}
+	return name
+}
+
+// segmentExtension returns the file extension for segments written with a codec
+func segmentExtension(codec compress.Codec) string {
+	return compress.SegmentExtension(codec)
+}
+
+// encodeSegment compresses data for writing into dst. Compressed segments start with a
+// versioned segment header carrying the channel metadata. RLE4 data follows as a bare RLE4
+// stream, other codecs as indexed containers so that compress.ReadRange can read a slice
+// without decompressing the whole segment.
+func encodeSegment(dst []byte, codec compress.Codec, data []byte, metadata *compress.Metadata) ([]byte, error) {
+	if codec == nil {
+		return data, nil
+	}
+	dst = compress.AppendSegmentHeader(dst[:0], metadata)
+	if codec.ID() == compress.CodecRLE4 {
+		return compress.HybridRLECompressInto(dst, data, compress.Options{}), nil
+	}
+	return compress.AppendIndexed(dst, codec, data, INDEX_BLOCK_SIZE)
+}
+
+// flushJob is a flushed buffer waiting to be compressed and written to disk
+type flushJob struct {
+	key      BufferKey
+	data     []byte
+	filename string
+	dir      string // Directory of the recording session, "" writes to the data directory
+	codec    compress.Codec
+	metadata *compress.Metadata
+	sync     bool           // Sync the segment to disk, see config.DURABILITY_FSYNC
+	taken    time.Time      // Time the data was taken from the buffer
+	record   *SegmentRecord // Segment manifest entry of a session segment, nil outside sessions
+}
+
+// segmentWriter compresses and writes flushed buffers on background workers,
+// so that the capture path never waits for compression or the disk
+type segmentWriter struct {
+	dataDir   string
+	dirLock   sync.RWMutex
+	queue     *writeQueue
+	memory    *memoryAccount
+	checksums atomic.Bool    // Write a checksum sidecar next to every segment
+	mirror    *segmentMirror // Copies written segments to a second directory, nil if disabled
+	uploads   *uploadQueue   // Uploads written segments to remote storage, nil if disabled
+	pending   sync.WaitGroup // Jobs submitted but not yet written
+	buffers   sync.Pool      // Reused compression output buffers
+
+	stats     map[BufferKey]*CompressionStats
+	statsLock sync.Mutex
+
+	// Segment manifests of the sessions written to, by session directory
+	manifests     map[string]*sessionManifest
+	manifestsLock sync.Mutex
+
+	// Flush latencies recorded while a benchmark runs, nil otherwise
+	latencies atomic.Pointer[latencyLog]
+}
+
+// newSegmentWriter starts the workers of a writer whose queue blocks once workers*2
+// segments are waiting, see writeQueue.configure
+func newSegmentWriter(workers int, dataDir string) *segmentWriter {
+	w := &segmentWriter{
+		dataDir: dataDir,
+		queue:   newWriteQueue(max(workers*2, 1), config.WRITE_QUEUE_BLOCK),
+		memory:  newMemoryAccount(),
+		stats:   make(map[BufferKey]*CompressionStats),
+
+		manifests: make(map[string]*sessionManifest),
+	}
+	w.buffers.New = func() any { return new([]byte) }
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				job, ok := w.queue.pop()
+				if !ok {
+					return
+				}
+				w.write(job)
+				w.memory.release(len(job.data))
+				w.pending.Done()
+			}
+		}()
+	}
+	return w
+}
+
+// fallbackWriter writes the segments of buffers created without a writer synchronously,
+// to the session directory or the working directory
+var fallbackWriter = sync.OnceValue(func() *segmentWriter {
+	return newSegmentWriter(0, "")
+})
+
+// wait blocks until all submitted jobs have been written
+func (w *segmentWriter) wait() {
+	w.pending.Wait()
+}
+
+// close stops the workers once the queued jobs have been written
+func (w *segmentWriter) close() {
+	w.queue.close()
+}
+
+// setDataDir changes the directory future segments are written to
+func (w *segmentWriter) setDataDir(dataDir string) {
+	w.dirLock.Lock()
+	defer w.dirLock.Unlock()
+	w.dataDir = dataDir
+}
+
+// directory returns the directory segments are written to
+func (w *segmentWriter) directory() string {
+	w.dirLock.RLock()
+	defer w.dirLock.RUnlock()
+	return w.dataDir
+}
+
+// write compresses and writes a job on the calling goroutine
+func (w *segmentWriter) write(job flushJob) error {
+	defer putFlushBuffer(job.data)
+
+	// Make sure the data directory exists
+	dataDir := job.dir
+	if dataDir == "" {
+		dataDir = w.directory()
+	}
+	os.MkdirAll(dataDir, 0755)
+
+	buffer := w.buffers.Get().(*[]byte)
+	defer w.buffers.Put(buffer)
+
+	start := time.Now()
+	compressedData, err := encodeSegment(*buffer, job.codec, job.data, job.metadata)
+	elapsed := time.Since(start)
+	if err != nil {
+		logger.Errorf("Failed to compress %s: %v\n", job.filename, err)
+		return err
+	}
+	path := filepath.Join(dataDir, job.filename)
+	err = writeSegmentFile(path, compressedData, job.sync)
+	if err != nil {
+		logger.Errorf("Failed to write file: %v\n", err)
+		return err
+	}
+	if w.checksums.Load() {
+		sum := sha256.Sum256(compressedData)
+		if err := writeChecksum(path, sum[:]); err != nil {
+			logger.Errorf("%v\n", err)
+		}
+	}
+	if job.codec == nil && len(job.metadata.Timestamps) > 0 {
+		// Raw segments have no header to carry them
+		if err := writeTimestamps(path, job.metadata.Timestamps); err != nil {
+			logger.Errorf("%v\n", err)
+		}
+	}
+	if job.record != nil {
+		record := *job.record
+		record.Size = int64(len(compressedData))
+		if err := w.addToManifest(dataDir, record); err != nil {
+			logger.Errorf("%v\n", err)
+		}
+	}
+	if w.mirror != nil {
+		w.mirror.add(w.directory(), path)
+	}
+	if w.uploads != nil {
+		w.uploads.add(path)
+	}
+	w.record(job.key, job.codec, len(job.data), len(compressedData), elapsed)
+	if latencies := w.latencies.Load(); latencies != nil && !job.taken.IsZero() {
+		latencies.add(time.Since(job.taken))
+	}
+	if job.codec != nil {
+		// Keep the grown buffer for the next segment
+		*buffer = compressedData[:0]
+	}
+	logger.InfoFields("Segment written", logger.Fields{
+		"file":             job.filename,
+		"uuid":             job.metadata.UUID,
+		"ip":               job.key.IP,
+		"port":             job.key.Port,
+		"bytes":            len(job.data),
+		"compressed_bytes": len(compressedData),
+		"ratio":            float64(len(job.data)) / float64(len(compressedData)),
+	})
+	return nil
+}
+
+// takeFlushJob takes the buffered data out of the buffer, returning false if it is empty
+func (db *DataBuffer) takeFlushJob() (flushJob, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.buffer) == 0 {
+		return flushJob{}, false
+	}
+
+	// Use the buffer directly, the writer returns it to flushBuffers once written
+	data := db.buffer
+	db.buffer = getFlushBuffer(db.flushSize)
+	if db.writer != nil {
+		db.writer.memory.buffered.Add(-int64(len(data)))
+	}
+
+	taken := time.Now()
+	filename := db.segmentName(taken)
+	var record *SegmentRecord
+	if db.sessionDir != "" {
+		record = db.segmentRecord(db.sessionDir)
+		record.Bytes = int64(len(data))
+		record.Samples = db.frames(len(data))
+		record.Start = db.bufferStart
+		record.End = taken
+		filename = record.Name
+	}
+	metadata := db.samples.decoder.Metadata()
+	metadata.Port = db.port
+	metadata.UUID = db.uuid
+	metadata.IP = db.clientIP
+	metadata.StartTime = db.bufferStart.UnixNano()
+	metadata.Timestamps = db.timestamps
+	db.timestamps = nil
+
+	return flushJob{
+		key:      BufferKey{IP: db.clientIP, Port: db.port},
+		data:     data,
+		filename: filename,
+		dir:      db.sessionDir,
+		codec:    db.codec,
+		metadata: &metadata,
+		sync:     db.durability == config.DURABILITY_FSYNC,
+		taken:    taken,
+		record:   record,
+	}, true
+}
+
+// setAlias changes the device alias used in the names of future segments
+func (db *DataBuffer) setAlias(alias string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.alias = alias
+}
+
+// setCodec changes the codec used for future flushes, nil writes raw data
+func (db *DataBuffer) setCodec(codec compress.Codec) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.codec = codec
+}
+
+// codecForPort returns the codec configured for a port
+func (s *Server) codecForPort(port int) compress.Codec {
+	s.compressionLock.RLock()
+	name, exists := s.compression[port]
+	s.compressionLock.RUnlock()
+	if !exists {
+		name = s.settings().Features.Compression
+	}
+	if name == "" || name == "none" {
+		return nil
+	}
+	codec, err := compress.CodecByName(name)
+	if err != nil {
+		logger.Errorf("Invalid codec for port %d: %v\n", port, err)
+		return nil
+	}
+	return codec
+}
+
+// codecForDevice returns the codec preferred for a device in the registry, falling back
+// to the codec configured for the port
+func (s *Server) codecForDevice(uuid string, port int) compress.Codec {
+	device, _ := s.registry.Get(uuid)
+	switch device.Settings.Compression {
+	case "":
+		return s.codecForPort(port)
+	case "none":
+		return nil
+	}
+	codec, err := compress.CodecByName(device.Settings.Compression)
+	if err != nil {
+		logger.Errorf("Invalid codec for device %s: %v\n", uuid, err)
+		return s.codecForPort(port)
+	}
+	return codec
+}
+
+// SetCompression selects the codec used when flushing a port, "none" writes raw .bin segments
+func (s *Server) SetCompression(port int, codecName string) error {
+	if codecName != "" && codecName != "none" {
+		if _, err := compress.CodecByName(codecName); err != nil {
+			return err
+		}
+	}
+
+	s.compressionLock.Lock()
+	s.compression[port] = codecName
+	s.compressionLock.Unlock()
+
+	s.buffersLock.RLock()
+	for key, buffer := range s.buffers {
+		if key.Port == port {
+			buffer.setCodec(s.codecForDevice(buffer.uuid, port))
+		}
+	}
+	s.buffersLock.RUnlock()
+
+	logger.Infof("Compression for port %d set to %q\n", port, codecName)
+	return nil
+}
+
+// GetCompression returns the codec name used when flushing a port
+func (s *Server) GetCompression(port int) string {
+	if codec := s.codecForPort(port); codec != nil {
+		return codec.Name()
+	}
+	return "none"
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"eth-daq-software/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What ForgetDevice does with the data files of a device
+const (
+	FORGET_KEEP_DATA    = "keep"    // Leave segments and sessions in the data directory
+	FORGET_DELETE_DATA  = "delete"  // Delete segments and sessions
+	FORGET_ARCHIVE_DATA = "archive" // Move segments and sessions to ARCHIVE_DIR
+)
+
+const (
+	ARCHIVE_DIR = "archive" // Directory in the data directory holding the data of forgotten devices
+)
+
+// ForgetDevice removes every trace of a device, for a retired DUT or a UUID reused after
+// a reflash. Its connections are closed, its session stopped, its buffers, log buffer,
+// health, registry entry, calibrations and group memberships removed, and its data files
+// kept, deleted or archived according to data. Device log files on disk are kept, as
+// they are named by IP rather than UUID.
+//
+// A device that is still running will reconnect and be registered again as a new device.
+func (s *Server) ForgetDevice(uuid string, data string) error {
+	if uuid == "" {
+		return fmt.Errorf("device UUID is required")
+	}
+	if data == "" {
+		data = FORGET_KEEP_DATA
+	}
+	if data != FORGET_KEEP_DATA && data != FORGET_DELETE_DATA && data != FORGET_ARCHIVE_DATA {
+		return fmt.Errorf("invalid data option %q, must be %s, %s or %s",
+			data, FORGET_KEEP_DATA, FORGET_DELETE_DATA, FORGET_ARCHIVE_DATA)
+	}
+
+	if s.sessionDir(uuid) != "" {
+		if err := s.StopRecording(uuid); err != nil {
+			logger.Errorf("Failed to stop recording for %s: %v\n", uuid, err)
+		}
+	}
+
+	ips := make(map[string]bool)
+	if device, exists := s.registry.Get(uuid); exists && device.LastIP != "" {
+		ips[device.LastIP] = true
+	}
+	for ip, conn := range s.GetAllConnectedIPs() {
+		if conn.UUID == uuid {
+			ips[ip] = true
+		}
+	}
+
+	if err := s.disconnectDevice(uuid); err != nil {
+		return err
+	}
+
+	// Connections that only completed the handshake have no data ports to close
+	s.connectedIPsLock.Lock()
+	for ip, conn := range s.connectedIPs {
+		if conn.UUID == uuid {
+			delete(s.connectedIPs, ip)
+		}
+	}
+	s.connectedIPsLock.Unlock()
+
+	s.logBuffersLock.Lock()
+	for ip := range ips {
+		if buffer, exists := s.logBuffers[ip]; exists {
+			buffer.mu.Lock()
+			buffer.closeFile("")
+			buffer.mu.Unlock()
+			delete(s.logBuffers, ip)
+		}
+	}
+	s.logBuffersLock.Unlock()
+
+	s.healthLock.Lock()
+	for ip := range ips {
+		delete(s.health, ip)
+	}
+	s.healthLock.Unlock()
+
+	var errs []error
+	if err := s.registry.Remove(uuid); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.calibrations.Reset(uuid); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.groups.RemoveDevice(uuid); err != nil {
+		errs = append(errs, err)
+	}
+
+	files := 0
+	if data != FORGET_KEEP_DATA {
+		var err error
+		files, err = s.removeDeviceData(uuid, data == FORGET_ARCHIVE_DATA)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	logger.InfoFields("Device forgotten", logger.Fields{
+		"uuid":  uuid,
+		"data":  data,
+		"files": files,
+	})
+	return errors.Join(errs...)
+}
+
+// disconnectDevice closes the data connections of a device and waits until their
+// buffers are flushed and removed
+func (s *Server) disconnectDevice(uuid string) error {
+	s.activeConnsLock.Lock()
+	s.buffersLock.RLock()
+	for key, buffer := range s.buffers {
+		if conn, exists := s.activeConns[key]; exists && buffer.uuid == uuid {
+			conn.Close()
+		}
+	}
+	s.buffersLock.RUnlock()
+	s.activeConnsLock.Unlock()
+
+	// HandleConnection removes the buffer while holding activeConnsLock
+	deadline := time.Now().Add(SHUTDOWN_TIMEOUT)
+	for {
+		s.activeConnsLock.RLock()
+		remaining := len(s.deviceBuffers(uuid))
+		s.activeConnsLock.RUnlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out closing %d connections of %s", remaining, uuid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.writer.wait()
+	return nil
+}
+
+// removeDeviceData deletes or archives the segments and sessions of a device in the data
+// directory and returns the number of segments and sessions handled
+func (s *Server) removeDeviceData(uuid string, archive bool) (int, error) {
+	dataDir := s.settings().DataDir
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read data directory: %v", err)
+	}
+
+	archiveDir := filepath.Join(dataDir, ARCHIVE_DIR,
+		fmt.Sprintf("%s_%s", SanitizeFilename(uuid), time.Now().Format("20060102-150405")))
+	if archive {
+		if err := os.MkdirAll(archiveDir, 0755); err != nil {
+			return 0, fmt.Errorf("failed to create archive directory: %v", err)
+		}
+	}
+
+	var errs []error
+	handled := 0
+	for _, entry := range entries {
+		path := filepath.Join(dataDir, entry.Name())
+		if !deviceDataEntry(path, entry, uuid) {
+			continue
+		}
+		if archive {
+			err = os.Rename(path, filepath.Join(archiveDir, entry.Name()))
+		} else {
+			err = os.RemoveAll(path)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %v", entry.Name(), err))
+			continue
+		}
+		handled++
+	}
+	return handled, errors.Join(errs...)
+}
+
+// deviceDataEntry reports whether a data directory entry is a segment, segment sidecar or
+// session of a device
+func deviceDataEntry(path string, entry os.DirEntry, uuid string) bool {
+	if !entry.IsDir() {
+		path = strings.TrimSuffix(strings.TrimSuffix(path, CHECKSUM_SUFFIX), TIMESTAMP_SUFFIX)
+		if !isSegmentFile(path) {
+			return false
+		}
+		info, err := ParseSegmentName(path)
+		return err == nil && info.UUID == uuid
+	}
+
+	data, err := os.ReadFile(filepath.Join(path, SESSION_MANIFEST))
+	if err != nil {
+		return false
+	}
+	var session Session
+	return json.Unmarshal(data, &session) == nil && session.UUID == uuid
+}
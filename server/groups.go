@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"eth-daq-software/logger"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DEVICE_GROUPS_FILE = "groups.json"
+)
+
+// GroupStore keeps named groups of device UUIDs and persists them to disk
+type GroupStore struct {
+	path   string
+	groups map[string][]string
+	mu     sync.RWMutex
+}
+
+// NewGroupStore creates an empty group store backed by the given file
+func NewGroupStore(path string) *GroupStore {
+	return &GroupStore{
+		path:   path,
+		groups: make(map[string][]string),
+	}
+}
+
+// Load reads the groups from disk. A missing file is not an error.
+func (gs *GroupStore) Load() error {
+	data, err := os.ReadFile(gs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read groups file: %v", err)
+	}
+
+	groups := make(map[string][]string)
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return fmt.Errorf("failed to parse groups file: %v", err)
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.groups = groups
+	return nil
+}
+
+// save writes the groups to disk, the caller must hold the lock
+func (gs *GroupStore) save() error {
+	data, err := json.MarshalIndent(gs.groups, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode groups: %v", err)
+	}
+	if err := os.WriteFile(gs.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write groups file: %v", err)
+	}
+	return nil
+}
+
+// Members returns the UUIDs of a group
+func (gs *GroupStore) Members(name string) ([]string, bool) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	members, exists := gs.groups[name]
+	return append([]string(nil), members...), exists
+}
+
+// All returns every group by name
+func (gs *GroupStore) All() map[string][]string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	groups := make(map[string][]string, len(gs.groups))
+	for name, members := range gs.groups {
+		groups[name] = append([]string(nil), members...)
+	}
+	return groups
+}
+
+// Set replaces the members of a group, an empty member list removes the group
+func (gs *GroupStore) Set(name string, uuids []string) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if len(uuids) == 0 {
+		delete(gs.groups, name)
+	} else {
+		members := append([]string(nil), uuids...)
+		sort.Strings(members)
+		gs.groups[name] = members
+	}
+	return gs.save()
+}
+
+// RemoveDevice removes a device from every group, groups left empty are removed
+func (gs *GroupStore) RemoveDevice(uuid string) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	changed := false
+	for name, members := range gs.groups {
+		if !slices.Contains(members, uuid) {
+			continue
+		}
+		members = slices.DeleteFunc(members, func(member string) bool { return member == uuid })
+		if len(members) == 0 {
+			delete(gs.groups, name)
+		} else {
+			gs.groups[name] = members
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return gs.save()
+}
+
+// SetGroup defines a group of devices that are recorded together, an empty member
+// list removes the group
+func (s *Server) SetGroup(name string, uuids []string) error {
+	if name == "" {
+		return fmt.Errorf("group name is required")
+	}
+	for _, uuid := range uuids {
+		if uuid == "" {
+			return fmt.Errorf("device UUID is required")
+		}
+	}
+	if err := s.groups.Set(name, uuids); err != nil {
+		return err
+	}
+	logger.Infof("Group %q set to %v\n", name, uuids)
+	return nil
+}
+
+// GetGroups returns every group by name
+func (s *Server) GetGroups() map[string][]string {
+	return s.groups.All()
+}
+
+// StartGroupRecording starts a recording session for every member of a group. The
+// sessions are started concurrently once all are prepared, and each session records its
+// start relative to the group start command in GroupOffset. Sessions that started are
+// returned even if others failed.
+//
+// Devices have no command channel, so acquisition itself keeps running on the devices;
+// the group controls which data is recorded into the sessions.
+func (s *Server) StartGroupRecording(name string) ([]Session, error) {
+	members, exists := s.groups.Members(name)
+	if !exists {
+		return nil, fmt.Errorf("unknown group %q", name)
+	}
+
+	var wg sync.WaitGroup
+	var groupStart time.Time
+	sessions := make([]Session, len(members))
+	errs := make([]error, len(members))
+	start := make(chan struct{})
+	for i, uuid := range members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			sessions[i], errs[i] = s.startRecording(Session{UUID: uuid, Group: name}, groupStart)
+		}()
+	}
+	// Release all members at once
+	groupStart = time.Now()
+	close(start)
+	wg.Wait()
+
+	var started []Session
+	var window time.Duration
+	for i, session := range sessions {
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("%s: %v", members[i], errs[i])
+			continue
+		}
+		started = append(started, session)
+		window = max(window, session.GroupOffset)
+	}
+	logger.InfoFields("Group recording started", logger.Fields{
+		"group":   name,
+		"devices": len(started),
+		"window":  window.String(),
+	})
+	return started, errors.Join(errs...)
+}
+
+// StopGroupRecording stops the sessions started for a group with StartGroupRecording
+func (s *Server) StopGroupRecording(name string) error {
+	var errs []error
+	stopped := 0
+	for _, session := range s.GetActiveSessions() {
+		if session.Group != name {
+			continue
+		}
+		if err := s.StopRecording(session.UUID); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", session.UUID, err))
+			continue
+		}
+		stopped++
+	}
+	if stopped == 0 && len(errs) == 0 {
+		return fmt.Errorf("group %q is not recording", name)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handshake schema versions sent by the firmware in the "schemaVersion" field
+const (
+	HANDSHAKE_SCHEMA_LEGACY = 0 // Firmware without schemaVersion, sample rates as strings
+	HANDSHAKE_SCHEMA_V1     = 1 // Identity and sample rates, rates as strings or numbers
+	HANDSHAKE_SCHEMA_V2     = 2 // Adds the channel map with units
+	HANDSHAKE_SCHEMA_V3     = 3 // Adds statusInterval, the connection then stays open for status messages
+	HANDSHAKE_SCHEMA_LATEST = HANDSHAKE_SCHEMA_V3
+)
+
+const (
+	HANDSHAKE_TIMEOUT       = 5 * time.Second // Time a device has to send its handshake after connecting
+	MAX_DEVICE_MESSAGE_SIZE = 64 * 1024       // Largest handshake or status message accepted
+)
+
+// HandshakeChannel describes one channel of a data port as reported by the device
+type HandshakeChannel struct {
+	Port  int    `json:"port"`
+	Index int    `json:"index"` // Position of the channel in the port's samples
+	Name  string `json:"name"`
+	Unit  string `json:"unit,omitempty"`
+	// Type of the thermocouple attached to a thermocouple channel, e.g. "K", see
+	// thermocoupleTypes. Unless configured for the port, the decoder linearizes with it.
+	Thermocouple string `json:"thermocouple,omitempty"`
+}
+
+// Handshake is a parsed handshake. Fields the device's schema version does not define
+// are listed in Missing rather than being mistaken for reported zero values.
+type Handshake struct {
+	SchemaVersion   int
+	UUID            string
+	MAC             string
+	FirmwareVersion string
+	HardwareVersion string
+	VgsSampleRate   int
+	VdsSampleRate   int
+	TcSampleRate    int
+	Channels        []HandshakeChannel
+	StatusInterval  int      // Seconds between status messages, 0 if the device closes the connection
+	Missing         []string // Optional fields absent from the handshake or not defined by its version
+	Unknown         []string // Fields not defined by the handshake's version, ignored
+}
+
+// handshakeRate is a sample rate sent either as a string or as a number
+type handshakeRate struct {
+	value int
+	set   bool
+}
+
+func (r *handshakeRate) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "" || text == "null" {
+		return nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("invalid sample rate %s", data)
+	}
+	r.value = value
+	r.set = true
+	return nil
+}
+
+// handshakeV1 holds the fields of legacy and version 1 handshakes
+type handshakeV1 struct {
+	UUID            string        `json:"uuid"`
+	MAC             string        `json:"mac"`
+	FirmwareVersion string        `json:"firmware"`
+	HardwareVersion string        `json:"hardware"`
+	VgsSampleRate   handshakeRate `json:"vgsSampleRate"`
+	VdsSampleRate   handshakeRate `json:"vdsSampleRate"`
+	TcSampleRate    handshakeRate `json:"tcSampleRate"`
+}
+
+// handshakeV2 adds the channel map to version 1
+type handshakeV2 struct {
+	handshakeV1
+	Channels []HandshakeChannel `json:"channels"`
+}
+
+// handshakeV3 adds the status interval to version 2
+type handshakeV3 struct {
+	handshakeV2
+	StatusInterval int `json:"statusInterval"`
+}
+
+// handshakeFields lists the fields defined by each schema version
+var handshakeFields = map[int][]string{
+	HANDSHAKE_SCHEMA_LEGACY: {"uuid", "mac", "firmware", "hardware", "vgsSampleRate", "vdsSampleRate", "tcSampleRate"},
+	HANDSHAKE_SCHEMA_V1:     {"schemaVersion", "uuid", "mac", "firmware", "hardware", "vgsSampleRate", "vdsSampleRate", "tcSampleRate"},
+	HANDSHAKE_SCHEMA_V2:     {"schemaVersion", "uuid", "mac", "firmware", "hardware", "vgsSampleRate", "vdsSampleRate", "tcSampleRate", "channels"},
+	HANDSHAKE_SCHEMA_V3:     {"schemaVersion", "uuid", "mac", "firmware", "hardware", "vgsSampleRate", "vdsSampleRate", "tcSampleRate", "channels", "statusInterval"},
+}
+
+// deviceMessages reads the JSON messages a device sends on its handshake connection: the
+// handshake followed by newline-delimited status messages. A message may arrive split
+// across any number of TCP segments.
+type deviceMessages struct {
+	conn    net.Conn
+	limit   io.LimitedReader
+	decoder *json.Decoder
+}
+
+func newDeviceMessages(conn net.Conn) *deviceMessages {
+	messages := &deviceMessages{conn: conn, limit: io.LimitedReader{R: conn}}
+	messages.decoder = json.NewDecoder(&messages.limit)
+	return messages
+}
+
+// next reads the next message, which must arrive within timeout. It returns io.EOF if the
+// device closed the connection between messages.
+func (m *deviceMessages) next(timeout time.Duration) (json.RawMessage, error) {
+	m.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer m.conn.SetReadDeadline(time.Time{})
+
+	// The limit applies per message, the decoder may already hold part of the next one
+	m.limit.N = MAX_DEVICE_MESSAGE_SIZE
+	var message json.RawMessage
+	if err := m.decoder.Decode(&message); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("connection closed before the message was complete")
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+// readHandshake reads the handshake JSON from a device
+func (m *deviceMessages) readHandshake() ([]byte, error) {
+	message, err := m.next(HANDSHAKE_TIMEOUT)
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("connection closed before the handshake was complete")
+	}
+	return message, err
+}
+
+// ParseHandshake parses a handshake with the parser of its schema version. Handshakes
+// from newer firmware are parsed as the latest known version, their additional fields
+// are reported in Unknown.
+func ParseHandshake(data []byte) (Handshake, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Handshake{}, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	version := HANDSHAKE_SCHEMA_LEGACY
+	if value, exists := raw["schemaVersion"]; exists {
+		if err := json.Unmarshal(value, &version); err != nil || version < HANDSHAKE_SCHEMA_V1 {
+			return Handshake{}, fmt.Errorf("invalid schemaVersion %s", value)
+		}
+	}
+
+	var handshake Handshake
+	var err error
+	switch {
+	case version <= HANDSHAKE_SCHEMA_V1:
+		handshake, err = parseHandshakeV1(data)
+		handshake.Missing = append(handshake.Missing, "channels")
+	case version == HANDSHAKE_SCHEMA_V2:
+		handshake, err = parseHandshakeV2(data)
+	default:
+		handshake, err = parseHandshakeV3(data)
+	}
+	if err != nil {
+		return Handshake{}, err
+	}
+	handshake.SchemaVersion = version
+
+	if handshake.UUID == "" {
+		return Handshake{}, fmt.Errorf("uuid is required")
+	}
+	if version >= HANDSHAKE_SCHEMA_V1 && handshake.MAC == "" {
+		return Handshake{}, fmt.Errorf("mac is required by schema version %d", version)
+	}
+
+	known := handshakeFields[min(version, HANDSHAKE_SCHEMA_LATEST)]
+	for field := range raw {
+		if !slices.Contains(known, field) {
+			handshake.Unknown = append(handshake.Unknown, field)
+		}
+	}
+	sort.Strings(handshake.Unknown)
+	return handshake, nil
+}
+
+// parseHandshakeV1 parses legacy and version 1 handshakes
+func parseHandshakeV1(data []byte) (Handshake, error) {
+	var fields handshakeV1
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Handshake{}, fmt.Errorf("invalid handshake: %v", err)
+	}
+	return fields.handshake(), nil
+}
+
+// parseHandshakeV2 parses version 2 handshakes
+func parseHandshakeV2(data []byte) (Handshake, error) {
+	var fields handshakeV2
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Handshake{}, fmt.Errorf("invalid handshake: %v", err)
+	}
+	return fields.handshake()
+}
+
+// parseHandshakeV3 parses version 3 handshakes. A missing statusInterval is not reported,
+// the device then closes the connection after the handshake like older firmware.
+func parseHandshakeV3(data []byte) (Handshake, error) {
+	var fields handshakeV3
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Handshake{}, fmt.Errorf("invalid handshake: %v", err)
+	}
+	if fields.StatusInterval < 0 {
+		return Handshake{}, fmt.Errorf("invalid statusInterval %d", fields.StatusInterval)
+	}
+	handshake, err := fields.handshakeV2.handshake()
+	if err != nil {
+		return Handshake{}, err
+	}
+	handshake.StatusInterval = fields.StatusInterval
+	return handshake, nil
+}
+
+// handshake converts the version 2 fields and checks the channel map
+func (fields handshakeV2) handshake() (Handshake, error) {
+	handshake := fields.handshakeV1.handshake()
+	if fields.Channels == nil {
+		handshake.Missing = append(handshake.Missing, "channels")
+	}
+	seen := make(map[[2]int]bool)
+	for _, channel := range fields.Channels {
+		if channel.Port <= 0 || channel.Index < 0 || channel.Name == "" {
+			return Handshake{}, fmt.Errorf("invalid channel %+v: port, index and name are required", channel)
+		}
+		if seen[[2]int{channel.Port, channel.Index}] {
+			return Handshake{}, fmt.Errorf("duplicate channel %d on port %d", channel.Index, channel.Port)
+		}
+		seen[[2]int{channel.Port, channel.Index}] = true
+		if _, exists := thermocoupleTypes[channel.Thermocouple]; channel.Thermocouple != "" && !exists {
+			return Handshake{}, fmt.Errorf("unsupported thermocouple type %q on port %d", channel.Thermocouple, channel.Port)
+		}
+	}
+	handshake.Channels = fields.Channels
+	return handshake, nil
+}
+
+// thermocoupleType returns the thermocouple type a device reported for a data port, "" if none
+func thermocoupleType(channels []HandshakeChannel, port int) string {
+	for _, channel := range channels {
+		if channel.Port == port && channel.Thermocouple != "" {
+			return channel.Thermocouple
+		}
+	}
+	return ""
+}
+
+// handshake converts the version 1 fields, listing the absent optional fields
+func (fields handshakeV1) handshake() Handshake {
+	handshake := Handshake{
+		UUID:            fields.UUID,
+		MAC:             fields.MAC,
+		FirmwareVersion: fields.FirmwareVersion,
+		HardwareVersion: fields.HardwareVersion,
+		VgsSampleRate:   fields.VgsSampleRate.value,
+		VdsSampleRate:   fields.VdsSampleRate.value,
+		TcSampleRate:    fields.TcSampleRate.value,
+	}
+	optional := []struct {
+		name string
+		set  bool
+	}{
+		{"mac", fields.MAC != ""},
+		{"firmware", fields.FirmwareVersion != ""},
+		{"hardware", fields.HardwareVersion != ""},
+		{"vgsSampleRate", fields.VgsSampleRate.set},
+		{"vdsSampleRate", fields.VdsSampleRate.set},
+		{"tcSampleRate", fields.TcSampleRate.set},
+	}
+	for _, field := range optional {
+		if !field.set {
+			handshake.Missing = append(handshake.Missing, field.name)
+		}
+	}
+	return handshake
+}
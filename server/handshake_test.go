@@ -0,0 +1,413 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/config"
+	"fmt"
+	"math"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestParseHandshake tests the per-version handshake parsers
+func TestParseHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Handshake
+		wantErr bool
+	}{
+		{
+			name: "legacy firmware omits rates",
+			data: `{"uuid":"dev1","mac":"aa","firmware":"1.0","hardware":"A","vgsSampleRate":"1000"}`,
+			want: Handshake{
+				UUID: "dev1", MAC: "aa", FirmwareVersion: "1.0", HardwareVersion: "A", VgsSampleRate: 1000,
+				Missing: []string{"vdsSampleRate", "tcSampleRate", "channels"},
+			},
+		},
+		{
+			name: "version 2 with channel map",
+			data: `{"schemaVersion":2,"uuid":"dev1","mac":"aa","firmware":"2.0","hardware":"B",` +
+				`"vgsSampleRate":1000,"vdsSampleRate":"2000","tcSampleRate":10,` +
+				`"channels":[{"port":5556,"index":0,"name":"Vgs","unit":"V"}]}`,
+			want: Handshake{
+				SchemaVersion: 2, UUID: "dev1", MAC: "aa", FirmwareVersion: "2.0", HardwareVersion: "B",
+				VgsSampleRate: 1000, VdsSampleRate: 2000, TcSampleRate: 10,
+				Channels: []HandshakeChannel{{Port: 5556, Index: 0, Name: "Vgs", Unit: "V"}},
+			},
+		},
+		{
+			name: "thermocouple type in channel map",
+			data: `{"schemaVersion":2,"uuid":"dev1","mac":"aa",` +
+				`"channels":[{"port":5557,"index":1,"name":"thermocouple","unit":"°C","thermocouple":"J"}]}`,
+			want: Handshake{
+				SchemaVersion: 2, UUID: "dev1", MAC: "aa",
+				Channels: []HandshakeChannel{{Port: 5557, Index: 1, Name: "thermocouple", Unit: "°C", Thermocouple: "J"}},
+				Missing:  []string{"firmware", "hardware", "vgsSampleRate", "vdsSampleRate", "tcSampleRate"},
+			},
+		},
+		{
+			name: "newer firmware fields are ignored",
+			data: `{"schemaVersion":4,"uuid":"dev1","mac":"aa","firmware":"4.0","hardware":"C",` +
+				`"vgsSampleRate":1,"vdsSampleRate":1,"tcSampleRate":1,"channels":[],"gain":4}`,
+			want: Handshake{
+				SchemaVersion: 4, UUID: "dev1", MAC: "aa", FirmwareVersion: "4.0", HardwareVersion: "C",
+				VgsSampleRate: 1, VdsSampleRate: 1, TcSampleRate: 1,
+				Channels: []HandshakeChannel{}, Unknown: []string{"gain"},
+			},
+		},
+		{name: "missing uuid", data: `{"mac":"aa"}`, wantErr: true},
+		{name: "version 1 requires mac", data: `{"schemaVersion":1,"uuid":"dev1"}`, wantErr: true},
+		{name: "invalid rate", data: `{"uuid":"dev1","vgsSampleRate":"fast"}`, wantErr: true},
+		{name: "invalid version", data: `{"schemaVersion":0,"uuid":"dev1"}`, wantErr: true},
+		{name: "duplicate channel", data: `{"schemaVersion":2,"uuid":"dev1","mac":"aa",` +
+			`"channels":[{"port":5556,"index":0,"name":"a"},{"port":5556,"index":0,"name":"b"}]}`, wantErr: true},
+		{name: "unsupported thermocouple type", data: `{"schemaVersion":2,"uuid":"dev1","mac":"aa",` +
+			`"channels":[{"port":5557,"index":1,"name":"thermocouple","thermocouple":"Q"}]}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHandshake([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHandshake() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseHandshake() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandshakeAfterDataPort tests that a data port connected before the handshake is
+// linked to the device once the handshake arrives
+func TestHandshakeAfterDataPort(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	state := t.TempDir()
+	s.registry = NewDeviceRegistry(filepath.Join(state, DEVICE_REGISTRY_FILE))
+	s.calibrations = NewCalibrationStore(filepath.Join(state, CALIBRATION_FILE))
+	if _, err := s.registry.Update("dev1", func(device *Device) { device.Alias = "DUT-3" }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Buffers are keyed by the raw client IP
+	buffer := NewDataBuffer(5556, "127.0.0.1", 10, "", 1024)
+	s.buffers[BufferKey{IP: "127.0.0.1", Port: 5556}] = buffer
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+
+	client.Write([]byte(`{"uuid":"dev1","mac":"aa","firmware":"1.0","hardware":"A"}`))
+	s.HandleHandshakeConnection(conn)
+
+	buffer.mu.Lock()
+	uuid, alias := buffer.uuid, buffer.alias
+	buffer.mu.Unlock()
+	if uuid != "dev1" || alias != "DUT-3" {
+		t.Errorf("Buffer UUID %q alias %q, want dev1 and DUT-3", uuid, alias)
+	}
+}
+
+// sendHandshake handles a handshake connection from 127.0.0.1 sending message
+func sendHandshake(t *testing.T, s *Server, message string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	client.Write([]byte(message))
+	s.HandleHandshakeConnection(conn)
+}
+
+// TestHandshakeSharedIP tests that the handshake of a second device behind the IP of an
+// active device does not take over the data buffers of the first
+func TestHandshakeSharedIP(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.DuplicateConnections = config.DUPLICATE_PARALLEL
+	s := NewServer(cfg)
+	s.registry = NewDeviceRegistry(filepath.Join(t.TempDir(), DEVICE_REGISTRY_FILE))
+
+	// dev1 sends data on port 5556, dev2 a parallel connection on the same port
+	shared := BufferKey{IP: "127.0.0.1", Port: 5556}
+	parallel := BufferKey{IP: "127.0.0.1", Port: 5556, UUID: "dev2"}
+	unclaimed := BufferKey{IP: "127.0.0.1", Port: 5555}
+	s.buffers[shared] = NewDataBuffer(5556, "127.0.0.1", 10, "", 1024)
+	s.buffers[parallel] = NewDataBuffer(5556, "127.0.0.1", 10, "", 1024)
+	s.buffers[unclaimed] = NewDataBuffer(5555, "127.0.0.1", 10, "", 1024)
+	sendHandshake(t, s, `{"uuid":"dev1","mac":"aa","firmware":"1.0","hardware":"A"}`)
+	conn1, conn2 := net.Pipe()
+	defer conn1.Close()
+	defer conn2.Close()
+	s.activeConns[shared] = conn1
+	s.activeConns[parallel] = conn2
+
+	uuid := func(key BufferKey) string {
+		s.buffersLock.RLock()
+		defer s.buffersLock.RUnlock()
+		return s.buffers[key].uuid
+	}
+	if got := uuid(unclaimed); got != "dev1" {
+		t.Errorf("Untagged buffer of the only device = %q, want dev1", got)
+	}
+
+	// Untagged buffers are ambiguous while dev1 is connected
+	s.buffers[unclaimed].uuid = ""
+	sendHandshake(t, s, `{"uuid":"dev2","mac":"bb","firmware":"1.0","hardware":"A"}`)
+	if got := uuid(shared); got != "dev1" {
+		t.Errorf("Buffer of active dev1 = %q after dev2's handshake, want dev1", got)
+	}
+	if got := uuid(unclaimed); got != "" {
+		t.Errorf("Untagged buffer = %q with two devices, want unclaimed", got)
+	}
+	if got := uuid(parallel); got != "dev2" {
+		t.Errorf("Parallel buffer = %q, want dev2", got)
+	}
+
+	// Once dev1 is gone, its IP may be a new device
+	s.unregisterConnection(shared)
+	sendHandshake(t, s, `{"uuid":"dev2","mac":"bb","firmware":"1.0","hardware":"A"}`)
+	if got := uuid(shared); got != "dev2" {
+		t.Errorf("Buffer of disconnected dev1 = %q after dev2's handshake, want dev2", got)
+	}
+}
+
+// TestHandshakeThermocoupleType tests that the thermocouple type a device reports selects
+// the linearization of its open and later thermocouple connections, unless configured
+func TestHandshakeThermocoupleType(t *testing.T) {
+	defer selectDecoders(nil)
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	s.registry = NewDeviceRegistry(filepath.Join(t.TempDir(), DEVICE_REGISTRY_FILE))
+
+	buffer := NewDataBuffer(5557, "127.0.0.1", 10, "", 1024)
+	s.buffers[BufferKey{IP: "127.0.0.1", Port: 5557}] = buffer
+
+	sendHandshake(t, s, `{"schemaVersion":2,"uuid":"dev1","mac":"aa",`+
+		`"channels":[{"port":5557,"index":1,"name":"thermocouple","thermocouple":"J"}]}`)
+
+	table := func(decoder ChannelDecoder) *thermocoupleTable {
+		return decoder.(thermocoupleDecoder).table.Load()
+	}
+	if got := table(buffer.samples.decoder); got != &thermocoupleJ {
+		t.Error("Open thermocouple connection not switched to J")
+	}
+	if got := s.deviceThermocouple("dev1", 5557); got != "J" {
+		t.Errorf("deviceThermocouple() = %q, want J", got)
+	}
+
+	// J at 100 °C with the cold junction at 0 °C
+	measured := thermocoupleJ.TemperatureToVoltage(100)
+	if tempC, ok := thermocoupleSample(table(buffer.samples.decoder), measured, 0, nil); !ok || math.Abs(tempC-100) > 0.1 {
+		t.Errorf("Thermocouple sample = %.3f °C, want 100 °C", tempC)
+	}
+
+	// A configured type takes precedence
+	if err := selectDecoders(map[int]config.ChannelConfig{5557: {ThermocoupleType: "T"}}); err != nil {
+		t.Fatal(err)
+	}
+	decoder := decoderForPort(5557)
+	if selectThermocoupleType(decoder, "J") || table(decoder) != &thermocoupleT {
+		t.Error("Handshake type replaced the configured type")
+	}
+}
+
+// TestReadHandshakeSplit tests that a handshake split across writes is read whole and
+// that a connection closed mid-handshake is an error
+func TestReadHandshakeSplit(t *testing.T) {
+	client, conn := net.Pipe()
+	go func() {
+		client.Write([]byte(`{"uuid":"dev1",`))
+		time.Sleep(10 * time.Millisecond)
+		client.Write([]byte(`"mac":"aa"}` + "\n"))
+	}()
+	message, err := newDeviceMessages(conn).readHandshake()
+	if err != nil || string(message) != `{"uuid":"dev1","mac":"aa"}` {
+		t.Errorf("readHandshake() = %s, %v", message, err)
+	}
+
+	client, conn = net.Pipe()
+	go func() {
+		client.Write([]byte(`{"uuid":`))
+		client.Close()
+	}()
+	if message, err := newDeviceMessages(conn).readHandshake(); err == nil {
+		t.Errorf("readHandshake() = %s, want error for a truncated handshake", message)
+	}
+}
+
+// TestDeviceStatus tests that status messages following a version 3 handshake are merged
+// into the connection and that only changes are sent to the frontend
+func TestDeviceStatus(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	state := t.TempDir()
+	s.registry = NewDeviceRegistry(filepath.Join(state, DEVICE_REGISTRY_FILE))
+	s.calibrations = NewCalibrationStore(filepath.Join(state, CALIBRATION_FILE))
+	var events []DeviceStatusChange
+	s.SetEventEmitter(func(name string, data ...interface{}) {
+		if name == DEVICE_STATUS_EVENT {
+			events = append(events, data[0].(DeviceStatusChange))
+		}
+	})
+
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.HandleHandshakeConnection(conn)
+		close(done)
+	}()
+	client.Write([]byte(`{"schemaVersion":3,"uuid":"dev1","mac":"aa","statusInterval":1}` + "\n"))
+	client.Write([]byte(`{"uptime":10,"temperature":41.5,"errorFlags":0,"bufferFill":0.25}` + "\n"))
+	client.Write([]byte(`{"uptime":11}` + "\n"))
+	client.Write([]byte(`{"uptime":12,"errorFlags":4}` + "\n"))
+	client.Close()
+	<-done
+
+	status, err := s.GetDeviceStatus("dev1")
+	if err != nil || status.Uptime != 12 || status.Temperature != 41.5 || status.ErrorFlags != 4 || status.BufferFill != 0.25 {
+		t.Errorf("GetDeviceStatus() = %+v, %v", status, err)
+	}
+	if connection, _ := s.GetIPConnectionData("unknown"); connection.Status == nil {
+		t.Error("Connection status not set")
+	}
+	if len(events) != 2 || events[1].Status.ErrorFlags != 4 {
+		t.Errorf("Status events = %+v, want the first status and the error", events)
+	}
+	if _, err := s.GetDeviceStatus("dev2"); err == nil {
+		t.Error("Expected error for a device without status, got nil")
+	}
+}
+
+// TestDeviceLatency tests that pings on the handshake connection are matched to the
+// device's replies and unanswered ones count as lost
+func TestDeviceLatency(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.PingInterval = 10
+	s := NewServer(cfg)
+	state := t.TempDir()
+	s.registry = NewDeviceRegistry(filepath.Join(state, DEVICE_REGISTRY_FILE))
+	s.calibrations = NewCalibrationStore(filepath.Join(state, CALIBRATION_FILE))
+
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.HandleHandshakeConnection(conn)
+		close(done)
+	}()
+	client.Write([]byte(`{"schemaVersion":3,"uuid":"dev1","mac":"aa","statusInterval":1}` + "\n"))
+
+	// Answer the first three pings and leave the fourth unanswered
+	decoder := json.NewDecoder(client)
+	for i := range 5 {
+		var ping struct {
+			Ping uint64 `json:"ping"`
+		}
+		if err := decoder.Decode(&ping); err != nil {
+			t.Fatal(err)
+		}
+		if ping.Ping != uint64(i+1) {
+			t.Fatalf("ping id = %d, want %d", ping.Ping, i+1)
+		}
+		if i < 3 {
+			client.Write([]byte(fmt.Sprintf(`{"pong":%d}`+"\n", ping.Ping)))
+		}
+	}
+	client.Close()
+	<-done
+
+	stats, err := s.GetDeviceLatency("dev1")
+	if err != nil || stats.Sent != 5 || stats.Received != 3 || stats.Lost != 1 {
+		t.Fatalf("GetDeviceLatency() = %+v, %v, want 5 sent, 3 received and 1 lost", stats, err)
+	}
+	if stats.Min <= 0 || stats.Min > stats.Mean || stats.Mean > stats.Max || stats.Last <= 0 {
+		t.Errorf("Round trip times = %+v", stats)
+	}
+	if _, err := s.GetDeviceStatus("dev1"); err == nil {
+		t.Error("Replies to pings were taken as status messages")
+	}
+}
+
+// TestConnectionEvents tests that the frontend is told when a device opens and closes data
+// ports and completes its handshake
+func TestConnectionEvents(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	state := t.TempDir()
+	s.registry = NewDeviceRegistry(filepath.Join(state, DEVICE_REGISTRY_FILE))
+	s.calibrations = NewCalibrationStore(filepath.Join(state, CALIBRATION_FILE))
+	var changes []DeviceConnectionChange
+	s.SetEventEmitter(func(name string, data ...interface{}) {
+		if name == DEVICE_CONNECTION_EVENT {
+			changes = append(changes, data[0].(DeviceConnectionChange))
+		}
+	})
+
+	// net.Pipe connections come from the IP "unknown"
+	s.AddIPConnection("unknown", 5556, "")
+	s.AddIPConnection("unknown", 5556, "")
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.HandleHandshakeConnection(conn)
+		close(done)
+	}()
+	client.Write([]byte(`{"schemaVersion":1,"uuid":"dev1","mac":"aa"}` + "\n"))
+	client.Close()
+	<-done
+	s.AddIPConnection("unknown", 5555, "dev1")
+	s.RemoveIPPort("unknown", 5556)
+	s.RemoveIPPort("unknown", 5555)
+
+	want := []DeviceConnectionChange{
+		{IP: "unknown", Port: 5556, Change: CONNECTION_PORT_OPENED},
+		{IP: "unknown", UUID: "dev1", Change: CONNECTION_HANDSHAKE},
+		{IP: "unknown", UUID: "dev1", Port: 5555, Change: CONNECTION_PORT_OPENED},
+		{IP: "unknown", UUID: "dev1", Port: 5556, Change: CONNECTION_PORT_CLOSED},
+		{IP: "unknown", UUID: "dev1", Port: 5555, Change: CONNECTION_PORT_CLOSED},
+		{IP: "unknown", UUID: "dev1", Change: CONNECTION_DISCONNECTED},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Changes = %+v, want %d", changes, len(want))
+	}
+	for i, change := range changes {
+		if change.Time.IsZero() {
+			t.Errorf("Change %d has no time", i)
+		}
+		change.Time = time.Time{}
+		if change != want[i] {
+			t.Errorf("Change %d = %+v, want %+v", i, change, want[i])
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"eth-daq-software/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	DEVICE_HEALTH_EVENT   = "device-health"    // Event carrying the DeviceHealth of a device that changed state
+	AVAILABILITY_LOG      = "availability.log" // State changes of every device, in the log directory
+	HEALTH_CHECK_INTERVAL = time.Second
+)
+
+// Device health states
+const (
+	HEALTH_ONLINE   = "online"   // Data or log lines received recently
+	HEALTH_DEGRADED = "degraded" // Silent for longer than DegradedAfter
+	HEALTH_OFFLINE  = "offline"  // Silent for longer than OfflineAfter
+)
+
+// DeviceHealth is the availability of a device, keyed by its sanitized IP
+type DeviceHealth struct {
+	IP       string
+	UUID     string
+	Alias    string
+	State    string
+	Since    time.Time // Time of the last state change
+	LastData time.Time // Time data was last received on any port, zero if never
+	LastLog  time.Time // Time a log line was last received, zero if never
+}
+
+// healthState returns the state of a device that was last heard from at lastSeen
+func healthState(now time.Time, lastSeen time.Time, degradedAfter time.Duration, offlineAfter time.Duration) string {
+	silence := now.Sub(lastSeen)
+	switch {
+	case silence >= offlineAfter:
+		return HEALTH_OFFLINE
+	case silence >= degradedAfter:
+		return HEALTH_DEGRADED
+	default:
+		return HEALTH_ONLINE
+	}
+}
+
+// checkHealth updates the receive times of every device from its buffers and reports
+// devices whose state changed
+func (s *Server) checkHealth(now time.Time) {
+	cfg := s.settings()
+	degradedAfter := time.Duration(cfg.DegradedAfter) * time.Second
+	offlineAfter := time.Duration(cfg.OfflineAfter) * time.Second
+
+	lastData := make(map[string]time.Time)
+	s.buffersLock.RLock()
+	for key, buffer := range s.buffers {
+		ip := SanitizeFilename(key.IP)
+		buffer.mu.Lock()
+		if buffer.lastData.After(lastData[ip]) {
+			lastData[ip] = buffer.lastData
+		}
+		buffer.mu.Unlock()
+	}
+	s.buffersLock.RUnlock()
+
+	lastLog := make(map[string]time.Time)
+	s.logBuffersLock.RLock()
+	for ip, buffer := range s.logBuffers {
+		buffer.mu.Lock()
+		lastLog[ip] = buffer.lastReceived
+		buffer.mu.Unlock()
+	}
+	s.logBuffersLock.RUnlock()
+
+	connected := s.GetAllConnectedIPs()
+	var changed []DeviceHealth
+
+	s.healthLock.Lock()
+	for ip, last := range lastData {
+		s.trackHealth(ip).LastData = last
+	}
+	for ip, last := range lastLog {
+		if !last.IsZero() {
+			s.trackHealth(ip).LastLog = last
+		}
+	}
+	for ip, health := range s.health {
+		if conn, exists := connected[ip]; exists && conn.UUID != "" {
+			health.UUID = conn.UUID
+			health.Alias = conn.Alias
+		}
+		lastSeen := health.LastData
+		if health.LastLog.After(lastSeen) {
+			lastSeen = health.LastLog
+		}
+		state := healthState(now, lastSeen, degradedAfter, offlineAfter)
+		if state != health.State {
+			health.State = state
+			health.Since = now
+			changed = append(changed, *health)
+		}
+	}
+	s.healthLock.Unlock()
+
+	for _, health := range changed {
+		s.reportHealth(health)
+	}
+}
+
+// trackHealth returns the health record of a device, creating it. The caller must hold healthLock.
+func (s *Server) trackHealth(ip string) *DeviceHealth {
+	health, exists := s.health[ip]
+	if !exists {
+		health = &DeviceHealth{IP: ip}
+		s.health[ip] = health
+	}
+	return health
+}
+
+// reportHealth logs a state change, appends it to the availability log and sends it to the frontend
+func (s *Server) reportHealth(health DeviceHealth) {
+	fields := logger.Fields{
+		"ip":        health.IP,
+		"uuid":      health.UUID,
+		"alias":     health.Alias,
+		"state":     health.State,
+		"last_data": formatHealthTime(health.LastData),
+		"last_log":  formatHealthTime(health.LastLog),
+	}
+	if health.State == HEALTH_ONLINE {
+		logger.InfoFields("Device online", fields)
+	} else {
+		logger.ErrorFields("Device "+health.State, fields)
+	}
+
+	line := fmt.Sprintf("%s %s ip=%s uuid=%s alias=%q last_data=%s last_log=%s\n",
+		health.Since.Format(time.RFC3339Nano), health.State, health.IP, health.UUID, health.Alias,
+		formatHealthTime(health.LastData), formatHealthTime(health.LastLog))
+	if err := appendAvailability(filepath.Join(s.settings().LogDir, AVAILABILITY_LOG), line); err != nil {
+		logger.Errorf("Failed to write availability log: %v\n", err)
+	}
+
+	if health.State != HEALTH_ONLINE && health.UUID != "" {
+		s.addMarker(Marker{Time: health.Since, Label: "device " + health.State, Source: MARKER_ALARM, UUID: health.UUID})
+	}
+	s.emitEvent(DEVICE_HEALTH_EVENT, health)
+	if health.State == HEALTH_OFFLINE {
+		s.notifyConnection(DeviceConnectionChange{IP: health.IP, UUID: health.UUID, Alias: health.Alias, Change: CONNECTION_OFFLINE})
+	}
+}
+
+// formatHealthTime formats a receive time for the availability log, "-" if never received
+func formatHealthTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// appendAvailability appends a line to the availability log
+func appendAvailability(path string, line string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(line)
+	return err
+}
+
+// StartHealthMonitor checks device health every HEALTH_CHECK_INTERVAL until the server is stopped
+func (s *Server) StartHealthMonitor() {
+	go func() {
+		ticker := time.NewTicker(HEALTH_CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.checkHealth(now)
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// GetDeviceHealth returns the health of every device seen since startup, by sanitized IP
+func (s *Server) GetDeviceHealth() map[string]DeviceHealth {
+	s.healthLock.RLock()
+	defer s.healthLock.RUnlock()
+	result := make(map[string]DeviceHealth, len(s.health))
+	for ip, health := range s.health {
+		result[ip] = *health
+	}
+	return result
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCheckHealth tests the degraded and offline transitions and the availability log
+func TestCheckHealth(t *testing.T) {
+	cfg := config.Default()
+	cfg.LogDir = t.TempDir()
+	s := NewServer(cfg)
+
+	now := time.Now()
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+	buffer.lastData = now
+	s.buffers[BufferKey{IP: "10.0.0.2", Port: 5556}] = buffer
+
+	steps := []struct {
+		at    time.Duration
+		state string
+	}{
+		{0, HEALTH_ONLINE},
+		{4 * time.Second, HEALTH_ONLINE},
+		{6 * time.Second, HEALTH_DEGRADED},
+		{31 * time.Second, HEALTH_OFFLINE},
+	}
+	for _, step := range steps {
+		s.checkHealth(now.Add(step.at))
+		if health := s.GetDeviceHealth()["10_0_0_2"]; health.State != step.state {
+			t.Errorf("After %v: state = %q, want %q", step.at, health.State, step.state)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(cfg.LogDir, AVAILABILITY_LOG))
+	if err != nil {
+		t.Fatalf("Failed to read availability log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], " offline ip=10_0_0_2") {
+		t.Errorf("Availability log = %q, want online, degraded and offline entries", lines)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"math"
+)
+
+// Histogram is a value histogram of a channel
+type Histogram struct {
+	From      float64 // Lower edge of the first bin
+	To        float64 // Upper edge of the last bin
+	BinWidth  float64
+	Counts    []int
+	Underflow int // Samples below From
+	Overflow  int // Samples above To
+	Total     int // Total number of samples, including under- and overflow
+}
+
+// NewHistogram creates an empty histogram with bins equal-width bins between from and to
+func NewHistogram(bins int, from float64, to float64) (*Histogram, error) {
+	if bins <= 0 {
+		return nil, fmt.Errorf("number of bins must be positive")
+	}
+	if !(to > from) {
+		return nil, fmt.Errorf("histogram range [%v, %v] is empty", from, to)
+	}
+	return &Histogram{
+		From:     from,
+		To:       to,
+		BinWidth: (to - from) / float64(bins),
+		Counts:   make([]int, bins),
+	}, nil
+}
+
+// Add counts one sample, NaN samples are ignored
+func (h *Histogram) Add(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	h.Total++
+	switch {
+	case value < h.From:
+		h.Underflow++
+	case value > h.To:
+		h.Overflow++
+	default:
+		bin := int((value - h.From) / h.BinWidth)
+		if bin >= len(h.Counts) {
+			bin = len(h.Counts) - 1 // value == To
+		}
+		h.Counts[bin]++
+	}
+}
+
+// histogramRange returns from and to unchanged if they describe a range, otherwise the
+// range of the samples
+func histogramRange(samples []float64, from float64, to float64) (float64, float64) {
+	if to > from {
+		return from, to
+	}
+	from, to = math.Inf(1), math.Inf(-1)
+	for _, sample := range samples {
+		if !math.IsNaN(sample) {
+			from = math.Min(from, sample)
+			to = math.Max(to, sample)
+		}
+	}
+	if math.IsInf(from, 1) {
+		return 0, 1
+	}
+	if from == to {
+		return from - 0.5, to + 0.5
+	}
+	return from, to
+}
+
+// GetHistogram computes a value histogram over the live sample history of a channel.
+// If to is not greater than from, the range of the samples is used.
+func (s *Server) GetHistogram(key BufferKey, bins int, from float64, to float64) (*Histogram, error) {
+	s.buffersLock.RLock()
+	buffer, exists := s.buffers[key]
+	s.buffersLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("no active channel for %s:%d", key.IP, key.Port)
+	}
+
+	buffer.statsMu.Lock()
+	samples := buffer.history.Last(buffer.history.Available())
+	buffer.statsMu.Unlock()
+
+	return sampleHistogram(samples, bins, from, to)
+}
+
+// sampleHistogram computes a value histogram of samples. If to is not greater than from,
+// the range of the samples is used.
+func sampleHistogram(samples []float64, bins int, from float64, to float64) (*Histogram, error) {
+	from, to = histogramRange(samples, from, to)
+	histogram, err := NewHistogram(bins, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, sample := range samples {
+		histogram.Add(sample)
+	}
+	return histogram, nil
+}
+
+// GetFileHistogram computes a value histogram over recorded data files of one channel.
+// For the thermocouple port the histogram is of the thermocouple temperature. Unlike
+// GetHistogram a range must be given, since the files are only read once.
+func (s *Server) GetFileHistogram(paths []string, bins int, from float64, to float64) (*Histogram, error) {
+	histogram, err := NewHistogram(bins, from, to)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := parseSegments(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	uuid := segments[0].UUID
+	port := segments[0].Port
+	decoder := newSampleDecoder(port,
+		s.calibrations.Get(CalibrationKey{UUID: uuid, Port: port, Channel: 0}),
+		s.calibrations.Get(CalibrationKey{UUID: uuid, Port: port, Channel: 1}))
+	selectThermocoupleType(decoder.samples.decoder, s.deviceThermocouple(uuid, port))
+	for _, segment := range segments {
+		data, err := ReadSegment(segment.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", segment.Path, err)
+		}
+		err = decoder.decode(data, func(values []float64) error {
+			histogram.Add(values[len(values)-1])
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", segment.Path, err)
+		}
+	}
+	return histogram, nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestHistogramBins tests bin edges, under- and overflow and ignored NaN samples
+func TestHistogramBins(t *testing.T) {
+	if _, err := NewHistogram(0, 0, 1); err == nil {
+		t.Error("Expected error for zero bins, got nil")
+	}
+	if _, err := NewHistogram(4, 1, 1); err == nil {
+		t.Error("Expected error for an empty range, got nil")
+	}
+
+	histogram, err := NewHistogram(4, 0, 4)
+	if err != nil {
+		t.Fatalf("NewHistogram() = %v", err)
+	}
+	for _, value := range []float64{-0.1, 0, 0.999, 1, 2.5, 4, 4.1, math.NaN()} {
+		histogram.Add(value)
+	}
+	// The lower edge belongs to a bin, the upper edge of the range to the last bin
+	if !reflect.DeepEqual(histogram.Counts, []int{2, 1, 1, 1}) {
+		t.Errorf("Counts = %v, want [2 1 1 1]", histogram.Counts)
+	}
+	if histogram.Underflow != 1 || histogram.Overflow != 1 || histogram.Total != 7 {
+		t.Errorf("Underflow %d overflow %d total %d, want 1, 1, 7",
+			histogram.Underflow, histogram.Overflow, histogram.Total)
+	}
+}
+
+// TestFileHistogram tests histograms of recorded segments
+func TestFileHistogram(t *testing.T) {
+	s := NewServer(config.Default())
+	s.calibrations = NewCalibrationStore(filepath.Join(t.TempDir(), CALIBRATION_FILE))
+	dir := t.TempDir()
+
+	// 0 V twice and 1 V once over two segments
+	paths := []string{
+		writeSegment(t, dir, 5556, 1, []uint16{32768, 32768}),
+		writeSegment(t, dir, 5556, 2, []uint16{32768 + 3200}),
+	}
+	histogram, err := s.GetFileHistogram(paths, 2, -0.5, 1.5)
+	if err != nil {
+		t.Fatalf("GetFileHistogram() = %v", err)
+	}
+	if !reflect.DeepEqual(histogram.Counts, []int{2, 1}) || histogram.Total != 3 {
+		t.Errorf("Histogram = %+v, want counts [2 1]", histogram)
+	}
+
+	// A truncated compressed segment is reported with its path
+	codec, _ := compress.CodecByName("zstd")
+	compressed, err := compress.CompressIndexed(codec, make([]byte, 4096), INDEX_BLOCK_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := filepath.Join(dir, "port5556_10_0_0_2_dev1_3.zst")
+	os.WriteFile(corrupt, compressed[:len(compressed)/2], 0644)
+	_, err = s.GetFileHistogram(append(paths, corrupt), 2, -0.5, 1.5)
+	if err == nil || !strings.Contains(err.Error(), corrupt) {
+		t.Errorf("GetFileHistogram() with a truncated segment = %v, want error naming it", err)
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HistoryData is the recorded data of a device port over a time range, reduced to a
+// min/max envelope for plotting
+type HistoryData struct {
+	UUID     string
+	Port     int
+	From     time.Time
+	To       time.Time
+	Channels []string      // Channel names, see ChannelNames
+	Units    []string      // Unit of each channel, see ChannelUnits
+	Points   [][]PlotPoint // Points per channel, X in seconds since From
+	Samples  int           // Samples in the range before downsampling
+	Segments int           // Segments read
+}
+
+// historySegment is a segment with the time span of its samples
+type historySegment struct {
+	SegmentInfo
+	end   time.Time // Time of the last sample
+	start time.Time // Time of the first sample, zero if it has to be estimated from the sample rate
+}
+
+// findSegments returns the segments of a device port in the data directory and its
+// session directories, in capture order
+func findSegments(dataDir string, uuid string, port int) ([]SegmentInfo, error) {
+	dirs := []string{dataDir}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != ARCHIVE_DIR {
+			dirs = append(dirs, filepath.Join(dataDir, entry.Name()))
+		}
+	}
+
+	var segments []SegmentInfo
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", dir, err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasPrefix(name, "port") || !isSegmentFile(name) {
+				continue
+			}
+			segment, err := ParseSegmentName(filepath.Join(dir, name))
+			if err == nil && segment.UUID == uuid && segment.Port == port {
+				segments = append(segments, segment)
+			}
+		}
+	}
+	sortSegments(segments)
+	return segments, nil
+}
+
+// segmentSpan returns the time span of a segment's samples. Uncompressed segments are
+// named by the time they were opened and last written at their modification time.
+// Compressed segments are named by their flush time, their start is not recorded. Whether
+// a segment is compressed is read from its header, as old versions wrote RLE4 segments
+// named .bin.
+func segmentSpan(segment SegmentInfo) (historySegment, error) {
+	span := historySegment{SegmentInfo: segment, end: time.Unix(0, segment.Timestamp)}
+	compressed, err := compress.IsCompressedFile(segment.Path)
+	if err != nil {
+		return historySegment{}, err
+	}
+	if !compressed {
+		info, err := os.Stat(segment.Path)
+		if err != nil {
+			return historySegment{}, err
+		}
+		span.start = span.end
+		span.end = info.ModTime()
+	}
+	return span, nil
+}
+
+// historyReader decodes consecutive segments of a channel and places their samples in time.
+// The samples of a segment are spread evenly over its span. The span of a compressed
+// segment starts where the previous segment ended, unless that would stretch it over a
+// gap in the recording at the sample rate measured on the previous segments.
+type historyReader struct {
+	decoder  *sampleDecoder
+	from     time.Time
+	envelope []*envelope
+	samples  int
+	rate     float64   // Samples per second of the last segment with a known span
+	lastEnd  time.Time // End of the previous segment
+	pending  []decodedSegment
+}
+
+// decodedSegment is a segment waiting for a sample rate to place it in time
+type decodedSegment struct {
+	end    time.Time
+	values [][]float64
+}
+
+// add decodes a segment and adds its samples to the envelopes
+func (hr *historyReader) add(segment historySegment) error {
+	data, err := ReadSegment(segment.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", segment.Path, err)
+	}
+	values := make([][]float64, len(hr.envelope))
+	err = hr.decoder.decode(data, func(sample []float64) error {
+		for i, value := range sample {
+			values[i] = append(values[i], value)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", segment.Path, err)
+	}
+	n := len(values[0])
+
+	start := segment.start
+	if start.IsZero() {
+		switch {
+		case hr.rate > 0 && segment.end.Add(-seconds(float64(n)/hr.rate)).After(hr.lastEnd):
+			start = segment.end.Add(-seconds(float64(n) / hr.rate))
+		case !hr.lastEnd.IsZero():
+			start = hr.lastEnd
+		default:
+			// Neither a previous segment nor a sample rate, wait for the next segment
+			hr.pending = append(hr.pending, decodedSegment{end: segment.end, values: values})
+			hr.lastEnd = segment.end
+			return nil
+		}
+	}
+	if n > 0 && segment.end.After(start) {
+		hr.rate = float64(n) / segment.end.Sub(start).Seconds()
+		hr.flushPending()
+	}
+	hr.place(start, segment.end, values)
+	hr.lastEnd = segment.end
+	return nil
+}
+
+// flushPending places the segments that waited for a sample rate, or at their end if
+// there is none
+func (hr *historyReader) flushPending() {
+	for _, segment := range hr.pending {
+		start := segment.end
+		if hr.rate > 0 {
+			start = segment.end.Add(-seconds(float64(len(segment.values[0])) / hr.rate))
+		}
+		hr.place(start, segment.end, segment.values)
+	}
+	hr.pending = nil
+}
+
+// place spreads the values of a segment evenly over [start, end]
+func (hr *historyReader) place(start time.Time, end time.Time, values [][]float64) {
+	n := len(values[0])
+	step := end.Sub(start).Seconds() / float64(max(n, 1))
+	offset := start.Sub(hr.from).Seconds()
+	for i := 0; i < n; i++ {
+		x := offset + float64(i+1)*step
+		for channel, env := range hr.envelope {
+			env.add(x, values[channel][i])
+		}
+		if x >= 0 && x <= hr.envelope[0].to {
+			hr.samples++
+		}
+	}
+}
+
+// seconds converts seconds to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// QueryHistory reads the recorded data of a device port between from and to and reduces
+// it to at most maxPoints points per channel, keeping spikes. Sample times are estimated
+// from the segment names and modification times, see historyReader.
+func (s *Server) QueryHistory(uuid string, port int, from time.Time, to time.Time, maxPoints int) (HistoryData, error) {
+	if maxPoints <= 0 {
+		return HistoryData{}, fmt.Errorf("number of points must be positive")
+	}
+	if !to.After(from) {
+		return HistoryData{}, fmt.Errorf("time range is empty")
+	}
+	segments, err := findSegments(s.settings().DataDir, uuid, port)
+	if err != nil {
+		return HistoryData{}, err
+	}
+
+	channels := ChannelNames(port)
+	reader := &historyReader{
+		decoder: newSampleDecoder(port,
+			s.calibrations.Get(CalibrationKey{UUID: uuid, Port: port, Channel: 0}),
+			s.calibrations.Get(CalibrationKey{UUID: uuid, Port: port, Channel: 1})),
+		from:     from,
+		envelope: make([]*envelope, len(channels)),
+	}
+	selectThermocoupleType(reader.decoder.samples.decoder, s.deviceThermocouple(uuid, port))
+	for i := range channels {
+		reader.envelope[i] = newEnvelope(0, to.Sub(from).Seconds(), maxPoints)
+	}
+
+	read := 0
+	for _, segment := range segments {
+		span, err := segmentSpan(segment)
+		if err != nil {
+			return HistoryData{}, fmt.Errorf("failed to read %s: %v", segment.Path, err)
+		}
+		// Segments ending before the range only matter as the start of the next one
+		if span.end.Before(from) {
+			reader.lastEnd = span.end
+			continue
+		}
+		if !span.start.IsZero() && span.start.After(to) || !reader.lastEnd.IsZero() && reader.lastEnd.After(to) {
+			break
+		}
+		if err := reader.add(span); err != nil {
+			return HistoryData{}, err
+		}
+		read++
+	}
+	reader.flushPending()
+
+	history := HistoryData{
+		UUID:     uuid,
+		Port:     port,
+		From:     from,
+		To:       to,
+		Channels: channels,
+		Units:    ChannelUnits(port),
+		Points:   make([][]PlotPoint, len(channels)),
+		Samples:  reader.samples,
+		Segments: read,
+	}
+	for i, env := range reader.envelope {
+		history.Points[i] = env.points()
+	}
+	return history, nil
+}
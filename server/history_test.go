@@ -0,0 +1,134 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestQueryHistory tests that samples of uncompressed and compressed segments are placed in
+// time and reduced to an envelope that keeps a spike
+func TestQueryHistory(t *testing.T) {
+	s := newDerivedTestServer(t, "vds", nil, nil)
+	dir := s.settings().DataDir
+	start := time.Unix(1700000000, 0)
+
+	// Two uncompressed segments of one second each at 4 samples per second, named by their
+	// open time and last written at their modification time
+	for i, raw := range [][]uint16{
+		{32768, 32768, 32768, 32768},
+		{32768, 32768 + 3200, 32768, 32768},
+	} {
+		open := start.Add(time.Duration(i) * time.Second)
+		path := writeSegment(t, dir, 5556, open.UnixNano(), raw)
+		os.Chtimes(path, open.Add(time.Second), open.Add(time.Second))
+	}
+
+	// A compressed segment named by its flush time, with a spike
+	codec, err := compress.CodecByName("lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := writeSegment(t, t.TempDir(), 5556, 0, []uint16{32768, 32768, 32768 + 16000, 32768})
+	data, _ := os.ReadFile(raw)
+	compressed, err := compress.Compress(codec, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "port5556_10_0_0_2_dev1_" + strconv.FormatInt(start.Add(3*time.Second).UnixNano(), 10) + ".lz4"
+	if err := os.WriteFile(filepath.Join(dir, name), compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := s.QueryHistory("dev1", 5556, start, start.Add(3*time.Second), 6)
+	if err != nil {
+		t.Fatalf("QueryHistory() = %v", err)
+	}
+	if history.Samples != 12 || history.Segments != 3 || len(history.Points[0]) > 6 {
+		t.Fatalf("QueryHistory() = %d samples from %d segments in %d points, want 12 from 3 in at most 6",
+			history.Samples, history.Segments, len(history.Points[0]))
+	}
+	spikes := 0
+	for _, point := range history.Points[0] {
+		switch {
+		case point.Y > 4:
+			spikes++
+			if point.X <= 2 || point.X > 3 {
+				t.Errorf("Spike of the compressed segment at %v s, want in the third second", point.X)
+			}
+		case point.Y > 0.5:
+			spikes++
+			if point.X <= 1 || point.X > 2 {
+				t.Errorf("Spike of the second segment at %v s, want in the second second", point.X)
+			}
+		}
+	}
+	if spikes != 2 {
+		t.Errorf("QueryHistory() points = %v, want both spikes", history.Points[0])
+	}
+
+	history, err = s.QueryHistory("dev1", 5556, start.Add(10*time.Second), start.Add(20*time.Second), 6)
+	if err != nil || history.Samples != 0 {
+		t.Errorf("QueryHistory() after the recording = %d samples, %v, want none", history.Samples, err)
+	}
+}
+
+// extensionCodec is a codec with a file extension unknown to the server, standing in for
+// codecs added later
+type extensionCodec struct{}
+
+func (extensionCodec) ID() byte                               { return 0x70 }
+func (extensionCodec) Name() string                           { return "test-extension" }
+func (extensionCodec) Extension() string                      { return ".tst" }
+func (extensionCodec) Compress(data []byte) ([]byte, error)   { return data, nil }
+func (extensionCodec) Decompress(data []byte) ([]byte, error) { return data, nil }
+
+// TestQueryHistoryMixedSegments tests that raw segments, RLE4 segments of old versions
+// named .bin and segments of a registered codec with its own extension are read together
+func TestQueryHistoryMixedSegments(t *testing.T) {
+	compress.RegisterCodec(extensionCodec{})
+	s := newDerivedTestServer(t, "vds", nil, nil)
+	dir := s.settings().DataDir
+	start := time.Unix(1700000000, 0)
+
+	path := writeSegment(t, dir, 5556, start.UnixNano(), []uint16{32768, 32768, 32768, 32768})
+	os.Chtimes(path, start.Add(time.Second), start.Add(time.Second))
+
+	// Flushed at 2 s by an old version, RLE4 in a .bin file
+	path = writeSegment(t, dir, 5556, start.Add(2*time.Second).UnixNano(), []uint16{32768, 32768 + 3200, 32768, 32768})
+	data, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, compress.HybridRLECompress(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flushed at 3 s with the new codec
+	raw := writeSegment(t, t.TempDir(), 5556, 0, []uint16{32768, 32768, 32768 + 16000, 32768})
+	data, _ = os.ReadFile(raw)
+	compressed, err := compress.Compress(extensionCodec{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "port5556_10_0_0_2_dev1_" + strconv.FormatInt(start.Add(3*time.Second).UnixNano(), 10) + compress.SegmentExtension(extensionCodec{})
+	if err := os.WriteFile(filepath.Join(dir, name), compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := s.QueryHistory("dev1", 5556, start, start.Add(3*time.Second), 12)
+	if err != nil {
+		t.Fatalf("QueryHistory() = %v", err)
+	}
+	if history.Samples != 12 || history.Segments != 3 {
+		t.Fatalf("QueryHistory() = %d samples from %d segments, want 12 from 3", history.Samples, history.Segments)
+	}
+	for _, point := range history.Points[0] {
+		switch {
+		case point.Y > 4 && (point.X <= 2 || point.X > 3):
+			t.Errorf("Spike of the .tst segment at %v s, want in the third second", point.X)
+		case point.Y > 0.5 && point.Y <= 4 && (point.X <= 1 || point.X > 2):
+			t.Errorf("Spike of the old RLE4 segment at %v s, want in the second second", point.X)
+		}
+	}
+}
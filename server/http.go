@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"eth-daq-software/logger"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Status is the state of the server reported by the HTTP status API
+type Status struct {
+	Devices     map[string]IPConnection     // Connected devices by sanitized IP
+	Rates       map[string]float64          // Transfer rate in MB/s by "ip:port"
+	LogCounts   map[string]LogCounts        // Device log errors and warnings by sanitized IP
+	LogLoss     map[string]LogLossStats     // Lost and repeated device log datagrams by sanitized IP
+	Compression map[string]CompressionStats // Segment statistics by "ip:port"
+	Sequence    map[string]SequenceStats    // Missing, duplicated and reordered frames by "ip:port"
+	Misaligned  map[string]int64            // Connections that ended mid-sample by "ip:port"
+	Listeners   []ListenerStatus            // Ports listened on and bind failures
+	Incidents   []Incident                  // Panics and listener failures recovered from
+}
+
+// GetStatus returns the connected devices, their rates and log and segment statistics
+func (s *Server) GetStatus() Status {
+	return Status{
+		Devices:     s.GetAllConnectedIPs(),
+		Rates:       s.GetAllBufferRates(),
+		LogCounts:   s.GetAllLogCounts(),
+		LogLoss:     s.GetAllLogLoss(),
+		Compression: s.GetCompressionStats(),
+		Sequence:    s.GetSequenceStats(),
+		Misaligned:  s.GetAllMisalignments(),
+		Listeners:   s.GetListenerStatus(),
+		Incidents:   s.GetIncidents(),
+	}
+}
+
+// StatusHandler returns the HTTP/REST status API:
+//
+//	GET /api/status                   Status
+//	GET /api/devices                  connected devices
+//	GET /api/devices/{ip}             connection of one device by IP
+//	GET /api/channel?ip=...&port=...&uuid=...
+//	                                  rate and averages of a data channel
+//	GET /api/rates                    transfer rates
+//	GET /api/rates/history?key=ip:port&seconds=...
+//	                                  per-second transfer rates of a channel, oldest first
+//	GET /api/logs?ip=...&severity=... device log lines, newest first
+//	GET /api/settings                 runtime settings
+//	PUT /api/settings                 update the runtime settings
+//	GET /api/diagnostics              goroutines, heap, GC pauses and queue depths
+//	GET /debug/pprof/...              net/http/pprof, with features.pprof enabled
+func (s *Server) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.GetStatus())
+	})
+	mux.HandleFunc("GET /api/devices", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.GetAllConnectedIPs())
+	})
+	mux.HandleFunc("GET /api/devices/{ip}", func(w http.ResponseWriter, r *http.Request) {
+		connection, exists := s.GetIPConnectionData(r.PathValue("ip"))
+		if !exists {
+			http.Error(w, fmt.Sprintf("no device connected from %s", r.PathValue("ip")), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, connection)
+	})
+	mux.HandleFunc("GET /api/channel", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		port, err := strconv.Atoi(query.Get("port"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid port: %v", err), http.StatusBadRequest)
+			return
+		}
+		key := BufferKey{IP: query.Get("ip"), Port: port, UUID: query.Get("uuid")}
+		values, exists := s.GetChannelValues(key)
+		if !exists {
+			http.Error(w, fmt.Sprintf("no channel %s:%d", key.IP, key.Port), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, values)
+	})
+	mux.HandleFunc("GET /api/rates", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.GetAllBufferRates())
+	})
+	mux.HandleFunc("GET /api/rates/history", func(w http.ResponseWriter, r *http.Request) {
+		seconds := 0
+		if value := r.URL.Query().Get("seconds"); value != "" {
+			var err error
+			if seconds, err = strconv.Atoi(value); err != nil {
+				http.Error(w, fmt.Sprintf("invalid seconds: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, s.GetRateHistory(r.URL.Query().Get("key"), seconds))
+	})
+	mux.HandleFunc("GET /api/logs", func(w http.ResponseWriter, r *http.Request) {
+		var severities []string
+		if severity := r.URL.Query().Get("severity"); severity != "" {
+			severities = strings.Split(severity, ",")
+		}
+		writeJSON(w, s.GetFilteredLogs(r.URL.Query().Get("ip"), severities))
+	})
+	mux.HandleFunc("GET /api/settings", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.GetSettings())
+	})
+	mux.HandleFunc("PUT /api/settings", func(w http.ResponseWriter, r *http.Request) {
+		settings := s.GetSettings()
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, fmt.Sprintf("invalid settings: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.UpdateSettings(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, s.GetSettings())
+	})
+	mux.HandleFunc("GET /api/diagnostics", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.GetDiagnostics())
+	})
+	s.handlePprof(mux)
+	return mux
+}
+
+// writeJSON writes value as a JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Errorf("Failed to write HTTP response: %v\n", err)
+	}
+}
+
+// StartHTTP serves the status API on addr ("host:port" or ":port") until the server is stopped
+func (s *Server) StartHTTP(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP status API on %s: %v", addr, err)
+	}
+	logger.Infof("HTTP status API listening on %s\n", listener.Addr())
+
+	httpServer := &http.Server{Handler: s.StatusHandler()}
+	context.AfterFunc(s.ctx, func() { httpServer.Close() })
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("HTTP status API stopped: %v\n", err)
+		}
+	}()
+	return nil
+}
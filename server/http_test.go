@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/config"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestStatusHandler tests the status codes and responses of the HTTP status API
+func TestStatusHandler(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	s.SetConfigPath(filepath.Join(t.TempDir(), "config.yaml"))
+	s.connectedIPs["10_0_0_2"] = &IPConnection{ActivePorts: map[int]bool{5556: true}, UUID: "dev1"}
+	logs := NewLogBuffer("10_0_0_2", 10)
+	logs.add(LogEntry{Line: "[E] ADC overrun", Severity: SEVERITY_ERROR})
+	logs.add(LogEntry{Line: "[I] started", Severity: SEVERITY_INFO})
+	s.logBuffers["10_0_0_2"] = logs
+	handler := s.StatusHandler()
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		status int
+		want   string // Substring of the response
+	}{
+		{"GET", "/api/status", "", http.StatusOK, `"10_0_0_2"`},
+		{"GET", "/api/devices", "", http.StatusOK, `"UUID":"dev1"`},
+		{"GET", "/api/devices/10.0.0.2", "", http.StatusOK, `"UUID":"dev1"`},
+		{"GET", "/api/devices/10.0.0.3", "", http.StatusNotFound, "no device connected"},
+		{"GET", "/api/channel?ip=10_0_0_2&port=x", "", http.StatusBadRequest, "invalid port"},
+		{"GET", "/api/channel?ip=10_0_0_2&port=5556", "", http.StatusNotFound, "no channel"},
+		{"GET", "/api/rates", "", http.StatusOK, "{}"},
+		{"GET", "/api/rates/history?key=10_0_0_2:5556&seconds=10", "", http.StatusOK, "[]"},
+		{"GET", "/api/rates/history?seconds=x", "", http.StatusBadRequest, "invalid seconds"},
+		{"GET", "/api/logs?ip=10.0.0.2&severity=" + SEVERITY_ERROR, "", http.StatusOK, "ADC overrun"},
+		{"GET", "/api/settings", "", http.StatusOK, `"AveragingWindow"`},
+		{"PUT", "/api/settings", "{", http.StatusBadRequest, "invalid settings"},
+		{"PUT", "/api/settings", `{"AveragingWindow": -1}`, http.StatusBadRequest, ""},
+		{"PUT", "/api/settings", `{"AveragingWindow": 250}`, http.StatusOK, `"AveragingWindow":250`},
+		{"POST", "/api/status", "", http.StatusMethodNotAllowed, ""},
+		{"GET", "/api/unknown", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.status || !strings.Contains(recorder.Body.String(), test.want) {
+			t.Errorf("%s %s = %d %q, want %d containing %q", test.method, test.target,
+				recorder.Code, recorder.Body.String(), test.status, test.want)
+		}
+		if test.status == http.StatusOK && recorder.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s has Content-Type %q", test.method, test.target, recorder.Header().Get("Content-Type"))
+		}
+	}
+
+	request := httptest.NewRequest("GET", "/api/logs?ip=10.0.0.2", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	var entries []LogEntry
+	if err := json.NewDecoder(recorder.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Line != "[I] started" {
+		t.Errorf("Logs = %+v, want both lines newest first", entries)
+	}
+	if got := s.GetSettings().AveragingWindow; got != 250 {
+		t.Errorf("Averaging window = %d, want 250", got)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/logger"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	PING_WRITE_TIMEOUT = 2 * time.Second // Time a ping may take to be written before the connection is given up
+	JITTER_GAIN        = 16              // Smoothing of the jitter estimate, as in RFC 3550
+)
+
+// RoundTripStats are the round trip times of the pings sent to a device on its handshake
+// connection. Devices echo {"ping":<id>} with {"pong":<id>}. A ping still unanswered when
+// the next one is sent counts as lost. Firmware that does not answer pings only ever
+// shows Sent.
+type RoundTripStats struct {
+	Sent     int64
+	Received int64
+	Lost     int64
+	Last     time.Duration
+	Min      time.Duration
+	Max      time.Duration
+	Mean     time.Duration
+	Jitter   time.Duration // Smoothed difference between consecutive round trip times
+	Updated  time.Time     // Time of the last reply
+}
+
+// record adds the round trip time of a reply
+func (stats *RoundTripStats) record(rtt time.Duration, now time.Time) {
+	if stats.Received == 0 {
+		stats.Min, stats.Max = rtt, rtt
+	} else {
+		diff := rtt - stats.Last
+		if diff < 0 {
+			diff = -diff
+		}
+		stats.Jitter += (diff - stats.Jitter) / JITTER_GAIN
+		stats.Min = min(stats.Min, rtt)
+		stats.Max = max(stats.Max, rtt)
+	}
+	stats.Received++
+	stats.Mean += (rtt - stats.Mean) / time.Duration(stats.Received)
+	stats.Last = rtt
+	stats.Updated = now
+}
+
+// pinger sends the pings of one handshake connection and matches the replies to them
+type pinger struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]time.Time // Send time of the unanswered ping, at most one
+	stats   RoundTripStats
+}
+
+// start numbers the next ping, counting the previous one as lost if it was not answered
+func (p *pinger) start() (uint64, RoundTripStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Lost += int64(len(p.pending))
+	clear(p.pending)
+	p.next++
+	p.pending[p.next] = time.Now()
+	p.stats.Sent++
+	return p.next, p.stats
+}
+
+// send writes ping id to the device
+func (p *pinger) send(messages *deviceMessages, id uint64) error {
+	data, err := json.Marshal(struct {
+		Ping uint64 `json:"ping"`
+	}{id})
+	if err != nil {
+		return err
+	}
+	messages.conn.SetWriteDeadline(time.Now().Add(PING_WRITE_TIMEOUT))
+	_, err = messages.conn.Write(append(data, '\n'))
+	return err
+}
+
+// reply records the reply to ping id. Replies to pings already counted as lost are
+// ignored.
+func (p *pinger) reply(id uint64) (RoundTripStats, bool) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sent, exists := p.pending[id]
+	if !exists {
+		return p.stats, false
+	}
+	delete(p.pending, id)
+	p.stats.record(now.Sub(sent), now)
+	return p.stats, true
+}
+
+// sendPings pings the device every interval until stop is closed or a ping cannot be written
+func (s *Server) sendPings(p *pinger, messages *deviceMessages, sanitizedIP string, uuid string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		id, stats := p.start()
+		s.updateLatency(sanitizedIP, uuid, stats)
+		if err := p.send(messages, id); err != nil {
+			if s.ctx.Err() == nil {
+				logger.Errorf("Error sending ping to %s: %v\n", sanitizedIP, err)
+			}
+			return
+		}
+	}
+}
+
+// updateLatency stores the latency statistics of the IP's connection
+func (s *Server) updateLatency(sanitizedIP string, uuid string, stats RoundTripStats) {
+	s.connectedIPsLock.Lock()
+	defer s.connectedIPsLock.Unlock()
+	connection, exists := s.connectedIPs[sanitizedIP]
+	if !exists || connection.UUID != uuid {
+		return
+	}
+	connection.Latency = &stats
+}
+
+// GetDeviceLatency returns the round trip times of the pings to a connected device
+func (s *Server) GetDeviceLatency(uuid string) (RoundTripStats, error) {
+	s.connectedIPsLock.RLock()
+	defer s.connectedIPsLock.RUnlock()
+	for _, connection := range s.connectedIPs {
+		if connection.UUID == uuid && connection.Latency != nil {
+			return *connection.Latency, nil
+		}
+	}
+	return RoundTripStats{}, fmt.Errorf("device %s has not been pinged", uuid)
+}
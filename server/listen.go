@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"eth-daq-software/logger"
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	LISTENER_ERROR_EVENT = "listener-error" // Event carrying the ListenerStatus of a port that could not be bound
+	BIND_RETRY_INTERVAL  = 2 * time.Second  // Delay between attempts to bind a port and its fallbacks
+)
+
+// ListenerStatus reports whether a configured port is being listened on
+type ListenerStatus struct {
+	Port      int    // Configured port, identifies the channel
+	BoundPort int    // Port listened on, a fallback if Port was in use, 0 if binding failed
+	Error     string // Why binding failed, "" when listening
+}
+
+// bind listens on port or, if it is in use, on its configured fallbacks, retrying up to
+// BindRetries times. The result is recorded for GetListenerStatus and failures are sent
+// to the frontend as a LISTENER_ERROR_EVENT.
+func (s *Server) bind(port int, reuse bool) (net.Listener, error) {
+	cfg := s.settings()
+	candidates := append([]int{port}, cfg.FallbackPorts[port]...)
+
+	var err error
+	for attempt := 0; attempt <= cfg.BindRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(BIND_RETRY_INTERVAL):
+			case <-s.ctx.Done():
+				return nil, s.ctx.Err()
+			}
+		}
+		for _, candidate := range candidates {
+			var listener net.Listener
+			listener, err = listen(candidate, reuse)
+			if err == nil {
+				if candidate != port {
+					logger.Infof("Port %d is unavailable, listening on fallback port %d\n", port, candidate)
+				}
+				s.setListenerStatus(ListenerStatus{Port: port, BoundPort: candidate})
+				return listener, nil
+			}
+		}
+	}
+
+	status := ListenerStatus{Port: port, Error: bindError(port, candidates, err)}
+	s.setListenerStatus(status)
+	logger.Errorf("%s\n", status.Error)
+	s.emitEvent(LISTENER_ERROR_EVENT, status)
+	return nil, err
+}
+
+// listen listens on a TCP port, with SO_REUSEPORT if reuse is set so that further sockets
+// can listen on it
+func listen(port int, reuse bool) (net.Listener, error) {
+	var config net.ListenConfig
+	if reuse {
+		config.Control = reusePort
+	}
+	return config.Listen(context.Background(), "tcp", fmt.Sprintf(":%d", port))
+}
+
+// tuneSocket applies the configured socket options of a listener port to an accepted
+// connection. Options that cannot be set are logged, the connection is kept.
+func (s *Server) tuneSocket(conn net.Conn, port int) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	socket := s.settings().SocketFor(port)
+	if socket.NoDelay != nil {
+		if err := tcpConn.SetNoDelay(*socket.NoDelay); err != nil {
+			logger.Errorf("Failed to set TCP_NODELAY on port %d: %v\n", port, err)
+		}
+	}
+	if socket.ReceiveBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(socket.ReceiveBuffer); err != nil {
+			logger.Errorf("Failed to set the receive buffer on port %d: %v\n", port, err)
+		}
+	}
+	if socket.KeepAlive != 0 {
+		period := time.Duration(socket.KeepAlive) * time.Second
+		err := tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+			Enable:   socket.KeepAlive > 0,
+			Idle:     period,
+			Interval: period,
+		})
+		if err != nil {
+			logger.Errorf("Failed to set keepalive on port %d: %v\n", port, err)
+		}
+	}
+}
+
+// bindError describes a bind failure with what the user can do about it
+func bindError(port int, candidates []int, err error) string {
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Sprintf("Failed to listen on port %d: %v", port, err)
+	}
+	if len(candidates) > 1 {
+		return fmt.Sprintf("Port %d and its fallback ports %v are already in use. Close the program using them, "+
+			"for example another eth-daq-software instance, or configure other fallback_ports.", port, candidates[1:])
+	}
+	return fmt.Sprintf("Port %d is already in use. Close the program using it, for example another "+
+		"eth-daq-software instance, or configure fallback_ports for it.", port)
+}
+
+// setListenerStatus records the binding result of a port
+func (s *Server) setListenerStatus(status ListenerStatus) {
+	s.listenersLock.Lock()
+	defer s.listenersLock.Unlock()
+	s.listeners[status.Port] = status
+}
+
+// GetListenerStatus returns the binding result of every port started so far, by port
+func (s *Server) GetListenerStatus() []ListenerStatus {
+	s.listenersLock.RLock()
+	defer s.listenersLock.RUnlock()
+	statuses := make([]ListenerStatus, 0, len(s.listeners))
+	for _, status := range s.listeners {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Port < statuses[j].Port
+	})
+	return statuses
+}
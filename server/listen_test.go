@@ -0,0 +1,185 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBindFallback tests that a port in use falls back to its alternate port and is
+// reported when no alternate is free
+func TestBindFallback(t *testing.T) {
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+	fallback := freePort(t)
+
+	cfg := config.Default()
+	cfg.FallbackPorts = map[int][]int{port: {fallback}}
+	s := NewServer(cfg)
+	listener, err := s.bind(port, false)
+	if err != nil {
+		t.Fatalf("bind() = %v, want fallback port %d", err, fallback)
+	}
+	listener.Close()
+	if status := s.GetListenerStatus(); len(status) != 1 || status[0].BoundPort != fallback || status[0].Error != "" {
+		t.Errorf("Status = %+v, want port %d bound to %d", status, port, fallback)
+	}
+
+	s = NewServer(config.Default())
+	if _, err := s.bind(port, false); err == nil {
+		t.Fatal("Expected error for port in use, got nil")
+	}
+	if status := s.GetListenerStatus(); len(status) != 1 || !strings.Contains(status[0].Error, "already in use") {
+		t.Errorf("Status = %+v, want port in use error", status)
+	}
+}
+
+// TestDuplicateConnectionPolicy tests the connection kept for each duplicate connection policy
+func TestDuplicateConnectionPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		uuid     string
+		accepted bool
+		key      BufferKey
+		closed   bool // Whether the existing connection is closed
+	}{
+		{config.DUPLICATE_REPLACE, "dev2", true, BufferKey{IP: "10.0.0.2", Port: 5556}, true},
+		{config.DUPLICATE_REJECT, "dev2", false, BufferKey{IP: "10.0.0.2", Port: 5556}, false},
+		{config.DUPLICATE_PARALLEL, "dev2", true, BufferKey{IP: "10.0.0.2", Port: 5556, UUID: "dev2"}, false},
+		{config.DUPLICATE_PARALLEL, "dev1", true, BufferKey{IP: "10.0.0.2", Port: 5556}, true},
+	}
+	for _, tt := range tests {
+		cfg := config.Default()
+		cfg.DuplicateConnections = tt.policy
+		s := NewServer(cfg)
+		key := BufferKey{IP: "10.0.0.2", Port: 5556}
+		s.buffers[key] = NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+		existing, existingPeer := net.Pipe()
+		s.registerConnection(key, existing, "dev1")
+
+		conn, _ := net.Pipe()
+		registered, accepted := s.registerConnection(key, conn, tt.uuid)
+		if accepted != tt.accepted || registered != tt.key {
+			t.Errorf("%s from %s: registerConnection() = %+v, %v, want %+v, %v",
+				tt.policy, tt.uuid, registered, accepted, tt.key, tt.accepted)
+		}
+		existingPeer.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, err := existingPeer.Read(make([]byte, 1))
+		if closed := err == io.EOF; closed != tt.closed {
+			t.Errorf("%s from %s: existing connection closed %v, want %v", tt.policy, tt.uuid, closed, tt.closed)
+		}
+	}
+}
+
+// TestAcceptLoops tests that a port with several accept loops accepts connections on all
+// of its sockets and stops with the server
+func TestAcceptLoops(t *testing.T) {
+	if !REUSE_PORT_SUPPORTED {
+		t.Skip("SO_REUSEPORT is not used on this platform")
+	}
+	port := freePort(t)
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.LogDir = t.TempDir()
+	cfg.UDPLogPort = freePort(t)
+	cfg.Channels = map[int]config.ChannelConfig{port: {AcceptLoops: 3}}
+	s := NewServer(cfg)
+	stopped := make(chan struct{})
+	go func() {
+		s.StartListener(port)
+		close(stopped)
+	}()
+
+	var conn net.Conn
+	var err error
+	for range 50 {
+		if conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// A further socket on the port only binds if the listener set SO_REUSEPORT
+	extra, err := listen(port, true)
+	if err != nil {
+		t.Fatalf("listen() with SO_REUSEPORT = %v", err)
+	}
+	extra.Close()
+
+	for i := range 8 {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			t.Fatalf("Connection %d: %v", i, err)
+		}
+		defer conn.Close()
+	}
+	for range 100 {
+		s.activeConnsLock.RLock()
+		accepted := len(s.activeConns)
+		s.activeConnsLock.RUnlock()
+		if accepted > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.Stop(2 * time.Second); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("StartListener did not return after Stop")
+	}
+}
+
+// TestListenerWatchdog tests that a panicking connection handler is recovered and reported
+// and that an accept loop whose socket is closed under it reports the failure
+func TestListenerWatchdog(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.LogDir = t.TempDir()
+	s := NewServer(cfg)
+	var events []interface{}
+	s.SetEventEmitter(func(name string, data ...interface{}) {
+		if name == INCIDENT_EVENT {
+			events = append(events, data...)
+		}
+	})
+
+	// A handler without a buffer panics
+	client, conn := net.Pipe()
+	defer client.Close()
+	s.connectionWg.Add(1)
+	s.HandleConnection(conn, nil, BufferKey{Port: 5556, IP: "10.0.0.2"})
+	incidents := s.GetIncidents()
+	if len(incidents) != 1 || incidents[0].Component != INCIDENT_CONNECTION || incidents[0].Port != 5556 ||
+		!strings.Contains(incidents[0].Stack, "HandleConnection") || len(events) != 1 {
+		t.Fatalf("GetIncidents() = %+v, events %d, want the recovered panic", incidents, len(events))
+	}
+
+	listener, err := listen(0, false)
+	if err != nil {
+		t.Fatalf("listen() = %v", err)
+	}
+	listener.Close()
+	if err := s.acceptLoop(listener, 5556); err == nil {
+		t.Error("acceptLoop() on a closed socket = nil, want an error")
+	}
+	s.Stop(time.Second)
+	if err := s.acceptLoop(listener, 5556); err != nil {
+		t.Errorf("acceptLoop() after Stop = %v, want nil", err)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LOG_SEQUENCE_PREFIX = '#' // Starts the sequence number of a numbered log datagram, "#42 I (1234) tag: text"
+	LOG_GAP_FACTOR      = 3   // Device time gap, in typical line intervals, counted as lost lines
+	LOG_GAP_MIN_LINES   = 8   // Lines needed before the typical interval is trusted
+	LOG_INTERVAL_GAIN   = 8   // Smoothing of the typical interval between lines
+)
+
+// LogLossStats count the log datagrams of a device that were lost or repeated on the way.
+// Firmware that numbers its datagrams gets exact counts. For other firmware lost lines are
+// estimated from gaps in the ESP-IDF style device timestamps, "E (1234) tag: text", that
+// are much longer than the typical interval between lines, which assumes a steady log rate.
+type LogLossStats struct {
+	Datagrams  int64 // Log datagrams received
+	Sequenced  int64 // Datagrams with a sequence number
+	Missing    int64 // Datagrams missing from the sequence numbers
+	Duplicates int64 // Datagrams repeating the previous sequence number
+	OutOfOrder int64 // Datagrams older than the previous one
+	Estimated  int64 // Lines estimated lost from device timestamp gaps, without sequence numbers
+}
+
+// logLossTracker counts the lost log datagrams of a device
+type logLossTracker struct {
+	stats    LogLossStats
+	sequence sequenceTracker
+	lastTime int64   // Device timestamp of the previous line in milliseconds, -1 if none
+	interval float64 // Smoothed interval between lines in milliseconds
+	lines    int     // Consecutive lines with device timestamps
+}
+
+// parseLogSequence splits the sequence number off a numbered log datagram
+func parseLogSequence(line string) (uint32, string, bool) {
+	if len(line) < 2 || line[0] != LOG_SEQUENCE_PREFIX {
+		return 0, line, false
+	}
+	number, rest, _ := strings.Cut(line[1:], " ")
+	sequence, err := strconv.ParseUint(number, 10, 32)
+	if err != nil {
+		return 0, line, false
+	}
+	return uint32(sequence), rest, true
+}
+
+// parseDeviceTime returns the milliseconds since boot of an ESP-IDF style log line
+func parseDeviceTime(line string) (int64, bool) {
+	line = strings.TrimLeft(line, " \t")
+	if len(line) < 4 || line[1] != ' ' || line[2] != '(' {
+		return 0, false
+	}
+	number, _, found := strings.Cut(line[3:], ")")
+	if !found {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return ms, true
+}
+
+// observe counts a log datagram. A sequence number of 0 starts the count over, as the
+// firmware numbers its datagrams from 0 after booting.
+func (t *logLossTracker) observe(sequence uint32, sequenced bool, line string) {
+	t.stats.Datagrams++
+	if sequenced {
+		if sequence == 0 {
+			t.sequence.reset()
+		}
+		t.sequence.observe(0, sequence, time.Now())
+		t.sequence.gaps = nil
+		t.stats.Sequenced++
+		t.stats.Missing = t.sequence.stats.Missing
+		t.stats.Duplicates = t.sequence.stats.Duplicates
+		t.stats.OutOfOrder = t.sequence.stats.OutOfOrder
+		return
+	}
+
+	ms, found := parseDeviceTime(line)
+	if !found {
+		return
+	}
+	if t.lines == 0 || ms < t.lastTime {
+		// First line, or the device rebooted
+		t.lastTime = ms
+		t.lines = 1
+		t.interval = 0
+		return
+	}
+	elapsed := float64(ms - t.lastTime)
+	t.lastTime = ms
+	t.lines++
+	if t.lines > LOG_GAP_MIN_LINES && t.interval > 0 && elapsed > LOG_GAP_FACTOR*t.interval {
+		t.stats.Estimated += int64(math.Round(elapsed/t.interval)) - 1
+		return
+	}
+	if t.interval == 0 {
+		t.interval = elapsed
+	} else {
+		t.interval += (elapsed - t.interval) / LOG_INTERVAL_GAIN
+	}
+}
+
+// add sums the stats of two log sources
+func (stats LogLossStats) add(other LogLossStats) LogLossStats {
+	stats.Datagrams += other.Datagrams
+	stats.Sequenced += other.Sequenced
+	stats.Missing += other.Missing
+	stats.Duplicates += other.Duplicates
+	stats.OutOfOrder += other.OutOfOrder
+	stats.Estimated += other.Estimated
+	return stats
+}
+
+// GetAllLogLoss returns the lost and repeated log datagrams of every device by sanitized
+// IP, summed over the UDP log ports
+func (s *Server) GetAllLogLoss() map[string]LogLossStats {
+	s.logBuffersLock.RLock()
+	defer s.logBuffersLock.RUnlock()
+
+	loss := make(map[string]LogLossStats, len(s.logBuffers))
+	for ip, buffer := range s.logBuffers {
+		buffer.mu.Lock()
+		var stats LogLossStats
+		for _, tracker := range buffer.loss {
+			stats = stats.add(tracker.stats)
+		}
+		loss[ip] = stats
+		buffer.mu.Unlock()
+	}
+	return loss
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MARKER_EVENT = "marker" // Event carrying a Marker
+	MAX_MARKERS  = 10000    // Markers kept in memory, the oldest are forgotten first
+)
+
+// Sources of markers
+const (
+	MARKER_MANUAL  = "manual"  // Added from the UI with AddMarker
+	MARKER_TRIGGER = "trigger" // Added by a trigger capture
+	MARKER_ALARM   = "alarm"   // Added when a device becomes degraded or offline
+)
+
+// Marker is a timestamped note, such as "applied load step", recorded with the data of
+// the active sessions and written to exports at the sample nearest its time
+type Marker struct {
+	Time   time.Time
+	Label  string
+	Source string // MARKER_MANUAL, MARKER_TRIGGER or MARKER_ALARM
+	UUID   string `json:",omitempty"` // Device the marker is about, "" for all devices
+}
+
+// AddMarker adds a manual marker for all devices at the current time
+func (s *Server) AddMarker(label string) (Marker, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return Marker{}, fmt.Errorf("marker label is required")
+	}
+	marker := Marker{Time: time.Now(), Label: label, Source: MARKER_MANUAL}
+	s.addMarker(marker)
+	return marker, nil
+}
+
+// addMarker keeps a marker, appends it to the manifests of the active sessions it applies
+// to and sends it to the frontend
+func (s *Server) addMarker(marker Marker) {
+	s.markersLock.Lock()
+	s.markers = append(s.markers, marker)
+	if len(s.markers) > MAX_MARKERS {
+		s.markers = slices.Delete(s.markers, 0, len(s.markers)-MAX_MARKERS)
+	}
+	s.markersLock.Unlock()
+
+	s.sessionsLock.Lock()
+	for uuid, session := range s.sessions {
+		if marker.UUID != "" && marker.UUID != uuid {
+			continue
+		}
+		session.Markers = append(session.Markers, marker)
+		if err := session.writeManifest(); err != nil {
+			logger.Errorf("Failed to record marker in session %s: %v\n", session.ID, err)
+		}
+	}
+	s.sessionsLock.Unlock()
+
+	logger.InfoFields("Marker added", logger.Fields{
+		"label":  marker.Label,
+		"source": marker.Source,
+		"uuid":   marker.UUID,
+	})
+	s.emitEvent(MARKER_EVENT, marker)
+}
+
+// keyUUID returns the UUID of the device sending on a channel, "" before its handshake
+func (s *Server) keyUUID(key BufferKey) string {
+	if key.UUID != "" {
+		return key.UUID
+	}
+	return s.deviceUUID(SanitizeFilename(key.IP))
+}
+
+// GetMarkers returns the markers added since the server started, oldest first
+func (s *Server) GetMarkers() []Marker {
+	s.markersLock.RLock()
+	defer s.markersLock.RUnlock()
+	return slices.Clone(s.markers)
+}
+
+// exportMarkers returns the markers of a device for an export of segments: those kept in
+// memory and those recorded in the manifests of the sessions the segments belong to
+func (s *Server) exportMarkers(uuid string, segments []SegmentInfo) []Marker {
+	var markers []Marker
+	for _, marker := range s.GetMarkers() {
+		if marker.UUID == "" || marker.UUID == uuid {
+			markers = append(markers, marker)
+		}
+	}
+
+	dirs := make(map[string]bool)
+	for _, segment := range segments {
+		dirs[filepath.Dir(segment.Path)] = true
+	}
+	for dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, SESSION_MANIFEST))
+		if err != nil {
+			continue
+		}
+		var session Session
+		if err := json.Unmarshal(data, &session); err != nil {
+			logger.Errorf("Failed to read markers of %s: %v\n", dir, err)
+			continue
+		}
+		for _, marker := range session.Markers {
+			if !slices.ContainsFunc(markers, func(m Marker) bool {
+				return m.Time.Equal(marker.Time) && m.Label == marker.Label
+			}) {
+				markers = append(markers, marker)
+			}
+		}
+	}
+
+	sort.Slice(markers, func(i, j int) bool { return markers[i].Time.Before(markers[j].Time) })
+	return markers
+}
+
+// markerCursor hands out the labels of markers as an export reaches their time
+type markerCursor struct {
+	markers []Marker
+	next    int
+}
+
+func newMarkerCursor(markers []Marker) *markerCursor {
+	markers = slices.Clone(markers)
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].Time.Before(markers[j].Time) })
+	return &markerCursor{markers: markers}
+}
+
+// skip leaves out the markers before t
+func (mc *markerCursor) skip(t time.Time) {
+	for mc.next < len(mc.markers) && mc.markers[mc.next].Time.Before(t) {
+		mc.next++
+	}
+}
+
+// until returns the labels of the markers up to t that were not returned yet
+func (mc *markerCursor) until(t time.Time) []string {
+	var labels []string
+	for mc.next < len(mc.markers) && !mc.markers[mc.next].Time.After(t) {
+		labels = append(labels, mc.markers[mc.next].Label)
+		mc.next++
+	}
+	return labels
+}
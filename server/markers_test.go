@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMarkerInSession tests that markers are recorded in the manifests of the active
+// sessions they apply to
+func TestMarkerInSession(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+
+	if _, err := s.AddMarker("  "); err == nil {
+		t.Error("Expected error for an empty label, got nil")
+	}
+	if _, err := s.StartRecording("dev1"); err != nil {
+		t.Fatalf("StartRecording() = %v", err)
+	}
+	if _, err := s.StartRecording("dev2"); err != nil {
+		t.Fatalf("StartRecording() = %v", err)
+	}
+	if _, err := s.AddMarker("load step"); err != nil {
+		t.Fatalf("AddMarker() = %v", err)
+	}
+	s.addMarker(Marker{Time: time.Now(), Label: "trigger on port 5555", Source: MARKER_TRIGGER, UUID: "dev2"})
+	sessions := s.GetActiveSessions()
+	for _, uuid := range []string{"dev1", "dev2"} {
+		if err := s.StopRecording(uuid); err != nil {
+			t.Fatalf("StopRecording() = %v", err)
+		}
+	}
+
+	want := map[string]int{"dev1": 1, "dev2": 2}
+	for _, session := range sessions {
+		data, err := os.ReadFile(filepath.Join(session.Dir, SESSION_MANIFEST))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest Session
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Markers) != want[session.UUID] || manifest.Markers[0].Label != "load step" {
+			t.Errorf("Markers of %s = %+v, want %d starting with the load step", session.UUID, manifest.Markers, want[session.UUID])
+		}
+	}
+	if markers := s.GetMarkers(); len(markers) != 2 {
+		t.Errorf("GetMarkers() = %+v, want 2 markers", markers)
+	}
+}
+
+// TestExportCSVMarkers tests that markers are written at the first sample at or after their
+// time, and markers outside the export are left out
+func TestExportCSVMarkers(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// Four samples at 1, 2, 3 and 4 s after the segment was opened
+	path := writeSegment(t, dir, 5556, start.UnixNano(), []uint16{32768, 32768, 32768, 32768})
+	if err := os.Chtimes(path, start.Add(4*time.Second), start.Add(4*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := parseSegments([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markers := []Marker{
+		{Time: start.Add(4 * time.Second), Label: "b"},
+		{Time: start.Add(-time.Second), Label: "before"},
+		{Time: start.Add(1500 * time.Millisecond), Label: "a"},
+		{Time: start.Add(4 * time.Second), Label: "c"},
+		{Time: start.Add(5 * time.Second), Label: "after"},
+	}
+	var out bytes.Buffer
+	if err := ExportCSV(&out, segments, nil, nil, ExportOptions{Markers: markers}); err != nil {
+		t.Fatalf("ExportCSV() = %v", err)
+	}
+	want := "sample,vgs [V],marker\n0,0,\n1,0,a\n2,0,\n3,0,b; c\n"
+	if got := out.String(); got != want {
+		t.Errorf("ExportCSV() = %q, want %q", got, want)
+	}
+}
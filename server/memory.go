@@ -0,0 +1,124 @@
+package server
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// memoryAccount tracks the received data held in memory between the receive path and
+// the disk, and enforces the configured cap on it
+type memoryAccount struct {
+	limit        atomic.Int64 // Cap on buffered plus pending bytes, 0 is unlimited
+	buffered     atomic.Int64 // Bytes in DataBuffer flush buffers
+	pending      atomic.Int64 // Bytes of flushed segments not yet written
+	earlyFlushes atomic.Int64 // Flushes triggered by the cap before the flush threshold
+	waits        atomic.Int64 // Flushes that waited for writes to free memory
+	lock         sync.Mutex
+	written      *sync.Cond // Broadcast when pending bytes are written or dropped
+}
+
+func newMemoryAccount() *memoryAccount {
+	m := &memoryAccount{}
+	m.written = sync.NewCond(&m.lock)
+	return m
+}
+
+// overLimit reports whether the buffered and pending bytes exceed the cap
+func (m *memoryAccount) overLimit() bool {
+	limit := m.limit.Load()
+	return limit > 0 && m.buffered.Load()+m.pending.Load() > limit
+}
+
+// reserve accounts for a flushed segment waiting to be written. While the segments
+// already pending would exceed the cap with this one, it waits for them to be written,
+// which pauses the connection flushing it.
+func (m *memoryAccount) reserve(size int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if limit := m.limit.Load(); limit > 0 && m.pending.Load() > 0 && m.pending.Load()+int64(size) > limit {
+		m.waits.Add(1)
+		for limit > 0 && m.pending.Load() > 0 && m.pending.Load()+int64(size) > limit {
+			m.written.Wait()
+			limit = m.limit.Load()
+		}
+	}
+	m.pending.Add(int64(size))
+}
+
+// release accounts for a pending segment that was written or dropped
+func (m *memoryAccount) release(size int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pending.Add(-int64(size))
+	m.written.Broadcast()
+}
+
+// setLimit changes the cap, waking flushes waiting for memory
+func (m *memoryAccount) setLimit(limit int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.limit.Store(limit)
+	m.written.Broadcast()
+}
+
+// MemoryStats is the memory held by received data, device logs and the Go runtime
+type MemoryStats struct {
+	BufferedBytes     int64  // Received data waiting to be flushed
+	PendingWriteBytes int64  // Flushed segments waiting to be written
+	HistoryBytes      int64  // Sample history and averaging windows
+	LogBytes          int64  // Device log lines kept in memory
+	TotalBytes        int64  // Sum of the above
+	Limit             int64  // Cap on BufferedBytes plus PendingWriteBytes, 0 is unlimited
+	EarlyFlushes      int64  // Flushes triggered by the cap before the flush threshold
+	Waits             int64  // Flushes that waited for writes to free memory
+	HeapBytes         uint64 // Heap memory in use by the whole application
+}
+
+// historyBytes returns the memory held by the sample history and averaging windows
+func (db *DataBuffer) historyBytes() int64 {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	samples := len(db.history.data) + len(db.circularBuffer.data) + cap(db.circularBuffer.sorted)
+	if db.historyB != nil {
+		samples += len(db.historyB.data)
+	}
+	if db.circularBufferB != nil {
+		samples += len(db.circularBufferB.data) + cap(db.circularBufferB.sorted)
+	}
+	return int64(samples) * 8
+}
+
+// GetMemoryStats returns the current memory usage
+func (s *Server) GetMemoryStats() MemoryStats {
+	memory := s.writer.memory
+	stats := MemoryStats{
+		BufferedBytes:     memory.buffered.Load(),
+		PendingWriteBytes: memory.pending.Load(),
+		Limit:             memory.limit.Load(),
+		EarlyFlushes:      memory.earlyFlushes.Load(),
+		Waits:             memory.waits.Load(),
+	}
+
+	s.buffersLock.RLock()
+	for _, buffer := range s.buffers {
+		stats.HistoryBytes += buffer.historyBytes()
+	}
+	s.buffersLock.RUnlock()
+
+	s.logBuffersLock.RLock()
+	for _, buffer := range s.logBuffers {
+		buffer.mu.Lock()
+		for _, entry := range buffer.logLines {
+			stats.LogBytes += int64(len(entry.Line))
+		}
+		buffer.mu.Unlock()
+	}
+	s.logBuffersLock.RUnlock()
+
+	stats.TotalBytes = stats.BufferedBytes + stats.PendingWriteBytes + stats.HistoryBytes + stats.LogBytes
+	var runtimeStats runtime.MemStats
+	runtime.ReadMemStats(&runtimeStats)
+	stats.HeapBytes = runtimeStats.HeapInuse
+	return stats
+}
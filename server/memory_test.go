@@ -0,0 +1,48 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"testing"
+	"time"
+)
+
+// TestMemoryLimit tests that buffers are flushed early above the memory cap and that
+// flushes wait for pending writes
+func TestMemoryLimit(t *testing.T) {
+	writer := newSegmentWriter(0, t.TempDir())
+	writer.queue.configure(10, config.WRITE_QUEUE_BLOCK)
+	writer.memory.setLimit(100)
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1000)
+	buffer.codec, _ = compress.CodecByName("zstd")
+	buffer.writer = writer
+
+	buffer.AddData(make([]byte, 60))
+	if buffered, pending := writer.memory.buffered.Load(), writer.memory.pending.Load(); buffered != 60 || pending != 0 {
+		t.Errorf("Buffered %d and pending %d bytes, want 60 and 0", buffered, pending)
+	}
+	buffer.AddData(make([]byte, 60))
+	if buffered, pending := writer.memory.buffered.Load(), writer.memory.pending.Load(); buffered != 0 || pending != 120 {
+		t.Errorf("Buffered %d and pending %d bytes after the early flush, want 0 and 120", buffered, pending)
+	}
+
+	// The pending segment must be written before the next one is accepted
+	added := make(chan struct{})
+	go func() {
+		buffer.AddData(make([]byte, 60))
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("AddData() returned while over the memory cap")
+	case <-time.After(20 * time.Millisecond):
+	}
+	job, _ := writer.queue.pop()
+	writer.memory.release(len(job.data))
+	writer.pending.Done()
+	<-added
+
+	if early, waits := writer.memory.earlyFlushes.Load(), writer.memory.waits.Load(); early != 2 || waits != 1 {
+		t.Errorf("%d early flushes and %d waits, want 2 and 1", early, waits)
+	}
+}
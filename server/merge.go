@@ -0,0 +1,196 @@
+package server
+
+import (
+	"crypto/sha256"
+	"eth-daq-software/compress"
+	"eth-daq-software/logger"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MergeResult describes the segments of one port of a session merged into one file
+type MergeResult struct {
+	Path     string
+	Segments int   // Segments concatenated
+	Bytes    int64 // Raw bytes written
+	// Gaps are the runs of frames missing from the port while recording, from the session
+	// manifest. The merged samples run on across them.
+	Gaps []SequenceGap
+	// Dropped are the numbers of segments of the port that were never written, such as
+	// segments dropped by a full write queue
+	Dropped []int
+}
+
+// MergeSegments concatenates the decompressed segments of one port in a session directory
+// into a raw .bin file at dest, in capture order, with checksum and timestamp sidecars. The merge fails
+// if the order of the segments is ambiguous or contradicts the start times in their
+// metadata, if a segment listed in the segment manifest is missing, or if a segment does
+// not match its checksum sidecar.
+func MergeSegments(dir string, port int, dest string) (MergeResult, error) {
+	session, err := readSessionManifest(dir)
+	if err != nil {
+		return MergeResult{}, fmt.Errorf("failed to read session manifest: %v", err)
+	}
+	if dest == "" {
+		return MergeResult{}, fmt.Errorf("merge destination is required")
+	}
+	if absDir, err := filepath.Abs(dir); err == nil {
+		if absDest, err := filepath.Abs(dest); err == nil && filepath.Dir(absDest) == absDir {
+			return MergeResult{}, fmt.Errorf("merged file must be written outside the session directory")
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return MergeResult{}, fmt.Errorf("failed to read session %s: %v", session.ID, err)
+	}
+	var segments []SegmentInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "port") || !isSegmentFile(name) {
+			continue
+		}
+		if segment, err := ParseSegmentName(filepath.Join(dir, name)); err == nil && segment.Port == port {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return MergeResult{}, fmt.Errorf("session %s has no segments of port %d", session.ID, port)
+	}
+	sortSegments(segments)
+
+	// Check the order before writing anything
+	var lastStart int64
+	for i, segment := range segments {
+		if i > 0 && segment.Timestamp == segments[i-1].Timestamp {
+			return MergeResult{}, fmt.Errorf("segments %s and %s have the same timestamp",
+				filepath.Base(segments[i-1].Path), filepath.Base(segment.Path))
+		}
+		if start := segmentStart(segment.Path); !start.IsZero() {
+			if start.UnixNano() < lastStart {
+				return MergeResult{}, fmt.Errorf("segment %s starts before the segment preceding it",
+					filepath.Base(segment.Path))
+			}
+			lastStart = start.UnixNano()
+		}
+	}
+
+	result := MergeResult{Path: dest, Segments: len(segments), Gaps: []SequenceGap{}, Dropped: []int{}}
+	manifest, err := ReadSegmentManifest(dir)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	last := 0
+	for _, record := range manifest.Segments {
+		if record.Port != port {
+			continue
+		}
+		if !slices.ContainsFunc(segments, func(segment SegmentInfo) bool { return filepath.Base(segment.Path) == record.Name }) {
+			return MergeResult{}, fmt.Errorf("segment %s of the manifest is missing", record.Name)
+		}
+		for sequence := last + 1; sequence < record.Sequence; sequence++ {
+			result.Dropped = append(result.Dropped, sequence)
+		}
+		last = record.Sequence
+	}
+	for _, gap := range session.Gaps {
+		if gap.Port == port {
+			result.Gaps = append(result.Gaps, gap)
+		}
+	}
+
+	partial := dest + PARTIAL_SEGMENT_SUFFIX
+	file, err := os.Create(partial)
+	if err != nil {
+		return MergeResult{}, fmt.Errorf("failed to create merged file: %v", err)
+	}
+	defer os.Remove(partial)
+	defer file.Close()
+	hash := sha256.New()
+	out := io.MultiWriter(file, hash)
+	var timestamps []compress.Timestamp
+	for _, segment := range segments {
+		if err := verifySegment(segment.Path); err != nil {
+			return MergeResult{}, err
+		}
+		segmentTimestamps, err := ReadTimestamps(segment.Path)
+		if err != nil {
+			return MergeResult{}, err
+		}
+		for _, timestamp := range segmentTimestamps {
+			timestamp.Offset += result.Bytes
+			timestamps = append(timestamps, timestamp)
+		}
+		reader, err := compress.DecompressFile(segment.Path)
+		if err != nil {
+			return MergeResult{}, err
+		}
+		n, err := io.Copy(out, reader)
+		if err != nil {
+			return MergeResult{}, fmt.Errorf("failed to write merged file: %v", err)
+		}
+		result.Bytes += n
+	}
+	if err := file.Sync(); err != nil {
+		return MergeResult{}, fmt.Errorf("failed to write merged file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return MergeResult{}, fmt.Errorf("failed to write merged file: %v", err)
+	}
+	if err := os.Rename(partial, dest); err != nil {
+		return MergeResult{}, fmt.Errorf("failed to write merged file: %v", err)
+	}
+	if err := writeChecksum(dest, hash.Sum(nil)); err != nil {
+		return result, err
+	}
+	if len(timestamps) > 0 {
+		if err := writeTimestamps(dest, timestamps); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// verifySegment compares a segment with its checksum sidecar, if it has one
+func verifySegment(path string) error {
+	want, err := readChecksum(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	got, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if string(got) != string(want) {
+		return fmt.Errorf("segment %s does not match its checksum", filepath.Base(path))
+	}
+	return nil
+}
+
+// MergeSession concatenates the segments of one port of a stopped session into one raw
+// file at dest, see MergeSegments
+func (s *Server) MergeSession(id string, port int, dest string) (MergeResult, error) {
+	dir, err := s.sessionPath(id)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	result, err := MergeSegments(dir, port, dest)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	logger.InfoFields("Session merged", logger.Fields{
+		"session":  id,
+		"port":     port,
+		"segments": result.Segments,
+		"gaps":     len(result.Gaps),
+		"dest":     dest,
+	})
+	return result, nil
+}
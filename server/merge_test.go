@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"eth-daq-software/compress"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestMergeSegments tests that the segments of a port are concatenated in capture order
+// with the gaps of the port, and that segments failing their checks fail the merge
+func TestMergeSegments(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "session")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	gap := SequenceGap{Port: 5556, From: 10, Count: 2}
+	session := Session{ID: "session", Dir: dir, Gaps: []SequenceGap{gap, {Port: 5555, From: 3, Count: 1}}}
+	if err := session.writeManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeSegment(t, dir, 5556, 3, []uint16{5, 6})
+	writeSegment(t, dir, 5556, 1, []uint16{1, 2})
+	writeSegment(t, dir, 5555, 2, []uint16{9})
+	compressed := compress.AppendSegmentHeader(nil, &compress.Metadata{Port: 5556, StartTime: 2})
+	compressed = compress.HybridRLECompressInto(compressed, []byte{3, 0, 4, 0}, compress.Options{})
+	middle := filepath.Join(dir, "port5556_10_0_0_2_dev1_2.rle4")
+	if err := os.WriteFile(middle, compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, _ := fileChecksum(middle)
+	if err := writeChecksum(middle, sum); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(filepath.Dir(dir), "merged.bin")
+	result, err := MergeSegments(dir, 5556, dest)
+	if err != nil {
+		t.Fatalf("MergeSegments() = %v", err)
+	}
+	if want := (MergeResult{Path: dest, Segments: 3, Bytes: 12, Gaps: []SequenceGap{gap}, Dropped: []int{}}); !reflect.DeepEqual(result, want) {
+		t.Errorf("MergeSegments() = %+v, want %+v", result, want)
+	}
+	data, _ := os.ReadFile(dest)
+	if want := []byte{1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0}; !bytes.Equal(data, want) {
+		t.Errorf("Merged data = %v, want %v", data, want)
+	}
+	if err := verifySegment(dest); err != nil {
+		t.Errorf("verifySegment(merged file) = %v", err)
+	}
+
+	if _, err := MergeSegments(dir, 5556, filepath.Join(dir, "merged.bin")); err == nil {
+		t.Error("Expected error for a merged file in the session directory, got nil")
+	}
+	os.WriteFile(middle, append(compressed, 0), 0644)
+	if _, err := MergeSegments(dir, 5556, dest); err == nil {
+		t.Error("Expected error for a segment not matching its checksum, got nil")
+	}
+	os.Remove(middle + CHECKSUM_SUFFIX)
+	writeSegment(t, dir, 5556, 2, []uint16{7})
+	if _, err := MergeSegments(dir, 5556, dest); err == nil {
+		t.Error("Expected error for segments with the same timestamp, got nil")
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"eth-daq-software/logger"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	MIRROR_RETRY_MIN = 1 * time.Second // First wait before retrying a failed copy to the mirror
+	MIRROR_RETRY_MAX = 1 * time.Minute // Longest wait before retrying a failed copy
+)
+
+// MirrorStatus is the progress of copying written segments to the mirror directory
+type MirrorStatus struct {
+	Dir       string
+	Pending   int       // Segments waiting to be copied
+	Copied    int64     // Segments copied since startup
+	LastError string    `json:",omitempty"` // Error of the last failed copy, "" once a copy succeeds
+	ErrorTime time.Time `json:",omitempty"`
+}
+
+// segmentFile is a written segment and its path relative to the data directory
+type segmentFile struct {
+	path string
+	rel  string
+}
+
+// segmentMirror copies written segments to a second directory, such as a NAS mount, on
+// its own goroutine. Failed copies are retried until they succeed, so a mirror that is
+// unavailable for a while catches up once it returns. Segments deleted by retention stay
+// in the mirror.
+type segmentMirror struct {
+	dir     string
+	lock    sync.Mutex
+	pending []segmentFile
+	status  MirrorStatus
+	wake    chan struct{}
+}
+
+func newSegmentMirror(dir string) *segmentMirror {
+	return &segmentMirror{
+		dir:    dir,
+		status: MirrorStatus{Dir: dir},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// add queues a written segment for copying, keeping its path below dataDir
+func (m *segmentMirror) add(dataDir string, path string) {
+	rel, err := filepath.Rel(dataDir, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		rel = filepath.Base(path)
+	}
+	m.lock.Lock()
+	m.pending = append(m.pending, segmentFile{path: path, rel: rel})
+	m.lock.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest segment waiting to be copied
+func (m *segmentMirror) next() (segmentFile, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.pending) == 0 {
+		return segmentFile{}, false
+	}
+	return m.pending[0], true
+}
+
+// done removes the oldest segment from the queue once it is copied or gone
+func (m *segmentMirror) done(copied bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pending = m.pending[1:]
+	if copied {
+		m.status.Copied++
+		m.status.LastError = ""
+	}
+}
+
+// run copies queued segments in order until ctx is cancelled
+func (m *segmentMirror) run(ctx context.Context) {
+	retry := MIRROR_RETRY_MIN
+	for {
+		file, ok := m.next()
+		if !ok {
+			select {
+			case <-m.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err := m.copy(file)
+		if err == nil {
+			m.done(true)
+			retry = MIRROR_RETRY_MIN
+			continue
+		}
+		if _, statErr := os.Stat(file.path); os.IsNotExist(statErr) {
+			// Deleted before it could be copied, e.g. by DeleteSession
+			logger.Errorf("Segment %s was removed before it was mirrored\n", file.path)
+			m.done(false)
+			continue
+		}
+
+		m.lock.Lock()
+		if m.status.LastError == "" {
+			logger.Errorf("Failed to mirror %s to %s, retrying: %v\n", file.path, m.dir, err)
+		}
+		m.status.LastError, m.status.ErrorTime = err.Error(), time.Now()
+		m.lock.Unlock()
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		retry = min(retry*2, MIRROR_RETRY_MAX)
+	}
+}
+
+// copy writes a segment, its checksum and timestamp sidecars and its session manifests to
+// the mirror. The
+// segment appears under its final name only once it is complete.
+func (m *segmentMirror) copy(file segmentFile) error {
+	dest := filepath.Join(m.dir, file.rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	partial := dest + PARTIAL_SEGMENT_SUFFIX
+	if err := copyFile(file.path, partial); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	if err := os.Rename(partial, dest); err != nil {
+		return err
+	}
+	sidecars := []string{
+		file.path + CHECKSUM_SUFFIX,
+		file.path + TIMESTAMP_SUFFIX,
+		filepath.Join(filepath.Dir(file.path), SESSION_MANIFEST),
+		filepath.Join(filepath.Dir(file.path), SEGMENT_MANIFEST),
+	}
+	for _, sidecar := range sidecars {
+		if _, err := os.Stat(sidecar); err != nil {
+			continue
+		}
+		if err := copyFile(sidecar, filepath.Join(filepath.Dir(dest), filepath.Base(sidecar))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartMirror copies written segments to config.MirrorDir until the server is stopped
+func (s *Server) StartMirror() {
+	if s.writer.mirror == nil {
+		return
+	}
+	go s.writer.mirror.run(s.ctx)
+}
+
+// GetMirrorStatus returns the progress of the mirror, with an empty Dir if no mirror is
+// configured
+func (s *Server) GetMirrorStatus() MirrorStatus {
+	m := s.writer.mirror
+	if m == nil {
+		return MirrorStatus{}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	status := m.status
+	status.Pending = len(m.pending)
+	return status
+}
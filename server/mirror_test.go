@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSegmentMirror tests that segments are copied below the mirror directory with their
+// checksum sidecars and session manifests, and removed segments are skipped
+func TestSegmentMirror(t *testing.T) {
+	dataDir, mirrorDir := t.TempDir(), t.TempDir()
+	sessionDir := filepath.Join(dataDir, "session1")
+	if err := os.Mkdir(sessionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	segment := filepath.Join(sessionDir, "port5556_1.bin")
+	files := map[string]string{segment: "data", segment + CHECKSUM_SUFFIX: "sum", filepath.Join(sessionDir, SESSION_MANIFEST): "{}"}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := newSegmentMirror(mirrorDir)
+	m.add(dataDir, filepath.Join(dataDir, "port5556_0.bin"))
+	m.add(dataDir, segment)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.lock.Lock()
+		pending, copied := len(m.pending), m.status.Copied
+		m.lock.Unlock()
+		if pending == 0 {
+			if copied != 1 {
+				t.Errorf("Copied = %d, want 1", copied)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d segments still pending", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for path, content := range files {
+		rel, _ := filepath.Rel(dataDir, path)
+		data, err := os.ReadFile(filepath.Join(mirrorDir, rel))
+		if err != nil || string(data) != content {
+			t.Errorf("Mirrored %s = %q (%v), want %q", rel, data, err, content)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// PeakOptions controls peak detection
+type PeakOptions struct {
+	WindowMs    int     // Length of the recent sample window to search, 0 searches the whole history
+	Prominence  float64 // Minimum prominence of a peak above its surrounding baseline
+	MinDistance int     // Minimum distance between reported peaks in samples
+	Valleys     bool    // Detect valleys instead of peaks
+}
+
+// Peak is a detected local extremum in the sample window
+type Peak struct {
+	Index      int       // Index of the sample in the searched window
+	Time       time.Time // Estimated time of the sample, based on the measured sample rate
+	Amplitude  float64
+	Prominence float64
+}
+
+// findPeaks returns the indices and prominences of the local maxima of samples with at least
+// the given prominence, keeping only the highest peak within minDistance samples
+func findPeaks(samples []float64, prominence float64, minDistance int) ([]int, []float64) {
+	var indices []int
+	var prominences []float64
+
+	leftMin := baseMinima(samples, false)
+	rightMin := baseMinima(samples, true)
+
+	for i := 1; i < len(samples)-1; i++ {
+		if samples[i] <= samples[i-1] {
+			continue
+		}
+		// Walk over plateaus, the peak is the middle of the plateau
+		j := i
+		for j+1 < len(samples) && samples[j+1] == samples[i] {
+			j++
+		}
+		if j+1 >= len(samples) || samples[j+1] > samples[i] {
+			i = j
+			continue
+		}
+		peak := (i + j) / 2
+
+		// The prominence is the height above the higher of the two lowest points
+		// between the peak and the nearest higher sample on either side
+		peakProminence := samples[i] - max(leftMin[i], rightMin[j])
+
+		if peakProminence >= prominence {
+			indices = append(indices, peak)
+			prominences = append(prominences, peakProminence)
+		}
+		i = j
+	}
+
+	if minDistance <= 1 || len(indices) < 2 {
+		return indices, prominences
+	}
+
+	// Keep the highest peaks first, discarding any peak too close to a kept one
+	order := make([]int, len(indices))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return samples[indices[order[a]]] > samples[indices[order[b]]]
+	})
+	removed := make([]bool, len(indices))
+	for _, current := range order {
+		if removed[current] {
+			continue
+		}
+		for other := current - 1; other >= 0 && indices[current]-indices[other] < minDistance; other-- {
+			removed[other] = true
+		}
+		for other := current + 1; other < len(indices) && indices[other]-indices[current] < minDistance; other++ {
+			removed[other] = true
+		}
+	}
+
+	keptIndices := make([]int, 0, len(indices))
+	keptProminences := make([]float64, 0, len(indices))
+	for i := range indices {
+		if !removed[i] {
+			keptIndices = append(keptIndices, indices[i])
+			keptProminences = append(keptProminences, prominences[i])
+		}
+	}
+	return keptIndices, keptProminences
+}
+
+// baseMinima returns for every sample the lowest sample between it and the nearest higher
+// sample before it, or after it if reverse is set, including the sample itself. It keeps
+// a stack of decreasing samples, each with the minimum since the sample below it, so that
+// every sample is pushed and popped once.
+func baseMinima(samples []float64, reverse bool) []float64 {
+	type entry struct {
+		value float64
+		min   float64 // Lowest sample after the entry below it, up to this one
+	}
+
+	minima := make([]float64, len(samples))
+	stack := make([]entry, 0, 64)
+	for n := range samples {
+		k := n
+		if reverse {
+			k = len(samples) - 1 - n
+		}
+		lowest := samples[k]
+		for len(stack) > 0 && stack[len(stack)-1].value <= samples[k] {
+			lowest = min(lowest, stack[len(stack)-1].min)
+			stack = stack[:len(stack)-1]
+		}
+		minima[k] = lowest
+		stack = append(stack, entry{value: samples[k], min: lowest})
+	}
+	return minima
+}
+
+// DetectPeaks finds peaks (or valleys) in the recent sample window of a channel
+func (s *Server) DetectPeaks(key BufferKey, opts PeakOptions) ([]Peak, error) {
+	s.buffersLock.RLock()
+	buffer, exists := s.buffers[key]
+	s.buffersLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("no active channel for %s:%d", key.IP, key.Port)
+	}
+
+	buffer.statsMu.Lock()
+	sampleRate := buffer.sampleRate
+	n := buffer.history.Available()
+	if opts.WindowMs > 0 && sampleRate > 0 {
+		n = min(n, int(float64(opts.WindowMs)/1000*sampleRate))
+	}
+	samples := buffer.history.Last(n)
+	buffer.statsMu.Unlock()
+
+	return samplePeaks(samples, sampleRate, time.Now(), opts), nil
+}
+
+// samplePeaks finds peaks (or valleys) in samples taken at sampleRate, the newest of them
+// at now
+func samplePeaks(samples []float64, sampleRate float64, now time.Time, opts PeakOptions) []Peak {
+	search := samples
+	if opts.Valleys {
+		search = make([]float64, len(samples))
+		for i, sample := range samples {
+			search[i] = -sample
+		}
+	}
+
+	indices, prominences := findPeaks(search, opts.Prominence, opts.MinDistance)
+	peaks := make([]Peak, len(indices))
+	for i, index := range indices {
+		peaks[i] = Peak{
+			Index:      index,
+			Amplitude:  samples[index],
+			Prominence: prominences[i],
+		}
+		if sampleRate > 0 {
+			age := float64(len(samples)-1-index) / sampleRate
+			peaks[i].Time = now.Add(-time.Duration(age * float64(time.Second)))
+		}
+	}
+	return peaks
+}
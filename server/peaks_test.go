@@ -0,0 +1,87 @@
+package server
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestFindPeaks tests peak detection with prominence and distance filtering
+func TestFindPeaks(t *testing.T) {
+	samples := []float64{0, 5, 0, 1, 0, 3, 3, 3, 0, 4, 2, 4.5, 0}
+
+	tests := []struct {
+		name        string
+		prominence  float64
+		minDistance int
+		expected    []int
+	}{
+		{"All peaks", 0, 0, []int{1, 3, 6, 9, 11}},
+		{"Prominence filter", 2, 0, []int{1, 6, 9, 11}},
+		{"Distance filter", 2, 3, []int{1, 6, 11}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indices, _ := findPeaks(samples, tt.prominence, tt.minDistance)
+			if !reflect.DeepEqual(indices, tt.expected) {
+				t.Fatalf("findPeaks = %v, want %v", indices, tt.expected)
+			}
+		})
+	}
+}
+
+// TestFindPeaksProminence compares prominences with a direct search on random samples
+func TestFindPeaksProminence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 100; run++ {
+		samples := make([]float64, 200)
+		for i := range samples {
+			// Few distinct values to get plateaus and equal peaks
+			samples[i] = float64(rng.Intn(8))
+		}
+
+		indices, prominences := findPeaks(samples, 0, 0)
+		for n, peak := range indices {
+			i, j := peak, peak
+			for i > 0 && samples[i-1] == samples[peak] {
+				i--
+			}
+			for j+1 < len(samples) && samples[j+1] == samples[peak] {
+				j++
+			}
+			leftMin, rightMin := samples[peak], samples[peak]
+			for k := i - 1; k >= 0 && samples[k] <= samples[peak]; k-- {
+				leftMin = min(leftMin, samples[k])
+			}
+			for k := j + 1; k < len(samples) && samples[k] <= samples[peak]; k++ {
+				rightMin = min(rightMin, samples[k])
+			}
+			if want := samples[peak] - max(leftMin, rightMin); prominences[n] != want {
+				t.Fatalf("run %d: prominence of peak %d = %v, want %v", run, peak, prominences[n], want)
+			}
+		}
+	}
+}
+
+// TestFindPeaksRisingNoise tests that a long rising signal with a peak every other sample,
+// where every peak is higher than all before it, is searched in linear time
+func TestFindPeaksRisingNoise(t *testing.T) {
+	samples := make([]float64, 1<<20)
+	for i := range samples {
+		samples[i] = float64(i)
+		if i%2 == 1 {
+			samples[i] += 2
+		}
+	}
+
+	start := time.Now()
+	indices, _ := findPeaks(samples, 0, 0)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("findPeaks took %v on %d samples", elapsed, len(samples))
+	}
+	if len(indices) != len(samples)/2-1 {
+		t.Fatalf("found %d peaks, want %d", len(indices), len(samples)/2-1)
+	}
+}
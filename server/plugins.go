@@ -0,0 +1,21 @@
+package server
+
+import (
+	"eth-daq-software/logger"
+	"fmt"
+	"plugin"
+)
+
+// LoadDecoderPlugins opens Go plugins adding decoders. A plugin registers its decoders with
+// RegisterDecoder from an init function, which runs when it is opened. Plugins must be
+// built with the same Go version and module versions as the server, and are not
+// supported on Windows.
+func LoadDecoderPlugins(paths []string) error {
+	for _, path := range paths {
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("failed to load decoder plugin %s: %v", path, err)
+		}
+		logger.Infof("Loaded decoder plugin %s\n", path)
+	}
+	return nil
+}
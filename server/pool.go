@@ -0,0 +1,41 @@
+package server
+
+import (
+	"sync"
+)
+
+// readChunks holds the read chunks of closed connections for reuse by new connections.
+// Chunk sizes are configured per channel, see getReadChunk.
+var readChunks = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// getReadChunk returns a pooled read chunk of size bytes
+func getReadChunk(size int) *[]byte {
+	chunk := readChunks.Get().(*[]byte)
+	if cap(*chunk) < size {
+		*chunk = make([]byte, size)
+	}
+	*chunk = (*chunk)[:size]
+	return chunk
+}
+
+// flushBuffers holds the buffers of written segments for reuse by DataBuffer, so that
+// the receive path does not allocate a new flush-sized buffer for every segment
+var flushBuffers sync.Pool
+
+// getFlushBuffer returns an empty buffer with a capacity of at least size
+func getFlushBuffer(size int) []byte {
+	if buffer, ok := flushBuffers.Get().(*[]byte); ok && cap(*buffer) >= size {
+		return (*buffer)[:0]
+	}
+	return make([]byte, 0, size)
+}
+
+// putFlushBuffer returns a buffer that is no longer referenced to the pool
+func putFlushBuffer(buffer []byte) {
+	buffer = buffer[:0]
+	flushBuffers.Put(&buffer)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"testing"
+)
+
+// BenchmarkAddData measures the receive path from AddData to written segments
+func BenchmarkAddData(b *testing.B) {
+	writer := newSegmentWriter(2, b.TempDir())
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1<<20)
+	buffer.writer = writer
+	chunk := make([]byte, 64*1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunk)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer.AddData(chunk)
+	}
+	buffer.FlushAsync()
+	writer.wait()
+}
+
+// BenchmarkReadChunk measures taking a read chunk for a new connection
+func BenchmarkReadChunk(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		chunk := getReadChunk(1 << 20)
+		readChunks.Put(chunk)
+	}
+}
+
+// TestProcessBytesSplit tests that samples split across chunks are decoded as if the
+// data had arrived in one chunk
+func TestProcessBytesSplit(t *testing.T) {
+	data := []byte{0x00, 0x80, 0x34, 0x12, 0xff, 0xff, 0x01, 0x00, 0x10, 0x20}
+
+	whole := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+	whole.processBytes(data)
+
+	split := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+	for _, chunk := range [][]byte{data[:1], data[1:4], {}, data[4:7], data[7:]} {
+		split.processBytes(chunk)
+	}
+
+	want := whole.history.Last(5)
+	got := split.history.Last(5)
+	if len(got) != len(want) {
+		t.Fatalf("Got %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Sample %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// TestAveragingGoroutine tests that samples processed on the averaging goroutine give the
+// same history as samples processed in AddData
+func TestAveragingGoroutine(t *testing.T) {
+	data := make([]byte, 3001)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	sync := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1<<20)
+	sync.AddData(data)
+
+	async := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1<<20)
+	async.startStats()
+	async.AddData(data[:1001])
+	async.addChunk(queuedChunk{chunk: pooledCopy(data[1001:]), n: 2000})
+	async.stopStats()
+
+	want := sync.history.Last(sync.history.Available())
+	got := async.history.Last(async.history.Available())
+	if len(got) != 1500 || len(got) != len(want) {
+		t.Fatalf("Got %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Sample %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// pooledCopy copies data into a read chunk
+func pooledCopy(data []byte) *[]byte {
+	chunk := getReadChunk(len(data))
+	copy(*chunk, data)
+	return chunk
+}
+
+// TestAlignmentReset tests that a new connection discards the partial sample of the
+// previous one and restarts the thermocouple interleave at the internal sensor
+func TestAlignmentReset(t *testing.T) {
+	// 25 °C internal and 0 mV thermocouple
+	pair := []byte{0x80, 0x0c, 0x00, 0x00}
+
+	buffer := NewDataBuffer(5557, "10.0.0.2", 10, "dev1", 1024)
+	buffer.startStats()
+	// The previous connection ended after an internal sample and half a thermocouple sample
+	buffer.AddData(pair[:3])
+	buffer.queueAlignmentReset()
+	buffer.AddData(pair)
+	buffer.queueAlignmentReset()
+	buffer.stopStats()
+
+	if count := buffer.GetMisalignments(); count != 1 {
+		t.Errorf("GetMisalignments() = %d, want 1", count)
+	}
+	internal := buffer.history.Last(2)
+	if internal[0] != internal[1] {
+		t.Errorf("Internal sensor history = %v, want the same reading twice", internal)
+	}
+	if available := buffer.historyB.Available(); available != 1 {
+		t.Errorf("Thermocouple history holds %d samples, want 1", available)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	RATE_HISTORY_INTERVAL = time.Second // Time between the samples of the rate history
+)
+
+// RateSample is the transfer rate of a channel over one RATE_HISTORY_INTERVAL
+type RateSample struct {
+	Time time.Time // End of the interval
+	Rate float64   // MB/s, 0 while the channel is not connected
+}
+
+// rateHistory holds the recent rate samples of a channel, oldest first
+type rateHistory struct {
+	samples  []RateSample
+	lastSeen time.Time // Time the channel was last connected
+}
+
+// rateCounter is the byte count of a buffer at the previous rate sample
+type rateCounter struct {
+	buffer *DataBuffer
+	bytes  int64
+}
+
+// StartRateHistory samples the transfer rate of every channel each RATE_HISTORY_INTERVAL
+// until the server is stopped
+func (s *Server) StartRateHistory() {
+	go func() {
+		ticker := time.NewTicker(RATE_HISTORY_INTERVAL)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.sampleRates(now, now.Sub(last))
+				last = now
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// sampleRates adds the rate of every channel over the elapsed time to its history. Channels
+// that disconnected get zero rates until they have been gone for the whole history.
+func (s *Server) sampleRates(now time.Time, elapsed time.Duration) {
+	keep := s.settings().RateHistory
+	received := make(map[string]int64)
+	counters := make(map[BufferKey]rateCounter)
+
+	s.rateHistoryLock.Lock()
+	defer s.rateHistoryLock.Unlock()
+	s.buffersLock.RLock()
+	for key, buffer := range s.buffers {
+		buffer.mu.Lock()
+		bytes := buffer.storedBytes
+		buffer.mu.Unlock()
+		counters[key] = rateCounter{buffer: buffer, bytes: bytes}
+
+		// A new buffer of the channel counts from 0
+		if previous, exists := s.rateCounters[key]; exists && previous.buffer == buffer {
+			bytes -= previous.bytes
+		}
+		received[fmt.Sprintf("%s:%d", key.IP, key.Port)] += bytes
+	}
+	s.buffersLock.RUnlock()
+	s.rateCounters = counters
+
+	if keep <= 0 {
+		clear(s.rateHistory)
+		return
+	}
+	limit := int(time.Duration(keep) * time.Second / RATE_HISTORY_INTERVAL)
+	for key := range received {
+		if _, exists := s.rateHistory[key]; !exists {
+			s.rateHistory[key] = &rateHistory{}
+		}
+	}
+	for key, history := range s.rateHistory {
+		bytes, connected := received[key]
+		if connected {
+			history.lastSeen = now
+		} else if now.Sub(history.lastSeen) > time.Duration(keep)*time.Second {
+			delete(s.rateHistory, key)
+			continue
+		}
+		history.samples = append(history.samples, RateSample{
+			Time: now,
+			Rate: float64(bytes) / elapsed.Seconds() / 1024 / 1024,
+		})
+		if len(history.samples) > limit {
+			history.samples = history.samples[len(history.samples)-limit:]
+		}
+	}
+}
+
+// GetRateHistory returns the rate samples of a channel, keyed "ip:port" as in
+// GetAllBufferRates, over the last seconds, oldest first. seconds <= 0 returns the whole
+// history.
+func (s *Server) GetRateHistory(key string, seconds int) []RateSample {
+	s.rateHistoryLock.Lock()
+	defer s.rateHistoryLock.Unlock()
+	history, exists := s.rateHistory[key]
+	if !exists {
+		return []RateSample{}
+	}
+	samples := history.samples
+	if seconds > 0 {
+		since := samples[len(samples)-1].Time.Add(-time.Duration(seconds) * time.Second)
+		for len(samples) > 0 && !samples[0].Time.After(since) {
+			samples = samples[1:]
+		}
+	}
+	return append([]RateSample{}, samples...)
+}
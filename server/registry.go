@@ -0,0 +1,290 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/compress"
+	"eth-daq-software/logger"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEVICE_REGISTRY_FILE = "devices.json"
+)
+
+// DeviceSettings are per-device preferences applied when the device connects
+type DeviceSettings struct {
+	AutoRecord  bool   // Start a recording session when the device connects
+	Compression string `json:",omitempty"` // Codec for the device's segments, "" uses the port's codec
+}
+
+// Device is a registry entry, created on the first handshake of a device
+type Device struct {
+	UUID            string
+	Alias           string `json:",omitempty"`
+	Notes           string `json:",omitempty"`
+	Settings        DeviceSettings
+	MAC             string
+	FirmwareVersion string
+	HardwareVersion string
+	LastIP          string    // Sanitized IP of the last connection
+	FirstSeen       time.Time // Time of the first handshake
+	LastSeen        time.Time // Time of the last handshake or disconnect
+	// Thermocouple types by data port, as reported in the last handshake, see
+	// HandshakeChannel. Recorded data of the device is decoded with them.
+	Thermocouples map[int]string `json:",omitempty"`
+}
+
+// DeviceEdit holds the user-editable fields of a registry entry
+type DeviceEdit struct {
+	Alias    string
+	Notes    string
+	Settings DeviceSettings
+}
+
+// RegisteredDevice is a registry entry together with its calibrations and connection state
+type RegisteredDevice struct {
+	Device
+	Calibrations []CalibrationEntry
+	Connected    bool
+}
+
+// DeviceRegistry keeps the devices seen so far and persists them to disk
+type DeviceRegistry struct {
+	path    string
+	devices map[string]*Device
+	mu      sync.RWMutex
+}
+
+// NewDeviceRegistry creates an empty registry backed by the given file
+func NewDeviceRegistry(path string) *DeviceRegistry {
+	return &DeviceRegistry{
+		path:    path,
+		devices: make(map[string]*Device),
+	}
+}
+
+// Load reads the registry from disk. A missing file is not an error.
+func (r *DeviceRegistry) Load() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read device registry: %v", err)
+	}
+
+	var devices []Device
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return fmt.Errorf("failed to parse device registry: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices = make(map[string]*Device)
+	for i := range devices {
+		r.devices[devices[i].UUID] = &devices[i]
+	}
+	logger.Infof("Loaded %d devices from %s\n", len(devices), r.path)
+	return nil
+}
+
+// save writes the registry to disk, the caller must hold the lock
+func (r *DeviceRegistry) save() error {
+	devices := make([]Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, *device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].UUID < devices[j].UUID
+	})
+
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode device registry: %v", err)
+	}
+	if err := os.WriteFile(r.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write device registry: %v", err)
+	}
+	return nil
+}
+
+// Get returns the registry entry of a device
+func (r *DeviceRegistry) Get(uuid string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if device, exists := r.devices[uuid]; exists {
+		return *device, true
+	}
+	return Device{}, false
+}
+
+// List returns all registry entries sorted by UUID
+func (r *DeviceRegistry) List() []Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	devices := make([]Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, *device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].UUID < devices[j].UUID
+	})
+	return devices
+}
+
+// Update applies change to the entry of a device, creating it if needed, and persists
+// the registry
+func (r *DeviceRegistry) Update(uuid string, change func(device *Device)) (Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	device, exists := r.devices[uuid]
+	if !exists {
+		device = &Device{UUID: uuid, FirstSeen: time.Now()}
+		r.devices[uuid] = device
+	}
+	change(device)
+	return *device, r.save()
+}
+
+// Remove deletes the entry of a device and persists the registry
+func (r *DeviceRegistry) Remove(uuid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.devices[uuid]; !exists {
+		return nil
+	}
+	delete(r.devices, uuid)
+	return r.save()
+}
+
+// registerHandshake records the identity, thermocouple types and last-seen information of a device
+func (s *Server) registerHandshake(uuid string, sanitizedIP string, mac string, firmware string, hardware string, channels []HandshakeChannel) Device {
+	thermocouples := make(map[int]string)
+	for _, channel := range channels {
+		if channel.Thermocouple != "" {
+			thermocouples[channel.Port] = channel.Thermocouple
+		}
+	}
+	device, err := s.registry.Update(uuid, func(device *Device) {
+		device.Thermocouples = thermocouples
+		device.MAC = mac
+		device.FirmwareVersion = firmware
+		device.HardwareVersion = hardware
+		device.LastIP = sanitizedIP
+		device.LastSeen = time.Now()
+	})
+	if err != nil {
+		logger.Errorf("Failed to register device %s: %v\n", uuid, err)
+	}
+	return device
+}
+
+// deviceThermocouple returns the thermocouple type a device reported for a port in its
+// last handshake, "" if none
+func (s *Server) deviceThermocouple(uuid string, port int) string {
+	device, _ := s.registry.Get(uuid)
+	return device.Thermocouples[port]
+}
+
+// registerDisconnect records when a device closed its last data port
+func (s *Server) registerDisconnect(uuid string) {
+	if _, exists := s.registry.Get(uuid); !exists {
+		return
+	}
+	if _, err := s.registry.Update(uuid, func(device *Device) {
+		device.LastSeen = time.Now()
+	}); err != nil {
+		logger.Errorf("Failed to update device %s: %v\n", uuid, err)
+	}
+}
+
+// LoadDevices loads the persisted device registry
+func (s *Server) LoadDevices() error {
+	return s.registry.Load()
+}
+
+// GetDevices returns all registered devices with their calibrations and connection state
+func (s *Server) GetDevices() []RegisteredDevice {
+	connected := make(map[string]bool)
+	for _, conn := range s.GetAllConnectedIPs() {
+		connected[conn.UUID] = true
+	}
+
+	devices := s.registry.List()
+	result := make([]RegisteredDevice, 0, len(devices))
+	for _, device := range devices {
+		result = append(result, RegisteredDevice{
+			Device:       device,
+			Calibrations: s.calibrations.List(device.UUID),
+			Connected:    connected[device.UUID],
+		})
+	}
+	return result
+}
+
+// EditDevice changes the alias, notes and preferred settings of a device and persists them
+func (s *Server) EditDevice(uuid string, edit DeviceEdit) error {
+	if uuid == "" {
+		return fmt.Errorf("device UUID is required")
+	}
+	if edit.Settings.Compression != "" && edit.Settings.Compression != "none" {
+		if _, err := compress.CodecByName(edit.Settings.Compression); err != nil {
+			return err
+		}
+	}
+
+	device, err := s.registry.Update(uuid, func(device *Device) {
+		device.Alias = strings.TrimSpace(edit.Alias)
+		device.Notes = edit.Notes
+		device.Settings = edit.Settings
+	})
+	if err != nil {
+		return err
+	}
+	s.applyDevice(device)
+	logger.Infof("Device %s updated: %+v\n", uuid, edit)
+	return nil
+}
+
+// SetDeviceAlias names a device, e.g. "DUT-3 gate driver board". The alias is shown
+// instead of the IP and UUID and added to segment file names and export headers.
+// An empty name removes the alias.
+func (s *Server) SetDeviceAlias(uuid string, name string) error {
+	if uuid == "" {
+		return fmt.Errorf("device UUID is required")
+	}
+	device, err := s.registry.Update(uuid, func(device *Device) {
+		device.Alias = strings.TrimSpace(name)
+	})
+	if err != nil {
+		return err
+	}
+	s.applyDevice(device)
+	logger.Infof("Device %s named %q\n", uuid, device.Alias)
+	return nil
+}
+
+// applyDevice applies the registry entry of a device to its connection and live buffers
+func (s *Server) applyDevice(device Device) {
+	s.connectedIPsLock.Lock()
+	for _, conn := range s.connectedIPs {
+		if conn.UUID == device.UUID {
+			conn.Alias = device.Alias
+		}
+	}
+	s.connectedIPsLock.Unlock()
+
+	buffers := s.deviceBuffers(device.UUID)
+	for _, buffer := range buffers {
+		buffer.setAlias(device.Alias)
+		buffer.setCodec(s.codecForDevice(device.UUID, buffer.port))
+	}
+	if len(buffers) > 0 {
+		s.autoStartRecording(device.UUID)
+	}
+}
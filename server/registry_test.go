@@ -0,0 +1,98 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestDeviceRegistry tests that registry entries survive a reload
+func TestDeviceRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DEVICE_REGISTRY_FILE)
+	registry := NewDeviceRegistry(path)
+
+	if _, err := registry.Update("dev1", func(device *Device) {
+		device.Alias = "DUT-3"
+		device.Settings.AutoRecord = true
+	}); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	device, err := registry.Update("dev1", func(device *Device) {
+		device.LastIP = "10_0_0_2"
+	})
+	if err != nil || device.Alias != "DUT-3" || device.FirstSeen.IsZero() {
+		t.Fatalf("Update() = %+v, %v, want existing entry updated", device, err)
+	}
+
+	reloaded := NewDeviceRegistry(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	got, exists := reloaded.Get("dev1")
+	if !exists || got.Alias != "DUT-3" || !got.Settings.AutoRecord || got.LastIP != "10_0_0_2" ||
+		!got.FirstSeen.Equal(device.FirstSeen) {
+		t.Errorf("Reloaded entry = %+v, want %+v", got, device)
+	}
+	if devices := reloaded.List(); len(devices) != 1 {
+		t.Errorf("List() returned %d devices, want 1", len(devices))
+	}
+}
+
+// TestForgetDevice tests that forgetting a device archives only its segments and sessions
+func TestForgetDevice(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	state := t.TempDir()
+	s := NewServer(cfg)
+	s.registry = NewDeviceRegistry(filepath.Join(state, DEVICE_REGISTRY_FILE))
+	s.calibrations = NewCalibrationStore(filepath.Join(state, CALIBRATION_FILE))
+	s.groups = NewGroupStore(filepath.Join(state, DEVICE_GROUPS_FILE))
+
+	s.registerHandshake("dev1", "10_0_0_2", "aa", "1.0", "A", nil)
+	s.registerHandshake("dev2", "10_0_0_3", "bb", "1.0", "A", nil)
+	if err := s.SetGroup("rig", []string{"dev1", "dev2"}); err != nil {
+		t.Fatalf("SetGroup() = %v", err)
+	}
+	session, err := s.StartRecording("dev1")
+	if err != nil {
+		t.Fatalf("StartRecording() = %v", err)
+	}
+	for _, name := range []string{"port5556_10.0.0.2_dev1_1.bin", "port5556_10.0.0.3_dev2_2.bin", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(cfg.DataDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.ForgetDevice("dev1", "shred"); err == nil {
+		t.Error("Expected error for invalid data option, got nil")
+	}
+	if err := s.ForgetDevice("dev1", FORGET_ARCHIVE_DATA); err != nil {
+		t.Fatalf("ForgetDevice() = %v", err)
+	}
+
+	var remaining []string
+	entries, _ := os.ReadDir(cfg.DataDir)
+	for _, entry := range entries {
+		remaining = append(remaining, entry.Name())
+	}
+	want := []string{ARCHIVE_DIR, "notes.txt", "port5556_10.0.0.3_dev2_2.bin"}
+	if !reflect.DeepEqual(remaining, want) {
+		t.Errorf("Data directory = %v, want %v", remaining, want)
+	}
+	archived, _ := filepath.Glob(filepath.Join(cfg.DataDir, ARCHIVE_DIR, "dev1_*", "*"))
+	if len(archived) != 2 || filepath.Base(archived[0]) != session.ID {
+		t.Errorf("Archived = %v, want the dev1 segment and session %s", archived, session.ID)
+	}
+
+	if _, exists := s.registry.Get("dev1"); exists {
+		t.Error("dev1 is still registered")
+	}
+	if members, _ := s.groups.Members("rig"); !reflect.DeepEqual(members, []string{"dev2"}) {
+		t.Errorf("Group members = %v, want [dev2]", members)
+	}
+	if active := s.GetActiveSessions(); len(active) != 0 {
+		t.Errorf("Active sessions = %v, want none", active)
+	}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/config"
+	"eth-daq-software/logger"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	RELAY_DIAL_TIMEOUT  = 5 * time.Second  // Time to connect to a relay host
+	RELAY_WRITE_TIMEOUT = 10 * time.Second // Time a relay host may take to accept a write
+	RELAY_RETRY_MIN     = 1 * time.Second  // First wait before reconnecting to a relay host
+	RELAY_RETRY_MAX     = 30 * time.Second // Longest wait before reconnecting to a relay host
+)
+
+// RelayHeader is the JSON line a relay connection starts with, the raw data of the channel
+// follows it
+type RelayHeader struct {
+	IP   string
+	Port int
+	UUID string
+}
+
+// RelayStatus is the state of the relay of one channel to one host
+type RelayStatus struct {
+	Address      string
+	Key          BufferKey
+	Connected    bool
+	SentBytes    int64
+	DroppedBytes int64  // Not sent because the buffer was full or the connection failed
+	Error        string `json:",omitempty"` // Last connection error, "" while connected
+}
+
+// relayStream forwards the data received on one connection to one relay host. Data is
+// buffered in whole frames so that the host always receives complete samples, even when
+// data is dropped or the stream reconnects.
+type relayStream struct {
+	address string
+	header  []byte
+	frame   int // Bytes per frame of samples of all channels of the port
+	limit   int // Bytes the buffer may hold
+	lock    sync.Mutex
+	pending []byte // Whole frames waiting to be sent
+	carry   []byte // Partial frame at the end of the received data
+	closed  bool
+	status  RelayStatus
+	wake    chan struct{}
+}
+
+// openRelays starts the configured relays of a connection's channel
+func (s *Server) openRelays(buffer *DataBuffer, key BufferKey) []*relayStream {
+	var streams []*relayStream
+	decoder := decoderForPort(buffer.port)
+	frame := decoder.SampleWidth() * len(decoder.Channels())
+	header, _ := json.Marshal(RelayHeader{IP: key.IP, Port: buffer.port, UUID: buffer.uuid})
+	header = append(header, '\n')
+	for _, relay := range s.settings().Relays {
+		if !relay.Relays(buffer.port) {
+			continue
+		}
+		rs := newRelayStream(relay, key, header, frame)
+		streams = append(streams, rs)
+		s.relaysLock.Lock()
+		s.relays = append(s.relays, rs)
+		s.relaysLock.Unlock()
+		go func() {
+			rs.run(s)
+			s.relaysLock.Lock()
+			s.relays = slices.DeleteFunc(s.relays, func(other *relayStream) bool { return other == rs })
+			s.relaysLock.Unlock()
+		}()
+	}
+	return streams
+}
+
+func newRelayStream(relay config.RelayConfig, key BufferKey, header []byte, frame int) *relayStream {
+	limit := max(relay.BufferSizeFor(), frame)
+	return &relayStream{
+		address: relay.Address,
+		header:  header,
+		frame:   frame,
+		limit:   limit,
+		status:  RelayStatus{Address: relay.Address, Key: key},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// write queues received data for sending, dropping the whole frames it holds when the
+// buffer is full. It does not block.
+func (rs *relayStream) write(data []byte) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.closed {
+		return
+	}
+	if len(rs.carry) > 0 {
+		data = append(rs.carry, data...)
+		rs.carry = nil
+	}
+	whole := len(data) - len(data)%rs.frame
+	if whole < len(data) {
+		rs.carry = slices.Clone(data[whole:])
+	}
+	if whole == 0 {
+		return
+	}
+	if len(rs.pending)+whole > rs.limit {
+		rs.status.DroppedBytes += int64(whole)
+		return
+	}
+	rs.pending = append(rs.pending, data[:whole]...)
+	rs.signal()
+}
+
+// close sends the data that is still buffered and ends the stream
+func (rs *relayStream) close() {
+	rs.lock.Lock()
+	rs.closed = true
+	rs.lock.Unlock()
+	rs.signal()
+}
+
+func (rs *relayStream) signal() {
+	select {
+	case rs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for data to send, it returns nil once the stream is closed and drained or
+// the server stops
+func (rs *relayStream) next(s *Server, spare []byte) []byte {
+	for {
+		rs.lock.Lock()
+		if len(rs.pending) > 0 {
+			batch := rs.pending
+			rs.pending = spare[:0]
+			rs.lock.Unlock()
+			return batch
+		}
+		closed := rs.closed
+		rs.lock.Unlock()
+		if closed {
+			return nil
+		}
+		select {
+		case <-rs.wake:
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// run connects to the relay host and sends the buffered data until the stream is closed,
+// reconnecting with backoff when the connection fails
+func (rs *relayStream) run(s *Server) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	retry := RELAY_RETRY_MIN
+	var spare []byte
+	for {
+		if conn == nil {
+			var err error
+			if conn, err = rs.connect(); err != nil {
+				conn = nil
+				rs.setError(err)
+				select {
+				case <-time.After(retry):
+				case <-s.ctx.Done():
+					return
+				}
+				retry = min(retry*2, RELAY_RETRY_MAX)
+				if rs.isClosed() {
+					return
+				}
+				continue
+			}
+			retry = RELAY_RETRY_MIN
+			rs.lock.Lock()
+			rs.status.Connected, rs.status.Error = true, ""
+			rs.lock.Unlock()
+		}
+
+		batch := rs.next(s, spare)
+		if batch == nil {
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(RELAY_WRITE_TIMEOUT))
+		_, err := conn.Write(batch)
+		rs.lock.Lock()
+		if err != nil {
+			// The host may have received part of the batch, the next connection starts over
+			rs.status.DroppedBytes += int64(len(batch))
+		} else {
+			rs.status.SentBytes += int64(len(batch))
+		}
+		rs.lock.Unlock()
+		spare = batch
+		if err != nil {
+			conn.Close()
+			conn = nil
+			rs.setError(err)
+		}
+	}
+}
+
+// connect dials the relay host and sends the stream header
+func (rs *relayStream) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", rs.address, RELAY_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Now().Add(RELAY_WRITE_TIMEOUT))
+	if _, err := conn.Write(rs.header); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// setError records a failed connection
+func (rs *relayStream) setError(err error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.status.Error != err.Error() {
+		logger.Errorf("Relay of %s:%d to %s failed: %v\n", rs.status.Key.IP, rs.status.Key.Port, rs.address, err)
+	}
+	rs.status.Connected, rs.status.Error = false, err.Error()
+}
+
+func (rs *relayStream) isClosed() bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	return rs.closed
+}
+
+// GetRelayStatus returns the state of the relays of the connected channels
+func (s *Server) GetRelayStatus() []RelayStatus {
+	s.relaysLock.Lock()
+	defer s.relaysLock.Unlock()
+	statuses := make([]RelayStatus, 0, len(s.relays))
+	for _, rs := range s.relays {
+		rs.lock.Lock()
+		statuses = append(statuses, rs.status)
+		rs.lock.Unlock()
+	}
+	return statuses
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"eth-daq-software/config"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestRelay tests that a relay host receives the header and the whole frames of a channel
+func TestRelay(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.Relays = []config.RelayConfig{{Address: listener.Addr().String(), Ports: []int{5556}}, {Address: "127.0.0.1:1", Ports: []int{5555}}}
+	s := NewServer(cfg)
+	defer s.cancel()
+
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+	key := BufferKey{IP: "10.0.0.2", Port: 5556, UUID: "dev1"}
+	relays := s.openRelays(buffer, key)
+	if len(relays) != 1 {
+		t.Fatalf("openRelays() = %d streams, want 1", len(relays))
+	}
+	// The trailing partial sample is not sent
+	relays[0].write([]byte{1, 2, 3})
+	relays[0].write([]byte{4, 5})
+	relays[0].close()
+
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header RelayHeader
+	if err := json.Unmarshal(line, &header); err != nil || header != (RelayHeader{IP: "10.0.0.2", Port: 5556, UUID: "dev1"}) {
+		t.Errorf("Header = %+v (%v), want the channel", header, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string([]byte{1, 2, 3, 4}) {
+		t.Errorf("Relayed data = %v, want [1 2 3 4]", data)
+	}
+}
+
+// TestRelayDrop tests that a full relay buffer drops whole frames
+func TestRelayDrop(t *testing.T) {
+	rs := newRelayStream(config.RelayConfig{Address: "127.0.0.1:1", BufferSize: 4}, BufferKey{}, nil, 2)
+	rs.write([]byte{1, 2, 3})
+	rs.write([]byte{4, 5})
+	rs.write([]byte{6, 7})
+	if string(rs.pending) != string([]byte{1, 2, 3, 4}) || string(rs.carry) != string([]byte{7}) {
+		t.Errorf("Buffered %v and %v, want [1 2 3 4] and [7]", rs.pending, rs.carry)
+	}
+	if rs.status.DroppedBytes != 2 {
+		t.Errorf("DroppedBytes = %d, want 2", rs.status.DroppedBytes)
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"eth-daq-software/logger"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	REPLAY_PREFIX     = "replay-" // Prefix of the IP and default UUID of replay devices
+	REPLAY_CHUNK_SIZE = 64 * 1024 // Bytes written to the pipeline at a time
+)
+
+// ReplayOptions controls a replay of recorded segments
+type ReplayOptions struct {
+	Paths []string // Segments of one device, any of its ports
+	Speed float64  // 1 replays at the original speed, 2 twice as fast, 0 as fast as possible
+	UUID  string   // UUID of the virtual device, "" uses REPLAY_PREFIX followed by the recorded UUID
+}
+
+// Replay is a running replay. The virtual device connects like a real one from the IP ID,
+// so its data is processed, recorded and exported like that of a connected device.
+type Replay struct {
+	ID      string // Virtual IP of the replay device
+	UUID    string // UUID of the replay device
+	Source  string // UUID of the recorded device
+	Ports   []int
+	Speed   float64
+	Started time.Time
+}
+
+// replayRun is a replay and the function stopping it
+type replayRun struct {
+	Replay
+	cancel context.CancelFunc
+}
+
+// virtualAddr is the remote address of a virtual device's connection, "<ip>:<port>"
+type virtualAddr string
+
+func (a virtualAddr) Network() string { return "virtual" }
+func (a virtualAddr) String() string  { return string(a) }
+
+// virtualConn is the server end of a pipe carrying the data of a virtual device, such as
+// a replay, with the device's address
+type virtualConn struct {
+	net.Conn
+	addr virtualAddr
+}
+
+func (c virtualConn) RemoteAddr() net.Addr { return c.addr }
+
+// StartReplay replays recorded segments as a virtual device. The device sends a handshake
+// and connects to the data ports of the segments, which then stream their data paced by
+// the segment times. The replay ends when all data has been sent or StopReplay is called.
+func (s *Server) StartReplay(opts ReplayOptions) (Replay, error) {
+	if opts.Speed < 0 {
+		return Replay{}, fmt.Errorf("replay speed must not be negative")
+	}
+	if len(opts.Paths) == 0 {
+		return Replay{}, fmt.Errorf("no files given")
+	}
+	ports := make(map[int][]SegmentInfo)
+	source := ""
+	for _, path := range opts.Paths {
+		segment, err := ParseSegmentName(path)
+		if err != nil {
+			return Replay{}, err
+		}
+		if source != "" && segment.UUID != source {
+			return Replay{}, fmt.Errorf("cannot replay devices %s and %s together", source, segment.UUID)
+		}
+		source = segment.UUID
+		ports[segment.Port] = append(ports[segment.Port], segment)
+	}
+
+	// All ports are paced from the earliest recorded time
+	origin := time.Time{}
+	for _, segments := range ports {
+		sortSegments(segments)
+		span, err := segmentSpan(segments[0])
+		if err != nil {
+			return Replay{}, fmt.Errorf("failed to read %s: %v", segments[0].Path, err)
+		}
+		first := span.start
+		if first.IsZero() {
+			first = span.end
+		}
+		if origin.IsZero() || first.Before(origin) {
+			origin = first
+		}
+	}
+
+	s.replaysLock.Lock()
+	s.replayCount++
+	run := &replayRun{Replay: Replay{
+		ID:      fmt.Sprintf("%s%d", REPLAY_PREFIX, s.replayCount),
+		UUID:    opts.UUID,
+		Source:  source,
+		Speed:   opts.Speed,
+		Started: time.Now(),
+	}}
+	if run.UUID == "" {
+		run.UUID = REPLAY_PREFIX + source
+	}
+	for port := range ports {
+		run.Ports = append(run.Ports, port)
+	}
+	slices.Sort(run.Ports)
+	ctx, cancel := context.WithCancel(s.ctx)
+	run.cancel = cancel
+	s.replays[run.ID] = run
+	s.replaysLock.Unlock()
+
+	// Identify the virtual device before its data ports connect
+	if err := s.replayHandshake(run.Replay); err != nil {
+		cancel()
+		s.removeReplay(run.ID)
+		return Replay{}, err
+	}
+
+	var wg sync.WaitGroup
+	for port, segments := range ports {
+		client, server := net.Pipe()
+		s.acceptConnection(virtualConn{Conn: server, addr: virtualAddr(fmt.Sprintf("%s:%d", run.ID, port))}, port)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer client.Close()
+			stop := context.AfterFunc(ctx, func() { client.Close() })
+			defer stop()
+			if err := replaySegments(ctx, client, segments, origin, run.Started, opts.Speed); err != nil && ctx.Err() == nil {
+				logger.Errorf("Replay %s of port %d failed: %v\n", run.ID, port, err)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		s.removeReplay(run.ID)
+		logger.Infof("Replay %s of %s finished\n", run.ID, source)
+	}()
+
+	logger.Infof("Replaying %d files of %s as %s (%s) at speed %v\n",
+		len(opts.Paths), source, run.ID, run.UUID, opts.Speed)
+	return run.Replay, nil
+}
+
+// replayHandshake sends the handshake of a replay device
+func (s *Server) replayHandshake(replay Replay) error {
+	message, err := json.Marshal(map[string]string{
+		"uuid":     replay.UUID,
+		"firmware": "replay",
+		"hardware": "replay of " + replay.Source,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode replay handshake: %v", err)
+	}
+	client, server := net.Pipe()
+	go func() {
+		client.Write(message)
+		client.Close()
+	}()
+	addr := virtualAddr(fmt.Sprintf("%s:%d", replay.ID, s.settings().HandshakePort))
+	s.HandleHandshakeConnection(virtualConn{Conn: server, addr: addr})
+	return nil
+}
+
+// replaySegments writes the data of the segments of one port to conn. With a speed above
+// zero each chunk is written when its time since origin, divided by speed, has passed
+// since started.
+func replaySegments(ctx context.Context, conn net.Conn, segments []SegmentInfo, origin time.Time, started time.Time, speed float64) error {
+	lastEnd := time.Time{}
+	for _, segment := range segments {
+		span, err := segmentSpan(segment)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", segment.Path, err)
+		}
+		data, err := ReadSegment(segment.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", segment.Path, err)
+		}
+		// Compressed segments start where the previous one ended, see historyReader
+		start := span.start
+		if start.IsZero() {
+			start = lastEnd
+			if start.IsZero() || start.After(span.end) {
+				start = span.end
+			}
+		}
+		lastEnd = span.end
+
+		for offset := 0; offset < len(data); offset += REPLAY_CHUNK_SIZE {
+			chunk := data[offset:min(offset+REPLAY_CHUNK_SIZE, len(data))]
+			if speed > 0 {
+				progress := float64(offset+len(chunk)) / float64(len(data))
+				recorded := start.Add(time.Duration(progress * float64(span.end.Sub(start))))
+				due := started.Add(time.Duration(float64(recorded.Sub(origin)) / speed))
+				select {
+				case <-time.After(time.Until(due)):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if _, err := conn.Write(chunk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeReplay forgets a finished or stopped replay
+func (s *Server) removeReplay(id string) {
+	s.replaysLock.Lock()
+	defer s.replaysLock.Unlock()
+	delete(s.replays, id)
+}
+
+// StopReplay stops a replay, its virtual device disconnects
+func (s *Server) StopReplay(id string) error {
+	s.replaysLock.Lock()
+	defer s.replaysLock.Unlock()
+	run, exists := s.replays[id]
+	if !exists {
+		return fmt.Errorf("no replay %s", id)
+	}
+	run.cancel()
+	return nil
+}
+
+// GetReplays returns the running replays
+func (s *Server) GetReplays() []Replay {
+	s.replaysLock.Lock()
+	defer s.replaysLock.Unlock()
+	replays := make([]Replay, 0, len(s.replays))
+	for _, run := range s.replays {
+		replays = append(replays, run.Replay)
+	}
+	sort.Slice(replays, func(i, j int) bool { return replays[i].Started.Before(replays[j].Started) })
+	return replays
+}
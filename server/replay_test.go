@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReplay tests that a replayed capture is processed and recorded like the data of a
+// connected device
+func TestReplay(t *testing.T) {
+	s := newDerivedTestServer(t, "vds", nil, nil)
+	dir := t.TempDir()
+	paths := []string{
+		writeSegment(t, dir, 5556, 1, []uint16{1, 2, 3}),
+		writeSegment(t, dir, 5556, 2, []uint16{4, 5}),
+	}
+
+	replay, err := s.StartReplay(ReplayOptions{Paths: paths})
+	if err != nil {
+		t.Fatalf("StartReplay() = %v", err)
+	}
+	if replay.UUID != "replay-dev1" || replay.Source != "dev1" || len(replay.Ports) != 1 {
+		t.Errorf("StartReplay() = %+v", replay)
+	}
+	for i := 0; i < 100 && len(s.GetReplays()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if replays := s.GetReplays(); len(replays) != 0 {
+		t.Fatalf("Replay still running: %+v", replays)
+	}
+	if err := s.Stop(2 * time.Second); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+
+	recorded, _ := filepath.Glob(filepath.Join(s.settings().DataDir, "port5556_replay-1_replay-dev1_*"))
+	var data []byte
+	for _, path := range recorded {
+		if filepath.Ext(path) == CHECKSUM_SUFFIX {
+			continue
+		}
+		segment, err := ReadSegment(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, segment...)
+	}
+	want := []byte{}
+	for _, path := range paths {
+		segment, _ := os.ReadFile(path)
+		want = append(want, segment...)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("Recorded %v from %v, want %v", data, recorded, want)
+	}
+
+	if _, err := s.StartReplay(ReplayOptions{Paths: paths, Speed: -1}); err == nil {
+		t.Error("Expected error for a negative speed, got nil")
+	}
+}
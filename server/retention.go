@@ -0,0 +1,88 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"eth-daq-software/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	RETENTION_CHECK_INTERVAL = time.Hour // How often expired segments are deleted
+)
+
+// isSegmentFile reports whether a file has the extension of a segment, raw or written
+// with a registered codec
+func isSegmentFile(name string) bool {
+	return compress.IsSegmentExtension(filepath.Ext(name))
+}
+
+// pruneSegments deletes the segments in dataDir last modified before cutoff and returns
+// the number of deleted files. Files not written by the segment writer are left alone.
+func pruneSegments(dataDir string, cutoff time.Time) (int, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "port") || !isSegmentFile(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dataDir, name)); err != nil {
+			logger.Errorf("Failed to delete expired segment %s: %v\n", name, err)
+			continue
+		}
+		os.Remove(filepath.Join(dataDir, name+CHECKSUM_SUFFIX))
+		os.Remove(filepath.Join(dataDir, name+TIMESTAMP_SUFFIX))
+		deleted++
+	}
+	return deleted, nil
+}
+
+// enforceRetention deletes the segments older than the configured retention period
+func (s *Server) enforceRetention() {
+	cfg := s.settings()
+	if cfg.RetentionDays <= 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -cfg.RetentionDays)
+	deleted, err := pruneSegments(cfg.DataDir, cutoff)
+	if err != nil {
+		logger.Errorf("Failed to apply retention to %s: %v\n", cfg.DataDir, err)
+		return
+	}
+	if deleted > 0 {
+		logger.InfoFields("Expired segments deleted", logger.Fields{
+			"dir":            cfg.DataDir,
+			"files":          deleted,
+			"retention_days": cfg.RetentionDays,
+		})
+	}
+}
+
+// StartRetention deletes expired segments now and every RETENTION_CHECK_INTERVAL until
+// the server is stopped
+func (s *Server) StartRetention() {
+	go func() {
+		s.enforceRetention()
+		ticker := time.NewTicker(RETENTION_CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.enforceRetention()
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPruneSegments tests that only expired segment files are deleted
+func TestPruneSegments(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+
+	files := []struct {
+		name    string
+		modTime time.Time
+		deleted bool
+	}{
+		{"port5555_10_0_0_2_abc_1.rle4", old, true},
+		{"port5556_10_0_0_2_abc_2.bin", old, true},
+		{"port5555_10_0_0_2_abc_3.rle4", now, false},
+		{"calibration.json", old, false},   // Not a segment
+		{"port5557_notes.txt", old, false}, // Not a segment
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		os.WriteFile(path, []byte{1, 2}, 0644)
+		os.Chtimes(path, file.modTime, file.modTime)
+	}
+
+	deleted, err := pruneSegments(dir, now.AddDate(0, 0, -7))
+	if err != nil || deleted != 2 {
+		t.Fatalf("pruneSegments() = %d, %v, want 2, nil", deleted, err)
+	}
+	for _, file := range files {
+		_, err := os.Stat(filepath.Join(dir, file.name))
+		if os.IsNotExist(err) != file.deleted {
+			t.Errorf("%s: deleted = %v, want %v", file.name, os.IsNotExist(err), file.deleted)
+		}
+	}
+}
+
+// TestCircularBufferResize tests that resizing keeps the most recent values
+func TestCircularBufferResize(t *testing.T) {
+	cb := NewCircularBuffer(4)
+	for i := 1; i <= 6; i++ {
+		cb.Add(float64(i)) // Holds 3, 4, 5, 6
+	}
+
+	if avg := cb.Resize(2).GetAverage(); avg != 5.5 {
+		t.Errorf("Shrunk average = %v, want 5.5", avg)
+	}
+	grown := cb.Resize(8)
+	if avg := grown.GetAverage(); avg != 4.5 || grown.GetCount() != 4 || grown.IsFullOnce() {
+		t.Errorf("Grown average = %v, count %d, want 4.5 with 4 values", avg, grown.GetCount())
+	}
+}
+
+// TestCircularBufferEMA tests that the EMA starts at the first value and is kept across a resize
+func TestCircularBufferEMA(t *testing.T) {
+	cb := NewCircularBuffer(2)
+	cb.SetAlpha(0.5)
+	for _, value := range []float64{4, 8, 0, 2} {
+		cb.Add(value) // EMA 4, 6, 3, 2.5
+	}
+	if ema := cb.GetStatistic(config.STATISTIC_EMA); ema != 2.5 {
+		t.Errorf("EMA = %v, want 2.5", ema)
+	}
+	if mean := cb.GetStatistic(config.STATISTIC_MEAN); mean != 1 {
+		t.Errorf("Mean = %v, want 1", mean)
+	}
+	resized := cb.Resize(4)
+	resized.Add(4.5)
+	if ema := resized.GetEMA(); ema != 3.5 {
+		t.Errorf("EMA after resize = %v, want 3.5", ema)
+	}
+}
+
+// TestCircularBufferMedian tests that the median follows the window and ignores a spike
+func TestCircularBufferMedian(t *testing.T) {
+	cb := NewCircularBuffer(3)
+	cb.Add(7) // Added before the median is tracked
+	cb.TrackMedian(true)
+	for _, value := range []float64{1, 1000, 2} {
+		cb.Add(value) // Holds 1, 1000, 2
+	}
+	if median := cb.GetStatistic(config.STATISTIC_MEDIAN); median != 2 {
+		t.Errorf("Median = %v, want 2", median)
+	}
+	resized := cb.Resize(2) // Holds 1000, 2
+	if median := resized.GetMedian(); median != 501 {
+		t.Errorf("Median after resize = %v, want 501", median)
+	}
+	cb.TrackMedian(false)
+	if median := cb.GetMedian(); median != 0 {
+		t.Errorf("Median when not tracked = %v, want 0", median)
+	}
+}
+
+// TestCircularBufferReject tests that a glitch is rejected and a lasting step accepted
+func TestCircularBufferReject(t *testing.T) {
+	cb := NewCircularBuffer(4)
+	cb.SetOutlierSigma(3)
+	for _, value := range []float64{1, 1.1, 0.9, 1} {
+		if cb.Reject(value) {
+			t.Fatalf("Reject(%v) = true before the buffer is full", value)
+		}
+		cb.Add(value)
+	}
+	if cb.Reject(1.05) || !cb.Reject(50) {
+		t.Error("Want 1.05 accepted and 50 rejected")
+	}
+	rejected := 1
+	for cb.Reject(5) {
+		rejected++
+	}
+	if rejected != 4 {
+		t.Errorf("Rejected %d values, want the step accepted after 4", rejected)
+	}
+}
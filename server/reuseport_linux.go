@@ -0,0 +1,24 @@
+//go:build linux
+
+package server
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// REUSE_PORT_SUPPORTED reports whether several sockets can listen on one port with the
+// kernel spreading new connections across them
+const REUSE_PORT_SUPPORTED = true
+
+// reusePort sets SO_REUSEPORT on a socket before it is bound
+func reusePort(network string, address string, conn syscall.RawConn) error {
+	var err error
+	if controlErr := conn.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package server
+
+import (
+	"syscall"
+)
+
+// REUSE_PORT_SUPPORTED reports whether several sockets can listen on one port with the
+// kernel spreading new connections across them
+const REUSE_PORT_SUPPORTED = false
+
+// reusePort is not used where SO_REUSEPORT does not balance connections
+func reusePort(network string, address string, conn syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"eth-daq-software/config"
+	"eth-daq-software/logger"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SEGMENT_WRITE_BUFFER      = 256 * 1024    // Size of the write buffer of appended segments
+	PARTIAL_SEGMENT_SUFFIX    = ".part"       // Appended to the name of a segment while it is written
+	INCOMPLETE_SEGMENT_SUFFIX = ".incomplete" // Replaces PARTIAL_SEGMENT_SUFFIX of segments left by a crash
+)
+
+// appendSegment is an uncompressed segment that received data is appended to. It is
+// opened on the first data and closed when it reaches the flush size or age, when the
+// device name or directory changes, or on flush. The file has PARTIAL_SEGMENT_SUFFIX
+// appended until it is closed.
+type appendSegment struct {
+	file       *os.File
+	path       string
+	dir        string
+	uuid       string
+	alias      string
+	size       int
+	opened     time.Time
+	hash       hash.Hash      // Checksum of the data written so far, nil without checksums
+	record     *SegmentRecord // Segment manifest entry of a session segment, nil outside sessions
+	timestamps *os.File       // Sidecar the timestamp records are appended to, nil before the first
+}
+
+// appendData appends data to the open segment, rolling over to a new segment if needed.
+// The caller must hold db.mu.
+func (db *DataBuffer) appendData(data []byte) error {
+	dir := db.sessionDir
+	if dir == "" {
+		dir = db.writer.directory()
+	}
+
+	segment := db.segment
+	if segment != nil && (segment.dir != dir || segment.uuid != db.uuid || segment.alias != db.alias) {
+		if err := db.closeSegment(); err != nil {
+			logger.Errorf("Failed to close segment %s: %v\n", segment.path, err)
+		}
+		segment = nil
+	}
+	if segment == nil {
+		var err error
+		if segment, err = db.openSegment(dir); err != nil {
+			return err
+		}
+	}
+
+	timestamp, timestamped := db.timestamp(segment.size, db.lastData)
+	if _, err := db.appendWriter.Write(data); err != nil {
+		db.closeSegment()
+		return fmt.Errorf("failed to write %s: %v", segment.path, err)
+	}
+	if segment.hash != nil {
+		segment.hash.Write(data)
+	}
+	if timestamped {
+		if err := segment.appendTimestamp(timestamp); err != nil {
+			logger.Errorf("%v\n", err)
+		}
+	}
+	segment.size += len(data)
+	db.storedBytes += int64(len(data))
+	if db.durability == config.DURABILITY_FLUSH || db.durability == config.DURABILITY_FSYNC {
+		// Hand the data to the operating system, so that it survives a crash of the application
+		if err := db.appendWriter.Flush(); err != nil {
+			db.closeSegment()
+			return fmt.Errorf("failed to write %s: %v", segment.path, err)
+		}
+	}
+
+	if segment.size >= db.flushSize || (db.flushInterval > 0 && time.Since(segment.opened) >= db.flushInterval) {
+		return db.closeSegment()
+	}
+	return nil
+}
+
+// openSegment creates a new segment in dir. The caller must hold db.mu.
+func (db *DataBuffer) openSegment(dir string) (*appendSegment, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	now := time.Now()
+	name := db.segmentName(now)
+	var record *SegmentRecord
+	if db.sessionDir != "" && dir == db.sessionDir {
+		record = db.segmentRecord(dir)
+		record.Start = now
+		name = record.Name
+	}
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path+PARTIAL_SEGMENT_SUFFIX, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %v", err)
+	}
+
+	if db.appendWriter == nil {
+		db.appendWriter = bufio.NewWriterSize(file, SEGMENT_WRITE_BUFFER)
+	} else {
+		db.appendWriter.Reset(file)
+	}
+	db.segment = &appendSegment{
+		file:   file,
+		path:   path,
+		dir:    dir,
+		uuid:   db.uuid,
+		alias:  db.alias,
+		opened: now,
+		record: record,
+	}
+	if db.writer.checksums.Load() {
+		db.segment.hash = sha256.New()
+	}
+	return db.segment, nil
+}
+
+// closeSegment writes out and closes the open segment, if any. The caller must hold db.mu.
+func (db *DataBuffer) closeSegment() error {
+	segment := db.segment
+	if segment == nil {
+		return nil
+	}
+	db.segment = nil
+
+	sync := db.durability == config.DURABILITY_FSYNC
+	err := db.appendWriter.Flush()
+	db.appendWriter.Reset(nil)
+	if err == nil && sync {
+		err = segment.file.Sync()
+	}
+	if closeErr := segment.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(segment.path+PARTIAL_SEGMENT_SUFFIX, segment.path)
+	}
+	if file := segment.timestamps; file != nil {
+		if sync {
+			file.Sync()
+		}
+		file.Close()
+		if err == nil {
+			if renameErr := os.Rename(file.Name(), segment.path+TIMESTAMP_SUFFIX); renameErr != nil {
+				logger.Errorf("Failed to write timestamps of %s: %v\n", segment.path, renameErr)
+			}
+		}
+	}
+	if err == nil && sync {
+		syncDir(segment.dir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", segment.path, err)
+	}
+	if segment.hash != nil {
+		if err := writeChecksum(segment.path, segment.hash.Sum(nil)); err != nil {
+			return err
+		}
+	}
+	if record := segment.record; record != nil {
+		record.Bytes, record.Size = int64(segment.size), int64(segment.size)
+		record.Samples = db.frames(segment.size)
+		record.End = time.Now()
+		if err := db.writer.addToManifest(segment.dir, *record); err != nil {
+			return err
+		}
+	}
+
+	key := BufferKey{IP: db.clientIP, Port: db.port}
+	db.writer.record(key, nil, segment.size, segment.size, 0)
+	logger.InfoFields("Segment written", logger.Fields{
+		"file":  filepath.Base(segment.path),
+		"uuid":  segment.uuid,
+		"ip":    db.clientIP,
+		"port":  db.port,
+		"bytes": segment.size,
+	})
+	return nil
+}
+
+// finishSegment closes the open segment so that later data starts a new one
+func (db *DataBuffer) finishSegment() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.closeSegment()
+}
+
+// segmentName returns the file name of a segment flushed or opened at t outside of a
+// session. Named devices get their alias appended after a '+'. Segments of sessions are
+// numbered instead, see segmentRecord.
+func (db *DataBuffer) segmentName(t time.Time) string {
+	alias := ""
+	if db.alias != "" {
+		alias = "+" + aliasFilename(db.alias)
+	}
+	return fmt.Sprintf("port%d_%s_%s_%d%s%s",
+		db.port,
+		db.clientIP,
+		db.uuid, // Include UUID in the filename
+		t.UnixNano(),
+		alias,
+		segmentExtension(db.codec),
+	)
+}
+
+// segmentRecord numbers the next segment of the buffer's port in a session directory and
+// returns its manifest entry. The caller must hold db.mu.
+func (db *DataBuffer) segmentRecord(dir string) *SegmentRecord {
+	writer := db.writer
+	if writer == nil {
+		writer = fallbackWriter()
+	}
+	sequence := writer.nextSegment(dir, db.port)
+	return &SegmentRecord{
+		Name:     numberedSegmentName(db.port, sequence, segmentExtension(db.codec)),
+		Port:     db.port,
+		Sequence: sequence,
+		IP:       db.clientIP,
+		UUID:     db.uuid,
+	}
+}
+
+// frames returns the number of samples per channel in bytes of received data
+func (db *DataBuffer) frames(bytes int) int64 {
+	decoder := db.samples.decoder
+	return int64(bytes / (decoder.SampleWidth() * len(decoder.Channels())))
+}
+
+// writeSegmentFile writes a segment under a temporary name and renames it once complete,
+// so that a crash never leaves a truncated segment under a segment name. With sync the
+// segment is on disk when writeSegmentFile returns.
+func writeSegmentFile(path string, data []byte, sync bool) error {
+	partial := path + PARTIAL_SEGMENT_SUFFIX
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil && sync {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return err
+	}
+	if sync {
+		syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+// syncDir syncs a directory so that renames in it survive power loss. Windows cannot sync
+// directories, where this does nothing.
+func syncDir(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	if file, err := os.Open(dir); err == nil {
+		file.Sync()
+		file.Close()
+	}
+}
+
+// markIncompleteSegments renames the partial segments in dataDir and its session
+// directories to INCOMPLETE_SEGMENT_SUFFIX. They were being written when the application
+// stopped and may be truncated. Returns the number of renamed segments.
+func markIncompleteSegments(dataDir string) (int, error) {
+	marked := 0
+	err := filepath.WalkDir(dataDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, PARTIAL_SEGMENT_SUFFIX) {
+			return nil
+		}
+		incomplete := strings.TrimSuffix(path, PARTIAL_SEGMENT_SUFFIX) + INCOMPLETE_SEGMENT_SUFFIX
+		if err := os.Rename(path, incomplete); err != nil {
+			return err
+		}
+		logger.Errorf("Segment %s was not completed, renamed to %s\n", path, filepath.Base(incomplete))
+		marked++
+		return nil
+	})
+	return marked, err
+}
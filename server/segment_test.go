@@ -0,0 +1,121 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// TestAppendSegment tests that uncompressed data is appended to segments that roll over
+// at the flush size and when the device is named
+func TestAppendSegment(t *testing.T) {
+	dir := t.TempDir()
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1000)
+	buffer.writer = newSegmentWriter(1, dir)
+
+	buffer.AddData(make([]byte, 600))
+	buffer.AddData(make([]byte, 600)) // Reaches the flush size
+	buffer.AddData(make([]byte, 100))
+	buffer.setAlias("DUT-3")
+	buffer.AddData(make([]byte, 200))
+	if err := buffer.FlushSync(); err != nil {
+		t.Fatalf("FlushSync() = %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var sizes []int
+	aliased := 0
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		sizes = append(sizes, int(info.Size()))
+		if segment, err := ParseSegmentName(entry.Name()); err == nil && segment.Alias == "DUT-3" {
+			aliased++
+		}
+	}
+	sort.Ints(sizes)
+	if len(sizes) != 3 || sizes[0] != 100 || sizes[1] != 200 || sizes[2] != 1200 {
+		t.Errorf("Segment sizes = %v, want [100 200 1200]", sizes)
+	}
+	if aliased != 1 {
+		t.Errorf("Got %d segments named after the alias, want 1", aliased)
+	}
+}
+
+// TestFlushWithoutWriter tests that buffers created without a writer write their
+// segments synchronously
+func TestFlushWithoutWriter(t *testing.T) {
+	dir := t.TempDir()
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 100)
+	buffer.sessionDir = dir
+
+	buffer.AddData(make([]byte, 150)) // Reaches the flush size, flushed by FlushAsync
+	buffer.AddData(make([]byte, 50))
+	if err := buffer.FlushSync(); err != nil {
+		t.Fatalf("FlushSync() = %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var sizes []int
+	for _, entry := range entries {
+		if entry.Name() == SEGMENT_MANIFEST {
+			continue
+		}
+		info, _ := entry.Info()
+		sizes = append(sizes, int(info.Size()))
+	}
+	sort.Ints(sizes)
+	if len(sizes) != 2 || sizes[0] != 50 || sizes[1] != 150 {
+		t.Errorf("Segment sizes = %v, want [50 150]", sizes)
+	}
+}
+
+// TestIncompleteSegments tests that an open segment has a temporary name and that
+// segments left partial are marked incomplete on startup
+func TestIncompleteSegments(t *testing.T) {
+	dir := t.TempDir()
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1000)
+	buffer.writer = newSegmentWriter(1, dir)
+	buffer.AddData(make([]byte, 100))
+
+	partial, _ := filepath.Glob(filepath.Join(dir, "*"+PARTIAL_SEGMENT_SUFFIX))
+	if len(partial) != 1 {
+		t.Fatalf("Partial segments = %v, want one while it is open", partial)
+	}
+	// The application stops without closing the segment
+	buffer.segment.file.Close()
+	buffer.segment = nil
+
+	marked, err := markIncompleteSegments(dir)
+	if err != nil || marked != 1 {
+		t.Fatalf("markIncompleteSegments() = %d, %v, want 1", marked, err)
+	}
+	incomplete, _ := filepath.Glob(filepath.Join(dir, "*.bin"+INCOMPLETE_SEGMENT_SUFFIX))
+	if len(incomplete) != 1 {
+		t.Errorf("Incomplete segments = %v, want one", incomplete)
+	}
+}
+
+// TestAppendDurability tests that the flush durability hands every chunk to the operating
+// system while the default buffers it
+func TestAppendDurability(t *testing.T) {
+	for durability, want := range map[string]int64{config.DURABILITY_NONE: 0, config.DURABILITY_FLUSH: 100, config.DURABILITY_FSYNC: 100} {
+		dir := t.TempDir()
+		buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1000)
+		buffer.writer = newSegmentWriter(1, dir)
+		buffer.durability = durability
+		buffer.AddData(make([]byte, 100))
+
+		info, err := os.Stat(buffer.segment.path + PARTIAL_SEGMENT_SUFFIX)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != want {
+			t.Errorf("%s: open segment holds %d bytes, want %d", durability, info.Size(), want)
+		}
+		if err := buffer.FlushSync(); err != nil {
+			t.Errorf("%s: FlushSync() = %v", durability, err)
+		}
+	}
+}
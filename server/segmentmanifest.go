@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SEGMENT_MANIFEST = "segments.json" // Segments of a session with their sizes and time bounds
+)
+
+// SegmentRecord describes a segment of a session in its segment manifest. Segments of a
+// session are named port<port>_<sequence> plus the extension of their codec, numbered from
+// 1 per port in capture order.
+type SegmentRecord struct {
+	Name     string
+	Port     int
+	Sequence int
+	IP       string
+	UUID     string
+	Bytes    int64     // Raw bytes captured
+	Size     int64     // Bytes of the file, after compression
+	Samples  int64     // Samples per channel
+	Start    time.Time // Time the first byte was received
+	End      time.Time // Time the segment was flushed or closed
+}
+
+// SegmentManifest lists the written segments of a session by port and sequence number
+type SegmentManifest struct {
+	Segments []SegmentRecord
+}
+
+// ReadSegmentManifest reads the segment manifest of a session directory. A session
+// without one, recorded before segments were numbered, has an empty manifest.
+func ReadSegmentManifest(dir string) (SegmentManifest, error) {
+	var manifest SegmentManifest
+	data, err := os.ReadFile(filepath.Join(dir, SEGMENT_MANIFEST))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to read %s: %v", filepath.Join(dir, SEGMENT_MANIFEST), err)
+	}
+	return manifest, nil
+}
+
+// numberedSegmentName returns the name of segment sequence of a port in a session
+func numberedSegmentName(port int, sequence int, extension string) string {
+	return fmt.Sprintf("port%d_%06d%s", port, sequence, extension)
+}
+
+// parseNumberedSegmentName returns the port and sequence number of a session segment name
+// without its extension, false for names of the form port<port>_<ip>_<uuid>_<unixnano>
+func parseNumberedSegmentName(name string) (int, int, bool) {
+	port, sequence, found := strings.Cut(strings.TrimPrefix(name, "port"), "_")
+	if !found || strings.Contains(sequence, "_") {
+		return 0, 0, false
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(sequence)
+	if err != nil || n <= 0 {
+		return 0, 0, false
+	}
+	return p, n, true
+}
+
+// sessionManifest is the segment manifest of a session being written and the next
+// sequence number of each port
+type sessionManifest struct {
+	manifest SegmentManifest
+	next     map[int]int
+}
+
+// loadSessionManifest reads the manifest of a session directory, so that numbering
+// continues after the segments already written
+func loadSessionManifest(dir string) *sessionManifest {
+	manifest, _ := ReadSegmentManifest(dir)
+	session := &sessionManifest{manifest: manifest, next: make(map[int]int)}
+	for _, record := range manifest.Segments {
+		session.next[record.Port] = max(session.next[record.Port], record.Sequence)
+	}
+	return session
+}
+
+// nextSegment returns the next sequence number of a port in a session directory
+func (w *segmentWriter) nextSegment(dir string, port int) int {
+	w.manifestsLock.Lock()
+	defer w.manifestsLock.Unlock()
+	session, exists := w.manifests[dir]
+	if !exists {
+		session = loadSessionManifest(dir)
+		w.manifests[dir] = session
+	}
+	session.next[port]++
+	return session.next[port]
+}
+
+// addToManifest adds a written segment to the manifest of its session directory. Segments
+// of a stopped session update the manifest on disk.
+func (w *segmentWriter) addToManifest(dir string, record SegmentRecord) error {
+	w.manifestsLock.Lock()
+	defer w.manifestsLock.Unlock()
+	session, exists := w.manifests[dir]
+	if !exists {
+		session = loadSessionManifest(dir)
+	}
+	// Segments are written by several workers, keep them in capture order
+	segments := append(session.manifest.Segments, record)
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].Port != segments[j].Port {
+			return segments[i].Port < segments[j].Port
+		}
+		return segments[i].Sequence < segments[j].Sequence
+	})
+	session.manifest.Segments = segments
+
+	data, err := json.MarshalIndent(session.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode segment manifest: %v", err)
+	}
+	if err := writeSegmentFile(filepath.Join(dir, SEGMENT_MANIFEST), data, false); err != nil {
+		return fmt.Errorf("failed to write segment manifest: %v", err)
+	}
+	return nil
+}
+
+// closeManifest forgets the manifest of a stopped session
+func (w *segmentWriter) closeManifest(dir string) {
+	w.manifestsLock.Lock()
+	defer w.manifestsLock.Unlock()
+	delete(w.manifests, dir)
+}
+
+// manifestCache holds the segment manifests read to parse numbered segment names, until
+// the manifest file changes
+var (
+	manifestCache     = make(map[string]cachedManifest)
+	manifestCacheLock sync.Mutex
+)
+
+type cachedManifest struct {
+	modTime time.Time
+	size    int64
+	records map[string]SegmentRecord // By file name
+}
+
+// lookupSegment returns the manifest record of a numbered session segment
+func lookupSegment(path string) (SegmentRecord, error) {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(filepath.Join(dir, SEGMENT_MANIFEST))
+	if err != nil {
+		return SegmentRecord{}, fmt.Errorf("no segment manifest for %s", path)
+	}
+
+	manifestCacheLock.Lock()
+	defer manifestCacheLock.Unlock()
+	cached, exists := manifestCache[dir]
+	if !exists || !cached.modTime.Equal(info.ModTime()) || cached.size != info.Size() {
+		manifest, err := ReadSegmentManifest(dir)
+		if err != nil {
+			return SegmentRecord{}, err
+		}
+		cached = cachedManifest{modTime: info.ModTime(), size: info.Size(), records: make(map[string]SegmentRecord)}
+		for _, record := range manifest.Segments {
+			cached.records[record.Name] = record
+		}
+		manifestCache[dir] = cached
+	}
+	record, exists := cached.records[filepath.Base(path)]
+	if !exists {
+		return SegmentRecord{}, fmt.Errorf("segment %s is not in the manifest of its session", path)
+	}
+	return record, nil
+}
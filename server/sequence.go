@@ -0,0 +1,115 @@
+package server
+
+import (
+	"eth-daq-software/logger"
+	"fmt"
+	"time"
+)
+
+// SequenceStats counts the frames of a channel that did not follow the previous frame.
+// They are counted from the frame sequence numbers of the framed data protocol, channels
+// of devices without sequence numbers report no frames.
+type SequenceStats struct {
+	Frames     int64 // Frames with a sequence number received
+	Gaps       int64 // Jumps over missing frames
+	Missing    int64 // Frames missing in the gaps
+	Duplicates int64 // Frames repeating the previous sequence number
+	OutOfOrder int64 // Frames older than the previous frame
+}
+
+// SequenceGap is a run of frames missing from a channel, written to the session manifest
+type SequenceGap struct {
+	Port  int
+	From  uint32    // Sequence number of the first missing frame
+	Count int64     // Number of missing frames
+	Time  time.Time // Time the frame after the gap was received
+}
+
+// sequenceTracker compares the sequence number of each frame with the previous one.
+// Sequence numbers wrap around, a frame less than half the number range ahead of the
+// previous one follows it and a frame further ahead is older.
+type sequenceTracker struct {
+	stats   SequenceStats
+	last    uint32
+	started bool
+	gaps    []SequenceGap // Gaps not yet written to a session manifest
+}
+
+// observe counts a frame, recording the gap before it if frames are missing
+func (t *sequenceTracker) observe(port int, sequence uint32, now time.Time) {
+	t.stats.Frames++
+	if !t.started {
+		t.started = true
+		t.last = sequence
+		return
+	}
+
+	switch ahead := sequence - t.last; {
+	case ahead == 0:
+		t.stats.Duplicates++
+	case ahead >= 1<<31:
+		t.stats.OutOfOrder++
+		return
+	case ahead > 1:
+		t.stats.Gaps++
+		t.stats.Missing += int64(ahead - 1)
+		t.gaps = append(t.gaps, SequenceGap{Port: port, From: t.last + 1, Count: int64(ahead - 1), Time: now})
+	}
+	t.last = sequence
+}
+
+// reset forgets the previous frame, as sequence numbers restart when a device reconnects
+func (t *sequenceTracker) reset() {
+	t.started = false
+}
+
+// observeSequence counts a received frame by its sequence number
+func (db *DataBuffer) observeSequence(sequence uint32) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.sequence.observe(db.port, sequence, time.Now())
+}
+
+// sequenceStats returns the frame counters of the buffer
+func (db *DataBuffer) sequenceStats() SequenceStats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.sequence.stats
+}
+
+// takeGaps returns the gaps detected since the last call
+func (db *DataBuffer) takeGaps() []SequenceGap {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	gaps := db.sequence.gaps
+	db.sequence.gaps = nil
+	return gaps
+}
+
+// recordGaps adds the gaps detected in a buffer to the active session of its device and
+// rewrites the session manifest. Gaps outside a session are only counted.
+func (s *Server) recordGaps(buffer *DataBuffer) {
+	gaps := buffer.takeGaps()
+	if len(gaps) == 0 {
+		return
+	}
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+	if session, exists := s.sessions[buffer.uuid]; exists {
+		session.Gaps = append(session.Gaps, gaps...)
+		if err := session.writeManifest(); err != nil {
+			logger.Errorf("Failed to record gaps of session %s: %v\n", session.ID, err)
+		}
+	}
+}
+
+// GetSequenceStats returns the frame counters of every live channel, keyed by "ip:port"
+func (s *Server) GetSequenceStats() map[string]SequenceStats {
+	s.buffersLock.RLock()
+	defer s.buffersLock.RUnlock()
+	stats := make(map[string]SequenceStats, len(s.buffers))
+	for key, buffer := range s.buffers {
+		stats[fmt.Sprintf("%s:%d", key.IP, key.Port)] = buffer.sequenceStats()
+	}
+	return stats
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSequenceTracker tests that gaps, duplicates and reordered frames are counted,
+// including across the wrap-around of the sequence numbers
+func TestSequenceTracker(t *testing.T) {
+	var tracker sequenceTracker
+	now := time.Now()
+	for _, sequence := range []uint32{1<<32 - 2, 1<<32 - 1, 0, 3, 3, 2, 4} {
+		tracker.observe(5556, sequence, now)
+	}
+
+	want := SequenceStats{Frames: 7, Gaps: 1, Missing: 2, Duplicates: 1, OutOfOrder: 1}
+	if tracker.stats != want {
+		t.Errorf("Stats = %+v, want %+v", tracker.stats, want)
+	}
+	if len(tracker.gaps) != 1 || tracker.gaps[0].From != 1 || tracker.gaps[0].Count != 2 {
+		t.Errorf("Gaps = %+v, want 2 frames from 1", tracker.gaps)
+	}
+
+	// A reconnected device starts counting again
+	tracker.reset()
+	tracker.observe(5556, 0, now)
+	if tracker.stats.Gaps != 1 || tracker.stats.OutOfOrder != 1 {
+		t.Errorf("Stats after reset = %+v", tracker.stats)
+	}
+}
+
+// TestSessionGaps tests that gaps detected while recording are written to the manifest
+func TestSessionGaps(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	s.registry = NewDeviceRegistry(filepath.Join(t.TempDir(), DEVICE_REGISTRY_FILE))
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+	s.buffers[BufferKey{IP: "10.0.0.2", Port: 5556}] = buffer
+
+	if _, err := s.StartRecording("dev1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, sequence := range []uint32{1, 2, 5} {
+		buffer.observeSequence(sequence)
+	}
+	if err := s.StopRecording("dev1"); err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, _ := filepath.Glob(filepath.Join(cfg.DataDir, "*", SESSION_MANIFEST))
+	if len(manifests) != 1 {
+		t.Fatalf("Found manifests %v, want one", manifests)
+	}
+	data, _ := os.ReadFile(manifests[0])
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		t.Fatal(err)
+	}
+	if gaps := session.Gaps; len(gaps) != 1 || gaps[0].Port != 5556 || gaps[0].From != 3 || gaps[0].Count != 2 {
+		t.Errorf("Session gaps = %+v, want 2 frames from 3", gaps)
+	}
+}
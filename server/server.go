@@ -1,19 +1,24 @@
 package server
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"bufio"
+	"context"
+	"errors"
 	"eth-daq-software/compress"
+	"eth-daq-software/config"
 	"eth-daq-software/logger"
 	"fmt"
 	"io"
 	"maps"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
-	"strconv"
+	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	// "eth-daq-software/logger"
@@ -21,26 +26,42 @@ import (
 	"github.com/davecgh/go-spew/spew"
 )
 
-const (
-	BUFFER_SIZE = 10 * 1024 * 1024 // 10MB
-)
-
 // First, let's create a type for our composite key
 type BufferKey struct {
 	IP   string
 	Port int
+	// UUID is set for the parallel connection of a second device behind the same IP, see
+	// config.DUPLICATE_PARALLEL, and empty otherwise
+	UUID string
 }
 
 type IPConnection struct {
 	ActivePorts     map[int]bool
 	TotalBytes      int64
 	UUID            string // Add this field to store the device UUID
+	Alias           string // Name from the device registry, "" if unnamed
 	MAC             string
 	FirmwareVersion string
 	HardwareVersion string
 	VgsSampleRate   int
 	VdsSampleRate   int
 	TcSampleRate    int
+	SchemaVersion   int                // Handshake schema version, HANDSHAKE_SCHEMA_LEGACY if not sent
+	Channels        []HandshakeChannel // Channel map from the handshake, nil if not reported
+	Status          *DeviceStatus      // Latest status message, nil if the device sent none
+	Latency         *RoundTripStats    // Round trip times of pings on the handshake connection, nil if not pinged
+
+	// Bytes received, counted by the connection readers without taking connectedIPsLock.
+	// TotalBytes is filled from it in the copies returned to callers.
+	bytes *atomic.Int64
+}
+
+// totalBytes returns the bytes received from the IP
+func (c *IPConnection) totalBytes() int64 {
+	if c.bytes == nil {
+		return c.TotalBytes
+	}
+	return c.bytes.Load()
 }
 
 // CircularBuffer implements a fixed-size circular buffer for uint16 values
@@ -50,7 +71,13 @@ type CircularBuffer struct {
 	count      int       // Current number of elements in buffer (may be less than size)
 	head       int       // Index where the next element will be inserted
 	sum        float64   // Running sum of all elements in the buffer
+	sumSquares float64   // Running sum of the squares of all elements, for the standard deviation
 	isFullOnce bool      // Flag indicating if the buffer has been filled at least once
+	alpha      float64   // Weight of a new value in ema
+	ema        float64   // Exponential moving average of all values added
+	sorted     []float64 // Values in ascending order, nil unless the median is tracked
+	sigma      float64   // Outlier threshold in standard deviations, 0 rejects nothing
+	rejectRun  int       // Consecutive values rejected by Reject
 }
 
 // NewCircularBuffer creates a new circular buffer with the specified size
@@ -62,6 +89,7 @@ func NewCircularBuffer(size int) *CircularBuffer {
 		head:       0,
 		sum:        0,
 		isFullOnce: false,
+		alpha:      1,
 	}
 }
 
@@ -72,6 +100,11 @@ func (cb *CircularBuffer) Add(value float64) {
 		// Calculate the index of the value being replaced (the oldest value)
 		oldestIdx := cb.head
 		cb.sum -= cb.data[oldestIdx]
+		cb.sumSquares -= cb.data[oldestIdx] * cb.data[oldestIdx]
+		if cb.sorted != nil {
+			i, _ := slices.BinarySearch(cb.sorted, cb.data[oldestIdx])
+			cb.sorted = slices.Delete(cb.sorted, i, i+1)
+		}
 	} else {
 		// Buffer isn't full yet, so increment count
 		cb.count++
@@ -80,6 +113,16 @@ func (cb *CircularBuffer) Add(value float64) {
 	// Add the new value to the buffer
 	cb.data[cb.head] = value
 	cb.sum += value
+	cb.sumSquares += value * value
+	if cb.count == 1 && !cb.isFullOnce {
+		cb.ema = value
+	} else {
+		cb.ema += cb.alpha * (value - cb.ema)
+	}
+	if cb.sorted != nil {
+		i, _ := slices.BinarySearch(cb.sorted, value)
+		cb.sorted = slices.Insert(cb.sorted, i, value)
+	}
 
 	// Move the head to the next position
 	cb.head = (cb.head + 1) % cb.size
@@ -98,6 +141,83 @@ func (cb *CircularBuffer) GetAverage() float64 {
 	return float64(cb.sum) / float64(cb.count)
 }
 
+// SetAlpha sets the weight of new values in the exponential moving average, 1 until set
+func (cb *CircularBuffer) SetAlpha(alpha float64) {
+	cb.alpha = alpha
+}
+
+// GetEMA returns the exponential moving average of the values added. Unlike the average
+// it is not limited to the values in the buffer.
+func (cb *CircularBuffer) GetEMA() float64 {
+	return cb.ema
+}
+
+// TrackMedian starts or stops keeping the values in order for GetMedian, which costs a
+// copy of the buffer and an insertion into it per value
+func (cb *CircularBuffer) TrackMedian(track bool) {
+	if !track {
+		cb.sorted = nil
+		return
+	}
+	if cb.sorted != nil {
+		return
+	}
+	cb.sorted = make([]float64, 0, cb.size)
+	oldest := cb.head - cb.count + cb.size
+	for i := 0; i < cb.count; i++ {
+		cb.sorted = append(cb.sorted, cb.data[(oldest+i)%cb.size])
+	}
+	slices.Sort(cb.sorted)
+}
+
+// GetMedian returns the median of the values in the buffer, 0 unless the median is tracked
+func (cb *CircularBuffer) GetMedian() float64 {
+	n := len(cb.sorted)
+	if n == 0 {
+		return 0.0
+	}
+	if n%2 == 1 {
+		return cb.sorted[n/2]
+	}
+	return (cb.sorted[n/2-1] + cb.sorted[n/2]) / 2
+}
+
+// GetStatistic returns the config.STATISTIC_* statistic of the values
+func (cb *CircularBuffer) GetStatistic(statistic string) float64 {
+	switch statistic {
+	case config.STATISTIC_EMA:
+		return cb.GetEMA()
+	case config.STATISTIC_MEDIAN:
+		return cb.GetMedian()
+	}
+	return cb.GetAverage()
+}
+
+// SetOutlierSigma sets the distance from the mean, in standard deviations, beyond which
+// Reject rejects a value. 0 rejects nothing.
+func (cb *CircularBuffer) SetOutlierSigma(sigma float64) {
+	cb.sigma = sigma
+	cb.rejectRun = 0
+}
+
+// Reject reports whether a value is an outlier to be left out of the buffer: further than
+// the outlier threshold from the mean of a full buffer. After a buffer size of consecutive
+// rejections values are accepted again, as the signal has moved rather than glitched.
+func (cb *CircularBuffer) Reject(value float64) bool {
+	if cb.sigma == 0 || !cb.isFullOnce || cb.rejectRun >= cb.size {
+		cb.rejectRun = 0
+		return false
+	}
+	mean := cb.GetAverage()
+	variance := max(cb.sumSquares/float64(cb.count)-mean*mean, 0)
+	if math.Abs(value-mean) <= cb.sigma*math.Sqrt(variance) {
+		cb.rejectRun = 0
+		return false
+	}
+	cb.rejectRun++
+	return true
+}
+
 // IsFull returns true if the buffer is at capacity
 func (cb *CircularBuffer) IsFull() bool {
 	return cb.count == cb.size
@@ -108,6 +228,18 @@ func (cb *CircularBuffer) IsFullOnce() bool {
 	return cb.isFullOnce
 }
 
+// Resize returns a buffer of the new size holding the most recent values of this buffer
+func (cb *CircularBuffer) Resize(size int) *CircularBuffer {
+	resized := NewCircularBuffer(size)
+	resized.TrackMedian(cb.sorted != nil)
+	oldest := cb.head - cb.count + cb.size
+	for i := max(cb.count-size, 0); i < cb.count; i++ {
+		resized.Add(cb.data[(oldest+i)%cb.size])
+	}
+	resized.alpha, resized.ema, resized.sigma = cb.alpha, cb.ema, cb.sigma
+	return resized
+}
+
 // GetCount returns the current number of elements in the buffer
 func (cb *CircularBuffer) GetCount() int {
 	return cb.count
@@ -120,73 +252,106 @@ func (cb *CircularBuffer) GetCapacity() int {
 
 // LogBuffer holds log lines for a specific IP
 type LogBuffer struct {
-	ip          string
-	logLines    []string
-	mu          sync.Mutex
-	maxLines    int
-	currentFile *os.File
+	ip           string
+	logLines     []LogEntry
+	counts       LogCounts                  // Errors and warnings received since the buffer was created
+	loss         map[string]*logLossTracker // By source, as each source numbers its datagrams
+	lastReceived time.Time                  // Time the last line was received
+	mu           sync.Mutex
+	maxLines     int
+	currentFile  *os.File
+	writer       *bufio.Writer // Buffers writes to currentFile, see flush
 }
 
 // NewLogBuffer creates a new log buffer for an IP
 func NewLogBuffer(ip string, maxLines int) *LogBuffer {
 	return &LogBuffer{
 		ip:       ip,
-		logLines: make([]string, 0, maxLines),
+		logLines: make([]LogEntry, 0, maxLines),
 		maxLines: maxLines,
 	}
 }
 
 type DataBuffer struct {
-	port                       int
-	clientIP                   string
-	buffer                     []byte
-	mu                         sync.Mutex
-	bytesReceived              int64
-	lastCheck                  time.Time
-	rate                       float64
-	circularBuffer             *CircularBuffer // Circular buffer to hold the last N samples
-	circularBufferB            *CircularBuffer // only used for thermocouple
-	lastAverage                float64         // Last calculated average
-	lastAverageB               float64
-	leftoverByte               *byte
-	hasLeftover                bool
-	tcInterleaveSelectInternal bool   // Channel selection, only used for thermocouple reading
-	uuid                       string // Add this field to store the device UUID
-
-}
-
-func NewDataBuffer(port int, clientIP string, avgWindowSize int, uuid string) *DataBuffer {
-	if port == 5557 {
-		return &DataBuffer{
-			port:                       port,
-			clientIP:                   SanitizeFilename(clientIP),
-			buffer:                     make([]byte, 0, BUFFER_SIZE),
-			lastCheck:                  time.Now(),
-			lastAverage:                0,
-			lastAverageB:               0,
-			circularBuffer:             NewCircularBuffer(avgWindowSize),
-			circularBufferB:            NewCircularBuffer(avgWindowSize),
-			leftoverByte:               nil,
-			hasLeftover:                false,
-			tcInterleaveSelectInternal: true,
-			uuid:                       uuid,
-		}
-	} else {
-		return &DataBuffer{
-			port:           port,
-			clientIP:       SanitizeFilename(clientIP),
-			buffer:         make([]byte, 0, BUFFER_SIZE),
-			lastCheck:      time.Now(),
-			lastAverage:    0,
-			circularBuffer: NewCircularBuffer(avgWindowSize),
-			leftoverByte:   nil,
-			hasLeftover:    false,
-			uuid:           uuid,
-		}
-	}
+	port              int
+	clientIP          string
+	buffer            []byte
+	mu                sync.Mutex
+	statsMu           sync.Mutex   // Guards the averaging and history fields, see startStats
+	statsSendLock     sync.RWMutex // Held for reading while sending to statsQueue, for writing to close it
+	statsQueue        chan queuedChunk
+	statsDone         chan struct{}
+	statsCheck        time.Time // Time sampleRate was last computed
+	bytesReceived     int64
+	lastCheck         time.Time
+	rate              float64
+	circularBuffer    *CircularBuffer // Circular buffer to hold the last N samples
+	circularBufferB   *CircularBuffer // only used for thermocouple
+	lastAverage       float64         // Last calculated average
+	lastAverageB      float64
+	statistic         string               // config.STATISTIC_* returned by CalculateAverage
+	samples           *sampleReader        // Decoder of the received bytes
+	uuid              string               // Add this field to store the device UUID
+	history           *SampleRing          // Recent scaled samples for pre-trigger capture
+	historyB          *SampleRing          // only used for thermocouple
+	historySignal     chan struct{}        // Closed when samples are added to history, nil without waiters
+	samplesReceived   int64                // Samples added to history since lastCheck
+	sampleRate        float64              // Samples per second per channel
+	outOfRange        int64                // Samples the decoder could not convert, see ChannelDecoder
+	outOfRangeLogged  int64                // outOfRange when it was last logged
+	outliers          int64                // Samples left out of the averaging windows, see CircularBuffer.Reject
+	misalignments     int64                // Connections that ended mid-sample, see resetAlignment
+	calibration       *Calibration         // Calibration applied before averaging, nil if uncalibrated
+	calibrationB      *Calibration         // only used for thermocouple
+	codec             compress.Codec       // Codec used when flushing, nil writes raw data
+	writer            *segmentWriter       // Background writer for FlushAsync
+	bufferStart       time.Time            // Time the first byte in buffer was received
+	flushSize         int                  // Buffer size at which the buffer is flushed
+	flushInterval     time.Duration        // Buffer age at which the buffer is flushed, 0 flushes by size only
+	storedBytes       int64                // Bytes stored since the buffer was created
+	unaccountedBytes  atomic.Int64         // Bytes received and not yet added to the bandwidth store, see foldBandwidth
+	timestamps        []compress.Timestamp // Timestamp records of the data in buffer
+	timestampInterval time.Duration        // Time between timestamp records, 0 disables them
+	lastTimestamp     time.Time            // Time of the last timestamp record
+	durability        string               // config.DURABILITY_* level of written segments
+	sessionDir        string               // Directory of the device's recording session, "" writes to the data directory
+	alias             string               // Device alias from the registry, added to segment file names
+	lastData          time.Time            // Time data was last received
+	segment           *appendSegment       // Open uncompressed segment, nil if none
+	appendWriter      *bufio.Writer        // Write buffer of segment, reused across segments
+	sequence          sequenceTracker      // Frame sequence numbers, see observeSequence
+	sessionTotals     *sessionTotals       // Data received in the device's session, nil outside one. Guarded by statsMu.
 
 }
 
+// NewDataBuffer creates the buffer of a data port, decoded with the port's decoder
+func NewDataBuffer(port int, clientIP string, avgWindowSize int, uuid string, flushSize int) *DataBuffer {
+	decoder := decoderForPort(port)
+	historySamples := HISTORY_SAMPLES
+	if decoder.Slow() {
+		historySamples = TC_HISTORY_SAMPLES
+	}
+	db := &DataBuffer{
+		port:           port,
+		clientIP:       SanitizeFilename(clientIP),
+		buffer:         getFlushBuffer(flushSize),
+		flushSize:      flushSize,
+		lastCheck:      time.Now(),
+		statsCheck:     time.Now(),
+		lastAverage:    0,
+		circularBuffer: NewCircularBuffer(avgWindowSize),
+		statistic:      config.STATISTIC_MEAN,
+		samples:        newSampleReader(decoder),
+		uuid:           uuid,
+		history:        NewSampleRing(historySamples),
+	}
+	if len(decoder.Channels()) > 1 {
+		db.circularBufferB = NewCircularBuffer(avgWindowSize)
+		db.historyB = NewSampleRing(historySamples)
+	}
+	return db
+}
+
 // GetRate returns the current transfer rate for this buffer
 func (db *DataBuffer) GetRate() float64 {
 	db.mu.Lock()
@@ -195,199 +360,199 @@ func (db *DataBuffer) GetRate() float64 {
 }
 
 func (db *DataBuffer) AddData(data []byte) {
+	db.add(data, nil)
+}
+
+// addChunk adds the data of a pooled read chunk, returning the chunk to readChunks once
+// its samples are processed
+func (db *DataBuffer) addChunk(queued queuedChunk) {
+	db.add((*queued.chunk)[:queued.n], queued.chunk)
+}
+
+// add stores received data and queues it for averaging, see queueStats. The data is
+// queued after it is stored, as the averaging goroutine returns chunk to the pool.
+func (db *DataBuffer) add(data []byte, chunk *[]byte) {
+	db.store(data)
+	//handles the uint16 average calculation
+	db.queueStats(data, chunk)
+}
+
+// store appends data to the open segment or the flush buffer
+func (db *DataBuffer) store(data []byte) {
 	db.mu.Lock()
 
-	db.buffer = append(db.buffer, data...)
 	db.bytesReceived += int64(len(data))
-	//handles the uint16 average calculation
-	db.processBytes(data)
+	db.lastData = time.Now()
 
 	elapsed := time.Since(db.lastCheck).Seconds()
 	if elapsed >= 1.0 {
 		rate := float64(db.bytesReceived) / elapsed / 1024 / 1024 // MB/s
 		db.rate = rate
-		logger.Debugf("Port %d - %s Rate: %.2f MB/s\n", db.port, db.clientIP, rate)
+		logger.DebugFields("Data rate", logger.Fields{
+			"uuid":      db.uuid,
+			"ip":        db.clientIP,
+			"port":      db.port,
+			"rate_mbps": rate,
+			"bytes":     db.bytesReceived,
+		})
 		db.bytesReceived = 0
 		db.lastCheck = time.Now()
 	}
 	// db.mu.Unlock()
 
-	if len(db.buffer) >= BUFFER_SIZE {
+	// Uncompressed data is appended to the open segment, compressed data is buffered
+	// until the whole segment can be compressed
+	if db.codec == nil && db.writer != nil {
+		err := db.appendData(data)
+		if err == nil {
+			db.mu.Unlock()
+			return
+		}
+		logger.Errorf("Failed to append to segment, buffering instead: %v\n", err)
+	} else if db.segment != nil {
+		if err := db.closeSegment(); err != nil {
+			logger.Errorf("Failed to close segment: %v\n", err)
+		}
+	}
+	if len(db.buffer) == 0 {
+		db.bufferStart = time.Now()
+	}
+	if timestamp, ok := db.timestamp(len(db.buffer), db.lastData); ok {
+		db.timestamps = append(db.timestamps, timestamp)
+	}
+	db.buffer = append(db.buffer, data...)
+	db.storedBytes += int64(len(data))
+	earlyFlush := false
+	if db.writer != nil {
+		db.writer.memory.buffered.Add(int64(len(data)))
+		earlyFlush = db.writer.memory.overLimit()
+	}
+
+	if len(db.buffer) >= db.flushSize || (db.flushInterval > 0 && time.Since(db.bufferStart) >= db.flushInterval) {
+		db.mu.Unlock()
+		db.FlushAsync()
+	} else if earlyFlush {
+		// Over the memory cap, move the data towards the disk. FlushAsync waits if the
+		// pending writes alone are over the cap.
 		db.mu.Unlock()
+		db.writer.memory.earlyFlushes.Add(1)
 		db.FlushAsync()
 	} else {
 		db.mu.Unlock()
 	}
 }
 
-// processBytes converts the raw bytes to uint16 samples, handling any byte alignment issues
-func (db *DataBuffer) processBytes(newBytes []byte) {
-	// Start with an empty temporary buffer
-	tempBuffer := make([]byte, 0, len(newBytes)+1) // +1 for potential leftover
-
-	// If we had a leftover byte from previous data, prepend it
-	if db.hasLeftover && db.leftoverByte != nil {
-		tempBuffer = append(tempBuffer, *db.leftoverByte)
-	}
-
-	// Add the new bytes
-	tempBuffer = append(tempBuffer, newBytes...)
-
-	// Process all complete uint16 samples (pairs of bytes)
-	completeBytes := len(tempBuffer) - (len(tempBuffer) % 2)
-	for i := 0; i < completeBytes; i += 2 {
-		var sample float64
-		if db.port == 5555 {
-			// Convert pair of bytes to uint16 (big-endian)
-			// HS ADC sample processing
-			sample = float64(int16(binary.LittleEndian.Uint16(tempBuffer[i : i+2])))
-			sample = sample * -1 / 32768 * 2.5 * 2
-			if sample > 0 {
-				sample = sample * 20
-			}
-			// Add to our circular buffer
-			db.circularBuffer.Add(sample)
-		} else if db.port == 5556 {
-			// GADC sample processing
-			sample = float64(binary.LittleEndian.Uint16(tempBuffer[i : i+2]))
-			// sample = sample*187.5e-6 - 6.144
-			sample = sample*312.5e-6 - 10.24
-			// Add to our circular buffer
-			db.circularBuffer.Add(sample)
-		} else {
-			// Thermocouple result processing
-			sample = float64(int16(binary.LittleEndian.Uint16(tempBuffer[i : i+2])))
-			if db.tcInterleaveSelectInternal { // read internal temp sensor
-				sample = sample / 4 * 0.03125
-				db.circularBuffer.Add(sample)
-			} else {
-				// add K type logic here
-				db.circularBufferB.Add(sample)
-			}
-			db.tcInterleaveSelectInternal = !db.tcInterleaveSelectInternal // switch channels
-		}
+// scaleHSADC converts a signed HS ADC sample to volts
+func scaleHSADC(x float64) float64 {
+	sample := x * -1 / 32768 * 2.5 * 2
+	if sample > 0 {
+		sample = sample * 20
+	}
+	return sample
+}
+
+// scaleGADC converts an unsigned GADC sample to volts
+func scaleGADC(x float64) float64 {
+	// return x*187.5e-6 - 6.144
+	return x*312.5e-6 - 10.24
+}
+
+// scaleTCInternal converts a signed internal temperature sensor reading to °C
+func scaleTCInternal(x float64) float64 {
+	return x / 4 * 0.03125
+}
 
+// scaleTCVoltage converts a signed thermocouple reading to mV
+func scaleTCVoltage(x float64) float64 {
+	return x * TC_LSB_MV
+}
+
+// processBytes decodes the raw bytes, a sample split across chunks is completed with the
+// bytes carried over from the previous chunk
+func (db *DataBuffer) processBytes(newBytes []byte) {
+	if db.sessionTotals != nil {
+		db.sessionTotals.addBytes(len(newBytes), time.Now())
 	}
+	db.samples.read(newBytes, [2]*Calibration{db.calibration, db.calibrationB}, db.processSample)
+	db.updateSampleRate()
+	db.notifyHistory()
+}
 
-	// Check if we have a leftover byte
-	if len(tempBuffer)%2 != 0 {
-		// Store the leftover byte for the next data chunk
-		leftover := tempBuffer[len(tempBuffer)-1]
-		db.leftoverByte = &leftover
-		db.hasLeftover = true
+// processSample adds a scaled sample of a channel to its averaging window and history.
+// Samples that could not be converted and outliers are only added to the history.
+func (db *DataBuffer) processSample(channel int, sample float64, ok bool) error {
+	circularBuffer, history := db.circularBuffer, db.history
+	if channel == 0 {
+		db.samplesReceived++
 	} else {
-		// No leftover byte
-		db.leftoverByte = nil
-		db.hasLeftover = false
+		circularBuffer, history = db.circularBufferB, db.historyB
+	}
+	switch {
+	case !ok:
+		db.outOfRange++
+	case circularBuffer.Reject(sample):
+		db.outliers++
+	default:
+		circularBuffer.Add(sample)
 	}
+	history.Add(sample)
+	if db.sessionTotals != nil {
+		db.sessionTotals.addSample(channel, sample, ok)
+	}
+	return nil
 }
 
 func (db *DataBuffer) FlushAsync() {
-	db.mu.Lock()
-	// logger.Debugf(spew.Sprintf("FlushAsync :%#v"), db)
-
-	if len(db.buffer) == 0 {
-		db.mu.Unlock()
+	if err := db.finishSegment(); err != nil {
+		logger.Errorf("Failed to close segment: %v\n", err)
+	}
+	job, ok := db.takeFlushJob()
+	if !ok {
 		return
 	}
 
-	// Copy the buffer data while the mutex is held
-
-	// Use the buffer directly
-	data := db.buffer
-	// data := make([]byte, len(db.buffer))
-	// copy(data, db.buffer)
-	// Reset the buffer but keep the capacity
-	db.buffer = make([]byte, 0, cap(db.buffer))
-	db.mu.Unlock()
-
-	// Generate filename and reset buffer immediately
-	filename := fmt.Sprintf("port%d_%s_%s_%d.bin",
-		db.port,
-		db.clientIP,
-		db.uuid, // Include UUID in the filename
-		time.Now().UnixNano(),
-	)
-	// db.buffer = make([]byte, 0, BUFFER_SIZE)
-
-	// Make sure the data directory exists
-	os.MkdirAll("data", 0755)
-
-	// Handle write asynchronously
-	go func(data []byte, filename string) {
-		compressedData := compress.HybridRLECompress(data)
-		err := os.WriteFile(filepath.Join("data", filename), compressedData, 0644)
-		if err != nil {
-			logger.Errorf("Failed to write file: %v\n", err)
-		} else {
-			logger.Infof("Written %d bytes to %s, compression ratio: %f\n", len(compressedData), filename, (float64(len(data)) / float64(len(compressedData))))
-		}
-	}(data, filename)
+	// Handle compression and write on a background worker
+	if db.writer != nil {
+		db.writer.submit(job)
+	} else {
+		fallbackWriter().write(job)
+	}
 }
 
 func (db *DataBuffer) FlushSync() error {
-	db.mu.Lock()
-	// logger.Debugf(spew.Sprintf("FlushSync: %#v", db))
-	if len(db.buffer) == 0 {
+	segmentErr := db.finishSegment()
+	job, ok := db.takeFlushJob()
+	if !ok {
 		logger.Debugf("FlushSync: Zero Length!\n")
-		db.mu.Unlock()
-		return nil
+		return segmentErr
 	}
 
-	// Copy the buffer data while the mutex is held
-
-	// Use the buffer directly
-	data := db.buffer
-	// data := make([]byte, len(db.buffer))
-	// copy(data, db.buffer)
-	// Reset the buffer but keep the capacity
-	db.buffer = make([]byte, 0, cap(db.buffer))
-	db.mu.Unlock()
-
-	// Generate filename and reset buffer immediately
-	filename := fmt.Sprintf("port%d_%s_%s_%d.bin",
-		db.port,
-		db.clientIP,
-		db.uuid, // Include UUID in the filename
-		time.Now().UnixNano(),
-	)
-	// db.buffer = make([]byte, 0, BUFFER_SIZE)
-
-	// Make sure the data directory exists
-	os.MkdirAll("data", 0755)
-
 	// Handle write synchronously
-
-	compressedData := compress.HybridRLECompress(data)
-	logger.Debugf("Data Compressed\n")
-	err := os.WriteFile(filepath.Join("data", filename), compressedData, 0644)
-	if err != nil {
-		logger.Errorf("Failed to write file: %v\n", err)
-		return err
-	} else {
-		logger.Infof("Written %d bytes to %s, compression ratio: %f\n", len(compressedData), filename, (float64(len(data)) / float64(len(compressedData))))
-		return nil
+	writer := db.writer
+	if writer == nil {
+		writer = fallbackWriter()
 	}
-
+	return errors.Join(segmentErr, writer.write(job))
 }
 
-// CalculateAverage calculates the current average of samples in the circular buffer
+// CalculateAverage calculates the current average of samples in the circular buffer, the
+// mean, EMA or median depending on the buffer's statistic.
 // Returns the average and whether the buffer has been filled at least once
 func (db *DataBuffer) CalculateAverage() (float64, bool) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
 
-	db.lastAverage = db.circularBuffer.GetAverage()
+	db.lastAverage = db.circularBuffer.GetStatistic(db.statistic)
 	isFullOnce := db.circularBuffer.IsFullOnce()
 
 	return db.lastAverage, isFullOnce
 }
 
 func (db *DataBuffer) CalculateAverageB() (float64, bool) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
 
-	db.lastAverageB = db.circularBufferB.GetAverage()
+	db.lastAverageB = db.circularBufferB.GetStatistic(db.statistic)
 	isFullOnce := db.circularBufferB.IsFullOnce()
 
 	return db.lastAverageB, isFullOnce
@@ -395,21 +560,27 @@ func (db *DataBuffer) CalculateAverageB() (float64, bool) {
 
 // GetLastAverage returns the last calculated average without recalculating
 func (db *DataBuffer) GetLastAverage() float64 {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
 
 	return db.lastAverage
 }
 
 // GetBufferStatus returns the current state of the circular buffer (count/capacity)
 func (db *DataBuffer) GetBufferStatus() (int, int) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
 
 	return db.circularBuffer.GetCount(), db.circularBuffer.GetCapacity()
 }
 
+// SHUTDOWN_TIMEOUT is how long Shutdown waits for connections to close and buffers to be written
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
 type Server struct {
+	config      *config.Config // Replaced as a whole by UpdateSettings, read with settings()
+	configPath  string         // File UpdateSettings persists the settings to
+	configLock  sync.RWMutex
 	buffers     map[BufferKey]*DataBuffer
 	buffersLock sync.RWMutex
 	// Track IP addresses and their connection times
@@ -418,32 +589,207 @@ type Server struct {
 	// New log-related fields
 	logBuffers      map[string]*LogBuffer
 	logBuffersLock  sync.RWMutex
-	udpListener     *net.UDPConn
+	udpListeners    map[int]*net.UDPConn // UDP log listeners by port
 	udpListenerLock sync.RWMutex
+	logEvents       deviceLogEvents // Device log lines pushed to the frontend
+	syslog          syslogForwarder // Device log lines forwarded to a syslog server
 	// Track active connections by IP:Port
 	activeConns     map[BufferKey]net.Conn
 	activeConnsLock sync.RWMutex
 	connectionWg    sync.WaitGroup // Global WaitGroup for tracking all connection handling goroutines
-
+	// Binding result of each listened port
+	listeners     map[int]ListenerStatus
+	listenersLock sync.RWMutex
+	// Per-second transfer rates of each channel by "ip:port", see GetRateHistory
+	rateHistory     map[string]*rateHistory
+	rateCounters    map[BufferKey]rateCounter
+	rateHistoryLock sync.Mutex
+	// Panics and listener failures recovered from, see GetIncidents
+	incidents     []Incident
+	incidentsLock sync.RWMutex
+	// Availability of each device by sanitized IP
+	health     map[string]*DeviceHealth
+	healthLock sync.RWMutex
+	// Registered devices by UUID
+	registry *DeviceRegistry
+	// Named groups of devices recorded together
+	groups *GroupStore
+	// Active recording sessions by device UUID
+	sessions     map[string]*Session
+	sessionsLock sync.RWMutex
+	// Markers added since the server started, see AddMarker
+	markers     []Marker
+	markersLock sync.RWMutex
+	// Cancelled by Stop to close the listeners and connections
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	// Per-device calibration coefficients
+	calibrations *CalibrationStore
+	// Compression codec name per port and the background segment writer
+	compression     map[int]string
+	compressionLock sync.RWMutex
+	writer          *segmentWriter
+	// Virtual channels computed from physical channels
+	derivedChannels map[string]*derivedChannel
+	derivedLock     sync.RWMutex
+	// Running replays of recorded segments by virtual IP
+	replays     map[string]*replayRun
+	replaysLock sync.Mutex
+	replayCount int // Replays started, numbers the virtual IPs
+	// Cancels running session exports by session ID
+	exports     map[string]context.CancelFunc
+	exportsLock sync.Mutex
+	// Bytes received per device and day
+	bandwidth *BandwidthStore
+	// Relays of connected channels to other hosts
+	relays     []*relayStream
+	relaysLock sync.Mutex
 }
 
-func NewServer() *Server {
+func NewServer(cfg *config.Config) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := newSegmentWriter(cfg.FlushWorkers, cfg.DataDir)
+	writer.queue.configure(cfg.WriteQueueDepth, cfg.WriteQueuePolicy)
+	writer.memory.setLimit(int64(cfg.MemoryLimit))
+	writer.checksums.Store(cfg.Features.Checksums)
+	if cfg.MirrorDir != "" {
+		writer.mirror = newSegmentMirror(cfg.MirrorDir)
+	}
+	if cfg.Upload.URL != "" {
+		writer.uploads = newUploadQueue(cfg.Upload.URL, cfg.DataDir)
+	}
 	return &Server{
-		buffers:      make(map[BufferKey]*DataBuffer),
-		connectedIPs: make(map[string]*IPConnection),
-		logBuffers:   make(map[string]*LogBuffer),
-		activeConns:  make(map[BufferKey]net.Conn),
+		ctx:             ctx,
+		cancel:          cancel,
+		config:          cfg,
+		configPath:      config.CONFIG_FILE,
+		buffers:         make(map[BufferKey]*DataBuffer),
+		connectedIPs:    make(map[string]*IPConnection),
+		logBuffers:      make(map[string]*LogBuffer),
+		udpListeners:    make(map[int]*net.UDPConn),
+		activeConns:     make(map[BufferKey]net.Conn),
+		calibrations:    NewCalibrationStore(CALIBRATION_FILE),
+		derivedChannels: make(map[string]*derivedChannel),
+		replays:         make(map[string]*replayRun),
+		exports:         make(map[string]context.CancelFunc),
+		compression:     make(map[int]string),
+		sessions:        make(map[string]*Session),
+		listeners:       make(map[int]ListenerStatus),
+		rateHistory:     make(map[string]*rateHistory),
+		health:          make(map[string]*DeviceHealth),
+		registry:        NewDeviceRegistry(DEVICE_REGISTRY_FILE),
+		groups:          NewGroupStore(DEVICE_GROUPS_FILE),
+		bandwidth:       NewBandwidthStore(filepath.Join(cfg.DataDir, BANDWIDTH_FILE)),
+		writer:          writer,
 	}
 }
 
+// Start loads the persisted calibrations and decoder plugins, selects the configured
+// decoders and starts the configured listeners, syslog forwarding and segment retention
+func (s *Server) Start() error {
+	cfg := s.settings()
+	if err := s.LoadCalibrations(); err != nil {
+		logger.Errorf("Failed to load calibrations: %v\n", err)
+	}
+	if err := s.LoadDevices(); err != nil {
+		logger.Errorf("Failed to load device registry: %v\n", err)
+	}
+	if err := s.groups.Load(); err != nil {
+		logger.Errorf("Failed to load device groups: %v\n", err)
+	}
+
+	if cfg.Features.Syslog != "" {
+		if err := s.SetSyslogForwarding(cfg.Features.Syslog); err != nil {
+			logger.Errorf("Failed to enable syslog forwarding: %v\n", err)
+		}
+	}
+
+	if err := ConfigureDecoders(cfg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	if _, err := markIncompleteSegments(cfg.DataDir); err != nil {
+		logger.Errorf("Failed to check for incomplete segments: %v\n", err)
+	}
+	s.StartRetention()
+	s.StartHealthMonitor()
+	s.StartStatsPublisher()
+	s.StartMirror()
+	s.StartUploads()
+	s.StartBandwidthAccounting()
+	s.StartRateHistory()
+
+	ports := append([]int{cfg.HandshakePort}, cfg.DataPorts...)
+	for _, port := range ports {
+		go s.StartListener(port)
+	}
+	return nil
+}
+
+// SetConfigPath sets the file UpdateSettings saves the settings to
+func (s *Server) SetConfigPath(path string) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	s.configPath = path
+}
+
+// StartListener accepts connections on port until the server stops. A data port with
+// several accept loops listens on that many sockets with SO_REUSEPORT. A listener that
+// panics or whose socket fails is reported as an incident and restarted.
 func (s *Server) StartListener(port int) {
+	for {
+		err := s.runListener(port)
+		if err == nil || errors.Is(err, errBindFailed) || s.ctx.Err() != nil {
+			return
+		}
+		s.reportFailure(INCIDENT_LISTENER, port, "", err)
+		s.setListenerStatus(ListenerStatus{Port: port, Error: fmt.Sprintf("Listener failed, restarting: %v", err)})
+		select {
+		case <-time.After(LISTENER_RESTART_DELAY):
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// errBindFailed is returned by runListener if the port could not be bound, which bind
+// has already reported
+var errBindFailed = errors.New("failed to bind")
+
+// runListener listens on port until the server stops, returning nil, or one of its accept
+// loops fails
+func (s *Server) runListener(port int) error {
+	loops := s.settings().AcceptLoopsFor(port)
+	if loops > 1 && !REUSE_PORT_SUPPORTED {
+		logger.Infof("Port %d: accept_loops needs SO_REUSEPORT, which is only used on Linux, listening once\n", port)
+		loops = 1
+	}
+
+	listener, err := s.bind(port, loops > 1)
 	if err != nil {
-		logger.Errorf("Failed to start server on port %d: %v\n", port, err)
-		return
+		return errBindFailed
+	}
+	bound := listener.Addr().(*net.TCPAddr).Port
+	listeners := []net.Listener{listener}
+	for len(listeners) < loops {
+		extra, err := listen(bound, true)
+		if err != nil {
+			logger.Errorf("Failed to open accept loop %d on port %d: %v\n", len(listeners)+1, bound, err)
+			break
+		}
+		listeners = append(listeners, extra)
+	}
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
 	}
-	defer listener.Close()
+	// Stop unblocks Accept by closing the listeners
+	stopListener := context.AfterFunc(s.ctx, closeAll)
+	defer stopListener()
 
 	// Initialize UDP log listener if not already started
 	if err := s.InitUDPLogListener(); err != nil {
@@ -451,94 +797,163 @@ func (s *Server) StartListener(port int) {
 		// Continue anyway, as this is not critical
 	}
 
-	logger.Infof("TCP Server listening on port %d\n", port)
+	if len(listeners) > 1 {
+		logger.Infof("TCP Server listening on port %d with %d accept loops\n", bound, len(listeners))
+	} else {
+		logger.Infof("TCP Server listening on port %d\n", bound)
+	}
+
+	// The first loop to end, when the server stops or on a failure, ends the others
+	results := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			results <- s.acceptLoop(listener, port)
+		}()
+	}
+	err = <-results
+	closeAll()
+	for range len(listeners) - 1 {
+		<-results
+	}
+	return err
+}
 
+// acceptLoop accepts the connections of one listening socket of port. It returns nil when
+// the server stops and an error if the socket fails or the loop panics.
+func (s *Server) acceptLoop(listener net.Listener, port int) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &panicError{value: value, stack: string(debug.Stack())}
+		}
+	}()
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if s.ctx.Err() != nil {
+				logger.Infof("TCP Server on port %d stopped\n", port)
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("listener closed: %v", err)
+			}
 			logger.Errorf("Failed to accept connection on port %d: %v\n", port, err)
 			continue
 		}
 
-		clientIP := GetClientIP(conn.RemoteAddr())
-		logger.Infof("New connection on port %d from %s\n", port, clientIP)
+		s.tuneSocket(conn, port)
+		s.acceptConnection(conn, port)
+	}
+}
 
-		// Create composite key
-		key := BufferKey{
-			IP:   clientIP,
-			Port: port,
-		}
+// acceptConnection starts handling a connection accepted on port, creating or reusing the
+// buffer of its IP and port
+func (s *Server) acceptConnection(conn net.Conn, port int) {
+	clientIP := GetClientIP(conn.RemoteAddr())
+	logger.Infof("New connection on port %d from %s\n", port, clientIP)
 
-		// Special handling for port 5002 (handshake)
-		if port == 5002 {
-			go s.HandleHandshakeConnection(conn)
-			continue
-		}
+	// Create composite key
+	key := BufferKey{
+		IP:   clientIP,
+		Port: port,
+	}
 
-		// Get UUID for this IP, if available
-		uuid := ""
-		s.connectedIPsLock.RLock()
-		if ipConn, exists := s.connectedIPs[SanitizeFilename(clientIP)]; exists {
-			uuid = ipConn.UUID
-		}
-		s.connectedIPsLock.RUnlock()
-
-		// Register this connection and close any existing ones
-		s.registerConnection(key, conn)
-
-		// Check if we already have a buffer for this IP:Port
-		// s.buffersLock.Lock()
-		// var buffer *DataBuffer
-		// if existingBuffer, exists := s.buffers[key]; exists {
-		// 	// Reuse existing buffer
-		// 	buffer = existingBuffer
-		// 	logger.Infof("Reusing existing buffer for %s:%d\n", clientIP, port)
-		// } else {
-		// 	// Create new buffer
-		// 	if port == 5557 {
-		// 		buffer = NewDataBuffer(port, clientIP, 5)
-
-		// 	} else {
-		// 		buffer = NewDataBuffer(port, clientIP, 1000)
-		// 	}
-
-		// 	s.buffers[key] = buffer
+	// Special handling for the handshake port
+	if port == s.settings().HandshakePort {
+		go s.HandleHandshakeConnection(conn)
+		return
+	}
+
+	// Get UUID and channel map for this IP, if available
+	uuid := ""
+	var channels []HandshakeChannel
+	s.connectedIPsLock.RLock()
+	if ipConn, exists := s.connectedIPs[SanitizeFilename(clientIP)]; exists {
+		uuid = ipConn.UUID
+		channels = ipConn.Channels
+	}
+	s.connectedIPsLock.RUnlock()
+
+	// Register this connection, applying the duplicate connection policy
+	key, accepted := s.registerConnection(key, conn, uuid)
+	if !accepted {
+		conn.Close()
+		return
+	}
+
+	// Check if we already have a buffer for this IP:Port
+	// s.buffersLock.Lock()
+	// var buffer *DataBuffer
+	// if existingBuffer, exists := s.buffers[key]; exists {
+	// 	// Reuse existing buffer
+	// 	buffer = existingBuffer
+	// 	logger.Infof("Reusing existing buffer for %s:%d\n", clientIP, port)
+	// } else {
+	// 	// Create new buffer
+	// 	if port == 5557 {
+	// 		buffer = NewDataBuffer(port, clientIP, 5)
+
+	// 	} else {
+	// 		buffer = NewDataBuffer(port, clientIP, 1000)
+	// 	}
+
+	// 	s.buffers[key] = buffer
+	// }
+	// s.buffersLock.Unlock()
+
+	// Check if we already have a buffer for this IP:Port:UUID combo
+	s.buffersLock.Lock()
+	var buffer *DataBuffer
+	if existingBuffer, exists := s.buffers[key]; exists && existingBuffer.uuid == uuid {
+		// Reuse existing buffer
+		buffer = existingBuffer
+		buffer.mu.Lock()
+		buffer.sequence.reset()
+		buffer.mu.Unlock()
+		// Update UUID if it's now available
+		// if buffer.uuid == "" && uuid != "" {
+		// 	buffer.uuid = uuid
 		// }
-		// s.buffersLock.Unlock()
-
-		// Check if we already have a buffer for this IP:Port:UUID combo
-		s.buffersLock.Lock()
-		var buffer *DataBuffer
-		if existingBuffer, exists := s.buffers[key]; exists && existingBuffer.uuid == uuid {
-			// Reuse existing buffer
-			buffer = existingBuffer
-			// Update UUID if it's now available
-			// if buffer.uuid == "" && uuid != "" {
-			// 	buffer.uuid = uuid
-			// }
-			logger.Infof("Reusing existing buffer for %s:%d (UUID: %s)\n", clientIP, port, buffer.uuid)
-		} else {
-			// Create new buffer
-			if port == 5557 {
-				buffer = NewDataBuffer(port, clientIP, 5, uuid)
-			} else {
-				buffer = NewDataBuffer(port, clientIP, 1000, uuid)
-			}
-			s.buffers[key] = buffer
+		logger.Infof("Reusing existing buffer for %s:%d (UUID: %s)\n", clientIP, port, buffer.uuid)
+	} else {
+		// Create new buffer
+		cfg := s.settings()
+		window := cfg.AveragingWindow
+		if decoderForPort(port).Slow() {
+			window = cfg.ThermocoupleAveragingWindow
 		}
-		s.buffersLock.Unlock()
+		buffer = NewDataBuffer(port, clientIP, window, uuid, cfg.FlushThresholdFor(port))
+		selectThermocoupleType(buffer.samples.decoder, thermocoupleType(channels, port))
+		buffer.flushInterval = time.Duration(cfg.FlushInterval) * time.Second
+		buffer.timestampInterval = time.Duration(cfg.TimestampInterval) * time.Millisecond
+		buffer.durability = cfg.DurabilityFor(port)
+		buffer.setStatistic(cfg.StatisticFor(port), cfg.EMAAlphaFor(port), cfg.OutlierSigmaFor(port))
+		s.applyCalibrations(buffer, uuid)
+		buffer.codec = s.codecForDevice(uuid, port)
+		buffer.writer = s.writer
+		buffer.setSessionDir(s.sessionDir(uuid))
+		device, _ := s.registry.Get(uuid)
+		buffer.alias = device.Alias
+		buffer.startStats()
+		s.buffers[key] = buffer
+	}
+	s.buffersLock.Unlock()
 
-		// Track IP connection
-		s.AddIPConnection(clientIP, port, uuid)
+	// Track IP connection
+	s.AddIPConnection(clientIP, port, uuid)
+	s.autoStartRecording(uuid)
 
-		go s.HandleConnection(conn, buffer, key)
-	}
+	s.connectionWg.Add(1)
+	go s.HandleConnection(conn, buffer, key)
 }
 
 // Modified HandleConnection to include the buffer key
+// The caller adds the connection to connectionWg.
 func (s *Server) HandleConnection(conn net.Conn, buffer *DataBuffer, key BufferKey) {
-	// Increment the WaitGroup when starting a connection handler
-	s.connectionWg.Add(1)
+	defer s.connectionWg.Done()
+	defer s.recoverPanic(INCIDENT_CONNECTION, key.Port, key.IP)
+	// Stop unblocks Read by closing the connection
+	stopConn := context.AfterFunc(s.ctx, func() { conn.Close() })
+	defer stopConn()
 	// Get UUID for this IP, if available
 	uuid := ""
 	s.connectedIPsLock.RLock()
@@ -548,10 +963,12 @@ func (s *Server) HandleConnection(conn net.Conn, buffer *DataBuffer, key BufferK
 	s.connectedIPsLock.RUnlock()
 
 	s.AddIPConnection(buffer.clientIP, buffer.port, uuid)
+	receivedBytes := s.ipBytesCounter(buffer.clientIP)
 
 	defer func() {
 		// Always FlushSync buffer on exit
 		buffer.FlushSync()
+		s.foldBandwidth(buffer)
 
 		// Close the connection
 		conn.Close()
@@ -562,24 +979,62 @@ func (s *Server) HandleConnection(conn net.Conn, buffer *DataBuffer, key BufferK
 			delete(s.activeConns, key)
 
 			// Only remove the buffer if this was the active connection
+			s.recordGaps(buffer)
 			s.buffersLock.Lock()
 			delete(s.buffers, key)
 			s.buffersLock.Unlock()
+			buffer.stopStats()
+			s.recordSessionTotals(buffer)
 
-			// Remove IP port tracking
-			s.RemoveIPPort(buffer.clientIP, buffer.port)
+			// Remove IP port tracking, unless a parallel connection still uses the port
+			parallel := false
+			for other := range s.activeConns {
+				parallel = parallel || (other.IP == key.IP && other.Port == key.Port)
+			}
+			if !parallel {
+				s.RemoveIPPort(buffer.clientIP, buffer.port)
+			}
+			if _, connected := s.GetIPInfo(buffer.clientIP); !connected {
+				s.autoStopRecording(buffer.uuid)
+				s.registerDisconnect(buffer.uuid)
+			}
 
 			logger.Infof("Connection closed from %s:%d\n", buffer.clientIP, buffer.port)
-			s.connectionWg.Done()
 		}
 		s.activeConnsLock.Unlock()
 	}()
 
-	chunk := make([]byte, 1048576) // 1MB chunks
+	// Received chunks are processed on their own goroutine so that averaging, compression
+	// and disk writes do not delay reading from the socket. Processing finishes before the
+	// buffer is flushed above.
+	// The chunk size is read once, a changed size applies to new connections
+	chunkSize := s.settings().ReadChunkSizeFor(buffer.port)
+	// A reused buffer may hold a partial sample of the previous connection
+	buffer.queueAlignmentReset()
+	queue := newChunkQueue(READ_QUEUE_SLOTS)
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		queue.consume(buffer.addChunk)
+	}()
+	defer func() {
+		queue.Close()
+		<-processed
+	}()
+	// Relays get a copy of the data before the chunk is handed to processing
+	relays := s.openRelays(buffer, key)
+	defer func() {
+		for _, relay := range relays {
+			relay.close()
+		}
+	}()
+
 	for {
-		n, err := conn.Read(chunk)
+		chunk := getReadChunk(chunkSize)
+		n, err := conn.Read(*chunk)
 		if err != nil {
-			if err != io.EOF {
+			readChunks.Put(chunk)
+			if err != io.EOF && s.ctx.Err() == nil {
 				logger.Errorf("Error reading from %s:%d: %v\n",
 					buffer.clientIP,
 					buffer.port,
@@ -597,12 +1052,29 @@ func (s *Server) HandleConnection(conn net.Conn, buffer *DataBuffer, key BufferK
 		s.activeConnsLock.RUnlock()
 
 		if !isActive || !isCurrentConn {
+			readChunks.Put(chunk)
 			logger.Infof("Connection %s:%d is no longer active, closing", buffer.clientIP, buffer.port)
 			return
 		}
-		buffer.AddData(chunk[:n])
-		// logger.Debugf("AddData Called\n")
-		s.UpdateIPBytes(buffer.clientIP, int64(n))
+		for _, relay := range relays {
+			relay.write((*chunk)[:n])
+		}
+		if !queue.Push(queuedChunk{chunk: chunk, n: n}) {
+			// Processing is behind by READ_QUEUE_SLOTS chunks, wait rather than drop data
+			logger.Errorf("Processing of %s:%d is falling behind, pausing reads\n", buffer.clientIP, buffer.port)
+			for !queue.Push(queuedChunk{chunk: chunk, n: n}) {
+				select {
+				case <-queue.notFull:
+				case <-s.ctx.Done():
+					readChunks.Put(chunk)
+					return
+				}
+			}
+		}
+		if receivedBytes != nil {
+			receivedBytes.Add(int64(n))
+		}
+		buffer.unaccountedBytes.Add(int64(n))
 	}
 }
 
@@ -638,6 +1110,32 @@ func (s *Server) GetAllBufferRates() map[string]float64 {
 	return rates
 }
 
+// GetAllMisalignments returns the number of connections that ended mid-sample per
+// channel, keyed by "ip:port"
+func (s *Server) GetAllMisalignments() map[string]int64 {
+	s.buffersLock.RLock()
+	defer s.buffersLock.RUnlock()
+
+	counts := make(map[string]int64, len(s.buffers))
+	for key, buffer := range s.buffers {
+		counts[fmt.Sprintf("%s:%d", key.IP, key.Port)] = buffer.GetMisalignments()
+	}
+	return counts
+}
+
+// GetAllOutliers returns the number of samples left out of the live values as outliers
+// per channel, keyed by "ip:port"
+func (s *Server) GetAllOutliers() map[string]int64 {
+	s.buffersLock.RLock()
+	defer s.buffersLock.RUnlock()
+
+	counts := make(map[string]int64, len(s.buffers))
+	for key, buffer := range s.buffers {
+		counts[fmt.Sprintf("%s:%d", key.IP, key.Port)] = buffer.GetOutliers()
+	}
+	return counts
+}
+
 // GetIPPortRate can now use the composite key directly
 func (s *Server) GetIPPortRate(ip string, port int) (float64, bool) {
 	s.buffersLock.RLock()
@@ -681,48 +1179,54 @@ func SanitizeFilename(ip string) string {
 // AddIPConnection records or updates an IP connection
 func (s *Server) AddIPConnection(ip string, port int, uuid string) {
 	s.connectedIPsLock.Lock()
-	defer s.connectedIPsLock.Unlock()
 
 	sanitizedIP := SanitizeFilename(ip)
+	opened := true
+	change := DeviceConnectionChange{IP: sanitizedIP, UUID: uuid, Port: port, Change: CONNECTION_PORT_OPENED}
 
 	if conn, exists := s.connectedIPs[sanitizedIP]; exists {
-
+		opened = !conn.ActivePorts[port]
 		conn.ActivePorts[port] = true
+		change.UUID = conn.UUID
+		change.Alias = conn.Alias
 	} else {
 		s.connectedIPs[sanitizedIP] = &IPConnection{
 
 			ActivePorts: map[int]bool{port: true},
-			TotalBytes:  0,
 			UUID:        uuid,
+			bytes:       new(atomic.Int64),
 		}
 	}
 
-	logger.Infof(spew.Sprintf("Current IP Connections: %#v", s.connectedIPs))
+	logger.Infof(spew.Sprintf("Current IP Connections: %#v", s.copyConnectedIPs()))
+	s.connectedIPsLock.Unlock()
+
+	if opened {
+		s.notifyConnection(change)
+	}
 }
 
 // RemoveIPPort removes a port from an IP's active connections
 func (s *Server) RemoveIPPort(ip string, port int) {
 	s.connectedIPsLock.Lock()
-	defer s.connectedIPsLock.Unlock()
 
 	sanitizedIP := SanitizeFilename(ip)
+	var changes []DeviceConnectionChange
 	if conn, exists := s.connectedIPs[sanitizedIP]; exists {
 		delete(conn.ActivePorts, port)
+		changes = append(changes, DeviceConnectionChange{IP: sanitizedIP, UUID: conn.UUID, Alias: conn.Alias, Port: port, Change: CONNECTION_PORT_CLOSED})
 
 		// If no more active ports, remove the IP entirely
 		if len(conn.ActivePorts) == 0 {
 			delete(s.connectedIPs, sanitizedIP)
+			changes = append(changes, DeviceConnectionChange{IP: sanitizedIP, UUID: conn.UUID, Alias: conn.Alias, Change: CONNECTION_DISCONNECTED})
 
 			// Close log file if it exists
 			s.logBuffersLock.Lock()
 			if buffer, exists := s.logBuffers[sanitizedIP]; exists {
 				buffer.mu.Lock()
-				if buffer.currentFile != nil {
-					buffer.currentFile.WriteString(fmt.Sprintf("=== Log ended at %s for %s ===\n",
-						time.Now().Format(time.RFC3339), ip))
-					buffer.currentFile.Close()
-					buffer.currentFile = nil
-				}
+				buffer.closeFile(fmt.Sprintf("=== Log ended at %s for %s ===\n",
+					time.Now().Format(time.RFC3339), ip))
 				buffer.mu.Unlock()
 
 				// Keep the log buffer for history, but close the file
@@ -730,18 +1234,36 @@ func (s *Server) RemoveIPPort(ip string, port int) {
 			s.logBuffersLock.Unlock()
 		}
 	}
-	logger.Infof(spew.Sprint("Current IP Connections: %#v", s.connectedIPs))
+	logger.Infof(spew.Sprint("Current IP Connections: %#v", s.copyConnectedIPs()))
+	s.connectedIPsLock.Unlock()
+
+	for _, change := range changes {
+		s.notifyConnection(change)
+	}
 }
 
 // UpdateIPBytes updates the total bytes transferred for an IP
 func (s *Server) UpdateIPBytes(ip string, bytes int64) {
+	if counter := s.ipBytesCounter(ip); counter != nil {
+		counter.Add(bytes)
+	}
+}
+
+// ipBytesCounter returns the received bytes counter of an IP, nil if it is not connected.
+// Connection readers keep the counter so that counting does not take connectedIPsLock.
+func (s *Server) ipBytesCounter(ip string) *atomic.Int64 {
 	s.connectedIPsLock.Lock()
 	defer s.connectedIPsLock.Unlock()
 
-	sanitizedIP := SanitizeFilename(ip)
-	if conn, exists := s.connectedIPs[sanitizedIP]; exists {
-		conn.TotalBytes += bytes
+	conn, exists := s.connectedIPs[SanitizeFilename(ip)]
+	if !exists {
+		return nil
 	}
+	if conn.bytes == nil {
+		conn.bytes = new(atomic.Int64)
+		conn.bytes.Store(conn.TotalBytes)
+	}
+	return conn.bytes
 }
 
 // GetIPInfo returns information about a specific IP
@@ -759,7 +1281,7 @@ func (s *Server) GetIPInfo(ip string) (*IPConnection, bool) {
 	return &IPConnection{
 
 		ActivePorts: maps.Clone(conn.ActivePorts),
-		TotalBytes:  conn.TotalBytes,
+		TotalBytes:  conn.totalBytes(),
 	}, true
 }
 
@@ -767,21 +1289,30 @@ func (s *Server) GetIPInfo(ip string) (*IPConnection, bool) {
 func (s *Server) GetAllConnectedIPs() map[string]IPConnection {
 	s.connectedIPsLock.RLock()
 	defer s.connectedIPsLock.RUnlock()
+	return s.copyConnectedIPs()
+}
 
-	// Create a deep copy of the map
+// copyConnectedIPs returns a deep copy of the connected IPs, the caller must hold
+// connectedIPsLock
+func (s *Server) copyConnectedIPs() map[string]IPConnection {
 	result := make(map[string]IPConnection)
 	for ip, connection := range s.connectedIPs {
 		result[ip] = IPConnection{
 
 			ActivePorts:     maps.Clone(connection.ActivePorts),
-			TotalBytes:      connection.TotalBytes,
+			TotalBytes:      connection.totalBytes(),
 			UUID:            connection.UUID,
+			Alias:           connection.Alias,
 			MAC:             connection.MAC,
 			FirmwareVersion: connection.FirmwareVersion,
 			HardwareVersion: connection.HardwareVersion,
 			VgsSampleRate:   connection.VgsSampleRate,
 			VdsSampleRate:   connection.VdsSampleRate,
 			TcSampleRate:    connection.TcSampleRate,
+			SchemaVersion:   connection.SchemaVersion,
+			Channels:        slices.Clone(connection.Channels),
+			Status:          connection.Status,
+			Latency:         connection.Latency,
 		}
 	}
 	return result
@@ -813,13 +1344,36 @@ func (s *Server) GetPortAverageB(key BufferKey) (float64, bool) {
 	}
 }
 
+// ChannelValues are the transfer rate and averages of a data channel
+type ChannelValues struct {
+	Rate     float64 // Transfer rate in MB/s
+	Average  float64 // Average of the first channel of the port
+	AverageB float64 // Average of the second channel, 0 for single-channel ports
+	Valid    bool    // Whether Average covers a full averaging window
+	ValidB   bool
+}
+
+// GetChannelValues returns the rate and averages of a data channel, false if it has no buffer
+func (s *Server) GetChannelValues(key BufferKey) (ChannelValues, bool) {
+	s.buffersLock.RLock()
+	buffer, exists := s.buffers[key]
+	s.buffersLock.RUnlock()
+	if !exists {
+		return ChannelValues{}, false
+	}
+	values := ChannelValues{Rate: buffer.GetRate()}
+	values.Average, values.Valid = buffer.CalculateAverage()
+	values.AverageB, values.ValidB = buffer.CalculateAverageB()
+	return values, true
+}
+
 // Add a method to stop all listeners and clean up resources
 func (s *Server) StopAllLogListeners() {
-	// Close UDP listener if active
+	// Close the UDP listeners
 	s.udpListenerLock.Lock()
-	if s.udpListener != nil {
-		s.udpListener.Close()
-		s.udpListener = nil
+	for port, conn := range s.udpListeners {
+		conn.Close()
+		delete(s.udpListeners, port)
 	}
 	s.udpListenerLock.Unlock()
 
@@ -827,69 +1381,93 @@ func (s *Server) StopAllLogListeners() {
 	s.logBuffersLock.Lock()
 	for ip, buffer := range s.logBuffers {
 		buffer.mu.Lock()
-		if buffer.currentFile != nil {
-			buffer.currentFile.WriteString(fmt.Sprintf("=== Log ended at %s for %s ===\n",
-				time.Now().Format(time.RFC3339), ip))
-			buffer.currentFile.Close()
-			buffer.currentFile = nil
-		}
+		buffer.closeFile(fmt.Sprintf("=== Log ended at %s for %s ===\n",
+			time.Now().Format(time.RFC3339), ip))
 		buffer.mu.Unlock()
 	}
 	s.logBuffersLock.Unlock()
 }
 
-// Initialize the UDP log listener
+// InitUDPLogListener starts the UDP log listeners on udp_log_port and the ports of
+// udp_log_listeners that are not listening yet
 func (s *Server) InitUDPLogListener() error {
 	s.udpListenerLock.Lock()
 	defer s.udpListenerLock.Unlock()
 
-	// If already listening, return
-	if s.udpListener != nil {
-		return nil
-	}
-
 	// Ensure logs directory exists
-	if err := os.MkdirAll("logs", 0755); err != nil {
+	if err := os.MkdirAll(s.settings().LogDir, 0755); err != nil {
 		logger.Errorf("Failed to create logs directory: %v", err)
 		return fmt.Errorf("failed to create logs directory: %v", err)
 	}
 
-	// Start UDP listener on port 2403
-	addr := net.UDPAddr{Port: 2403} // Listen on all interfaces
-	conn, err := net.ListenUDP("udp", &addr)
-	if err != nil {
-		logger.Errorf("Failed to start UDP listener for logs: %v", err)
-		return fmt.Errorf("failed to start UDP listener for logs: %v", err)
-	}
+	sources := map[int]string{s.settings().UDPLogPort: ""}
+	maps.Copy(sources, s.settings().UDPLogListeners)
+	var errs []error
+	for _, port := range slices.Sorted(maps.Keys(sources)) {
+		// If already listening, skip
+		if _, exists := s.udpListeners[port]; exists {
+			continue
+		}
 
-	s.udpListener = conn
+		addr := net.UDPAddr{Port: port} // Listen on all interfaces
+		conn, err := net.ListenUDP("udp", &addr)
+		if err != nil {
+			logger.Errorf("Failed to start UDP listener for logs on port %d: %v", port, err)
+			errs = append(errs, fmt.Errorf("failed to start UDP listener for logs on port %d: %v", port, err))
+			continue
+		}
+		s.udpListeners[port] = conn
 
-	// Handle UDP messages in a goroutine
-	go s.HandleUDPLogs(conn)
+		// Handle UDP messages in a goroutine
+		go s.HandleUDPLogs(conn, port, sources[port])
 
-	logger.Infof("Started UDP log listener on port 2403")
-	return nil
+		logger.Infof("Started UDP log listener on port %d", port)
+	}
+	return errors.Join(errs...)
 }
 
-// Handle incoming UDP log messages
-func (s *Server) HandleUDPLogs(conn *net.UDPConn) {
+// HandleUDPLogs handles the log messages received on a UDP log port, marking their lines
+// with source, "" for the main log port
+func (s *Server) HandleUDPLogs(conn *net.UDPConn, port int, source string) {
+	var failure error
 	defer func() {
+		if value := recover(); value != nil {
+			failure = &panicError{value: value, stack: string(debug.Stack())}
+		}
 		conn.Close()
 
 		s.udpListenerLock.Lock()
-		s.udpListener = nil
+		if s.udpListeners[port] == conn {
+			delete(s.udpListeners, port)
+		}
 		s.udpListenerLock.Unlock()
 
-		logger.Infof("UDP log listener closed")
+		logger.Infof("UDP log listener on port %d closed", port)
+		if failure != nil && s.ctx.Err() == nil {
+			s.reportFailure(INCIDENT_UDP_LOGS, port, "", failure)
+			time.AfterFunc(LISTENER_RESTART_DELAY, func() {
+				if s.ctx.Err() == nil {
+					s.InitUDPLogListener()
+				}
+			})
+		}
 	}()
 
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.flushLogFiles(time.Duration(s.settings().DeviceLogFlushInterval)*time.Millisecond, stop)
+	syncLines := s.settings().DeviceLogSync == config.DEVICE_LOG_SYNC_LINE
+
 	// Buffer for receiving UDP packets
 	packet := make([]byte, 16384)
 
 	for {
 		n, addr, err := conn.ReadFromUDP(packet)
 		if err != nil {
-			logger.Errorf("Error reading UDP logs: %v\n", err)
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("Error reading UDP logs: %v\n", err)
+				failure = err
+			}
 			return
 		}
 
@@ -901,47 +1479,39 @@ func (s *Server) HandleUDPLogs(conn *net.UDPConn) {
 		s.logBuffersLock.Lock()
 		logBuffer, exists := s.logBuffers[sanitizedIP]
 		if !exists {
-			logBuffer = NewLogBuffer(sanitizedIP, 500) // Store last 500 lines
+			logBuffer = NewLogBuffer(sanitizedIP, s.settings().DeviceLogLines)
 			s.logBuffers[sanitizedIP] = logBuffer
 
 			// Create log file
 			logFileName := fmt.Sprintf("logs_%s_%d.txt", sanitizedIP, time.Now().UnixNano())
-			logFilePath := filepath.Join("logs", logFileName)
+			logFilePath := filepath.Join(s.settings().LogDir, logFileName)
 
-			file, err := os.Create(logFilePath)
-			if err != nil {
+			header := fmt.Sprintf("=== Log started at %s for %s ===\n", time.Now().Format(time.RFC3339), senderIP)
+			if err := logBuffer.openFile(logFilePath, header); err != nil {
 				logger.Errorf("Failed to create log file for %s: %v\n", senderIP, err)
-			} else {
-				logBuffer.currentFile = file
-				file.WriteString(fmt.Sprintf("=== Log started at %s for %s ===\n",
-					time.Now().Format(time.RFC3339), senderIP))
 			}
 		}
 		s.logBuffersLock.Unlock()
 
 		// Process the log message
-		logLine := strings.TrimRight(string(packet[:n]), "\x00")
+		sequence, logLine, sequenced := parseLogSequence(strings.TrimRight(string(packet[:n]), "\x00"))
 		timestamp := time.Now().Format(time.RFC3339)
-		formattedLine := fmt.Sprintf("[%s] %s", timestamp, logLine)
+		formattedLine := fmt.Sprintf("[%s] %s", timestamp, tagLogLine(source, logLine))
+		severity := parseSeverity(logLine)
 
 		logBuffer.mu.Lock()
+		logBuffer.lossTracker(source).observe(sequence, sequenced, logLine)
 
 		// Add to circular buffer
-		if len(logBuffer.logLines) >= logBuffer.maxLines {
-			// Remove oldest entry if at capacity
-			logBuffer.logLines = append(logBuffer.logLines[1:], formattedLine)
-		} else {
-			// Otherwise just append
-			logBuffer.logLines = append(logBuffer.logLines, formattedLine)
-		}
+		logBuffer.add(LogEntry{Line: formattedLine, Severity: severity, Source: source})
 
-		// Write to file if open
-		if logBuffer.currentFile != nil {
-			logBuffer.currentFile.WriteString(formattedLine + "\n")
-			logBuffer.currentFile.Sync() // FlushAsync to disk
-		}
+		// Write to file if open, lines are flushed by flushLogFiles unless every line is synced
+		logBuffer.writeLine(formattedLine+"\n", syncLines)
 
 		logBuffer.mu.Unlock()
+
+		s.publishDeviceLog(sanitizedIP, formattedLine, severity, source)
+		s.forwardSyslog(sanitizedIP, logLine, severity)
 	}
 }
 
@@ -962,7 +1532,9 @@ func (s *Server) GetLastLogs(ip string) []string {
 
 	// Copy the log lines
 	result := make([]string, len(buffer.logLines))
-	copy(result, buffer.logLines)
+	for i, entry := range buffer.logLines {
+		result[i] = entry.Line
+	}
 
 	// Reverse the array to get newest to oldest order
 	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
@@ -987,56 +1559,124 @@ func (s *Server) ShutdownSimple() {
 	logger.Infof("Server shutdown complete")
 }
 
-// Enhanced Shutdown method with better FlushAsyncing guarantees
+// Shutdown stops the server, waiting up to SHUTDOWN_TIMEOUT for buffers to be written
 func (s *Server) Shutdown() {
-	logger.Infof("Shutting down server...")
-
-	// // Use a WaitGroup to ensure all FlushAsync operations complete
-	// var wg sync.WaitGroup
+	if err := s.Stop(SHUTDOWN_TIMEOUT); err != nil {
+		logger.Errorf("%v - some data may be lost\n", err)
+	}
+}
 
-	// First stop the UDP listener to prevent new incoming data
-	s.StopAllLogListeners()
+// Stop closes the listeners and the UDP log listener, cancels the connection readers
+// and waits until their buffers have been flushed and written, or the timeout expires.
+// Only the first call stops the server, later calls return nil immediately.
+func (s *Server) Stop(timeout time.Duration) error {
+	err := error(nil)
+	s.stopOnce.Do(func() {
+		logger.Infof("Shutting down server...")
+		deadline := time.NewTimer(timeout)
+		defer deadline.Stop()
+
+		// Stop accepting data first, cancelling the accept loops and readers closes their
+		// sockets and the readers flush their buffers on exit
+		s.StopAllLogListeners()
+		s.cancel()
+
+		if !waitUntil(s.connectionWg.Wait, deadline.C) {
+			err = fmt.Errorf("timed out waiting for connections to close")
+			return
+		}
+		logger.Infof("All connections and flushes completed successfully")
+		s.stopAllRecordings()
+		s.foldAllBandwidth()
+		if saveErr := s.bandwidth.Save(); saveErr != nil {
+			logger.Errorf("%v\n", saveErr)
+		}
 
-	// Close all active connections
-	s.activeConnsLock.Lock()
-	for key, conn := range s.activeConns {
-		logger.Infof("Closing connection for %s:%d", key.IP, key.Port)
-		conn.Close()
-	}
-	s.activeConnsLock.Unlock()
+		// Wait for background writes queued by FlushAsync
+		if !waitUntil(s.writer.wait, deadline.C) {
+			err = fmt.Errorf("timed out waiting for background writes")
+			return
+		}
+		logger.Infof("Server shutdown complete")
+	})
+	return err
+}
 
-	// Wait with timeout for all HandleConnection goroutines to complete
-	// This includes their buffer flushes since Done() is called after flushing
+// waitUntil runs wait, returning false if deadline fires first
+func waitUntil(wait func(), deadline <-chan time.Time) bool {
 	done := make(chan struct{})
 	go func() {
-		s.connectionWg.Wait()
-		logger.Infof("Global connection tracking WaitGroup done")
+		wait()
 		close(done)
 	}()
-
 	select {
 	case <-done:
-		logger.Infof("All connections and flushes completed successfully")
-	case <-time.After(10 * time.Second):
-		logger.Errorf("Timed out waiting for connections to complete - some data may be lost")
+		return true
+	case <-deadline:
+		return false
 	}
-	logger.Infof("Server shutdown complete")
 }
 
 // Add a method to register an active connection
-func (s *Server) registerConnection(key BufferKey, conn net.Conn) {
+// registerConnection registers a data connection of the device uuid. If the IP and port
+// already have a connection the configured duplicate connection policy decides which
+// connection is kept. Returns the key the connection is registered under, or false if
+// it was rejected.
+func (s *Server) registerConnection(key BufferKey, conn net.Conn, uuid string) (BufferKey, bool) {
 	s.activeConnsLock.Lock()
 	defer s.activeConnsLock.Unlock()
 
 	// Check if there's an existing connection for this IP:Port
-	if existingConn, exists := s.activeConns[key]; exists {
-		// Close the existing connection
-		logger.Infof("Closing existing connection for %s:%d", key.IP, key.Port)
-		existingConn.Close()
+	existingConn, exists := s.activeConns[key]
+	if !exists {
+		s.activeConns[key] = conn
+		return key, true
+	}
+
+	s.buffersLock.RLock()
+	existingUUID := ""
+	if buffer, exists := s.buffers[key]; exists {
+		existingUUID = buffer.uuid
+	}
+	s.buffersLock.RUnlock()
+
+	duplicate := DuplicateConnection{IP: key.IP, Port: key.Port, UUID: uuid, ExistingUUID: existingUUID}
+	switch policy := s.settings().DuplicateConnections; {
+	case policy == config.DUPLICATE_REJECT:
+		duplicate.Action = DUPLICATE_REJECTED
+	case policy == config.DUPLICATE_PARALLEL && uuid != "" && existingUUID != "" && uuid != existingUUID:
+		duplicate.Action = DUPLICATE_PARALLEL
+		key.UUID = uuid
+		// The second device reconnecting replaces its own parallel connection
+		existingConn, exists = s.activeConns[key]
+	default:
+		duplicate.Action = DUPLICATE_REPLACED
+	}
+	s.notifyDuplicate(duplicate)
+	if duplicate.Action == DUPLICATE_REJECTED {
+		return key, false
 	}
 
-	// Register the new connection
+	if exists {
+		existingConn.Close()
+	}
 	s.activeConns[key] = conn
+	return key, true
+}
+
+// otherDeviceActive reports whether a device other than uuid has a data connection open
+// from ip
+func (s *Server) otherDeviceActive(ip string, uuid string) bool {
+	s.activeConnsLock.Lock()
+	defer s.activeConnsLock.Unlock()
+	s.buffersLock.RLock()
+	defer s.buffersLock.RUnlock()
+	for key := range s.activeConns {
+		if buffer, exists := s.buffers[key]; exists && key.IP == ip && buffer.uuid != "" && buffer.uuid != uuid {
+			return true
+		}
+	}
+	return false
 }
 
 // Add a method to unregister a connection
@@ -1049,90 +1689,115 @@ func (s *Server) unregisterConnection(key BufferKey) {
 
 func (s *Server) HandleHandshakeConnection(conn net.Conn) {
 	defer conn.Close()
+	defer s.recoverPanic(INCIDENT_HANDSHAKE, s.settings().HandshakePort, GetClientIP(conn.RemoteAddr()))
+	stopConn := context.AfterFunc(s.ctx, func() { conn.Close() })
+	defer stopConn()
 
 	clientIP := GetClientIP(conn.RemoteAddr())
 
-	// Buffer for reading the handshake data
-	buffer := make([]byte, 4096)
-
 	// Read handshake data
-	n, err := conn.Read(buffer)
+	messages := newDeviceMessages(conn)
+	message, err := messages.readHandshake()
 	if err != nil {
 		logger.Errorf("Error reading handshake from %s: %v\n", clientIP, err)
 		return
 	}
 
-	// Define a struct to match the expected JSON structure
-	type HandshakeData struct {
-		UUID            string `json:"uuid"`
-		MAC             string `json:"mac"`
-		FirmwareVersion string `json:"firmware,omitempty"`
-		HardwareVersion string `json:"hardware,omitempty"`
-		VgsSampleRate   string `json:"vgsSampleRate,omitempty"`
-		VdsSampleRate   string `json:"vdsSampleRate,omitempty"`
-		TcSampleRate    string `json:"tcSampleRate,omitempty"`
-		// Add any other fields you expect in the handshake
-	}
-
-	// Parse the JSON data
-	var handshakeData HandshakeData
-	if err := json.Unmarshal(buffer[:n], &handshakeData); err != nil {
-		logger.Errorf("Failed to parse handshake JSON from %s: %v\n", clientIP, err)
-		// Send error response
-		// conn.Write([]byte(`{"status":"error","message":"Invalid JSON format"}`))
+	// Parse the handshake with the parser of its schema version
+	handshakeData, err := ParseHandshake(message)
+	if err != nil {
+		logger.Errorf("Invalid handshake from %s: %v\n", clientIP, err)
 		return
 	}
-
-	// Validate required fields
-	if handshakeData.UUID == "" {
-		logger.Errorf("Missing UUID in handshake from %s\n", clientIP)
-		// conn.Write([]byte(`{"status":"error","message":"UUID is required"}`))
-		return
+	if handshakeData.SchemaVersion > HANDSHAKE_SCHEMA_LATEST {
+		logger.Infof("Handshake from %s uses schema version %d, newer than the supported version %d\n",
+			clientIP, handshakeData.SchemaVersion, HANDSHAKE_SCHEMA_LATEST)
 	}
 
 	// Log the received handshake data
-	logger.Infof("Received handshake from %s: UUID=%s, Hardware=%s, Firmware=%s\n",
-		clientIP, handshakeData.UUID, handshakeData.HardwareVersion, handshakeData.FirmwareVersion)
+	logger.InfoFields("Received handshake", logger.Fields{
+		"ip":       clientIP,
+		"uuid":     handshakeData.UUID,
+		"hardware": handshakeData.HardwareVersion,
+		"firmware": handshakeData.FirmwareVersion,
+		"schema":   handshakeData.SchemaVersion,
+		"missing":  strings.Join(handshakeData.Missing, ","),
+		"ignored":  strings.Join(handshakeData.Unknown, ","),
+	})
+
+	// Link the connection to the device registry
+	sanitizedIP := SanitizeFilename(clientIP)
+	device := s.registerHandshake(handshakeData.UUID, sanitizedIP, handshakeData.MAC,
+		handshakeData.FirmwareVersion, handshakeData.HardwareVersion, handshakeData.Channels)
 
 	// Store the UUID for this IP
 	s.connectedIPsLock.Lock()
-	sanitizedIP := SanitizeFilename(clientIP)
 	if ipConn, exists := s.connectedIPs[sanitizedIP]; exists {
 		ipConn.UUID = handshakeData.UUID
+		ipConn.Alias = device.Alias
 		ipConn.FirmwareVersion = handshakeData.FirmwareVersion
 		ipConn.HardwareVersion = handshakeData.HardwareVersion
 		ipConn.MAC = handshakeData.MAC
-		ipConn.VdsSampleRate, _ = strconv.Atoi(handshakeData.VdsSampleRate)
-		ipConn.VgsSampleRate, _ = strconv.Atoi(handshakeData.VgsSampleRate)
-		ipConn.TcSampleRate, _ = strconv.Atoi(handshakeData.TcSampleRate)
-		// You might want to store other handshake data as well
+		ipConn.VdsSampleRate = handshakeData.VdsSampleRate
+		ipConn.VgsSampleRate = handshakeData.VgsSampleRate
+		ipConn.TcSampleRate = handshakeData.TcSampleRate
+		ipConn.SchemaVersion = handshakeData.SchemaVersion
+		ipConn.Channels = handshakeData.Channels
+		ipConn.Status = nil
+		ipConn.Latency = nil
 	} else {
-		vdsSampleRate, _ := strconv.Atoi(handshakeData.VdsSampleRate)
-		vgsSampleRate, _ := strconv.Atoi(handshakeData.VgsSampleRate)
-		tcSampleRate, _ := strconv.Atoi(handshakeData.TcSampleRate)
 		s.connectedIPs[sanitizedIP] = &IPConnection{
 			ActivePorts:     make(map[int]bool),
-			TotalBytes:      0,
 			UUID:            handshakeData.UUID,
+			Alias:           device.Alias,
 			FirmwareVersion: handshakeData.FirmwareVersion,
 			HardwareVersion: handshakeData.HardwareVersion,
 			MAC:             handshakeData.MAC,
-			VdsSampleRate:   vdsSampleRate,
-			VgsSampleRate:   vgsSampleRate,
-			TcSampleRate:    tcSampleRate,
+			VdsSampleRate:   handshakeData.VdsSampleRate,
+			VgsSampleRate:   handshakeData.VgsSampleRate,
+			TcSampleRate:    handshakeData.TcSampleRate,
+			SchemaVersion:   handshakeData.SchemaVersion,
+			Channels:        handshakeData.Channels,
+			bytes:           new(atomic.Int64),
 		}
 	}
 	s.connectedIPsLock.Unlock()
+	s.notifyConnection(DeviceConnectionChange{IP: sanitizedIP, UUID: handshakeData.UUID, Alias: device.Alias, Change: CONNECTION_HANDSHAKE})
 
-	// Update existing data buffers with this UUID
+	// Update existing data buffers with this UUID. Parallel connections belong to the
+	// device of their key. Data connections do not identify their device, so a buffer
+	// keyed by IP and port alone is only claimed while no other device behind the IP has
+	// data connections open.
+	otherActive := s.otherDeviceActive(clientIP, handshakeData.UUID)
 	s.buffersLock.Lock()
+	sessionDir := s.sessionDir(handshakeData.UUID)
+	openPorts := false
 	for key, buffer := range s.buffers {
-		if key.IP == sanitizedIP {
+		if key.IP != clientIP {
+			continue
+		}
+		if key.UUID == handshakeData.UUID || key.UUID == "" && (buffer.uuid == handshakeData.UUID || !otherActive) {
 			buffer.uuid = handshakeData.UUID
+			buffer.setSessionDir(sessionDir)
+			buffer.setAlias(device.Alias)
+			buffer.setCodec(s.codecForDevice(handshakeData.UUID, buffer.port))
+			selectThermocoupleType(buffer.samples.decoder, thermocoupleType(handshakeData.Channels, buffer.port))
+			s.applyCalibrations(buffer, handshakeData.UUID)
+			openPorts = true
 		}
 	}
 	s.buffersLock.Unlock()
 
+	// Data ports opened before the handshake
+	if openPorts {
+		s.autoStartRecording(handshakeData.UUID)
+	}
+
+	// Firmware with a status interval keeps the connection open for status messages
+	if handshakeData.StatusInterval > 0 {
+		s.readDeviceStatus(messages, sanitizedIP, handshakeData.UUID, handshakeData.StatusInterval)
+	}
+
 	// Send acknowledgment as JSON
 	// response := struct {
 	// 	Status  string `json:"status"`
@@ -1163,14 +1828,19 @@ func (s *Server) GetIPConnectionData(ip string) (IPConnection, bool) {
 		// Create a deep copy of the connection
 		connectionCopy := IPConnection{
 			ActivePorts:     make(map[int]bool),
-			TotalBytes:      connection.TotalBytes,
+			TotalBytes:      connection.totalBytes(),
 			UUID:            connection.UUID,
+			Alias:           connection.Alias,
 			MAC:             connection.MAC,
 			FirmwareVersion: connection.FirmwareVersion,
 			HardwareVersion: connection.HardwareVersion,
 			VgsSampleRate:   connection.VgsSampleRate,
 			VdsSampleRate:   connection.VdsSampleRate,
 			TcSampleRate:    connection.TcSampleRate,
+			SchemaVersion:   connection.SchemaVersion,
+			Channels:        slices.Clone(connection.Channels),
+			Status:          connection.Status,
+			Latency:         connection.Latency,
 		}
 
 		// Deep copy the map
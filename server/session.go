@@ -0,0 +1,261 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	SESSION_MANIFEST = "session.json" // Session description written to each session directory
+)
+
+// Session is a recording of one device. While a session is active the device's segments
+// are written to the session directory instead of the data directory.
+type Session struct {
+	ID    string
+	UUID  string
+	IP    string
+	Dir   string    // Directory holding the session's segments and manifest
+	Start time.Time // Time the session was started
+	Stop  time.Time // Time the session was stopped, zero while recording
+	Auto  bool      // Started automatically when the device connected
+	// Group the session was started with and the session's start relative to the group's
+	// start command, see StartGroupRecording
+	Group       string        `json:",omitempty"`
+	GroupOffset time.Duration `json:",omitempty"`
+	// Gaps are the runs of frames missing from the recording, see SequenceGap
+	Gaps []SequenceGap `json:",omitempty"`
+	// Markers added while recording, see AddMarker
+	Markers []Marker `json:",omitempty"`
+	// Bytes received from the device while recording, set when the session stops
+	Bytes int64 `json:",omitempty"`
+
+	startBytes int64                  // Bytes received from the device before the session started
+	totals     map[int]*sessionTotals // Data received per port, see SessionStats
+}
+
+// writeManifest writes the session description to its directory
+func (session *Session) writeManifest() error {
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(session.Dir, SESSION_MANIFEST), data, 0644); err != nil {
+		return fmt.Errorf("failed to write session manifest: %v", err)
+	}
+	return nil
+}
+
+// setSessionDir changes the directory future flushes are written to, "" writes to the data
+// directory. Data received in a session is counted for its summary, see takeSessionTotals.
+func (db *DataBuffer) setSessionDir(dir string) {
+	db.mu.Lock()
+	db.sessionDir = dir
+	db.mu.Unlock()
+
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	if dir != "" && db.sessionTotals == nil {
+		db.sessionTotals = &sessionTotals{}
+	}
+}
+
+// sessionDir returns the directory of the active session of a device, or ""
+func (s *Server) sessionDir(uuid string) string {
+	s.sessionsLock.RLock()
+	defer s.sessionsLock.RUnlock()
+	if session, exists := s.sessions[uuid]; exists {
+		return session.Dir
+	}
+	return ""
+}
+
+// deviceBuffers returns the live buffers of a device
+func (s *Server) deviceBuffers(uuid string) []*DataBuffer {
+	s.buffersLock.RLock()
+	defer s.buffersLock.RUnlock()
+	var buffers []*DataBuffer
+	for _, buffer := range s.buffers {
+		if buffer.uuid == uuid {
+			buffers = append(buffers, buffer)
+		}
+	}
+	return buffers
+}
+
+// StartRecording starts a recording session for a device. Data received before the
+// session is flushed to the data directory first.
+func (s *Server) StartRecording(uuid string) (Session, error) {
+	return s.startRecording(Session{UUID: uuid}, time.Time{})
+}
+
+// startRecording starts a session for template.UUID, filling in the remaining fields
+// of template. GroupOffset is measured from groupStart unless it is zero.
+func (s *Server) startRecording(template Session, groupStart time.Time) (Session, error) {
+	uuid := template.UUID
+	if uuid == "" {
+		return Session{}, fmt.Errorf("device UUID is required")
+	}
+
+	ip := ""
+	for connectedIP, conn := range s.GetAllConnectedIPs() {
+		if conn.UUID == uuid {
+			ip = connectedIP
+		}
+	}
+	start := time.Now()
+	id := fmt.Sprintf("%s_%s", start.Format("20060102-150405"), SanitizeFilename(uuid))
+	session := &template
+	session.ID = id
+	session.IP = ip
+	session.Dir = filepath.Join(s.settings().DataDir, id)
+	session.Start = start
+	s.foldBandwidth(s.deviceBuffers(uuid)...)
+	session.startBytes = s.bandwidth.Total(uuid)
+	if !groupStart.IsZero() {
+		session.GroupOffset = start.Sub(groupStart)
+	}
+
+	s.sessionsLock.Lock()
+	if active, exists := s.sessions[uuid]; exists {
+		s.sessionsLock.Unlock()
+		return *active, fmt.Errorf("device %s is already recording session %s", uuid, active.ID)
+	}
+	if err := os.MkdirAll(session.Dir, 0755); err != nil {
+		s.sessionsLock.Unlock()
+		return Session{}, fmt.Errorf("failed to create session directory: %v", err)
+	}
+	if err := session.writeManifest(); err != nil {
+		s.sessionsLock.Unlock()
+		return Session{}, err
+	}
+	s.sessions[uuid] = session
+	s.sessionsLock.Unlock()
+
+	for _, buffer := range s.deviceBuffers(uuid) {
+		buffer.FlushAsync()
+		buffer.setSessionDir(session.Dir)
+	}
+
+	logger.InfoFields("Recording started", logger.Fields{
+		"session": session.ID,
+		"uuid":    uuid,
+		"ip":      ip,
+		"auto":    session.Auto,
+		"group":   session.Group,
+	})
+	return *session, nil
+}
+
+// StopRecording stops the recording session of a device. Data received during the
+// session is flushed to the session directory.
+func (s *Server) StopRecording(uuid string) error {
+	s.sessionsLock.Lock()
+	session, exists := s.sessions[uuid]
+	if !exists {
+		s.sessionsLock.Unlock()
+		return fmt.Errorf("device %s is not recording", uuid)
+	}
+	delete(s.sessions, uuid)
+	s.sessionsLock.Unlock()
+
+	for _, buffer := range s.deviceBuffers(uuid) {
+		buffer.FlushAsync()
+		buffer.setSessionDir("")
+		session.Gaps = append(session.Gaps, buffer.takeGaps()...)
+		session.addTotals(buffer.port, buffer.takeSessionTotals())
+	}
+	s.writer.closeManifest(session.Dir)
+
+	session.Stop = time.Now()
+	s.foldBandwidth(s.deviceBuffers(uuid)...)
+	session.Bytes = s.bandwidth.Total(uuid) - session.startBytes
+	logger.InfoFields("Recording stopped", logger.Fields{
+		"session":  session.ID,
+		"uuid":     uuid,
+		"duration": session.Stop.Sub(session.Start).Round(time.Second).String(),
+	})
+	if err := session.writeSummary(); err != nil {
+		logger.Errorf("%v\n", err)
+	}
+	return session.writeManifest()
+}
+
+// stopAllRecordings stops every active session
+func (s *Server) stopAllRecordings() {
+	for _, session := range s.GetActiveSessions() {
+		if err := s.StopRecording(session.UUID); err != nil {
+			logger.Errorf("Failed to stop session %s: %v\n", session.ID, err)
+		}
+	}
+}
+
+// GetActiveSessions returns the sessions currently recording, oldest first
+func (s *Server) GetActiveSessions() []Session {
+	s.sessionsLock.RLock()
+	defer s.sessionsLock.RUnlock()
+	sessions := make([]Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+	return sessions
+}
+
+// autoStartRecording starts a session for a device with auto-record enabled, unless
+// one is already active
+func (s *Server) autoStartRecording(uuid string) {
+	if uuid == "" || !s.GetAutoRecord(uuid) || s.sessionDir(uuid) != "" {
+		return
+	}
+	if _, err := s.startRecording(Session{UUID: uuid, Auto: true}, time.Time{}); err != nil {
+		logger.Errorf("Failed to start recording for %s: %v\n", uuid, err)
+	}
+}
+
+// autoStopRecording stops an automatically started session once its device has
+// closed all data ports
+func (s *Server) autoStopRecording(uuid string) {
+	s.sessionsLock.RLock()
+	session, exists := s.sessions[uuid]
+	auto := exists && session.Auto
+	s.sessionsLock.RUnlock()
+	if !auto {
+		return
+	}
+	if err := s.StopRecording(uuid); err != nil {
+		logger.Errorf("Failed to stop recording for %s: %v\n", uuid, err)
+	}
+}
+
+// SetAutoRecord enables or disables starting a session when a device connects and
+// stores it in the device registry
+func (s *Server) SetAutoRecord(uuid string, enabled bool) error {
+	if uuid == "" {
+		return fmt.Errorf("device UUID is required")
+	}
+	device, err := s.registry.Update(uuid, func(device *Device) {
+		device.Settings.AutoRecord = enabled
+	})
+	if err != nil {
+		return err
+	}
+	logger.Infof("Auto-record for %s set to %v\n", uuid, enabled)
+
+	// Start right away if the device is already connected
+	s.applyDevice(device)
+	return nil
+}
+
+// GetAutoRecord reports whether a session is started when the device connects
+func (s *Server) GetAutoRecord(uuid string) bool {
+	device, _ := s.registry.Get(uuid)
+	return device.Settings.AutoRecord
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"eth-daq-software/config"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRecordingSession tests that segments are written to the session directory only
+// while the session is active
+func TestRecordingSession(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.Features.Compression = "none"
+	s := NewServer(cfg)
+
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+	buffer.writer = s.writer
+	s.buffers[BufferKey{IP: "10_0_0_2", Port: 5556}] = buffer
+
+	buffer.AddData(make([]byte, 100)) // Before the session
+	session, err := s.StartRecording("dev1")
+	if err != nil {
+		t.Fatalf("StartRecording() = %v", err)
+	}
+	if _, err := s.StartRecording("dev1"); err == nil {
+		t.Error("Expected error when starting a second session, got nil")
+	}
+	buffer.AddData(make([]byte, 200)) // During the session
+	if err := s.StopRecording("dev1"); err != nil {
+		t.Fatalf("StopRecording() = %v", err)
+	}
+	buffer.AddData(make([]byte, 300)) // After the session
+	buffer.FlushAsync()
+	s.writer.wait()
+
+	sizes := func(dir string) []int64 {
+		var sizes []int64
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if filepath.Ext(entry.Name()) == ".bin" {
+				info, _ := entry.Info()
+				sizes = append(sizes, info.Size())
+			}
+		}
+		return sizes
+	}
+	if got := sizes(session.Dir); len(got) != 1 || got[0] != 200 {
+		t.Errorf("Session segments = %v, want [200]", got)
+	}
+	if got := sizes(cfg.DataDir); len(got) != 2 || got[0]+got[1] != 400 {
+		t.Errorf("Data directory segments = %v, want 100 and 300 bytes", got)
+	}
+
+	segments, err := ReadSegmentManifest(session.Dir)
+	if err != nil || len(segments.Segments) != 1 {
+		t.Fatalf("ReadSegmentManifest() = %+v, %v, want one segment", segments, err)
+	}
+	record := segments.Segments[0]
+	if record.Name != "port5556_000001.bin" || record.Sequence != 1 || record.Bytes != 200 || record.Samples != 100 ||
+		record.UUID != "dev1" || record.End.Before(record.Start) {
+		t.Errorf("Segment manifest entry = %+v, want segment 1 of 200 bytes", record)
+	}
+	segment, err := ParseSegmentName(filepath.Join(session.Dir, record.Name))
+	if err != nil || segment.UUID != "dev1" || segment.Port != 5556 || segment.Sequence != 1 ||
+		segment.Timestamp != record.Start.UnixNano() {
+		t.Errorf("ParseSegmentName(%s) = %+v, %v, want segment 1 of dev1", record.Name, segment, err)
+	}
+
+	var manifest Session
+	data, _ := os.ReadFile(filepath.Join(session.Dir, SESSION_MANIFEST))
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Stop.IsZero() || manifest.UUID != "dev1" {
+		t.Errorf("Manifest = %+v, %v, want stopped session of dev1", manifest, err)
+	}
+	if active := s.GetActiveSessions(); len(active) != 0 {
+		t.Errorf("Active sessions = %v, want none", active)
+	}
+
+	sessions, err := s.ListSessions()
+	if err != nil {
+		t.Fatalf("ListSessions() = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != session.ID || sessions[0].Recording || sessions[0].Segments != 1 ||
+		sessions[0].Size <= 200 || len(sessions[0].Channels) != 1 || sessions[0].Channels[0] != "vgs" {
+		t.Errorf("ListSessions() = %+v, want the stopped session with one vgs segment", sessions)
+	}
+}
+
+// TestGroupRecording tests that a group starts and stops a session for each member
+func TestGroupRecording(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	s.groups = NewGroupStore(filepath.Join(t.TempDir(), DEVICE_GROUPS_FILE))
+
+	if _, err := s.StartGroupRecording("rig"); err == nil {
+		t.Error("Expected error for unknown group, got nil")
+	}
+	if err := s.SetGroup("rig", []string{"dev2", "dev1"}); err != nil {
+		t.Fatalf("SetGroup() = %v", err)
+	}
+
+	sessions, err := s.StartGroupRecording("rig")
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("StartGroupRecording() = %v, %v, want 2 sessions", sessions, err)
+	}
+	for _, session := range sessions {
+		if session.Group != "rig" || session.GroupOffset < 0 {
+			t.Errorf("Session %s group = %q offset %v, want rig and non-negative offset",
+				session.UUID, session.Group, session.GroupOffset)
+		}
+	}
+	if err := s.StopGroupRecording("rig"); err != nil {
+		t.Fatalf("StopGroupRecording() = %v", err)
+	}
+	if active := s.GetActiveSessions(); len(active) != 0 {
+		t.Errorf("Active sessions = %v, want none", active)
+	}
+	if err := s.StopGroupRecording("rig"); err == nil {
+		t.Error("Expected error when the group is not recording, got nil")
+	}
+}
+
+// TestDeleteArchiveSession tests that only stopped sessions are deleted or archived
+func TestDeleteArchiveSession(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+
+	first, err := s.StartRecording("dev1")
+	if err != nil {
+		t.Fatalf("StartRecording() = %v", err)
+	}
+	if err := s.DeleteSession(first.ID); err == nil {
+		t.Error("Expected error deleting a recording session, got nil")
+	}
+	if err := s.StopRecording("dev1"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.StartRecording("dev2")
+	if err != nil {
+		t.Fatalf("StartRecording() = %v", err)
+	}
+	if err := s.StopRecording("dev2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"", "..", "../" + first.ID, "unknown"} {
+		if err := s.DeleteSession(id); err == nil {
+			t.Errorf("Expected error deleting session %q, got nil", id)
+		}
+	}
+	if err := s.DeleteSession(first.ID); err != nil {
+		t.Errorf("DeleteSession() = %v", err)
+	}
+	dest := t.TempDir()
+	if err := s.ArchiveSession(second.ID, dest); err != nil {
+		t.Fatalf("ArchiveSession() = %v", err)
+	}
+	if _, err := readSessionManifest(filepath.Join(dest, second.ID)); err != nil {
+		t.Errorf("Archived manifest: %v", err)
+	}
+	if sessions, _ := s.ListSessions(); len(sessions) != 0 {
+		t.Errorf("ListSessions() = %+v, want none", sessions)
+	}
+}
+
+// TestExportSession tests that every port of a session is exported and the export reports
+// its end
+func TestExportSession(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+	session, err := s.StartRecording("dev1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StopRecording("dev1"); err != nil {
+		t.Fatal(err)
+	}
+	writeSegment(t, session.Dir, 5555, 1, []uint16{0, 1})
+	writeSegment(t, session.Dir, 5556, 1, []uint16{32768})
+
+	done := make(chan ExportProgress, 1)
+	s.SetEventEmitter(func(name string, data ...interface{}) {
+		if progress := data[0].(ExportProgress); name == EXPORT_PROGRESS_EVENT && progress.Finished {
+			done <- progress
+		}
+	})
+	if err := s.ExportSession(session.ID, "hdf5", SessionExportOptions{Dir: t.TempDir()}); err == nil {
+		t.Error("Expected error for an unknown format, got nil")
+	}
+	dest := t.TempDir()
+	if err := s.ExportSession(session.ID, EXPORT_CSV, SessionExportOptions{Dir: dest}); err != nil {
+		t.Fatalf("ExportSession() = %v", err)
+	}
+	progress := <-done
+	if progress.Error != "" || progress.Done != 2 || len(progress.Files) != 2 {
+		t.Fatalf("Progress = %+v, want 2 files", progress)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, session.ID+"_port5556.csv"))
+	if err != nil || string(data) != "sample,vgs [V]\n0,0\n" {
+		t.Errorf("Exported %q, %v", data, err)
+	}
+}
+
+// TestSessionSummary tests that the channel statistics of a session, over its connections,
+// are written to the session directory and listed with the session
+func TestSessionSummary(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.Features.Compression = "none"
+	s := NewServer(cfg)
+
+	key := BufferKey{IP: "10_0_0_2", Port: 5556}
+	buffer := NewDataBuffer(key.Port, key.IP, 10, "dev1", 1<<20)
+	buffer.writer = s.writer
+	s.buffers[key] = buffer
+	buffer.AddData(binary.LittleEndian.AppendUint16(nil, 0)) // Before the session
+	if _, err := s.StartRecording("dev1"); err != nil {
+		t.Fatalf("StartRecording() = %v", err)
+	}
+	buffer.AddData(binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, 32768), 32768))
+	s.recordSessionTotals(buffer) // Connection closed
+
+	reconnected := NewDataBuffer(key.Port, key.IP, 10, "dev1", 1<<20)
+	reconnected.writer = s.writer
+	reconnected.setSessionDir(s.sessionDir("dev1"))
+	s.buffers[key] = reconnected
+	reconnected.AddData(binary.LittleEndian.AppendUint16(nil, 0))
+	s.addMarker(Marker{Time: time.Now(), Label: "device degraded", Source: MARKER_ALARM, UUID: "dev1"})
+	s.sessions["dev1"].Gaps = append(s.sessions["dev1"].Gaps, SequenceGap{Port: 5556, Count: 3})
+	if err := s.StopRecording("dev1"); err != nil {
+		t.Fatalf("StopRecording() = %v", err)
+	}
+	s.writer.wait()
+
+	sessions, err := s.ListSessions()
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("ListSessions() = %+v, %v, want one session", sessions, err)
+	}
+	stats, err := s.GetSessionSummary(sessions[0].ID)
+	if err != nil || len(stats.Channels) != 1 || len(sessions[0].Stats) != 1 {
+		t.Fatalf("GetSessionSummary() = %+v, %v, want one channel in the summary and the list", stats, err)
+	}
+	channel := stats.Channels[0]
+	if channel.Port != 5556 || channel.Channel != "vgs" || channel.Bytes != 6 || channel.Samples != 3 ||
+		math.Abs(channel.Min-scaleGADC(0)) > 1e-9 || math.Abs(channel.Max-scaleGADC(32768)) > 1e-9 || channel.Gaps != 1 || channel.Alarms != 1 ||
+		channel.Duration <= 0 || channel.Last.Before(channel.First) {
+		t.Errorf("Channel summary = %+v, want 3 vgs samples of both connections with one gap and alarm", channel)
+	}
+	if want := (2*scaleGADC(32768) + scaleGADC(0)) / 3; math.Abs(channel.Mean-want) > 1e-9 {
+		t.Errorf("Mean = %v, want %v", channel.Mean, want)
+	}
+}
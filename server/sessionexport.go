@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"eth-daq-software/logger"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	EXPORT_CSV            = "csv"             // Built-in session export format, one CSV file per port
+	EXPORT_PROGRESS_EVENT = "export-progress" // Event carrying the ExportProgress of a session export
+)
+
+// Exporter writes the segments of one port of a device, in capture order, in an export
+// format. Exporters for formats such as HDF5 or Parquet, which need libraries the
+// application is not built with, can be added with RegisterExporter from a plugin, see
+// LoadDecoderPlugins.
+type Exporter func(w io.Writer, segments []SegmentInfo, calibration *Calibration, calibrationB *Calibration, opts ExportOptions) error
+
+// exportFormat is a registered export format
+type exportFormat struct {
+	extension string // File extension including the dot
+	export    Exporter
+}
+
+var (
+	exporters     = map[string]exportFormat{EXPORT_CSV: {extension: ".csv", export: ExportCSV}}
+	exportersLock sync.RWMutex
+)
+
+// RegisterExporter makes an export format available to ExportSession. Files are written
+// with the extension, e.g. ".parquet".
+func RegisterExporter(name string, extension string, export Exporter) {
+	exportersLock.Lock()
+	defer exportersLock.Unlock()
+	exporters[name] = exportFormat{extension: extension, export: export}
+}
+
+// GetExportFormats returns the names of the registered export formats
+func GetExportFormats() []string {
+	exportersLock.RLock()
+	defer exportersLock.RUnlock()
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SessionExportOptions controls an export of a whole session
+type SessionExportOptions struct {
+	ExportOptions
+	Dir string // Directory the files are written to, one per port named <session>_port<port>
+}
+
+// ExportProgress reports the progress of a session export
+type ExportProgress struct {
+	Session  string
+	Format   string
+	Files    []string // Files written so far
+	Done     int      // Ports exported
+	Total    int      // Ports to export
+	Finished bool     // The export completed, failed or was cancelled
+	Error    string   // Why the export failed, "" if it did not
+}
+
+// ctxWriter fails writes once its context is done, cancelling an exporter at its next write
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw ctxWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+// ExportSession exports all ports of a stopped session in the background, sending
+// EXPORT_PROGRESS_EVENT after each port and when the export finishes. A session is
+// exported once at a time, CancelExport stops the export.
+func (s *Server) ExportSession(id string, format string, opts SessionExportOptions) error {
+	dir, err := s.sessionPath(id)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		format = EXPORT_CSV
+	}
+	exportersLock.RLock()
+	exporter, exists := exporters[format]
+	exportersLock.RUnlock()
+	if !exists {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	if opts.Dir == "" {
+		return fmt.Errorf("export directory is required")
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %v", err)
+	}
+	session, err := readSessionManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read session %s: %v", id, err)
+	}
+	ports := make(map[int][]SegmentInfo)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "port") || !isSegmentFile(name) {
+			continue
+		}
+		if segment, err := ParseSegmentName(filepath.Join(dir, name)); err == nil {
+			ports[segment.Port] = append(ports[segment.Port], segment)
+		}
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("session %s has no segments", id)
+	}
+
+	s.exportsLock.Lock()
+	if _, running := s.exports[id]; running {
+		s.exportsLock.Unlock()
+		return fmt.Errorf("session %s is already being exported", id)
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.exports[id] = cancel
+	s.exportsLock.Unlock()
+
+	go func() {
+		defer func() {
+			s.exportsLock.Lock()
+			delete(s.exports, id)
+			s.exportsLock.Unlock()
+			cancel()
+		}()
+
+		progress := ExportProgress{Session: id, Format: format, Total: len(ports)}
+		for _, port := range slices.Sorted(maps.Keys(ports)) {
+			dest := filepath.Join(opts.Dir, fmt.Sprintf("%s_port%d%s", id, port, exporter.extension))
+			err := s.exportSessionPort(ctx, exporter.export, session.UUID, ports[port], dest, opts.ExportOptions)
+			if err != nil {
+				os.Remove(dest)
+				if errors.Is(err, context.Canceled) {
+					err = fmt.Errorf("export cancelled")
+				}
+				progress.Error = err.Error()
+				break
+			}
+			progress.Files = append(progress.Files, dest)
+			progress.Done++
+			if progress.Done < progress.Total {
+				s.emitEvent(EXPORT_PROGRESS_EVENT, progress)
+			}
+		}
+
+		progress.Finished = true
+		logger.InfoFields("Session exported", logger.Fields{
+			"session": id,
+			"format":  format,
+			"files":   len(progress.Files),
+			"error":   progress.Error,
+		})
+		s.emitEvent(EXPORT_PROGRESS_EVENT, progress)
+	}()
+	return nil
+}
+
+// exportSessionPort exports the segments of one port of a session to dest
+func (s *Server) exportSessionPort(ctx context.Context, export Exporter, uuid string, segments []SegmentInfo, dest string,
+	opts ExportOptions) error {
+	file, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+
+	if opts.Device == "" {
+		device, _ := s.registry.Get(uuid)
+		opts.Device = device.Alias
+	}
+	if opts.Markers == nil {
+		opts.Markers = s.exportMarkers(uuid, segments)
+	}
+	port := segments[0].Port
+	writer := bufio.NewWriter(ctxWriter{ctx: ctx, w: file})
+	err = export(writer, segments,
+		s.calibrations.Get(CalibrationKey{UUID: uuid, Port: port, Channel: 0}),
+		s.calibrations.Get(CalibrationKey{UUID: uuid, Port: port, Channel: 1}),
+		opts)
+	if err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write export file: %v", err)
+	}
+	return file.Close()
+}
+
+// CancelExport stops the running export of a session. Files of ports exported before it
+// was cancelled are kept.
+func (s *Server) CancelExport(id string) error {
+	s.exportsLock.Lock()
+	defer s.exportsLock.Unlock()
+	cancel, running := s.exports[id]
+	if !running {
+		return fmt.Errorf("session %s is not being exported", id)
+	}
+	cancel()
+	return nil
+}
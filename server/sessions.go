@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"eth-daq-software/logger"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SessionSummary describes a recorded session for browsing past captures
+type SessionSummary struct {
+	Session
+	Alias     string        // Device alias from the registry, "" for unnamed devices
+	Recording bool          // The session is still recording
+	Duration  time.Duration // Recording time so far for an active session
+	Ports     []int         // Data ports with segments in the session
+	Channels  []string      // Channel names of Ports, see ChannelNames
+	Segments  int           // Number of segment files
+	Size      int64         // Bytes of all files in the session directory
+	// Stats are the channel statistics written when the session stopped, see SESSION_SUMMARY
+	Stats []ChannelSummary `json:",omitempty"`
+}
+
+// readSessionManifest reads the session description of a session directory
+func readSessionManifest(dir string) (Session, error) {
+	data, err := os.ReadFile(filepath.Join(dir, SESSION_MANIFEST))
+	if err != nil {
+		return Session{}, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return Session{}, fmt.Errorf("failed to read %s: %v", filepath.Join(dir, SESSION_MANIFEST), err)
+	}
+	return session, nil
+}
+
+// ListSessions returns the sessions in the data directory, newest first, from their
+// manifests and segment files. Active sessions are included with Recording set.
+func (s *Server) ListSessions() ([]SessionSummary, error) {
+	dataDir := s.settings().DataDir
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %v", err)
+	}
+	active := make(map[string]Session)
+	for _, session := range s.GetActiveSessions() {
+		active[session.ID] = session
+	}
+
+	var sessions []SessionSummary
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == ARCHIVE_DIR {
+			continue
+		}
+		dir := filepath.Join(dataDir, entry.Name())
+		session, err := readSessionManifest(dir)
+		if err != nil {
+			continue
+		}
+		// The manifest of an active session is only written at its start and on markers
+		if current, recording := active[session.ID]; recording {
+			session = current
+		}
+		summary, err := summarizeSession(session, dir)
+		if err != nil {
+			return nil, err
+		}
+		_, summary.Recording = active[session.ID]
+		if summary.Recording {
+			summary.Duration = time.Since(session.Start)
+		}
+		device, _ := s.registry.Get(session.UUID)
+		summary.Alias = device.Alias
+		sessions = append(sessions, summary)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Start.After(sessions[j].Start) })
+	return sessions, nil
+}
+
+// summarizeSession adds the ports, size and duration of the files in a session directory
+// to its manifest
+func summarizeSession(session Session, dir string) (SessionSummary, error) {
+	summary := SessionSummary{Session: session}
+	if !session.Stop.IsZero() {
+		summary.Duration = session.Stop.Sub(session.Start)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return SessionSummary{}, fmt.Errorf("failed to read session %s: %v", session.ID, err)
+	}
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || file.IsDir() {
+			continue
+		}
+		summary.Size += info.Size()
+		name := file.Name()
+		if !strings.HasPrefix(name, "port") || !isSegmentFile(name) {
+			continue
+		}
+		summary.Segments++
+		if segment, err := ParseSegmentName(filepath.Join(dir, name)); err == nil && !slices.Contains(summary.Ports, segment.Port) {
+			summary.Ports = append(summary.Ports, segment.Port)
+		}
+	}
+	slices.Sort(summary.Ports)
+	if stats, err := readSessionSummary(dir); err == nil {
+		summary.Stats = stats.Channels
+	} else if !os.IsNotExist(err) {
+		logger.Errorf("%v\n", err)
+	}
+	for _, port := range summary.Ports {
+		summary.Channels = append(summary.Channels, ChannelNames(port)...)
+	}
+	return summary, nil
+}
+
+// sessionPath returns the directory of a stopped session in the data directory
+func (s *Server) sessionPath(id string) (string, error) {
+	if id == "" || id == ARCHIVE_DIR || id != filepath.Base(id) || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid session ID %q", id)
+	}
+	for _, session := range s.GetActiveSessions() {
+		if session.ID == id {
+			return "", fmt.Errorf("session %s is still recording", id)
+		}
+	}
+	dir := filepath.Join(s.settings().DataDir, id)
+	if _, err := readSessionManifest(dir); err != nil {
+		return "", fmt.Errorf("no session %s: %v", id, err)
+	}
+	return dir, nil
+}
+
+// DeleteSession deletes a stopped session and its files
+func (s *Server) DeleteSession(id string) error {
+	dir, err := s.sessionPath(id)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to delete session %s: %v", id, err)
+	}
+	logger.InfoFields("Session deleted", logger.Fields{"session": id})
+	return nil
+}
+
+// ArchiveSession moves a stopped session to a directory of the same name in destPath,
+// which may be on another volume
+func (s *Server) ArchiveSession(id string, destPath string) error {
+	dir, err := s.sessionPath(id)
+	if err != nil {
+		return err
+	}
+	if destPath == "" {
+		return fmt.Errorf("archive destination is required")
+	}
+	dest := filepath.Join(destPath, id)
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		return fmt.Errorf("failed to create archive destination: %v", err)
+	}
+
+	if err := os.Rename(dir, dest); err != nil {
+		// Rename fails across volumes, copy the files and delete them once all are copied
+		if err := copyDir(dir, dest); err != nil {
+			os.RemoveAll(dest)
+			return fmt.Errorf("failed to archive session %s: %v", id, err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("session %s archived but not deleted: %v", id, err)
+		}
+	}
+	logger.InfoFields("Session archived", logger.Fields{"session": id, "dest": dest})
+	return nil
+}
+
+// copyDir copies the files of a directory, which has no subdirectories, to a new
+// directory and syncs them to disk
+func copyDir(src string, dest string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dest, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies a file and syncs the copy to disk
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
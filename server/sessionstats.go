@@ -0,0 +1,232 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	SESSION_SUMMARY = "summary.json" // Channel statistics written to a session directory when it stops
+)
+
+// ChannelSummary are the statistics of one channel of a stopped session
+type ChannelSummary struct {
+	Port     int
+	Channel  string // Channel name, see ChannelNames
+	Unit     string
+	First    time.Time     // Time the first sample of the session was received
+	Last     time.Time     // Time the last sample of the session was received
+	Duration time.Duration // Last - First
+	Bytes    int64         // Bytes received on the port, shared by its channels
+	Samples  int64
+	Invalid  int64 // Samples the decoder could not convert, left out of Min, Max and Mean
+	Min      float64
+	Max      float64
+	Mean     float64
+	Gaps     int // Runs of frames missing from the port, see SequenceGap
+	Alarms   int // Alarm markers of the device during the session
+}
+
+// SessionStats summarize the data of a stopped session, per channel
+type SessionStats struct {
+	ID       string
+	UUID     string
+	Start    time.Time
+	Stop     time.Time
+	Channels []ChannelSummary
+}
+
+// channelTotals accumulate the samples of a channel
+type channelTotals struct {
+	samples int64
+	valid   int64
+	min     float64
+	max     float64
+	sum     float64
+}
+
+// sessionTotals accumulate the data a buffer received during a session
+type sessionTotals struct {
+	first    time.Time
+	last     time.Time
+	bytes    int64
+	channels [2]channelTotals
+}
+
+// addBytes counts bytes received at now
+func (st *sessionTotals) addBytes(n int, now time.Time) {
+	if st.first.IsZero() {
+		st.first = now
+	}
+	st.last = now
+	st.bytes += int64(n)
+}
+
+// addSample counts a sample of a channel, only converted samples enter the value statistics
+func (st *sessionTotals) addSample(channel int, sample float64, ok bool) {
+	totals := &st.channels[channel]
+	totals.samples++
+	if !ok {
+		return
+	}
+	if totals.valid == 0 {
+		totals.min, totals.max = sample, sample
+	} else {
+		totals.min = math.Min(totals.min, sample)
+		totals.max = math.Max(totals.max, sample)
+	}
+	totals.valid++
+	totals.sum += sample
+}
+
+// add merges the totals of another buffer of the same port
+func (st *sessionTotals) add(other *sessionTotals) {
+	if other.bytes == 0 {
+		return
+	}
+	if st.first.IsZero() || other.first.Before(st.first) {
+		st.first = other.first
+	}
+	if other.last.After(st.last) {
+		st.last = other.last
+	}
+	st.bytes += other.bytes
+	for i := range st.channels {
+		totals, more := &st.channels[i], other.channels[i]
+		if more.valid > 0 {
+			if totals.valid == 0 {
+				totals.min, totals.max = more.min, more.max
+			} else {
+				totals.min = math.Min(totals.min, more.min)
+				totals.max = math.Max(totals.max, more.max)
+			}
+		}
+		totals.samples += more.samples
+		totals.valid += more.valid
+		totals.sum += more.sum
+	}
+}
+
+// takeSessionTotals returns the totals of the buffer's session and stops counting, nil
+// outside a session
+func (db *DataBuffer) takeSessionTotals() *sessionTotals {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	totals := db.sessionTotals
+	db.sessionTotals = nil
+	return totals
+}
+
+// addTotals adds the totals of a buffer of port to the session. The caller must hold
+// s.sessionsLock while the session is active.
+func (session *Session) addTotals(port int, totals *sessionTotals) {
+	if totals == nil {
+		return
+	}
+	if session.totals == nil {
+		session.totals = make(map[int]*sessionTotals)
+	}
+	if _, exists := session.totals[port]; !exists {
+		session.totals[port] = &sessionTotals{}
+	}
+	session.totals[port].add(totals)
+}
+
+// recordSessionTotals adds the totals of a closed buffer to the active session of its device
+func (s *Server) recordSessionTotals(buffer *DataBuffer) {
+	totals := buffer.takeSessionTotals()
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+	if session, exists := s.sessions[buffer.uuid]; exists {
+		session.addTotals(buffer.port, totals)
+	}
+}
+
+// stats summarizes the totals of a stopped session
+func (session *Session) stats() SessionStats {
+	stats := SessionStats{ID: session.ID, UUID: session.UUID, Start: session.Start, Stop: session.Stop, Channels: []ChannelSummary{}}
+	alarms := 0
+	for _, marker := range session.Markers {
+		if marker.Source == MARKER_ALARM {
+			alarms++
+		}
+	}
+	for _, port := range slices.Sorted(maps.Keys(session.totals)) {
+		totals := session.totals[port]
+		gaps := 0
+		for _, gap := range session.Gaps {
+			if gap.Port == port {
+				gaps++
+			}
+		}
+		units := ChannelUnits(port)
+		for i, name := range ChannelNames(port) {
+			channel := totals.channels[i]
+			summary := ChannelSummary{
+				Port:     port,
+				Channel:  name,
+				Unit:     units[i],
+				First:    totals.first,
+				Last:     totals.last,
+				Duration: totals.last.Sub(totals.first),
+				Bytes:    totals.bytes,
+				Samples:  channel.samples,
+				Invalid:  channel.samples - channel.valid,
+				Min:      channel.min,
+				Max:      channel.max,
+				Gaps:     gaps,
+				Alarms:   alarms,
+			}
+			if channel.valid > 0 {
+				summary.Mean = channel.sum / float64(channel.valid)
+			}
+			stats.Channels = append(stats.Channels, summary)
+		}
+	}
+	return stats
+}
+
+// writeSummary writes the statistics of a stopped session to its directory
+func (session *Session) writeSummary() error {
+	data, err := json.MarshalIndent(session.stats(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session summary: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(session.Dir, SESSION_SUMMARY), data, 0644); err != nil {
+		return fmt.Errorf("failed to write session summary: %v", err)
+	}
+	return nil
+}
+
+// readSessionSummary reads the statistics of a session directory. Sessions that are
+// recording or were stopped before summaries were written have none.
+func readSessionSummary(dir string) (SessionStats, error) {
+	data, err := os.ReadFile(filepath.Join(dir, SESSION_SUMMARY))
+	if err != nil {
+		return SessionStats{}, err
+	}
+	var stats SessionStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return SessionStats{}, fmt.Errorf("failed to read %s: %v", filepath.Join(dir, SESSION_SUMMARY), err)
+	}
+	return stats, nil
+}
+
+// GetSessionSummary returns the channel statistics of a stopped session
+func (s *Server) GetSessionSummary(id string) (SessionStats, error) {
+	dir, err := s.sessionPath(id)
+	if err != nil {
+		return SessionStats{}, err
+	}
+	stats, err := readSessionSummary(dir)
+	if os.IsNotExist(err) {
+		return SessionStats{}, fmt.Errorf("session %s has no summary", id)
+	}
+	return stats, err
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+)
+
+// Settings are the configuration values that can be changed while the server is running
+type Settings struct {
+	AveragingWindow             int     // Samples averaged for the live value
+	ThermocoupleAveragingWindow int     // Averaging window of the thermocouple port
+	Statistic                   string  // Live value of a channel, config.STATISTIC_*
+	EMAAlpha                    float64 // Weight of a new sample in the exponential moving average
+	OutlierSigma                float64 // Samples further from the window mean in standard deviations are rejected, 0 keeps all
+	StatsInterval               int     // Milliseconds between stats events, 0 disables them
+	FlushThreshold              int     // Buffer size in bytes at which a segment is flushed
+	ReadChunkSize               int     // Size in bytes of the chunks new data connections are read into
+	FlushInterval               int     // Buffer age in seconds at which a segment is flushed, 0 flushes by size only
+	DataDir                     string  // Directory new segments are written to
+	RetentionDays               int     // Age at which segments are deleted, 0 keeps them forever
+	Durability                  string  // How far segments are written before they count as written
+	DuplicateConnections        string  // Policy for a second data connection from the same IP and port
+	WriteQueueDepth             int     // Flushed segments that can wait for a writer worker
+	WriteQueuePolicy            string  // What happens to a flushed segment when the write queue is full
+	MemoryLimit                 int     // Bytes of received data held in memory before flushing early, 0 is unlimited
+
+	// Channels overrides FlushThreshold, ReadChunkSize, Durability and the statistic per data port
+	Channels map[int]config.ChannelConfig
+}
+
+// settings returns the current configuration, which must not be modified
+func (s *Server) settings() *config.Config {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.config
+}
+
+// GetSettings returns the settings that can be changed at runtime
+func (s *Server) GetSettings() Settings {
+	cfg := s.settings()
+	return Settings{
+		AveragingWindow:             cfg.AveragingWindow,
+		ThermocoupleAveragingWindow: cfg.ThermocoupleAveragingWindow,
+		Statistic:                   cfg.Statistic,
+		EMAAlpha:                    cfg.EMAAlpha,
+		OutlierSigma:                cfg.OutlierSigma,
+		StatsInterval:               cfg.StatsInterval,
+		FlushThreshold:              cfg.FlushThreshold,
+		ReadChunkSize:               cfg.ReadChunkSize,
+		FlushInterval:               cfg.FlushInterval,
+		DataDir:                     cfg.DataDir,
+		RetentionDays:               cfg.RetentionDays,
+		Durability:                  cfg.Durability,
+		DuplicateConnections:        cfg.DuplicateConnections,
+		WriteQueueDepth:             cfg.WriteQueueDepth,
+		WriteQueuePolicy:            cfg.WriteQueuePolicy,
+		MemoryLimit:                 cfg.MemoryLimit,
+		Channels:                    maps.Clone(cfg.Channels),
+	}
+}
+
+// UpdateSettings validates and applies new settings to the live buffers and saves them to
+// the configuration file. Segments already written stay in the previous data directory.
+func (s *Server) UpdateSettings(settings Settings) error {
+	s.configLock.Lock()
+	cfg := *s.config
+	cfg.AveragingWindow = settings.AveragingWindow
+	cfg.ThermocoupleAveragingWindow = settings.ThermocoupleAveragingWindow
+	cfg.Statistic = settings.Statistic
+	cfg.EMAAlpha = settings.EMAAlpha
+	cfg.OutlierSigma = settings.OutlierSigma
+	cfg.StatsInterval = settings.StatsInterval
+	cfg.FlushThreshold = settings.FlushThreshold
+	cfg.ReadChunkSize = settings.ReadChunkSize
+	cfg.Channels = maps.Clone(settings.Channels)
+	cfg.FlushInterval = settings.FlushInterval
+	cfg.DataDir = settings.DataDir
+	cfg.RetentionDays = settings.RetentionDays
+	cfg.Durability = settings.Durability
+	cfg.DuplicateConnections = settings.DuplicateConnections
+	cfg.WriteQueueDepth = settings.WriteQueueDepth
+	cfg.WriteQueuePolicy = settings.WriteQueuePolicy
+	cfg.MemoryLimit = settings.MemoryLimit
+	if err := cfg.Validate(); err != nil {
+		s.configLock.Unlock()
+		return err
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		s.configLock.Unlock()
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	// Connected channels keep their decoder until they reconnect
+	if err := selectDecoders(cfg.Channels); err != nil {
+		s.configLock.Unlock()
+		return err
+	}
+	s.config = &cfg
+	configPath := s.configPath
+	s.configLock.Unlock()
+	s.recompileDerived()
+
+	s.writer.setDataDir(cfg.DataDir)
+	s.writer.queue.configure(cfg.WriteQueueDepth, cfg.WriteQueuePolicy)
+	s.writer.memory.setLimit(int64(cfg.MemoryLimit))
+	s.buffersLock.RLock()
+	for _, buffer := range s.buffers {
+		window := cfg.AveragingWindow
+		if buffer.samples.decoder.Slow() {
+			window = cfg.ThermocoupleAveragingWindow
+		}
+		buffer.applySettings(window, cfg.StatisticFor(buffer.port), cfg.EMAAlphaFor(buffer.port), cfg.OutlierSigmaFor(buffer.port),
+			cfg.FlushThresholdFor(buffer.port), time.Duration(cfg.FlushInterval)*time.Second, cfg.DurabilityFor(buffer.port),
+			time.Duration(cfg.TimestampInterval)*time.Millisecond)
+	}
+	s.buffersLock.RUnlock()
+
+	if cfg.RetentionDays > 0 {
+		go s.enforceRetention()
+	}
+
+	if err := cfg.Save(configPath); err != nil {
+		return fmt.Errorf("settings applied but not saved: %v", err)
+	}
+	return nil
+}
+
+// applySettings resizes the averaging windows, keeping the most recent samples, selects
+// the statistic and changes when the buffer is flushed and timestamped
+func (db *DataBuffer) applySettings(window int, statistic string, alpha float64, sigma float64, flushSize int,
+	flushInterval time.Duration, durability string, timestampInterval time.Duration) {
+	db.statsMu.Lock()
+	if window != db.circularBuffer.GetCapacity() {
+		db.circularBuffer = db.circularBuffer.Resize(window)
+		if db.circularBufferB != nil {
+			db.circularBufferB = db.circularBufferB.Resize(window)
+		}
+	}
+	db.setStatistic(statistic, alpha, sigma)
+	db.statsMu.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.flushSize = flushSize
+	db.flushInterval = flushInterval
+	db.durability = durability
+	db.timestampInterval = timestampInterval
+}
+
+// setStatistic selects the statistic returned by CalculateAverage, the weight of new
+// samples in the EMA and the outlier threshold, keeping the windows in order only for
+// the median. The caller must hold db.statsMu once stats have started.
+func (db *DataBuffer) setStatistic(statistic string, alpha float64, sigma float64) {
+	db.statistic = statistic
+	median := statistic == config.STATISTIC_MEDIAN
+	db.circularBuffer.SetAlpha(alpha)
+	db.circularBuffer.TrackMedian(median)
+	db.circularBuffer.SetOutlierSigma(sigma)
+	if db.circularBufferB != nil {
+		db.circularBufferB.SetAlpha(alpha)
+		db.circularBufferB.TrackMedian(median)
+		db.circularBufferB.SetOutlierSigma(sigma)
+	}
+}
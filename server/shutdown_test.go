@@ -0,0 +1,77 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestStop tests that Stop ends the accept loop and connection readers and writes the
+// buffered data of connected devices
+func TestStop(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.LogDir = t.TempDir()
+	cfg.UDPLogPort = freePort(t)
+	cfg.Features.Compression = "none"
+	s := NewServer(cfg)
+
+	// The port number selects the sample format, use the GADC port
+	port := 5556
+	if listener, err := net.Listen("tcp", ":5556"); err != nil {
+		t.Skipf("Port %d unavailable: %v", port, err)
+	} else {
+		listener.Close()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.StartListener(port)
+		close(stopped)
+	}()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.Write(make([]byte, 1000))
+	time.Sleep(50 * time.Millisecond)
+
+	if err := s.Stop(2 * time.Second); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("StartListener did not return after Stop")
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(cfg.DataDir, "*.bin"))
+	if len(segments) != 1 {
+		t.Fatalf("Got %d segments, want 1", len(segments))
+	}
+	if info, _ := os.Stat(segments[0]); info.Size() != 1000 {
+		t.Errorf("Segment size = %d, want 1000", info.Size())
+	}
+}
+
+// freePort returns a TCP port that is free to listen on
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
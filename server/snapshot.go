@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	MAX_SNAPSHOT_SAMPLES = 100000 // Samples per channel returned by GetChannelSnapshot
+)
+
+// ChannelSnapshot holds the most recent scaled samples of the channels of a data port,
+// for a live scope view
+type ChannelSnapshot struct {
+	End        time.Time   // Time of the newest sample
+	SampleRate float64     // Samples per second per channel, 0 if unknown
+	Channels   []string    // Names of the channels, see ChannelNames
+	Units      []string    // Unit of each channel, see ChannelUnits
+	Samples    [][]float64 // Samples of each channel, oldest first
+	Gaps       [][]int     // Indices of samples that could not be converted, 0 in Samples
+}
+
+// GetChannelSnapshot returns the n most recent scaled samples of each channel of a data
+// port, fewer if not as many were received. n is capped at MAX_SNAPSHOT_SAMPLES.
+func (s *Server) GetChannelSnapshot(key BufferKey, n int) (ChannelSnapshot, error) {
+	if n <= 0 {
+		return ChannelSnapshot{}, fmt.Errorf("number of samples must be positive")
+	}
+	n = min(n, MAX_SNAPSHOT_SAMPLES)
+
+	s.buffersLock.RLock()
+	buffer, exists := s.buffers[key]
+	s.buffersLock.RUnlock()
+	if !exists {
+		return ChannelSnapshot{}, fmt.Errorf("no active channel for %s:%d", key.IP, key.Port)
+	}
+
+	decoder := buffer.samples.decoder
+	snapshot := ChannelSnapshot{
+		End:      time.Now(),
+		Channels: decoder.Channels(),
+		Units:    decoder.Units(),
+	}
+	buffer.statsMu.Lock()
+	snapshot.SampleRate = buffer.sampleRate
+	// Mid-frame the first channel is a sample ahead, end both channels at the last frame
+	frames := buffer.history.Total()
+	if buffer.historyB != nil {
+		frames = buffer.historyB.Total()
+	}
+	start := frames - min(uint64(n), frames)
+	for _, history := range []*SampleRing{buffer.history, buffer.historyB} {
+		if history != nil {
+			samples := history.Since(start)
+			snapshot.Samples = append(snapshot.Samples, samples[:len(samples)-int(history.Total()-frames)])
+		}
+	}
+	buffer.statsMu.Unlock()
+
+	if len(snapshot.Samples) == 2 {
+		count := min(len(snapshot.Samples[0]), len(snapshot.Samples[1]))
+		snapshot.Samples[0] = snapshot.Samples[0][len(snapshot.Samples[0])-count:]
+		snapshot.Samples[1] = snapshot.Samples[1][len(snapshot.Samples[1])-count:]
+	}
+	snapshot.Gaps = make([][]int, len(snapshot.Samples))
+	for channel, samples := range snapshot.Samples {
+		for i, sample := range samples {
+			if math.IsNaN(sample) {
+				samples[i] = 0
+				snapshot.Gaps[channel] = append(snapshot.Gaps[channel], i)
+			}
+		}
+	}
+	return snapshot, nil
+}
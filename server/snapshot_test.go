@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// TestGetChannelSnapshot tests that the channels of a snapshot end at the same frame and
+// out-of-range readings are reported as gaps
+func TestGetChannelSnapshot(t *testing.T) {
+	s := newDerivedTestServer(t, "vds", nil, nil)
+	key := BufferKey{IP: "10.0.0.2", Port: 5557}
+	buffer := NewDataBuffer(5557, "10.0.0.2", 5, "dev1", 1024)
+	s.buffers[key] = buffer
+
+	// Internal sensor at 25 °C with an out-of-range and an in-range thermocouple reading,
+	// then the internal sensor of a frame that is not complete yet
+	var data []byte
+	for _, raw := range []uint16{3200, 32767, 3200, 0, 3200} {
+		data = binary.LittleEndian.AppendUint16(data, raw)
+	}
+	buffer.AddData(data)
+
+	snapshot, err := s.GetChannelSnapshot(key, 10)
+	if err != nil {
+		t.Fatalf("GetChannelSnapshot() = %v", err)
+	}
+	if len(snapshot.Samples) != 2 || len(snapshot.Samples[0]) != 2 || len(snapshot.Samples[1]) != 2 {
+		t.Fatalf("Samples = %v, want 2 frames of 2 channels", snapshot.Samples)
+	}
+	if len(snapshot.Gaps[1]) != 1 || snapshot.Gaps[1][0] != 0 || snapshot.Samples[1][0] != 0 {
+		t.Errorf("Gaps = %v with samples %v, want the first thermocouple sample", snapshot.Gaps, snapshot.Samples[1])
+	}
+	if snapshot.Units[1] != UNIT_CELSIUS {
+		t.Errorf("Units = %v, want °C", snapshot.Units)
+	}
+
+	if snapshot, _ := s.GetChannelSnapshot(key, 1); len(snapshot.Samples[0]) != 1 || snapshot.Samples[1][0] == 0 {
+		t.Errorf("Samples = %v, want the last frame", snapshot.Samples)
+	}
+	if _, err := s.GetChannelSnapshot(BufferKey{IP: "10.0.0.3", Port: 5557}, 10); err == nil {
+		t.Error("Expected error for an unknown channel, got nil")
+	}
+}
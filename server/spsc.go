@@ -0,0 +1,102 @@
+package server
+
+import (
+	"sync/atomic"
+)
+
+const (
+	READ_QUEUE_SLOTS = 32 // Read chunks a connection can queue ahead of processing, a power of two
+)
+
+// queuedChunk is a pooled read chunk holding n received bytes
+type queuedChunk struct {
+	chunk *[]byte
+	n     int
+}
+
+// chunkQueue is a lock-free single-producer/single-consumer ring passing read chunks from
+// a connection reader to its processor, so that slow processing or disk writes do not
+// stall reading from the socket. Push must only be called by the producer and Pop by the
+// consumer.
+type chunkQueue struct {
+	slots    []queuedChunk
+	mask     uint64
+	head     atomic.Uint64 // Next slot to pop, written by the consumer
+	tail     atomic.Uint64 // Next slot to push, written by the producer
+	closed   atomic.Bool
+	notEmpty chan struct{} // Wakes the consumer after a push or close
+	notFull  chan struct{} // Wakes the producer after a pop
+}
+
+// newChunkQueue creates a queue with slots slots, rounded up to a power of two
+func newChunkQueue(slots int) *chunkQueue {
+	size := 1
+	for size < slots {
+		size <<= 1
+	}
+	return &chunkQueue{
+		slots:    make([]queuedChunk, size),
+		mask:     uint64(size - 1),
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+// Push queues a chunk, returning false if the queue is full
+func (q *chunkQueue) Push(chunk queuedChunk) bool {
+	tail := q.tail.Load()
+	if tail-q.head.Load() == uint64(len(q.slots)) {
+		return false
+	}
+	q.slots[tail&q.mask] = chunk
+	q.tail.Store(tail + 1)
+	wake(q.notEmpty)
+	return true
+}
+
+// Pop takes the oldest chunk, returning false if the queue is empty
+func (q *chunkQueue) Pop() (queuedChunk, bool) {
+	head := q.head.Load()
+	if head == q.tail.Load() {
+		return queuedChunk{}, false
+	}
+	chunk := q.slots[head&q.mask]
+	q.slots[head&q.mask] = queuedChunk{}
+	q.head.Store(head + 1)
+	wake(q.notFull)
+	return chunk, true
+}
+
+// Close tells the consumer that no more chunks will be pushed
+func (q *chunkQueue) Close() {
+	q.closed.Store(true)
+	wake(q.notEmpty)
+}
+
+// Len returns the number of queued chunks
+func (q *chunkQueue) Len() int {
+	return int(q.tail.Load() - q.head.Load())
+}
+
+// consume calls process for every chunk until the queue is closed and drained
+func (q *chunkQueue) consume(process func(chunk queuedChunk)) {
+	for {
+		if chunk, ok := q.Pop(); ok {
+			process(chunk)
+			continue
+		}
+		// Check closed before waiting, a chunk pushed before Close is still popped
+		if q.closed.Load() && q.Len() == 0 {
+			return
+		}
+		<-q.notEmpty
+	}
+}
+
+// wake wakes a waiter without blocking
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+)
+
+// TestChunkQueue tests that chunks arrive in order across goroutines and that a full
+// queue refuses pushes
+func TestChunkQueue(t *testing.T) {
+	queue := newChunkQueue(3)
+	for i := 0; i < 4; i++ {
+		if !queue.Push(queuedChunk{n: i}) {
+			t.Fatalf("Push(%d) = false, want true", i)
+		}
+	}
+	if queue.Push(queuedChunk{n: 4}) {
+		t.Fatal("Push() to a full queue = true, want false")
+	}
+	for i := 0; i < 4; i++ {
+		if chunk, ok := queue.Pop(); !ok || chunk.n != i {
+			t.Fatalf("Pop() = %d, %v, want %d", chunk.n, ok, i)
+		}
+	}
+
+	const count = 100000
+	var got []int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.consume(func(chunk queuedChunk) {
+			got = append(got, chunk.n)
+		})
+	}()
+	for i := 0; i < count; i++ {
+		for !queue.Push(queuedChunk{n: i}) {
+			<-queue.notFull
+		}
+	}
+	queue.Close()
+	<-done
+
+	if len(got) != count {
+		t.Fatalf("Consumed %d chunks, want %d", len(got), count)
+	}
+	for i, n := range got {
+		if n != i {
+			t.Fatalf("Chunk %d = %d, want in order", i, n)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	STATS_EVENT = "stats" // Event carrying a StatsUpdate
+	// STATS_DISABLED_INTERVAL is how often a disabled stats publisher checks whether it
+	// was enabled
+	STATS_DISABLED_INTERVAL = time.Second
+)
+
+// ChannelStats are the live statistics of a data channel
+type ChannelStats struct {
+	Key        BufferKey
+	Rate       float64   // Bytes per second received
+	SampleRate float64   // Samples per second per channel
+	Channels   []string  // Names of the channels, see ChannelNames
+	Units      []string  // Unit of each channel's value
+	Values     []float64 // Live value of each channel, see config.Statistic
+	Valid      []bool    // Whether the averaging window of each channel was filled at least once
+	Outliers   int64     // Samples left out of the values as outliers
+	OutOfRange int64     // Samples that could not be converted
+}
+
+// StatsUpdate is the consolidated stats of all data channels, sent every
+// config.StatsInterval so that the frontend does not poll every channel
+type StatsUpdate struct {
+	Time     time.Time
+	Channels []ChannelStats // Ordered by IP and port
+}
+
+// stats returns the live statistics of the buffer
+func (db *DataBuffer) stats(key BufferKey) ChannelStats {
+	decoder := db.samples.decoder
+	stats := ChannelStats{
+		Key:      key,
+		Rate:     db.GetRate(),
+		Channels: decoder.Channels(),
+		Units:    decoder.Units(),
+	}
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	stats.SampleRate = db.sampleRate
+	stats.Outliers = db.outliers
+	stats.OutOfRange = db.outOfRange
+	for _, window := range []*CircularBuffer{db.circularBuffer, db.circularBufferB} {
+		if window != nil {
+			stats.Values = append(stats.Values, window.GetStatistic(db.statistic))
+			stats.Valid = append(stats.Valid, window.IsFullOnce())
+		}
+	}
+	return stats
+}
+
+// GetStats returns the live statistics of all data channels, as sent in STATS_EVENT
+func (s *Server) GetStats() StatsUpdate {
+	s.buffersLock.RLock()
+	update := StatsUpdate{Time: time.Now(), Channels: make([]ChannelStats, 0, len(s.buffers))}
+	for key, buffer := range s.buffers {
+		update.Channels = append(update.Channels, buffer.stats(key))
+	}
+	s.buffersLock.RUnlock()
+
+	sort.Slice(update.Channels, func(i, j int) bool {
+		a, b := update.Channels[i].Key, update.Channels[j].Key
+		if a.IP != b.IP {
+			return a.IP < b.IP
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.UUID < b.UUID
+	})
+	return update
+}
+
+// StartStatsPublisher sends a STATS_EVENT every config.StatsInterval until the server is
+// stopped. Changes of the interval apply from the next event.
+func (s *Server) StartStatsPublisher() {
+	go func() {
+		for {
+			interval := time.Duration(s.settings().StatsInterval) * time.Millisecond
+			if interval > 0 {
+				s.publishStats()
+			} else {
+				interval = STATS_DISABLED_INTERVAL
+			}
+			select {
+			case <-time.After(interval):
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// publishStats sends the stats of all data channels, if there are any and an event
+// emitter is set
+func (s *Server) publishStats() {
+	s.logEvents.mu.Lock()
+	emitting := s.logEvents.emit != nil
+	s.logEvents.mu.Unlock()
+	if !emitting {
+		return
+	}
+	update := s.GetStats()
+	if len(update.Channels) == 0 {
+		return
+	}
+	s.emitEvent(STATS_EVENT, update)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/binary"
+	"eth-daq-software/config"
+	"eth-daq-software/logger"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPublishStats tests that the stats event carries the values and units of every channel
+func TestPublishStats(t *testing.T) {
+	s := newDerivedTestServer(t, "vds", []float64{1, 1}, []float64{2, 2})
+	s.publishStats() // No emitter, nothing to do
+
+	var events []StatsUpdate
+	s.SetEventEmitter(func(name string, data ...interface{}) {
+		if name == STATS_EVENT {
+			events = append(events, data[0].(StatsUpdate))
+		}
+	})
+	buffer := NewDataBuffer(5557, "10.0.0.2", 1, "dev1", 1024)
+	buffer.AddData(binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, 3200), 0))
+	s.buffers[BufferKey{IP: "10.0.0.2", Port: 5557}] = buffer
+	s.publishStats()
+
+	if len(events) != 1 || len(events[0].Channels) != 3 {
+		t.Fatalf("Events = %+v, want one with 3 channels", events)
+	}
+	tc := events[0].Channels[2]
+	if tc.Key.Port != 5557 || len(tc.Values) != 2 || !tc.Valid[1] || math.Abs(tc.Values[1]-tc.Values[0]) > 0.05 || tc.Units[1] != UNIT_CELSIUS {
+		t.Errorf("Thermocouple stats = %+v, want both channels at the cold junction temperature in °C", tc)
+	}
+}
+
+// TestGetServerStatus tests that the status collects the channels and recent errors
+func TestGetServerStatus(t *testing.T) {
+	s := newDerivedTestServer(t, "vds", []float64{1}, []float64{2})
+	logger.Errorf("status test error\n")
+
+	status := s.GetServerStatus()
+	if len(status.Channels) != 2 || status.DiskError != "" || status.DiskFreeBytes == 0 {
+		t.Errorf("GetServerStatus() = %+v, want 2 channels and the free space", status)
+	}
+	if len(status.Errors) == 0 || !strings.Contains(status.Errors[0].Message, "status test error") {
+		t.Errorf("Errors = %+v, want the logged error first", status.Errors)
+	}
+}
+
+// TestRateHistory tests that channel rates are sampled per interval, drop to zero after a
+// disconnect and are trimmed to the configured history
+func TestRateHistory(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.RateHistory = 3
+	s := NewServer(cfg)
+	key := BufferKey{IP: "10.0.0.2", Port: 5556}
+	buffer := NewDataBuffer(key.Port, key.IP, 10, "dev1", 16<<20) // Not flushed
+	s.buffers[key] = buffer
+
+	start := time.Now()
+	for i := range 4 {
+		buffer.AddData(make([]byte, (i+1)*1024*1024))
+		s.sampleRates(start.Add(time.Duration(i+1)*time.Second), time.Second)
+	}
+	delete(s.buffers, key)
+	s.sampleRates(start.Add(5*time.Second), time.Second)
+
+	got := s.GetRateHistory("10.0.0.2:5556", 0)
+	want := []float64{3, 4, 0}
+	if len(got) != len(want) {
+		t.Fatalf("GetRateHistory() = %+v, want rates %v", got, want)
+	}
+	for i, sample := range got {
+		if sample.Rate != want[i] || !sample.Time.Equal(start.Add(time.Duration(i+3)*time.Second)) {
+			t.Errorf("Sample %d = %+v, want %v MB/s at %ds", i, sample, want[i], i+3)
+		}
+	}
+	if got := s.GetRateHistory("10.0.0.2:5556", 1); len(got) != 1 || got[0].Rate != 0 {
+		t.Errorf("GetRateHistory(1s) = %+v, want the last sample", got)
+	}
+
+	// Gone for the whole history
+	s.sampleRates(start.Add(10*time.Second), time.Second)
+	if got := s.GetRateHistory("10.0.0.2:5556", 0); len(got) != 0 {
+		t.Errorf("GetRateHistory() after the history = %+v, want none", got)
+	}
+}
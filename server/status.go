@@ -0,0 +1,67 @@
+package server
+
+import (
+	"eth-daq-software/logger"
+	"time"
+)
+
+const (
+	STATUS_ERRORS = 20 // Recent application errors in ServerStatus
+)
+
+// ServerStatus is everything the status bar shows, collected in one call
+type ServerStatus struct {
+	Time       time.Time
+	Listeners  []ListenerStatus        // Binding result of each port
+	Devices    map[string]IPConnection // Connected devices by sanitized IP
+	Health     map[string]DeviceHealth // Availability of every device seen, by sanitized IP
+	Channels   []ChannelStats          // Rates and values of every data channel
+	WriteQueue WriteQueueStats         // Backlog of segments waiting to be written
+	Sessions   []Session               // Active recording sessions
+	Uploads    UploadStatus            // Progress of uploads to remote storage, empty Target if disabled
+	Errors     []logger.AppLogEntry    // Most recent application errors, newest first
+	// Free space on the data volume and the capture time it lasts at the current ingest
+	// rate, see GetDiskUsage. The size of the data directory is left out as it takes a
+	// walk of the directory.
+	DiskFreeBytes        uint64
+	DiskRemainingSeconds float64
+	DiskError            string // Why the free space is unknown, "" if it is known
+}
+
+// GetServerStatus returns the state of the listeners, devices, channels, write backlog,
+// disk and sessions and the recent errors
+func (s *Server) GetServerStatus() ServerStatus {
+	status := ServerStatus{
+		Time:       time.Now(),
+		Listeners:  s.GetListenerStatus(),
+		Devices:    s.GetAllConnectedIPs(),
+		Health:     s.GetDeviceHealth(),
+		Channels:   s.GetStats().Channels,
+		WriteQueue: s.GetWriteQueueStats(),
+		Sessions:   s.GetActiveSessions(),
+		Uploads:    s.GetUploadStatus(),
+	}
+
+	free, _, err := diskSpace(s.settings().DataDir)
+	if err != nil {
+		status.DiskError = err.Error()
+	}
+	status.DiskFreeBytes = free
+	ingest := 0.0
+	for _, channel := range status.Channels {
+		ingest += channel.Rate
+	}
+	if ingest > 0 {
+		status.DiskRemainingSeconds = float64(free) / ingest
+	}
+
+	for _, entry := range logger.Recent() {
+		if entry.Level == "ERROR" {
+			status.Errors = append(status.Errors, entry)
+			if len(status.Errors) == STATUS_ERRORS {
+				break
+			}
+		}
+	}
+	return status
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"eth-daq-software/logger"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SYSLOG_PORT     = 514          // Default syslog port when the address has none
+	SYSLOG_APP_NAME = "eth-daq-fw" // APP-NAME of forwarded device log lines
+	SYSLOG_FACILITY = 16           // Facility local0
+	syslogNilValue  = "-"          // RFC 5424 NILVALUE
+)
+
+// syslogForwarder sends device log lines to a remote syslog server over UDP (RFC 5424/5426)
+type syslogForwarder struct {
+	address string
+	conn    net.Conn
+	mu      sync.Mutex
+}
+
+// syslogSeverities maps parsed device log severities to syslog severity codes,
+// lines without a severity are sent as informational
+var syslogSeverities = map[string]int{
+	SEVERITY_ERROR:   3,
+	SEVERITY_WARNING: 4,
+	SEVERITY_INFO:    6,
+	SEVERITY_DEBUG:   7,
+	SEVERITY_VERBOSE: 7,
+	SEVERITY_NONE:    6,
+}
+
+// formatSyslog formats a device log line as an RFC 5424 message with the device UUID as
+// HOSTNAME, falling back to its IP address before the handshake
+func formatSyslog(timestamp time.Time, uuid string, ip string, line string, severity string) string {
+	hostname := syslogName(uuid, 255)
+	if hostname == syslogNilValue {
+		hostname = syslogName(ip, 255)
+	}
+	structuredData := fmt.Sprintf(`[origin ip="%s"]`, escapeSDParam(ip))
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s",
+		SYSLOG_FACILITY*8+syslogSeverities[severity],
+		timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		hostname,
+		SYSLOG_APP_NAME,
+		syslogNilValue, // PROCID
+		syslogNilValue, // MSGID
+		structuredData,
+		strings.TrimRight(line, "\r\n"),
+	)
+}
+
+// syslogName restricts a header field to printable US-ASCII without spaces
+func syslogName(value string, maxLength int) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if len(name) > maxLength {
+		name = name[:maxLength]
+	}
+	if name == "" {
+		return syslogNilValue
+	}
+	return name
+}
+
+// escapeSDParam escapes a structured data parameter value
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// SetSyslogForwarding forwards device log lines to the syslog server at address
+// ("host" or "host:port"), an empty address disables forwarding
+func (s *Server) SetSyslogForwarding(address string) error {
+	s.syslog.mu.Lock()
+	defer s.syslog.mu.Unlock()
+
+	if s.syslog.conn != nil {
+		s.syslog.conn.Close()
+		s.syslog.conn = nil
+		s.syslog.address = ""
+	}
+	if address == "" {
+		logger.Infof("Syslog forwarding disabled\n")
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(SYSLOG_PORT))
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog server %s: %v", address, err)
+	}
+	s.syslog.conn = conn
+	s.syslog.address = address
+	logger.Infof("Forwarding device logs to syslog server %s\n", address)
+	return nil
+}
+
+// GetSyslogForwarding returns the syslog server device log lines are forwarded to, or ""
+func (s *Server) GetSyslogForwarding() string {
+	s.syslog.mu.Lock()
+	defer s.syslog.mu.Unlock()
+	return s.syslog.address
+}
+
+// forwardSyslog sends a device log line to the syslog server, if forwarding is enabled
+func (s *Server) forwardSyslog(sanitizedIP string, line string, severity string) {
+	s.syslog.mu.Lock()
+	defer s.syslog.mu.Unlock()
+	if s.syslog.conn == nil {
+		return
+	}
+
+	// UDP send errors are not worth interrupting log reception for
+	s.syslog.conn.Write([]byte(formatSyslog(time.Now(), s.deviceUUID(sanitizedIP), sanitizedIP, line, severity)))
+}
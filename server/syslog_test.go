@@ -0,0 +1,24 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFormatSyslog tests the RFC 5424 message layout
+func TestFormatSyslog(t *testing.T) {
+	timestamp := time.Date(2025, 2, 26, 14, 3, 18, 48699000, time.UTC)
+
+	got := formatSyslog(timestamp, "a1b2-c3d4", "192_168_1_20", "ADC started\r\n", SEVERITY_INFO)
+	want := `<134>1 2025-02-26T14:03:18.048699Z a1b2-c3d4 eth-daq-fw - - [origin ip="192_168_1_20"] ADC started`
+	if got != want {
+		t.Fatalf("formatSyslog()\n got: %s\nwant: %s", got, want)
+	}
+
+	// Before the handshake the IP address is the hostname
+	got = formatSyslog(timestamp, "", "192_168_1_20", "[E] boot failed", SEVERITY_ERROR)
+	want = `<131>1 2025-02-26T14:03:18.048699Z 192_168_1_20 eth-daq-fw - - [origin ip="192_168_1_20"] [E] boot failed`
+	if got != want {
+		t.Fatalf("formatSyslog()\n got: %s\nwant: %s", got, want)
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// TC_LSB_MV is the thermocouple ADC resolution in millivolts (ADS1118, FSR ±0.256 V)
+	TC_LSB_MV = 0.256 / 32768 * 1000
+)
+
+// polyRange is one segment of a NIST ITS-90 thermocouple polynomial
+type polyRange struct {
+	min, max float64   // Valid input range of the polynomial
+	coeffs   []float64 // Coefficients, lowest order first
+}
+
+// thermocoupleTable holds the NIST ITS-90 reference functions for one thermocouple type
+type thermocoupleTable struct {
+	forward []polyRange // °C -> mV
+	inverse []polyRange // mV -> °C
+	// Exponential term of the forward function above 0 °C (only used for K-type)
+	a0, a1, a2 float64
+}
+
+// thermocoupleK is the NIST ITS-90 table for K-type thermocouples
+var thermocoupleK = thermocoupleTable{
+	forward: []polyRange{
+		{min: -270, max: 0, coeffs: []float64{
+			0.0,
+			0.394501280250e-01,
+			0.236223735980e-04,
+			-0.328589067840e-06,
+			-0.499048287770e-08,
+			-0.675090591730e-10,
+			-0.574103274280e-12,
+			-0.310888728940e-14,
+			-0.104516093650e-16,
+			-0.198892668780e-19,
+			-0.163226974860e-22,
+		}},
+		{min: 0, max: 1372, coeffs: []float64{
+			-0.176004136860e-01,
+			0.389212049750e-01,
+			0.185587700320e-04,
+			-0.994575928740e-07,
+			0.318409457190e-09,
+			-0.560728448890e-12,
+			0.560750590590e-15,
+			-0.320207200030e-18,
+			0.971511471520e-22,
+			-0.121047212750e-25,
+		}},
+	},
+	inverse: []polyRange{
+		{min: -5.891, max: 0, coeffs: []float64{
+			0.0,
+			2.5173462e+01,
+			-1.1662878e+00,
+			-1.0833638e+00,
+			-8.9773540e-01,
+			-3.7342377e-01,
+			-8.6632643e-02,
+			-1.0450598e-02,
+			-5.1920577e-04,
+		}},
+		{min: 0, max: 20.644, coeffs: []float64{
+			0.0,
+			2.508355e+01,
+			7.860106e-02,
+			-2.503131e-01,
+			8.315270e-02,
+			-1.228034e-02,
+			9.804036e-04,
+			-4.413030e-05,
+			1.057734e-06,
+			-1.052755e-08,
+		}},
+		{min: 20.644, max: 54.886, coeffs: []float64{
+			-1.318058e+02,
+			4.830222e+01,
+			-1.646031e+00,
+			5.464731e-02,
+			-9.650715e-04,
+			8.802193e-06,
+			-3.110810e-08,
+		}},
+	},
+	a0: 0.118597600000e+00,
+	a1: -0.118343200000e-03,
+	a2: 0.126968600000e+03,
+}
+
+// thermocoupleJ is the NIST ITS-90 table for J-type thermocouples
+var thermocoupleJ = thermocoupleTable{
+	forward: []polyRange{
+		{min: -210, max: 760, coeffs: []float64{
+			0.0,
+			0.503811878150e-01,
+			0.304758369300e-04,
+			-0.856810657200e-07,
+			0.132281952950e-09,
+			-0.170529583370e-12,
+			0.209480906970e-15,
+			-0.125383953360e-18,
+			0.156317256970e-22,
+		}},
+		{min: 760, max: 1200, coeffs: []float64{
+			0.296456256810e+03,
+			-0.149761277860e+01,
+			0.317871039240e-02,
+			-0.318476867010e-05,
+			0.157208190040e-08,
+			-0.306913690560e-12,
+		}},
+	},
+	inverse: []polyRange{
+		{min: -8.095, max: 0, coeffs: []float64{
+			0.0,
+			1.9528268e+01,
+			-1.2286185e+00,
+			-1.0752178e+00,
+			-5.9086933e-01,
+			-1.7256713e-01,
+			-2.8131513e-02,
+			-2.3963370e-03,
+			-8.3823321e-05,
+		}},
+		{min: 0, max: 42.919, coeffs: []float64{
+			0.0,
+			1.978425e+01,
+			-2.001204e-01,
+			1.036969e-02,
+			-2.549687e-04,
+			3.585153e-06,
+			-5.344285e-08,
+			5.099890e-10,
+		}},
+		{min: 42.919, max: 69.553, coeffs: []float64{
+			-3.11358187e+03,
+			3.00543684e+02,
+			-9.94773230e+00,
+			1.70276630e-01,
+			-1.43033468e-03,
+			4.73886084e-06,
+		}},
+	},
+}
+
+// thermocoupleT is the NIST ITS-90 table for T-type thermocouples
+var thermocoupleT = thermocoupleTable{
+	forward: []polyRange{
+		{min: -270, max: 0, coeffs: []float64{
+			0.0,
+			0.387481063640e-01,
+			0.441944343470e-04,
+			0.118443231050e-06,
+			0.200329735540e-07,
+			0.901380195590e-09,
+			0.226511565930e-10,
+			0.360711542050e-12,
+			0.384939398830e-14,
+			0.282135219250e-16,
+			0.142515947790e-18,
+			0.487686622860e-21,
+			0.107955392700e-23,
+			0.139450270620e-26,
+			0.797951539270e-30,
+		}},
+		{min: 0, max: 400, coeffs: []float64{
+			0.0,
+			0.387481063640e-01,
+			0.332922278800e-04,
+			0.206182434040e-06,
+			-0.218822568460e-08,
+			0.109968809280e-10,
+			-0.308157587720e-13,
+			0.454791352900e-16,
+			-0.275129016730e-19,
+		}},
+	},
+	inverse: []polyRange{
+		{min: -5.603, max: 0, coeffs: []float64{
+			0.0,
+			2.5949192e+01,
+			-2.1316967e-01,
+			7.9018692e-01,
+			4.2527777e-01,
+			1.3304473e-01,
+			2.0241446e-02,
+			1.2668171e-03,
+		}},
+		{min: 0, max: 20.872, coeffs: []float64{
+			0.0,
+			2.592800e+01,
+			-7.602961e-01,
+			4.637791e-02,
+			-2.165394e-03,
+			6.048144e-05,
+			-7.293422e-07,
+		}},
+	},
+}
+
+// thermocoupleN is the NIST ITS-90 table for N-type thermocouples
+var thermocoupleN = thermocoupleTable{
+	forward: []polyRange{
+		{min: -270, max: 0, coeffs: []float64{
+			0.0,
+			0.261591059620e-01,
+			0.109574842280e-04,
+			-0.938411115540e-07,
+			-0.464120397590e-10,
+			-0.263033577160e-11,
+			-0.226534380030e-13,
+			-0.760893007910e-16,
+			-0.934196678350e-19,
+		}},
+		{min: 0, max: 1300, coeffs: []float64{
+			0.0,
+			0.259293946010e-01,
+			0.157101418800e-04,
+			0.438256272370e-07,
+			-0.252611697940e-09,
+			0.643118193390e-12,
+			-0.100634715190e-14,
+			0.997453389920e-18,
+			-0.608632456070e-21,
+			0.208492293390e-24,
+			-0.306821961510e-28,
+		}},
+	},
+	inverse: []polyRange{
+		{min: -3.990, max: 0, coeffs: []float64{
+			0.0,
+			3.8436847e+01,
+			1.1010485e+00,
+			5.2229312e+00,
+			7.2060525e+00,
+			5.8488586e+00,
+			2.7754916e+00,
+			7.7075166e-01,
+			1.1582665e-01,
+			7.3138868e-03,
+		}},
+		{min: 0, max: 20.613, coeffs: []float64{
+			0.0,
+			3.86896e+01,
+			-1.08267e+00,
+			4.70205e-02,
+			-2.12169e-06,
+			-1.17272e-04,
+			5.39280e-06,
+			-7.98156e-08,
+		}},
+		{min: 20.613, max: 47.513, coeffs: []float64{
+			1.972485e+01,
+			3.300943e+01,
+			-3.915159e-01,
+			9.855391e-03,
+			-1.274371e-04,
+			7.767022e-07,
+		}},
+	},
+}
+
+// thermocoupleTypes are the supported thermocouple types. The type of a port is selected
+// with config.ChannelConfig.ThermocoupleType, or else by the device in its handshake, see
+// HandshakeChannel. Ports with neither use K.
+var thermocoupleTypes = map[string]*thermocoupleTable{
+	"J": &thermocoupleJ,
+	"K": &thermocoupleK,
+	"N": &thermocoupleN,
+	"T": &thermocoupleT,
+}
+
+// evalPoly evaluates a polynomial with Horner's method
+func evalPoly(coeffs []float64, x float64) float64 {
+	result := 0.0
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		result = result*x + coeffs[i]
+	}
+	return result
+}
+
+// findRange returns the polynomial segment that covers x, clamping to the outermost segments
+func findRange(ranges []polyRange, x float64) polyRange {
+	for _, r := range ranges {
+		if x >= r.min && x <= r.max {
+			return r
+		}
+	}
+	if x < ranges[0].min {
+		return ranges[0]
+	}
+	return ranges[len(ranges)-1]
+}
+
+// TemperatureToVoltage returns the thermoelectric voltage in mV for a junction temperature in °C
+func (t *thermocoupleTable) TemperatureToVoltage(tempC float64) float64 {
+	mv := evalPoly(findRange(t.forward, tempC).coeffs, tempC)
+	if tempC > 0 && t.a0 != 0 {
+		mv += t.a0 * math.Exp(t.a1*(tempC-t.a2)*(tempC-t.a2))
+	}
+	return mv
+}
+
+// VoltageToTemperature returns the junction temperature in °C for a thermoelectric voltage in mV
+func (t *thermocoupleTable) VoltageToTemperature(mv float64) float64 {
+	return evalPoly(findRange(t.inverse, mv).coeffs, mv)
+}
+
+// InRange reports whether a thermoelectric voltage is covered by the table
+func (t *thermocoupleTable) InRange(mv float64) bool {
+	return mv >= t.inverse[0].min && mv <= t.inverse[len(t.inverse)-1].max
+}
+
+// CompensatedTemperature converts a measured thermocouple voltage to the hot junction
+// temperature, using the cold junction (internal sensor) temperature for compensation
+func (t *thermocoupleTable) CompensatedTemperature(measuredMv float64, coldJunctionC float64) (float64, error) {
+	totalMv := measuredMv + t.TemperatureToVoltage(coldJunctionC)
+	if !t.InRange(totalMv) {
+		return 0, fmt.Errorf("thermocouple voltage %.3f mV out of range", totalMv)
+	}
+	return t.VoltageToTemperature(totalMv), nil
+}
+
+// thermocoupleSample converts a thermocouple voltage in mV to a calibrated temperature. A
+// reading outside the table of the thermocouple type, e.g. from an open or shorted thermocouple, is a NaN
+// sample everywhere: histories and exports keep it so that samples stay aligned with the
+// internal sensor and with time, averages and histograms skip it, and it is counted so
+// that it can be reported. ok is false for out-of-range readings.
+func thermocoupleSample(table *thermocoupleTable, mv float64, coldJunctionC float64, calibration *Calibration) (float64, bool) {
+	temperature, err := table.CompensatedTemperature(mv, coldJunctionC)
+	if err != nil {
+		return math.NaN(), false
+	}
+	return calibration.Apply(temperature), true
+}
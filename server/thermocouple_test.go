@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// TestThermocoupleReference checks the functions of every supported type against NIST
+// ITS-90 table values
+func TestThermocoupleReference(t *testing.T) {
+	type point struct {
+		tempC float64
+		mv    float64
+	}
+	tests := map[string][]point{
+		"J": {{-200, -7.890}, {-100, -4.633}, {0, 0.000}, {100, 5.269}, {500, 27.393}, {760, 42.919}, {1000, 57.953}, {1200, 69.553}},
+		"K": {{-200, -5.891}, {-100, -3.554}, {0, 0.000}, {25, 1.000}, {100, 4.096}, {500, 20.644}, {1000, 41.276}, {1300, 52.410}},
+		"N": {{-200, -3.990}, {-100, -2.407}, {0, 0.000}, {100, 2.774}, {500, 16.748}, {1000, 36.256}, {1300, 47.513}},
+		"T": {{-200, -5.603}, {-100, -3.379}, {0, 0.000}, {100, 4.279}, {200, 9.288}, {300, 14.862}, {400, 20.872}},
+	}
+	if len(tests) != len(thermocoupleTypes) {
+		t.Errorf("Reference points for %d types, want all %d", len(tests), len(thermocoupleTypes))
+	}
+
+	for name, points := range tests {
+		table := thermocoupleTypes[name]
+		if table == nil {
+			t.Errorf("Thermocouple type %s not supported", name)
+			continue
+		}
+		for _, tt := range points {
+			mv := table.TemperatureToVoltage(tt.tempC)
+			if math.Abs(mv-tt.mv) > 0.002 {
+				t.Errorf("%s: TemperatureToVoltage(%v) = %.4f mV, want %.3f mV", name, tt.tempC, mv, tt.mv)
+			}
+			tempC := table.VoltageToTemperature(tt.mv)
+			if math.Abs(tempC-tt.tempC) > 0.1 {
+				t.Errorf("%s: VoltageToTemperature(%v) = %.3f °C, want %v °C", name, tt.mv, tempC, tt.tempC)
+			}
+		}
+	}
+}
+
+// TestThermocoupleKCompensation tests cold-junction compensation
+func TestThermocoupleKCompensation(t *testing.T) {
+	// Hot junction at 100 °C with the cold junction at 25 °C
+	measured := thermocoupleK.TemperatureToVoltage(100) - thermocoupleK.TemperatureToVoltage(25)
+	tempC, err := thermocoupleK.CompensatedTemperature(measured, 25)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(tempC-100) > 0.1 {
+		t.Fatalf("Compensated temperature = %.3f °C, want 100 °C", tempC)
+	}
+
+	// Far outside the table
+	if _, err := thermocoupleK.CompensatedTemperature(100, 25); err == nil {
+		t.Fatal("Expected error for out of range voltage, got nil")
+	}
+}
+
+// TestThermocoupleOutOfRange tests that live processing and export treat out-of-range
+// readings the same way
+func TestThermocoupleOutOfRange(t *testing.T) {
+	// 25 °C internal, out-of-range thermocouple, 25 °C internal, 0 mV thermocouple
+	data := []byte{}
+	for _, raw := range []uint16{3200, 32767, 3200, 0} {
+		data = binary.LittleEndian.AppendUint16(data, raw)
+	}
+
+	buffer := NewDataBuffer(5557, "10.0.0.2", 10, "dev1", 1024)
+	buffer.AddData(data)
+	live := buffer.historyB.Last(2)
+	if !math.IsNaN(live[0]) || math.Abs(live[1]-25) > 0.1 {
+		t.Errorf("Live thermocouple history = %v, want [NaN 25]", live)
+	}
+	if count := buffer.GetOutOfRangeCount(); count != 1 {
+		t.Errorf("GetOutOfRangeCount() = %d, want 1", count)
+	}
+	if average, _ := buffer.CalculateAverageB(); math.Abs(average-25) > 0.1 {
+		t.Errorf("Thermocouple average = %v, want 25", average)
+	}
+
+	var exported []float64
+	decoder := newSampleDecoder(5557, nil, nil)
+	decoder.decode(data, func(values []float64) error {
+		exported = append(exported, values[1])
+		return nil
+	})
+	if len(exported) != 2 || !math.IsNaN(exported[0]) || exported[1] != live[1] || decoder.outOfRange != 1 {
+		t.Errorf("Exported thermocouple samples = %v with %d out of range, want [NaN %v] with 1",
+			exported, decoder.outOfRange, live[1])
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"eth-daq-software/compress"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// The timestamp records of an uncompressed segment are kept in a sidecar named like the
+// segment with TIMESTAMP_SUFFIX appended. It holds JSON Lines, one compress.Timestamp per
+// line in the order of their offsets:
+//
+//	{"offset":0,"sample":0,"monotonic":1250000,"wall":1740830400000000000}
+//	{"offset":20000,"sample":10000,"monotonic":1001250000,"wall":1740830401000000000}
+//
+// Records are appended as their data is written, under PARTIAL_SEGMENT_SUFFIX like the
+// segment, so that a segment left by a crash keeps the records of the data it holds.
+// Sidecars of older versions hold a single JSON array of the records, which is still read.
+const (
+	TIMESTAMP_SUFFIX = ".timestamps" // Appended to the name of an uncompressed segment for its timestamp records
+)
+
+// processStart is the origin of the monotonic times of timestamp records
+var processStart = time.Now()
+
+// timestamp returns the timestamp record of data about to be stored at offset of the open
+// segment or buffer, if one is due. The first data of a segment always gets one. The
+// caller must hold db.mu.
+func (db *DataBuffer) timestamp(offset int, now time.Time) (compress.Timestamp, bool) {
+	if db.timestampInterval <= 0 || (offset > 0 && now.Sub(db.lastTimestamp) < db.timestampInterval) {
+		return compress.Timestamp{}, false
+	}
+	db.lastTimestamp = now
+	decoder := db.samples.decoder
+	return compress.Timestamp{
+		Offset:    int64(offset),
+		Sample:    db.storedBytes / int64(decoder.SampleWidth()*len(decoder.Channels())),
+		Monotonic: now.Sub(processStart).Nanoseconds(),
+		Wall:      now.UnixNano(),
+	}, true
+}
+
+// appendTimestampLine appends a timestamp record to data as a line of a sidecar
+func appendTimestampLine(data []byte, timestamp compress.Timestamp) []byte {
+	line, _ := json.Marshal(timestamp) // Cannot fail for a struct of integers
+	return append(append(data, line...), '\n')
+}
+
+// appendTimestamp appends a timestamp record to the sidecar of the open segment, creating
+// it with the first record. The caller must hold the mu of the segment's buffer.
+func (segment *appendSegment) appendTimestamp(timestamp compress.Timestamp) error {
+	if segment.timestamps == nil {
+		file, err := os.Create(segment.path + TIMESTAMP_SUFFIX + PARTIAL_SEGMENT_SUFFIX)
+		if err != nil {
+			return fmt.Errorf("failed to create timestamps of %s: %v", segment.path, err)
+		}
+		segment.timestamps = file
+	}
+	if _, err := segment.timestamps.Write(appendTimestampLine(nil, timestamp)); err != nil {
+		return fmt.Errorf("failed to write timestamps of %s: %v", segment.path, err)
+	}
+	return nil
+}
+
+// writeTimestamps writes the timestamp records of an uncompressed segment to its sidecar
+func writeTimestamps(path string, timestamps []compress.Timestamp) error {
+	var data []byte
+	for _, timestamp := range timestamps {
+		data = appendTimestampLine(data, timestamp)
+	}
+	if err := os.WriteFile(path+TIMESTAMP_SUFFIX, data, 0644); err != nil {
+		return fmt.Errorf("failed to write timestamps of %s: %v", path, err)
+	}
+	return nil
+}
+
+// parseTimestamps parses a timestamp sidecar, of JSON Lines or of an older version
+func parseTimestamps(data []byte) ([]compress.Timestamp, error) {
+	var timestamps []compress.Timestamp
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &timestamps)
+		return timestamps, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var timestamp compress.Timestamp
+		if err := decoder.Decode(&timestamp); err == io.EOF {
+			return timestamps, nil
+		} else if err != nil {
+			return nil, err
+		}
+		timestamps = append(timestamps, timestamp)
+	}
+}
+
+// ReadTimestamps returns the timestamp records of a segment, from the metadata of a
+// compressed segment or the sidecar of an uncompressed one. Segments written without
+// them have none.
+func ReadTimestamps(path string) ([]compress.Timestamp, error) {
+	data, err := os.ReadFile(path + TIMESTAMP_SUFFIX)
+	if err == nil {
+		timestamps, err := parseTimestamps(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamps of %s: %v", path, err)
+		}
+		return timestamps, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if compressed, err := compress.IsCompressedFile(path); err != nil || !compressed {
+		return nil, err
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := compress.ReadMetadata(data)
+	if err != nil || metadata == nil {
+		return nil, err
+	}
+	return metadata.Timestamps, nil
+}
+
+// sampleClock returns the time of sample i of a segment of n samples spanning start to
+// end. Samples are spread evenly between the timestamp records of the segment, or over
+// the whole span without them.
+func sampleClock(timestamps []compress.Timestamp, frameSize int, n int, start time.Time, end time.Time) func(i int) time.Time {
+	type point struct {
+		index int
+		time  time.Time
+	}
+	points := []point{{0, start}}
+	for _, timestamp := range timestamps {
+		index := int(timestamp.Offset) / frameSize
+		t := time.Unix(0, timestamp.Wall)
+		switch last := &points[len(points)-1]; {
+		case index == 0:
+			last.time = t
+		case index > last.index && index < n && t.After(last.time):
+			points = append(points, point{index, t})
+		}
+	}
+	if end.After(points[len(points)-1].time) {
+		points = append(points, point{n, end})
+	}
+
+	return func(i int) time.Time {
+		// The last point at or before i and the one after it
+		k := sort.Search(len(points), func(k int) bool { return points[k].index > i }) - 1
+		k = max(min(k, len(points)-2), 0)
+		if len(points) == 1 {
+			return points[0].time
+		}
+		a, b := points[k], points[k+1]
+		return a.time.Add(time.Duration(float64(b.time.Sub(a.time)) * float64(i-a.index) / float64(b.index-a.index)))
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"eth-daq-software/compress"
+	"eth-daq-software/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSegmentTimestamps tests that timestamp records are stored in the header of compressed
+// segments and next to raw ones, with the offset and sample count of their data
+func TestSegmentTimestamps(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	s := NewServer(cfg)
+
+	for _, codec := range []string{"zstd", "none"} {
+		buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1000)
+		buffer.writer = s.writer
+		buffer.codec, _ = compress.CodecByName(codec)
+		buffer.timestampInterval = time.Nanosecond
+		for range 3 {
+			buffer.AddData(make([]byte, 100))
+			time.Sleep(time.Millisecond)
+		}
+		if err := buffer.FlushSync(); err != nil {
+			t.Fatal(err)
+		}
+
+		paths, _ := filepath.Glob(filepath.Join(cfg.DataDir, "port5556_*"+segmentExtension(buffer.codec)))
+		if len(paths) != 1 {
+			t.Fatalf("%s: segments = %v, want 1", codec, paths)
+		}
+		timestamps, err := ReadTimestamps(paths[0])
+		if err != nil || len(timestamps) != 3 {
+			t.Fatalf("%s: ReadTimestamps() = %v, %v, want 3 records", codec, timestamps, err)
+		}
+		for i, timestamp := range timestamps {
+			if timestamp.Offset != int64(100*i) || timestamp.Sample != int64(50*i) ||
+				(i > 0 && timestamp.Wall <= timestamps[i-1].Wall) || (i > 0 && timestamp.Monotonic <= timestamps[i-1].Monotonic) {
+				t.Errorf("%s: timestamp %d = %+v, want offset %d after the previous one", codec, i, timestamp, 100*i)
+			}
+		}
+	}
+}
+
+// TestSampleClock tests that samples are placed between timestamp records and the end of
+// their segment
+func TestSampleClock(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	timestamps := []compress.Timestamp{
+		{Offset: 0, Wall: start.Add(10 * time.Millisecond).UnixNano()},
+		{Offset: 100, Wall: start.Add(time.Second).UnixNano()}, // Sample 50 of 2 byte frames
+	}
+	clock := sampleClock(timestamps, 2, 100, start, start.Add(1500*time.Millisecond))
+
+	tests := []struct {
+		sample int
+		want   time.Duration
+	}{
+		{0, 10 * time.Millisecond},
+		{25, 505 * time.Millisecond},
+		{50, time.Second},
+		{75, 1250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := clock(tt.sample).Sub(start); got != tt.want {
+			t.Errorf("clock(%d) = %v, want %v", tt.sample, got, tt.want)
+		}
+	}
+
+	clock = sampleClock(nil, 2, 10, start, start.Add(time.Second))
+	if got := clock(5).Sub(start); got != 500*time.Millisecond {
+		t.Errorf("clock(5) without timestamps = %v, want 500ms", got)
+	}
+}
+
+// TestTimestampSidecar tests that the records of a raw segment are appended to its sidecar
+// while the segment is written, and that sidecars of older versions are still read
+func TestTimestampSidecar(t *testing.T) {
+	dir := t.TempDir()
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1000)
+	buffer.writer = newSegmentWriter(1, dir)
+	buffer.timestampInterval = time.Nanosecond
+	buffer.AddData(make([]byte, 100))
+	time.Sleep(time.Millisecond)
+	buffer.AddData(make([]byte, 100))
+
+	path := buffer.segment.path
+	data, err := os.ReadFile(path + TIMESTAMP_SUFFIX + PARTIAL_SEGMENT_SUFFIX)
+	if err != nil {
+		t.Fatalf("Sidecar of the open segment: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Errorf("Sidecar of the open segment = %q, want 2 lines", data)
+	}
+	if err := buffer.FlushSync(); err != nil {
+		t.Fatal(err)
+	}
+	timestamps, err := ReadTimestamps(path)
+	if err != nil || len(timestamps) != 2 || timestamps[1].Offset != 100 {
+		t.Errorf("ReadTimestamps() = %+v, %v, want records at 0 and 100", timestamps, err)
+	}
+	if _, err := os.Stat(path + TIMESTAMP_SUFFIX + PARTIAL_SEGMENT_SUFFIX); !os.IsNotExist(err) {
+		t.Errorf("Partial sidecar left after closing the segment: %v", err)
+	}
+
+	legacy := filepath.Join(dir, "legacy.bin")
+	os.WriteFile(legacy, make([]byte, 100), 0644)
+	os.WriteFile(legacy+TIMESTAMP_SUFFIX, []byte(`[{"offset":0,"sample":0,"monotonic":1,"wall":2},{"offset":50,"sample":25,"monotonic":3,"wall":4}]`), 0644)
+	timestamps, err = ReadTimestamps(legacy)
+	if err != nil || len(timestamps) != 2 || timestamps[1] != (compress.Timestamp{Offset: 50, Sample: 25, Monotonic: 3, Wall: 4}) {
+		t.Errorf("ReadTimestamps() of an array sidecar = %+v, %v", timestamps, err)
+	}
+}
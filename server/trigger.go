@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"eth-daq-software/logger"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	HISTORY_SAMPLES    = 1 << 20 // Raw samples kept per channel for pre-trigger capture
+	TC_HISTORY_SAMPLES = 4096    // The thermocouple channel is slow, keep a small history
+)
+
+// SampleRing keeps the most recent scaled samples of a channel so that a trigger
+// can look back in time. Unlike CircularBuffer it does not keep a running sum,
+// it only remembers the samples and how many have been written in total. The ring
+// grows up to its size as samples are added, so slow or idle channels only hold
+// what they have received.
+type SampleRing struct {
+	data  []float64
+	size  int
+	total uint64 // Total number of samples ever written
+}
+
+// NewSampleRing creates a new sample ring with the specified capacity
+func NewSampleRing(size int) *SampleRing {
+	return &SampleRing{
+		size: size,
+	}
+}
+
+// Add appends a sample, overwriting the oldest one once the ring is full
+func (sr *SampleRing) Add(value float64) {
+	if len(sr.data) < sr.size {
+		sr.data = append(sr.data, value)
+	} else {
+		sr.data[sr.total%uint64(sr.size)] = value
+	}
+	sr.total++
+}
+
+// Total returns the number of samples written since the ring was created
+func (sr *SampleRing) Total() uint64 {
+	return sr.total
+}
+
+// Last returns a copy of the newest n samples, oldest first
+func (sr *SampleRing) Last(n int) []float64 {
+	return sr.Since(sr.total - uint64(min(n, sr.Available())))
+}
+
+// Since returns a copy of all samples written from the absolute position start onwards,
+// oldest first. Samples that have already been overwritten are skipped.
+func (sr *SampleRing) Since(start uint64) []float64 {
+	oldest := sr.total - uint64(sr.Available())
+	if start < oldest {
+		start = oldest
+	}
+	if start >= sr.total {
+		return []float64{}
+	}
+
+	result := make([]float64, 0, sr.total-start)
+	for i := start; i < sr.total; i++ {
+		result = append(result, sr.data[i%uint64(sr.size)])
+	}
+	return result
+}
+
+// Available returns how many samples can currently be read back
+func (sr *SampleRing) Available() int {
+	if sr.total < uint64(sr.size) {
+		return int(sr.total)
+	}
+	return sr.size
+}
+
+// TriggerCapture holds the samples around a trigger event, like an oscilloscope capture
+type TriggerCapture struct {
+	Key          BufferKey
+	Derived      string // Name of the captured derived channel, "" for physical channels
+	TriggerTime  time.Time
+	SampleRate   float64   // Measured samples per second per channel at the time of the trigger
+	PreTrigger   []float64 // Samples before the trigger, oldest first
+	PostTrigger  []float64 // Samples after the trigger, oldest first
+	PreTriggerB  []float64 // Thermocouple channel B, only used for thermocouple
+	PostTriggerB []float64
+}
+
+// triggerPosition is the position of the trigger in the sample history of a buffer
+type triggerPosition struct {
+	total      uint64
+	totalB     uint64
+	sampleRate float64
+}
+
+// markTrigger records the current history position and returns the pre-trigger samples
+func (db *DataBuffer) markTrigger(pre time.Duration) (triggerPosition, []float64, []float64) {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+
+	pos := triggerPosition{
+		total:      db.history.Total(),
+		sampleRate: db.sampleRate,
+	}
+	n := int(pre.Seconds() * db.sampleRate)
+	preSamples := db.history.Last(n)
+
+	var preSamplesB []float64
+	if db.historyB != nil {
+		pos.totalB = db.historyB.Total()
+		preSamplesB = db.historyB.Last(n)
+	}
+	return pos, preSamples, preSamplesB
+}
+
+// notifyHistory wakes the goroutines waiting for new samples in waitHistory. The caller
+// must hold db.statsMu.
+func (db *DataBuffer) notifyHistory() {
+	if db.historySignal != nil {
+		close(db.historySignal)
+		db.historySignal = nil
+	}
+}
+
+// waitHistory blocks until n samples have been added to the history after the absolute
+// position total, deadline has passed or ctx is done
+func (db *DataBuffer) waitHistory(ctx context.Context, total uint64, n int, deadline time.Time) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		db.statsMu.Lock()
+		if db.history.Total() >= total+uint64(n) {
+			db.statsMu.Unlock()
+			return
+		}
+		if db.historySignal == nil {
+			db.historySignal = make(chan struct{})
+		}
+		signal := db.historySignal
+		db.statsMu.Unlock()
+
+		select {
+		case <-signal:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// waitPostTrigger waits until the samples of post after a trigger at triggerTime have
+// been received. With a known sample rate it returns as soon as they have arrived, giving
+// up after twice post plus a second if the channel slows down or stops. Without a rate it
+// waits until post has elapsed. It returns the number of post-trigger samples to keep,
+// 0 keeping all.
+func (s *Server) waitPostTrigger(buffer *DataBuffer, pos triggerPosition, triggerTime time.Time, post time.Duration) int {
+	n := int(post.Seconds() * pos.sampleRate)
+	if n <= 0 {
+		buffer.waitHistory(s.ctx, pos.total, math.MaxInt32, triggerTime.Add(post))
+		return 0
+	}
+	buffer.waitHistory(s.ctx, pos.total, n, triggerTime.Add(2*post+time.Second))
+	return n
+}
+
+// samplesSince returns the samples recorded after a trigger position
+func (db *DataBuffer) samplesSince(pos triggerPosition) ([]float64, []float64) {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+
+	samples := db.history.Since(pos.total)
+	var samplesB []float64
+	if db.historyB != nil {
+		samplesB = db.historyB.Since(pos.totalB)
+	}
+	return samples, samplesB
+}
+
+// CaptureTrigger triggers a capture on the given channel. The returned capture contains
+// the samples of the last pre duration before the trigger, and blocks until the samples
+// of post after it have been received, see waitPostTrigger.
+func (s *Server) CaptureTrigger(key BufferKey, pre time.Duration, post time.Duration) (TriggerCapture, error) {
+	s.buffersLock.RLock()
+	buffer, exists := s.buffers[key]
+	s.buffersLock.RUnlock()
+
+	if !exists {
+		return TriggerCapture{}, fmt.Errorf("no active channel for %s:%d", key.IP, key.Port)
+	}
+
+	capture := TriggerCapture{
+		Key:         key,
+		TriggerTime: time.Now(),
+	}
+	pos, preSamples, preSamplesB := buffer.markTrigger(pre)
+	capture.SampleRate = pos.sampleRate
+	capture.PreTrigger = preSamples
+	capture.PreTriggerB = preSamplesB
+
+	n := s.waitPostTrigger(buffer, pos, capture.TriggerTime, post)
+
+	capture.PostTrigger, capture.PostTriggerB = buffer.samplesSince(pos)
+	if n > 0 {
+		capture.PostTrigger = capture.PostTrigger[:min(n, len(capture.PostTrigger))]
+		if capture.PostTriggerB != nil {
+			capture.PostTriggerB = capture.PostTriggerB[:min(n, len(capture.PostTriggerB))]
+		}
+	}
+	s.addMarker(Marker{
+		Time:   capture.TriggerTime,
+		Label:  fmt.Sprintf("trigger on port %d", key.Port),
+		Source: MARKER_TRIGGER,
+		UUID:   s.keyUUID(key),
+	})
+	logger.Infof("Trigger capture on %s:%d: %d pre-trigger and %d post-trigger samples\n",
+		key.IP, key.Port, len(capture.PreTrigger), len(capture.PostTrigger))
+
+	return capture, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSampleRing tests that the ring grows up to its size and then wraps around
+func TestSampleRing(t *testing.T) {
+	ring := NewSampleRing(4)
+	if len(ring.data) != 0 {
+		t.Fatalf("New ring holds %d samples, want 0", len(ring.data))
+	}
+	for i := 0; i < 3; i++ {
+		ring.Add(float64(i))
+	}
+	if got := ring.Last(10); !reflect.DeepEqual(got, []float64{0, 1, 2}) {
+		t.Errorf("Last(10) before wrapping = %v, want [0 1 2]", got)
+	}
+
+	for i := 3; i < 6; i++ {
+		ring.Add(float64(i))
+	}
+	if len(ring.data) != 4 || ring.Total() != 6 || ring.Available() != 4 {
+		t.Errorf("Ring holds %d samples, total %d, available %d, want 4, 6, 4",
+			len(ring.data), ring.Total(), ring.Available())
+	}
+	if got := ring.Last(2); !reflect.DeepEqual(got, []float64{4, 5}) {
+		t.Errorf("Last(2) = %v, want [4 5]", got)
+	}
+	// Overwritten samples are skipped
+	if got := ring.Since(0); !reflect.DeepEqual(got, []float64{2, 3, 4, 5}) {
+		t.Errorf("Since(0) = %v, want [2 3 4 5]", got)
+	}
+	if got := ring.Since(6); len(got) != 0 {
+		t.Errorf("Since(6) = %v, want none", got)
+	}
+}
+
+// TestCaptureTrigger tests that a capture holds the pre-trigger samples and returns once
+// the post-trigger samples have arrived
+func TestCaptureTrigger(t *testing.T) {
+	s := NewServer(config.Default())
+	buffer := NewDataBuffer(5556, "10.0.0.2", 10, "dev1", 1024)
+	key := BufferKey{IP: "10.0.0.2", Port: 5556}
+	s.buffers[key] = buffer
+
+	buffer.statsMu.Lock()
+	for i := 0; i < 100; i++ {
+		buffer.history.Add(float64(i))
+	}
+	buffer.sampleRate = 1000
+	buffer.statsMu.Unlock()
+
+	// Deliver 30 samples in one burst, more than the 20 post-trigger samples
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		buffer.statsMu.Lock()
+		for i := 100; i < 130; i++ {
+			buffer.history.Add(float64(i))
+		}
+		buffer.notifyHistory()
+		buffer.statsMu.Unlock()
+	}()
+
+	start := time.Now()
+	capture, err := s.CaptureTrigger(key, 50*time.Millisecond, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CaptureTrigger() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("CaptureTrigger() took %v", elapsed)
+	}
+	if len(capture.PreTrigger) != 50 || capture.PreTrigger[0] != 50 || capture.PreTrigger[49] != 99 {
+		t.Errorf("PreTrigger = %v, want 50 to 99", capture.PreTrigger)
+	}
+	if len(capture.PostTrigger) != 20 || capture.PostTrigger[0] != 100 || capture.PostTrigger[19] != 119 {
+		t.Errorf("PostTrigger = %v, want 100 to 119", capture.PostTrigger)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+)
+
+// Units of channel values
+const (
+	UNIT_VOLT        = "V"
+	UNIT_MILLIVOLT   = "mV"
+	UNIT_AMPERE      = "A"
+	UNIT_MILLIAMPERE = "mA"
+	UNIT_CELSIUS     = "°C"
+	UNIT_KELVIN      = "K"
+	UNIT_FAHRENHEIT  = "°F"
+)
+
+// unit is a unit of a quantity, in which a value is value = base*factor + offset with base
+// the value in the first unit of the quantity
+type unit struct {
+	quantity string
+	factor   float64
+	offset   float64
+}
+
+var units = map[string]unit{
+	UNIT_VOLT:        {"voltage", 1, 0},
+	UNIT_MILLIVOLT:   {"voltage", 1000, 0},
+	UNIT_AMPERE:      {"current", 1, 0},
+	UNIT_MILLIAMPERE: {"current", 1000, 0},
+	UNIT_CELSIUS:     {"temperature", 1, 0},
+	UNIT_KELVIN:      {"temperature", 1, 273.15},
+	UNIT_FAHRENHEIT:  {"temperature", 1.8, 32},
+}
+
+// ConvertUnit converts a value between units of the same quantity, e.g. V to mV or °C to K
+func ConvertUnit(value float64, from string, to string) (float64, error) {
+	convert, err := unitConverter(from, to)
+	if err != nil || convert == nil {
+		return value, err
+	}
+	return convert(value), nil
+}
+
+// unitConverter returns a function converting values from one unit to another, or nil if
+// the units are the same
+func unitConverter(from string, to string) (func(float64) float64, error) {
+	if from == to {
+		return nil, nil
+	}
+	source, known := units[from]
+	if !known {
+		return nil, fmt.Errorf("unknown unit %q", from)
+	}
+	target, known := units[to]
+	if !known {
+		return nil, fmt.Errorf("unknown unit %q", to)
+	}
+	if source.quantity != target.quantity {
+		return nil, fmt.Errorf("cannot convert %s to %s", from, to)
+	}
+	return func(value float64) float64 {
+		return (value-source.offset)/source.factor*target.factor + target.offset
+	}, nil
+}
+
+// ChannelUnits returns the unit of each channel of a port, in the order of ChannelNames
+func ChannelUnits(port int) []string {
+	return decoderForPort(port).Units()
+}
+
+// ChannelValue is the average of a channel in a unit
+type ChannelValue struct {
+	Value float64
+	Unit  string
+	Valid bool // The averaging window has been filled at least once
+}
+
+// GetChannelAverage returns the average of a channel of a port, 0 for the primary channel
+// or 1 for channel B, converted to unit. "" returns it in the channel's own unit.
+func (s *Server) GetChannelAverage(key BufferKey, channel int, unit string) (ChannelValue, error) {
+	s.buffersLock.RLock()
+	buffer, exists := s.buffers[key]
+	s.buffersLock.RUnlock()
+	if !exists {
+		return ChannelValue{}, fmt.Errorf("no active channel for %s:%d", key.IP, key.Port)
+	}
+	channelUnits := buffer.samples.decoder.Units()
+	if channel < 0 || channel >= len(channelUnits) {
+		return ChannelValue{}, fmt.Errorf("port %d has no channel %d", key.Port, channel)
+	}
+
+	var value ChannelValue
+	if channel == 0 {
+		value.Value, value.Valid = buffer.CalculateAverage()
+	} else {
+		value.Value, value.Valid = buffer.CalculateAverageB()
+	}
+	value.Unit = channelUnits[channel]
+	if unit != "" {
+		converted, err := ConvertUnit(value.Value, value.Unit, unit)
+		if err != nil {
+			return ChannelValue{}, err
+		}
+		value.Value, value.Unit = converted, unit
+	}
+	return value, nil
+}
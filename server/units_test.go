@@ -0,0 +1,47 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+// TestConvertUnit tests conversions between units of a quantity
+func TestConvertUnit(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		want     float64
+	}{
+		{1.5, UNIT_VOLT, UNIT_MILLIVOLT, 1500},
+		{250, UNIT_MILLIAMPERE, UNIT_AMPERE, 0.25},
+		{25, UNIT_CELSIUS, UNIT_KELVIN, 298.15},
+		{100, UNIT_CELSIUS, UNIT_FAHRENHEIT, 212},
+		{32, UNIT_FAHRENHEIT, UNIT_KELVIN, 273.15},
+		{3, UNIT_VOLT, UNIT_VOLT, 3},
+	}
+	for _, tt := range tests {
+		got, err := ConvertUnit(tt.value, tt.from, tt.to)
+		if err != nil || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("ConvertUnit(%v, %s, %s) = %v, %v, want %v", tt.value, tt.from, tt.to, got, err, tt.want)
+		}
+	}
+	if _, err := ConvertUnit(1, UNIT_VOLT, UNIT_CELSIUS); err == nil {
+		t.Error("Expected error converting V to °C, got nil")
+	}
+	if _, err := ConvertUnit(1, "furlong", UNIT_VOLT); err == nil {
+		t.Error("Expected error for an unknown unit, got nil")
+	}
+}
+
+// TestGetChannelAverage tests that averages are returned with their unit
+func TestGetChannelAverage(t *testing.T) {
+	s := newDerivedTestServer(t, "vds", []float64{1, 1, 1}, []float64{2, 2, 2})
+	key := BufferKey{IP: "10.0.0.2", Port: 5556}
+	value, err := s.GetChannelAverage(key, 0, UNIT_MILLIVOLT)
+	if err != nil || value.Unit != UNIT_MILLIVOLT || math.Abs(value.Value-2000) > 1e-6 {
+		t.Errorf("GetChannelAverage() = %+v, %v, want 2000 mV", value, err)
+	}
+	if _, err := s.GetChannelAverage(key, 1, ""); err == nil {
+		t.Error("Expected error for channel B of the GADC port, got nil")
+	}
+}
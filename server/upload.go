@@ -0,0 +1,464 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"eth-daq-software/config"
+	"eth-daq-software/logger"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	UPLOAD_LEDGER    = ".uploaded"     // File in the data directory listing the uploaded segments
+	UPLOAD_MANIFEST  = ".uploads.json" // File in the data directory with the pending uploads
+	UPLOAD_EVENT     = "upload"        // Event carrying the UploadStatus after an upload finished or failed
+	UPLOAD_RETRY_MIN = 1 * time.Second // First wait before retrying a failed upload
+	UPLOAD_RETRY_MAX = 5 * time.Minute // Longest wait before retrying a failed upload
+)
+
+// Uploader copies files to remote storage
+type Uploader interface {
+	// Upload copies the file at localPath to name, a slash-separated path below the
+	// destination
+	Upload(ctx context.Context, localPath string, name string) error
+	Close() error
+}
+
+// UploaderFactory connects to the destination of an upload configuration
+type UploaderFactory func(cfg config.UploadConfig, target *url.URL) (Uploader, error)
+
+var (
+	uploaders     = map[string]UploaderFactory{}
+	uploadersLock sync.RWMutex
+)
+
+// RegisterUploader adds an upload destination for URLs with scheme, replacing an uploader
+// of the same scheme
+func RegisterUploader(scheme string, factory UploaderFactory) {
+	uploadersLock.Lock()
+	defer uploadersLock.Unlock()
+	uploaders[scheme] = factory
+}
+
+// newUploader connects to the configured upload destination
+func newUploader(cfg config.UploadConfig) (Uploader, error) {
+	target, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload url: %v", err)
+	}
+	uploadersLock.RLock()
+	factory, ok := uploaders[target.Scheme]
+	uploadersLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported upload scheme %q", target.Scheme)
+	}
+	return factory(cfg, target)
+}
+
+// ResumableUploader is an Uploader that continues interrupted uploads of large files
+type ResumableUploader interface {
+	Uploader
+	// Resume uploads a file, continuing from progress, and passes the updated progress to
+	// save as parts are confirmed by the destination
+	Resume(ctx context.Context, localPath string, name string, progress UploadProgress, save func(UploadProgress)) error
+}
+
+// UploadProgress is a segment waiting to be uploaded or being uploaded, as recorded in the
+// UPLOAD_MANIFEST
+type UploadProgress struct {
+	Name      string    // Path below the data directory, slash-separated
+	Path      string    // Local path
+	Size      int64     `json:",omitempty"` // Size of the file when the upload started
+	Sent      int64     `json:",omitempty"` // Bytes confirmed by the destination
+	UploadID  string    `json:",omitempty"` // Multipart upload of an S3 destination
+	Parts     []string  `json:",omitempty"` // ETags of the uploaded parts of UploadID
+	Attempts  int       `json:",omitempty"` // Failed attempts
+	LastError string    `json:",omitempty"`
+	RetryAt   time.Time `json:",omitempty"` // Earliest time of the next attempt after a failure
+	Active    bool      `json:"-"`          // Being uploaded by a worker
+}
+
+// UploadStatus is the progress of uploading written segments
+type UploadStatus struct {
+	Target        string           // Destination URL without credentials
+	Pending       int              // Segments waiting to be uploaded, including those in progress
+	InProgress    []UploadProgress // Segments being uploaded
+	Uploaded      int64            // Segments uploaded since startup
+	UploadedBytes int64
+	LastError     string    `json:",omitempty"` // Error of the last failed upload, "" once an upload succeeds
+	ErrorTime     time.Time `json:",omitempty"`
+}
+
+// uploadQueue uploads written segments on a number of workers. Uploaded segments are
+// listed in the UPLOAD_LEDGER of the data directory and the pending ones, with the progress
+// of interrupted uploads, in its UPLOAD_MANIFEST. On startup uploads resume from the
+// manifest and segments missing from both files are queued again.
+type uploadQueue struct {
+	dataDir  string
+	lock     sync.Mutex
+	pending  []*UploadProgress // Oldest first, uploads may finish in any order
+	queued   map[string]bool   // Names in pending
+	uploaded map[string]bool   // Names in the ledger
+	status   UploadStatus
+	wake     chan struct{}
+	notify   func() // Called after an upload finished or failed
+}
+
+func newUploadQueue(target string, dataDir string) *uploadQueue {
+	if u, err := url.Parse(target); err == nil {
+		u.User = nil
+		target = u.String()
+	}
+	return &uploadQueue{
+		dataDir:  dataDir,
+		queued:   make(map[string]bool),
+		uploaded: make(map[string]bool),
+		status:   UploadStatus{Target: target},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// add queues a written segment for uploading, unless it is queued or uploaded
+func (q *uploadQueue) add(path string) {
+	rel, err := filepath.Rel(q.dataDir, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		rel = filepath.Base(path)
+	}
+	q.lock.Lock()
+	q.addLocked(&UploadProgress{Name: filepath.ToSlash(rel), Path: path})
+	q.lock.Unlock()
+}
+
+func (q *uploadQueue) addLocked(progress *UploadProgress) {
+	if q.queued[progress.Name] || q.uploaded[progress.Name] {
+		return
+	}
+	q.queued[progress.Name] = true
+	q.pending = append(q.pending, progress)
+	if err := q.saveManifest(); err != nil {
+		logger.Errorf("Failed to save upload manifest: %v\n", err)
+	}
+	q.signal()
+}
+
+func (q *uploadQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// saveManifest writes the pending uploads to the UPLOAD_MANIFEST, replacing it atomically
+func (q *uploadQueue) saveManifest() error {
+	data, err := json.MarshalIndent(struct{ Pending []*UploadProgress }{q.pending}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSegmentFile(filepath.Join(q.dataDir, UPLOAD_MANIFEST), data, false)
+}
+
+// load queues the uploads of the manifest, then the segments of the data directory that
+// are neither in the manifest nor in the ledger, oldest first. Archived sessions are
+// left out.
+func (q *uploadQueue) load() error {
+	file, err := os.Open(filepath.Join(q.dataDir, UPLOAD_LEDGER))
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		q.lock.Lock()
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				q.uploaded[line] = true
+			}
+		}
+		q.lock.Unlock()
+		err = scanner.Err()
+		file.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read upload ledger: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(q.dataDir, UPLOAD_MANIFEST))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read upload manifest: %v", err)
+	}
+	if err == nil {
+		var manifest struct{ Pending []*UploadProgress }
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("failed to parse upload manifest: %v", err)
+		}
+		q.lock.Lock()
+		for _, progress := range manifest.Pending {
+			progress.Path = filepath.Join(q.dataDir, filepath.FromSlash(progress.Name))
+			progress.RetryAt = time.Time{}
+			q.addLocked(progress)
+		}
+		q.lock.Unlock()
+	}
+
+	type found struct {
+		path    string
+		modTime time.Time
+	}
+	var segments []found
+	err = filepath.WalkDir(q.dataDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ARCHIVE_DIR && filepath.Dir(path) == filepath.Clean(q.dataDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(entry.Name(), "port") || !isSegmentFile(path) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		segments = append(segments, found{path, info.ModTime()})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to list segments to upload: %v", err)
+	}
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].modTime.Before(segments[j].modTime) })
+	for _, segment := range segments {
+		q.add(segment.path)
+	}
+	return nil
+}
+
+// next takes the oldest upload that is neither in progress nor waiting for a retry. If
+// there is none it returns how long to wait for a retry, 0 if there is nothing to retry.
+func (q *uploadQueue) next() (UploadProgress, bool, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for _, progress := range q.pending {
+		if progress.Active {
+			continue
+		}
+		if progress.RetryAt.After(now) {
+			if until := progress.RetryAt.Sub(now); wait == 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+		progress.Active = true
+		return *progress, true, 0
+	}
+	return UploadProgress{}, false, wait
+}
+
+// find returns the pending upload of a name, nil if there is none
+func (q *uploadQueue) find(name string) *UploadProgress {
+	for _, progress := range q.pending {
+		if progress.Name == name {
+			return progress
+		}
+	}
+	return nil
+}
+
+// save records the progress of an upload in the manifest
+func (q *uploadQueue) save(update UploadProgress) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	progress := q.find(update.Name)
+	if progress == nil {
+		return
+	}
+	progress.Size, progress.Sent, progress.UploadID, progress.Parts = update.Size, update.Sent, update.UploadID, update.Parts
+	if err := q.saveManifest(); err != nil {
+		logger.Errorf("Failed to save upload manifest: %v\n", err)
+	}
+}
+
+// finish removes an upload that succeeded or whose file is gone from the queue, recording
+// uploaded files in the ledger. A failed upload stays queued and is retried with backoff.
+func (q *uploadQueue) finish(name string, size int64, uploaded bool, uploadErr error) error {
+	if q.notify != nil {
+		defer q.notify()
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	progress := q.find(name)
+	if progress == nil {
+		return nil
+	}
+	progress.Active = false
+	if uploadErr != nil {
+		if q.status.LastError == "" {
+			logger.Errorf("Failed to upload %s to %s, retrying: %v\n", name, q.status.Target, uploadErr)
+		}
+		progress.Attempts++
+		progress.LastError = uploadErr.Error()
+		progress.RetryAt = time.Now().Add(min(UPLOAD_RETRY_MIN<<min(progress.Attempts-1, 16), UPLOAD_RETRY_MAX))
+		q.status.LastError, q.status.ErrorTime = uploadErr.Error(), time.Now()
+		return q.saveManifest()
+	}
+
+	q.pending = slices.DeleteFunc(q.pending, func(other *UploadProgress) bool { return other == progress })
+	delete(q.queued, name)
+	if err := q.saveManifest(); err != nil {
+		return err
+	}
+	if !uploaded {
+		return nil
+	}
+	q.uploaded[name] = true
+	q.status.Uploaded++
+	q.status.UploadedBytes += size
+	q.status.LastError = ""
+
+	ledger, err := os.OpenFile(filepath.Join(q.dataDir, UPLOAD_LEDGER), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := ledger.WriteString(name + "\n"); err != nil {
+		ledger.Close()
+		return err
+	}
+	if err := ledger.Sync(); err != nil {
+		ledger.Close()
+		return err
+	}
+	return ledger.Close()
+}
+
+// upload sends a segment, resuming an interrupted upload if the uploader can, followed by
+// its sidecars and session manifests, which are replaced on every upload
+func (q *uploadQueue) upload(ctx context.Context, uploader Uploader, progress UploadProgress) error {
+	var err error
+	if resumable, ok := uploader.(ResumableUploader); ok {
+		err = resumable.Resume(ctx, progress.Path, progress.Name, progress, q.save)
+	} else {
+		err = uploader.Upload(ctx, progress.Path, progress.Name)
+	}
+	if err != nil {
+		return err
+	}
+	sidecars := map[string]string{
+		progress.Path + CHECKSUM_SUFFIX:                              progress.Name + CHECKSUM_SUFFIX,
+		progress.Path + TIMESTAMP_SUFFIX:                             progress.Name + TIMESTAMP_SUFFIX,
+		filepath.Join(filepath.Dir(progress.Path), SESSION_MANIFEST): path.Join(path.Dir(progress.Name), SESSION_MANIFEST),
+		filepath.Join(filepath.Dir(progress.Path), SEGMENT_MANIFEST): path.Join(path.Dir(progress.Name), SEGMENT_MANIFEST),
+	}
+	for local, name := range sidecars {
+		if _, err := os.Stat(local); err != nil {
+			continue
+		}
+		if err := uploader.Upload(ctx, local, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run uploads queued segments until ctx is cancelled
+func (q *uploadQueue) run(ctx context.Context, uploader Uploader) {
+	defer uploader.Close()
+	for {
+		progress, ok, wait := q.next()
+		if !ok {
+			var retry <-chan time.Time
+			if wait > 0 {
+				retry = time.After(wait)
+			}
+			select {
+			case <-q.wake:
+			case <-retry:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		info, err := os.Stat(progress.Path)
+		if os.IsNotExist(err) {
+			// Deleted before it could be uploaded, e.g. by retention
+			logger.Errorf("Segment %s was removed before it was uploaded\n", progress.Path)
+			q.finish(progress.Name, 0, false, nil)
+			continue
+		}
+		var size int64
+		if err == nil {
+			size = info.Size()
+			err = q.upload(ctx, uploader, progress)
+		}
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if err := q.finish(progress.Name, size, err == nil, err); err != nil {
+			logger.Errorf("Failed to record upload of %s: %v\n", progress.Name, err)
+		}
+		// Another worker may be waiting for a retry that is now due
+		q.signal()
+	}
+}
+
+// StartUploads uploads the segments of the data directory that were not uploaded yet and
+// then every written segment, until the server is stopped
+func (s *Server) StartUploads() {
+	q := s.writer.uploads
+	if q == nil {
+		return
+	}
+	cfg := s.settings().Upload
+	var workers []Uploader
+	for range max(cfg.Workers, 1) {
+		uploader, err := newUploader(cfg)
+		if err != nil {
+			logger.Errorf("Failed to start uploads: %v\n", err)
+			q.lock.Lock()
+			q.status.LastError, q.status.ErrorTime = err.Error(), time.Now()
+			q.lock.Unlock()
+			for _, worker := range workers {
+				worker.Close()
+			}
+			return
+		}
+		workers = append(workers, uploader)
+	}
+	q.notify = func() { s.emitEvent(UPLOAD_EVENT, s.GetUploadStatus()) }
+	if err := q.load(); err != nil {
+		logger.Errorf("%v\n", err)
+	}
+	for _, uploader := range workers {
+		go q.run(s.ctx, uploader)
+	}
+}
+
+// GetUploadStatus returns the progress of uploads, with an empty Target if uploads are
+// not configured
+func (s *Server) GetUploadStatus() UploadStatus {
+	q := s.writer.uploads
+	if q == nil {
+		return UploadStatus{}
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	status := q.status
+	status.Pending = len(q.pending)
+	for _, progress := range q.pending {
+		if progress.Active {
+			status.InProgress = append(status.InProgress, *progress)
+		}
+	}
+	return status
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"eth-daq-software/config"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	UPLOAD_S3_TIMEOUT = 10 * time.Minute // Time an S3 request may take
+	S3_PART_SIZE      = 8 * 1024 * 1024  // Size of the parts of multipart uploads, larger files are uploaded in parts
+)
+
+func init() {
+	RegisterUploader("s3", newS3Uploader)
+}
+
+// s3Uploader puts files into a bucket of S3 or an S3-compatible service
+type s3Uploader struct {
+	client    minio.Core
+	transport *http.Transport
+	bucket    string
+	prefix    string
+}
+
+func newS3Uploader(cfg config.UploadConfig, target *url.URL) (Uploader, error) {
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil || base.Host == "" || strings.Trim(base.Path, "/") != "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	accessKey, secretKey := cfg.AccessKeyID, cfg.SecretAccessKey
+	if accessKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("S3 credentials are missing")
+	}
+	secure := base.Scheme == "https"
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+	// The upload queue retries failed uploads with its own backoff
+	client, err := minio.NewCore(base.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure:       secure,
+		Transport:    transport,
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+		MaxRetries:   1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %v", endpoint, err)
+	}
+	return &s3Uploader{
+		client:    *client,
+		transport: transport,
+		bucket:    target.Host,
+		prefix:    strings.Trim(target.Path, "/"),
+	}, nil
+}
+
+// objectName returns the key of the object for name
+func (u *s3Uploader) objectName(name string) string {
+	return path.Join(u.prefix, name)
+}
+
+// Upload puts a file as one object
+func (u *s3Uploader) Upload(ctx context.Context, localPath string, name string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, UPLOAD_S3_TIMEOUT)
+	defer cancel()
+	_, err = u.client.PutObject(ctx, u.bucket, u.objectName(name), file, info.Size(), "", "", minio.PutObjectOptions{})
+	return err
+}
+
+// Resume uploads files larger than S3_PART_SIZE in parts, continuing the multipart upload
+// of progress. An upload the service no longer knows starts over on the next attempt.
+func (u *s3Uploader) Resume(ctx context.Context, localPath string, name string, progress UploadProgress, save func(UploadProgress)) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size <= S3_PART_SIZE {
+		return u.Upload(ctx, localPath, name)
+	}
+	object := u.objectName(name)
+	if progress.UploadID == "" || progress.Size != size {
+		requestCtx, cancel := context.WithTimeout(ctx, UPLOAD_S3_TIMEOUT)
+		id, err := u.client.NewMultipartUpload(requestCtx, u.bucket, object, minio.PutObjectOptions{})
+		cancel()
+		if err != nil {
+			return err
+		}
+		progress.UploadID, progress.Size, progress.Sent, progress.Parts = id, size, 0, nil
+		save(progress)
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	restart := func(err error) error {
+		if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+			progress.UploadID, progress.Sent, progress.Parts = "", 0, nil
+			save(progress)
+		}
+		return err
+	}
+	for part := len(progress.Parts); int64(part)*S3_PART_SIZE < size; part++ {
+		offset := int64(part) * S3_PART_SIZE
+		length := min(S3_PART_SIZE, size-offset)
+		requestCtx, cancel := context.WithTimeout(ctx, UPLOAD_S3_TIMEOUT)
+		uploaded, err := u.client.PutObjectPart(requestCtx, u.bucket, object, progress.UploadID, part+1,
+			io.NewSectionReader(file, offset, length), length, minio.PutObjectPartOptions{})
+		cancel()
+		if err != nil {
+			return restart(err)
+		}
+		progress.Parts = append(progress.Parts, uploaded.ETag)
+		progress.Sent = offset + length
+		save(progress)
+	}
+
+	parts := make([]minio.CompletePart, len(progress.Parts))
+	for i, etag := range progress.Parts {
+		parts[i] = minio.CompletePart{PartNumber: i + 1, ETag: etag}
+	}
+	ctx, cancel := context.WithTimeout(ctx, UPLOAD_S3_TIMEOUT)
+	defer cancel()
+	if _, err := u.client.CompleteMultipartUpload(ctx, u.bucket, object, progress.UploadID, parts, minio.PutObjectOptions{}); err != nil {
+		return restart(err)
+	}
+	return nil
+}
+
+func (u *s3Uploader) Close() error {
+	u.transport.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"eth-daq-software/config"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	SFTP_DIAL_TIMEOUT  = 15 * time.Second // Time to connect and log in to an SFTP server
+	SFTP_SAVE_INTERVAL = 1024 * 1024      // Bytes written between updates of the upload manifest
+)
+
+func init() {
+	RegisterUploader("sftp", newSFTPUploader)
+}
+
+// sftpUploader writes files to an SFTP server, connecting on the first upload and again
+// after a failed one
+type sftpUploader struct {
+	address string
+	dir     string
+	config  *ssh.ClientConfig
+	client  *ssh.Client
+	sftp    *sftp.Client
+}
+
+func newSFTPUploader(cfg config.UploadConfig, target *url.URL) (Uploader, error) {
+	if target.User == nil || target.User.Username() == "" {
+		return nil, fmt.Errorf("sftp url must name the user, sftp://user@host/path")
+	}
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		key, err := os.ReadFile(expandHome(cfg.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SFTP key: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SFTP key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp uploads need a key_file or password")
+	}
+	knownHosts := cfg.KnownHosts
+	if knownHosts == "" {
+		knownHosts = "~/.ssh/known_hosts"
+	}
+	hostKeys, err := knownhosts.New(expandHome(knownHosts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %v", err)
+	}
+
+	address := target.Host
+	if target.Port() == "" {
+		address = net.JoinHostPort(target.Hostname(), "22")
+	}
+	return &sftpUploader{
+		address: address,
+		dir:     target.Path,
+		config: &ssh.ClientConfig{
+			User:            target.User.Username(),
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         SFTP_DIAL_TIMEOUT,
+		},
+	}, nil
+}
+
+// expandHome replaces a leading ~ of a path with the home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// connect logs in and starts the sftp subsystem
+func (u *sftpUploader) connect() error {
+	client, err := ssh.Dial("tcp", u.address, u.config)
+	if err != nil {
+		return err
+	}
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	u.client, u.sftp = client, sftpClient
+	return nil
+}
+
+// Upload writes a file under a temporary name and renames it once complete
+func (u *sftpUploader) Upload(ctx context.Context, localPath string, name string) error {
+	return u.Resume(ctx, localPath, name, UploadProgress{}, nil)
+}
+
+// Resume continues writing the temporary file of an interrupted upload of the same file
+func (u *sftpUploader) Resume(ctx context.Context, localPath string, name string, progress UploadProgress, save func(UploadProgress)) error {
+	if u.client == nil {
+		if err := u.connect(); err != nil {
+			return err
+		}
+	}
+	// Closing the connection unblocks a transfer when ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { u.client.Close() })
+	defer stop()
+	err := sftpPut(u.sftp, localPath, path.Join(u.dir, name), progress, save)
+	if err != nil {
+		u.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func (u *sftpUploader) Close() error {
+	if u.client == nil {
+		return nil
+	}
+	u.sftp.Close()
+	err := u.client.Close()
+	u.client, u.sftp = nil, nil
+	return err
+}
+
+// sftpPut writes a file to remotePath under a temporary name, creating its directories,
+// and renames it into place. If progress is of an earlier attempt for the same file,
+// writing continues at the end of the temporary file. save, if set, receives the progress
+// every SFTP_SAVE_INTERVAL bytes.
+func sftpPut(client *sftp.Client, localPath string, remotePath string, progress UploadProgress, save func(UploadProgress)) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+	partial := remotePath + PARTIAL_SEGMENT_SUFFIX
+	var offset int64
+	if progress.Sent > 0 && progress.Size == info.Size() {
+		if remote, err := client.Stat(partial); err == nil && remote.Size() <= info.Size() {
+			offset = remote.Size()
+		}
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	remote, err := client.OpenFile(partial, flags)
+	if err != nil {
+		return err
+	}
+	if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+		remote.Close()
+		return err
+	}
+	progress.Size, progress.Sent = info.Size(), offset
+	for progress.Sent < progress.Size {
+		section := io.NewSectionReader(file, progress.Sent, min(SFTP_SAVE_INTERVAL, progress.Size-progress.Sent))
+		n, err := remote.ReadFrom(section)
+		progress.Sent += n
+		if err != nil {
+			remote.Close()
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if save != nil {
+			save(progress)
+		}
+	}
+	if err := remote.Close(); err != nil {
+		return err
+	}
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(partial, remotePath)
+	}
+	// Plain renames fail if the target exists
+	client.Remove(remotePath)
+	return client.Rename(partial, remotePath)
+}
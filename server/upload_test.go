@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"eth-daq-software/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// TestUploadS3 tests that segments missing from the ledger are uploaded with their session
+// manifests and recorded in the ledger
+func TestUploadS3(t *testing.T) {
+	var lock sync.Mutex
+	objects := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		body := s3Body(r)
+		lock.Lock()
+		objects[r.URL.Path] = string(body)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	dataDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dataDir, "session1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"port5556_0.bin":          "old",
+		"session1/port5556_1.bin": "data",
+		"session1/session.json":   "{}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dataDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, UPLOAD_LEDGER), []byte("port5556_0.bin\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := `{"Pending": [{"Name": "session1/port5556_1.bin", "Attempts": 2}]}`
+	if err := os.WriteFile(filepath.Join(dataDir, UPLOAD_MANIFEST), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.UploadConfig{URL: "s3://bucket/box1", Endpoint: server.URL, AccessKeyID: "key", SecretAccessKey: "secret"}
+	target, _ := url.Parse(cfg.URL)
+	uploader, err := newS3Uploader(cfg, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newUploadQueue(cfg.URL, dataDir)
+	if err := q.load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.pending) != 1 || q.pending[0].Attempts != 2 {
+		t.Errorf("Loaded %d uploads, want the one of the manifest", len(q.pending))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx, uploader)
+	waitUploads(t, q)
+
+	want := map[string]string{"/bucket/box1/session1/port5556_1.bin": "data", "/bucket/box1/session1/session.json": "{}"}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(objects) != len(want) {
+		t.Errorf("Uploaded %v, want %v", objects, want)
+	}
+	for name, content := range want {
+		if objects[name] != content {
+			t.Errorf("Object %s = %q, want %q", name, objects[name], content)
+		}
+	}
+	ledger, _ := os.ReadFile(filepath.Join(dataDir, UPLOAD_LEDGER))
+	if string(ledger) != "port5556_0.bin\nsession1/port5556_1.bin\n" {
+		t.Errorf("Ledger = %q", ledger)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dataDir, UPLOAD_MANIFEST)); strings.Contains(string(data), "port5556") {
+		t.Errorf("Manifest = %s, want no pending uploads", data)
+	}
+}
+
+// s3Body returns the payload of a request, decoding the signed chunks S3 clients send over
+// plain HTTP
+func s3Body(r *http.Request) []byte {
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		return body
+	}
+	var payload []byte
+	for {
+		header, rest, ok := strings.Cut(string(body), "\r\n")
+		size, err := strconv.ParseInt(strings.Split(header, ";")[0], 16, 64)
+		if !ok || err != nil || size == 0 || int64(len(rest)) < size {
+			return payload
+		}
+		payload = append(payload, rest[:size]...)
+		body = []byte(strings.TrimPrefix(rest[size:], "\r\n"))
+	}
+}
+
+// waitUploads waits until the queue is empty
+func waitUploads(t *testing.T, q *uploadQueue) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.lock.Lock()
+		pending, lastError := len(q.pending), q.status.LastError
+		q.lock.Unlock()
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d uploads pending, last error %q", pending, lastError)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// countingWriter counts the bytes written to the files of an sftp.FileWriter
+type countingWriter struct {
+	sftp.FileWriter
+	written *atomic.Int64
+}
+
+func (w countingWriter) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	file, err := w.FileWriter.Filewrite(r)
+	return countingWriterAt{file, w.written}, err
+}
+
+type countingWriterAt struct {
+	io.WriterAt
+	written *atomic.Int64
+}
+
+func (w countingWriterAt) WriteAt(p []byte, offset int64) (int, error) {
+	n, err := w.WriterAt.WriteAt(p, offset)
+	w.written.Add(int64(n))
+	return n, err
+}
+
+// TestSFTPPut tests that files are written under a temporary name and renamed into place,
+// and that an interrupted upload continues at the end of the temporary file
+func TestSFTPPut(t *testing.T) {
+	var written atomic.Int64
+	handlers := sftp.InMemHandler()
+	handlers.FilePut = countingWriter{handlers.FilePut, &written}
+	serverConn, clientConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, handlers)
+	go server.Serve()
+	defer server.Close()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	readRemote := func(name string) string {
+		file, err := client.Open(name)
+		if err != nil {
+			return ""
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		return string(data)
+	}
+
+	local := filepath.Join(t.TempDir(), "port5556_1.bin")
+	data := strings.Repeat("x", SFTP_SAVE_INTERVAL+10)
+	if err := os.WriteFile(local, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var saved []int64
+	save := func(progress UploadProgress) { saved = append(saved, progress.Sent) }
+	if err := sftpPut(client, local, "/srv/box1/port5556_1.bin", UploadProgress{}, save); err != nil {
+		t.Fatalf("sftpPut() = %v", err)
+	}
+	if readRemote("/srv/box1/port5556_1.bin") != data || written.Load() != int64(len(data)) {
+		t.Errorf("Written %d bytes, want the uploaded file", written.Load())
+	}
+	if _, err := client.Stat("/srv/box1/port5556_1.bin" + PARTIAL_SEGMENT_SUFFIX); err == nil {
+		t.Error("Temporary file was not renamed")
+	}
+	if len(saved) != 2 || saved[0] != SFTP_SAVE_INTERVAL || saved[1] != int64(len(data)) {
+		t.Errorf("Saved progress %v", saved)
+	}
+
+	partial, err := client.Create("/srv/box1/port5556_2.bin" + PARTIAL_SEGMENT_SUFFIX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial.Write([]byte(data[:100]))
+	partial.Close()
+	written.Store(0)
+	progress := UploadProgress{Size: int64(len(data)), Sent: 100}
+	if err := sftpPut(client, local, "/srv/box1/port5556_2.bin", progress, nil); err != nil {
+		t.Fatalf("sftpPut() = %v", err)
+	}
+	if readRemote("/srv/box1/port5556_2.bin") != data || written.Load() != int64(len(data)-100) {
+		t.Errorf("Resumed upload wrote %d bytes, want %d", written.Load(), len(data)-100)
+	}
+}
+
+// TestS3Resume tests that a multipart upload interrupted after a part continues with the
+// next part
+func TestS3Resume(t *testing.T) {
+	var lock sync.Mutex
+	parts := make(map[string]string)
+	uploads := make(map[string]int)
+	var object string
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		query := r.URL.Query()
+		body := s3Body(r)
+		switch {
+		case r.Method == http.MethodPost && query.Has("uploads"):
+			io.WriteString(w, "<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>")
+		case r.Method == http.MethodPut && query.Get("uploadId") == "u1":
+			number := query.Get("partNumber")
+			if number == "2" && !failed {
+				failed = true
+				http.Error(w, "", http.StatusServiceUnavailable)
+				return
+			}
+			parts[number] = string(body)
+			uploads[number]++
+			w.Header().Set("ETag", `"etag`+number+`"`)
+		case r.Method == http.MethodPost && query.Get("uploadId") == "u1":
+			if !strings.Contains(string(body), "etag2") {
+				io.WriteString(w, "<Error><Code>InvalidPart</Code></Error>")
+				return
+			}
+			object = parts["1"] + parts["2"]
+			io.WriteString(w, "<CompleteMultipartUploadResult><Bucket>bucket</Bucket></CompleteMultipartUploadResult>")
+		default:
+			http.Error(w, "", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	local := filepath.Join(t.TempDir(), "port5555_1.bin")
+	data := strings.Repeat("abcdefgh", S3_PART_SIZE/8+1)
+	if err := os.WriteFile(local, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.UploadConfig{URL: "s3://bucket", Endpoint: server.URL, AccessKeyID: "key", SecretAccessKey: "secret"}
+	target, _ := url.Parse(cfg.URL)
+	uploader, err := newS3Uploader(cfg, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress UploadProgress
+	save := func(update UploadProgress) { progress = update }
+	resumable := uploader.(ResumableUploader)
+	if err := resumable.Resume(context.Background(), local, "port5555_1.bin", progress, save); err == nil {
+		t.Fatal("Expected error for the failed part, got nil")
+	}
+	if progress.UploadID != "u1" || len(progress.Parts) != 1 || progress.Sent != S3_PART_SIZE {
+		t.Errorf("Progress = %+v, want the first part", progress)
+	}
+	if err := resumable.Resume(context.Background(), local, "port5555_1.bin", progress, save); err != nil {
+		t.Fatalf("Resume() = %v", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if object != data || uploads["1"] != 1 {
+		t.Errorf("Uploaded %d bytes with part 1 sent %d times, want %d bytes and once", len(object), uploads["1"], len(data))
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"eth-daq-software/logger"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	INCIDENT_EVENT         = "incident"      // Event carrying an Incident
+	LISTENER_RESTART_DELAY = 2 * time.Second // Delay before a dead listener is restarted
+	MAX_INCIDENTS          = 100             // Incidents kept for GetIncidents
+)
+
+// Parts of the server an incident happened in
+const (
+	INCIDENT_LISTENER   = "listener"   // Accept loop of a TCP port, restarted
+	INCIDENT_CONNECTION = "connection" // Reader of a data connection, the connection is closed
+	INCIDENT_HANDSHAKE  = "handshake"  // Handler of a handshake connection, the connection is closed
+	INCIDENT_UDP_LOGS   = "udp-logs"   // UDP log listener, restarted
+)
+
+// Incident is a panic or an unexpected stop of a listener or connection handler that the
+// server recovered from
+type Incident struct {
+	Time      time.Time
+	Component string // One of the INCIDENT_* parts
+	Port      int
+	IP        string // Client of a connection, "" for listeners
+	Error     string
+	Stack     string // Stack of the panicking goroutine, "" if the component stopped without a panic
+}
+
+// panicError is a recovered panic with the stack it was raised on
+type panicError struct {
+	value interface{}
+	stack string
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// reportIncident logs an incident, keeps it for GetIncidents and sends it to the frontend
+func (s *Server) reportIncident(incident Incident) {
+	incident.Time = time.Now()
+	logger.ErrorFields("Recovered from "+incident.Component+" failure", logger.Fields{
+		"port":  incident.Port,
+		"ip":    incident.IP,
+		"error": incident.Error,
+		"stack": incident.Stack,
+	})
+
+	s.incidentsLock.Lock()
+	s.incidents = append(s.incidents, incident)
+	if len(s.incidents) > MAX_INCIDENTS {
+		s.incidents = s.incidents[len(s.incidents)-MAX_INCIDENTS:]
+	}
+	s.incidentsLock.Unlock()
+	s.emitEvent(INCIDENT_EVENT, incident)
+}
+
+// reportFailure reports err, a recovered panic or a stopped component, as an incident
+func (s *Server) reportFailure(component string, port int, ip string, err error) {
+	incident := Incident{Component: component, Port: port, IP: ip, Error: err.Error()}
+	var recovered *panicError
+	if errors.As(err, &recovered) {
+		incident.Stack = recovered.stack
+	}
+	s.reportIncident(incident)
+}
+
+// recoverPanic reports a panic of a connection handler instead of letting it end the
+// process. It must be deferred by the handler.
+func (s *Server) recoverPanic(component string, port int, ip string) {
+	if value := recover(); value != nil {
+		s.reportFailure(component, port, ip, &panicError{value: value, stack: string(debug.Stack())})
+	}
+}
+
+// GetIncidents returns the incidents the server recovered from, oldest first
+func (s *Server) GetIncidents() []Incident {
+	s.incidentsLock.RLock()
+	defer s.incidentsLock.RUnlock()
+	return append([]Incident{}, s.incidents...)
+}
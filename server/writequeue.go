@@ -0,0 +1,137 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"eth-daq-software/logger"
+	"sync"
+)
+
+// WriteQueueStats describes the segments waiting for a writer worker, to spot a disk that
+// cannot keep up before data is lost
+type WriteQueueStats struct {
+	Depth           int    // Segments waiting to be written
+	QueuedBytes     int64  // Bytes waiting to be written
+	MaxDepth        int    // Highest depth since startup
+	Capacity        int    // Segments that can wait before the policy applies
+	Policy          string // config.WRITE_QUEUE_BLOCK or config.WRITE_QUEUE_DROP_OLDEST
+	Blocked         int64  // Flushes that waited for room in the queue
+	DroppedSegments int64  // Segments dropped to make room
+	DroppedBytes    int64  // Bytes of the dropped segments
+}
+
+// writeQueue is the bounded queue of flushed segments between the receive path and the
+// writer workers. When it is full, push either waits for room or drops the oldest segment.
+type writeQueue struct {
+	jobs     []flushJob
+	stats    WriteQueueStats
+	closed   bool // Set by close, pop returns once the queue is empty
+	lock     sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+}
+
+func newWriteQueue(capacity int, policy string) *writeQueue {
+	q := &writeQueue{stats: WriteQueueStats{Capacity: capacity, Policy: policy}}
+	q.notEmpty = sync.NewCond(&q.lock)
+	q.notFull = sync.NewCond(&q.lock)
+	return q
+}
+
+// configure changes the capacity and policy. A smaller capacity applies to the next push,
+// queued segments are kept.
+func (q *writeQueue) configure(capacity int, policy string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.stats.Capacity = capacity
+	q.stats.Policy = policy
+	q.notFull.Broadcast()
+}
+
+// push queues a job and returns the jobs dropped to make room for it
+func (q *writeQueue) push(job flushJob) []flushJob {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var dropped []flushJob
+	if len(q.jobs) >= q.stats.Capacity {
+		if q.stats.Policy == config.WRITE_QUEUE_DROP_OLDEST {
+			dropped = q.jobs[:len(q.jobs)-q.stats.Capacity+1]
+			q.jobs = q.jobs[len(dropped):]
+			for _, droppedJob := range dropped {
+				q.stats.DroppedSegments++
+				q.stats.DroppedBytes += int64(len(droppedJob.data))
+				q.stats.QueuedBytes -= int64(len(droppedJob.data))
+			}
+		} else {
+			q.stats.Blocked++
+			for len(q.jobs) >= q.stats.Capacity {
+				q.notFull.Wait()
+			}
+		}
+	}
+
+	q.jobs = append(q.jobs, job)
+	q.stats.QueuedBytes += int64(len(job.data))
+	q.stats.MaxDepth = max(q.stats.MaxDepth, len(q.jobs))
+	q.notEmpty.Signal()
+	return dropped
+}
+
+// pop waits for a job and removes it from the queue, returning false once the queue is
+// closed and empty
+func (q *writeQueue) pop() (flushJob, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.jobs) == 0 {
+		if q.closed {
+			return flushJob{}, false
+		}
+		q.notEmpty.Wait()
+	}
+	job := q.jobs[0]
+	q.jobs[0] = flushJob{}
+	q.jobs = q.jobs[1:]
+	q.stats.QueuedBytes -= int64(len(job.data))
+	q.notFull.Signal()
+	return job, true
+}
+
+// close wakes the waiting workers, which exit once the queued jobs are written
+func (q *writeQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+}
+
+// snapshot returns the current statistics
+func (q *writeQueue) snapshot() WriteQueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	stats := q.stats
+	stats.Depth = len(q.jobs)
+	return stats
+}
+
+// submit queues a job for a background worker, waiting for room or dropping older
+// segments when the queue is full, and waiting for memory when over the memory cap
+func (w *segmentWriter) submit(job flushJob) {
+	w.pending.Add(1)
+	w.memory.reserve(len(job.data))
+	for _, dropped := range w.queue.push(job) {
+		logger.ErrorFields("Write queue full, dropped segment", logger.Fields{
+			"file":  dropped.filename,
+			"ip":    dropped.key.IP,
+			"port":  dropped.key.Port,
+			"bytes": len(dropped.data),
+		})
+		w.memory.release(len(dropped.data))
+		putFlushBuffer(dropped.data)
+		w.pending.Done()
+	}
+}
+
+// GetWriteQueueStats returns the depth and drop counts of the segment write queue
+func (s *Server) GetWriteQueueStats() WriteQueueStats {
+	return s.writer.queue.snapshot()
+}
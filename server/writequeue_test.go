@@ -0,0 +1,51 @@
+package server
+
+import (
+	"eth-daq-software/config"
+	"testing"
+	"time"
+)
+
+// TestWriteQueueDropOldest tests that a full queue drops the oldest segments and counts them
+func TestWriteQueueDropOldest(t *testing.T) {
+	writer := newSegmentWriter(0, t.TempDir())
+	writer.queue.configure(2, config.WRITE_QUEUE_DROP_OLDEST)
+	for i, size := range []int{10, 20, 30} {
+		writer.submit(flushJob{filename: string(rune('a' + i)), data: make([]byte, size)})
+	}
+
+	stats := writer.queue.snapshot()
+	want := WriteQueueStats{Depth: 2, QueuedBytes: 50, MaxDepth: 2, Capacity: 2,
+		Policy: config.WRITE_QUEUE_DROP_OLDEST, DroppedSegments: 1, DroppedBytes: 10}
+	if stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+	if job, _ := writer.queue.pop(); job.filename != "b" {
+		t.Errorf("Oldest queued segment = %q, want b", job.filename)
+	}
+}
+
+// TestWriteQueueBlock tests that a full queue with the block policy waits for a worker
+func TestWriteQueueBlock(t *testing.T) {
+	queue := newWriteQueue(1, config.WRITE_QUEUE_BLOCK)
+	queue.push(flushJob{filename: "a"})
+
+	pushed := make(chan struct{})
+	go func() {
+		queue.push(flushJob{filename: "b"})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push() returned while the queue was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if job, _ := queue.pop(); job.filename != "a" {
+		t.Errorf("pop() = %q, want a", job.filename)
+	}
+	<-pushed
+	if stats := queue.snapshot(); stats.Depth != 1 || stats.Blocked != 1 || stats.DroppedSegments != 0 {
+		t.Errorf("Stats = %+v, want depth 1 after blocking once", stats)
+	}
+}
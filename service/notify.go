@@ -0,0 +1,33 @@
+package service
+
+import (
+	"net"
+	"os"
+)
+
+// States reported to the service manager with Notify
+const (
+	READY    = "READY=1"    // Startup finished, the listeners are accepting devices
+	STOPPING = "STOPPING=1" // Shutdown started, buffers are being flushed
+)
+
+// Notify reports a state change to systemd (sd_notify) over the socket in NOTIFY_SOCKET.
+// It does nothing when not started by systemd with Type=notify.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading '@' selects the abstract socket namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
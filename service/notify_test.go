@@ -0,0 +1,32 @@
+package service
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// TestNotify tests that states are sent to the socket in NOTIFY_SOCKET
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := Notify(READY); err != nil {
+		t.Fatalf("Notify() without NOTIFY_SOCKET = %v, want nil", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	if err := Notify(READY); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	message := make([]byte, 64)
+	n, err := conn.Read(message)
+	if err != nil || string(message[:n]) != READY {
+		t.Fatalf("Received %q, %v, want %q", message[:n], err, READY)
+	}
+}
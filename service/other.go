@@ -0,0 +1,40 @@
+//go:build !windows
+
+package service
+
+import (
+	"errors"
+)
+
+// errNotWindows is returned by the Windows service functions on other systems
+var errNotWindows = errors.New("Windows services are only available on Windows, use build/linux/eth-daq-software.service with systemd")
+
+// IsWindowsService reports whether the process was started by the service control manager
+func IsWindowsService() bool {
+	return false
+}
+
+// RunWindowsService runs the service name, see the Windows implementation
+func RunWindowsService(name string, run func(stop <-chan struct{}) error) error {
+	return errNotWindows
+}
+
+// Install registers the executable as the service name, see the Windows implementation
+func Install(name string, executable string, args []string) error {
+	return errNotWindows
+}
+
+// Uninstall removes the service name, see the Windows implementation
+func Uninstall(name string) error {
+	return errNotWindows
+}
+
+// Start starts the service name, see the Windows implementation
+func Start(name string) error {
+	return errNotWindows
+}
+
+// Stop stops the service name, see the Windows implementation
+func Stop(name string) error {
+	return errNotWindows
+}
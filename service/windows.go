@@ -0,0 +1,152 @@
+//go:build windows
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	CONTROL_TIMEOUT = 30 * time.Second // Time a service has to start or stop, buffers are written on stop
+)
+
+// IsWindowsService reports whether the process was started by the service control manager
+func IsWindowsService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+// handler runs the server of a Windows service until the service control manager stops it
+type handler struct {
+	run func(stop <-chan struct{}) error
+	err error
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- h.run(stop) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case h.err = <-done:
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(CONTROL_TIMEOUT / time.Millisecond)}
+				close(stop)
+				h.err = <-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// RunWindowsService runs the service name, calling run with a channel that is closed when
+// the service is stopped. run returns once the server has shut down.
+func RunWindowsService(name string, run func(stop <-chan struct{}) error) error {
+	h := &handler{run: run}
+	if err := svc.Run(name, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+// Install registers the executable as the automatically started service name, run with args
+func Install(name string, executable string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", name)
+	}
+	s, err := m.CreateService(name, executable, mgr.Config{
+		DisplayName: name,
+		Description: "Receives and records data from eth-daq devices",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to install service %s: %v", name, err)
+	}
+	defer s.Close()
+	return nil
+}
+
+// Uninstall removes the service name, which must be stopped
+func Uninstall(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		if err := s.Delete(); err != nil {
+			return fmt.Errorf("failed to uninstall service %s: %v", name, err)
+		}
+		return nil
+	})
+}
+
+// Start starts the service name and waits until it runs
+func Start(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		if err := s.Start(); err != nil {
+			return fmt.Errorf("failed to start service %s: %v", name, err)
+		}
+		return waitForState(s, svc.Running)
+	})
+}
+
+// Stop stops the service name and waits until it has written its buffers and stopped
+func Stop(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		if _, err := s.Control(svc.Stop); err != nil {
+			return fmt.Errorf("failed to stop service %s: %v", name, err)
+		}
+		return waitForState(s, svc.Stopped)
+	})
+}
+
+// withService calls f with the installed service name
+func withService(name string, f func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %v", name, err)
+	}
+	defer s.Close()
+	return f(s)
+}
+
+// waitForState polls the service until it reaches state or CONTROL_TIMEOUT expires
+func waitForState(s *mgr.Service, state svc.State) error {
+	deadline := time.Now().Add(CONTROL_TIMEOUT)
+	for {
+		status, err := s.Query()
+		if err != nil {
+			return fmt.Errorf("failed to query service %s: %v", s.Name, err)
+		}
+		if status.State == state {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not reach state %d within %v", s.Name, state, CONTROL_TIMEOUT)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+}
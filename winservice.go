@@ -0,0 +1,56 @@
+package main
+
+import (
+	"eth-daq-software/service"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	SERVICE_NAME = "eth-daq-software" // Name of the Windows service
+)
+
+// runService runs the server headless as the Windows service. The service control
+// manager starts services in the system directory, relative paths of the configuration
+// are taken from the directory of the executable.
+func runService(configPath string, httpAddr string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(filepath.Dir(executable)); err != nil {
+		return err
+	}
+	return service.RunWindowsService(SERVICE_NAME, func(stop <-chan struct{}) error {
+		return serveHeadless(configPath, httpAddr, stop)
+	})
+}
+
+// controlService installs, uninstalls, starts or stops the Windows service. The service
+// runs with the configuration file and status API address of the installing command.
+func controlService(action string, configPath string, httpAddr string) error {
+	switch action {
+	case "install":
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		configPath, err = filepath.Abs(configPath)
+		if err != nil {
+			return err
+		}
+		args := []string{"-config", configPath}
+		if httpAddr != "" {
+			args = append(args, "-http", httpAddr)
+		}
+		return service.Install(SERVICE_NAME, executable, args)
+	case "uninstall":
+		return service.Uninstall(SERVICE_NAME)
+	case "start":
+		return service.Start(SERVICE_NAME)
+	case "stop":
+		return service.Stop(SERVICE_NAME)
+	}
+	return fmt.Errorf("unknown service action %q, use install, uninstall, start or stop", action)
+}